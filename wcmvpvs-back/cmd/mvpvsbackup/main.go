@@ -0,0 +1,150 @@
+/*
+Mvpvsbackup is the maintenance tool for the SQLite database backups written by `webapi` (see the `Backup` section of
+its configuration, or the /admin/backup/snapshot endpoint).
+
+Usage:
+
+	mvpvsbackup verify <backup-file>
+	mvpvsbackup restore [flags] <backup-file>
+
+The commands are:
+
+	verify
+		Runs `PRAGMA integrity_check` on the backup file.
+
+	restore
+		Checks the backup integrity and then swaps it in place of the live database. The current database (and its
+		-wal/-shm files, if any) is kept next to it with a `.pre-restore-<timestamp>` suffix. The web server MUST be
+		stopped while restoring.
+
+The restore flags are:
+
+	-db <path>
+		Path of the live database to replace (default: /data/mvpvs.db).
+
+Return values (exit codes):
+
+	0
+		The command was successful
+
+	> 0
+		The backup is corrupted, or the command failed
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: mvpvsbackup verify|restore [flags] <backup-file>")
+	}
+
+	switch args[0] {
+	case "verify":
+		fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: mvpvsbackup verify <backup-file>")
+		}
+		if err := database.VerifyIntegrity(fs.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Println("ok") //nolint:forbidigo
+		return nil
+	case "restore":
+		fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+		dbPath := fs.String("db", "/data/mvpvs.db", "path of the live database to replace")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errors.New("usage: mvpvsbackup restore [-db <path>] <backup-file>")
+		}
+		return restore(fs.Arg(0), *dbPath)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// restore copies the backup next to the live database, verifies the copy and then renames it over the live file.
+// Verifying the copy (instead of the source) guarantees that what ends up in place is exactly what was checked.
+func restore(backupPath, dbPath string) error {
+	if err := database.VerifyIntegrity(backupPath); err != nil {
+		return fmt.Errorf("backup %s: %w", backupPath, err)
+	}
+
+	staging := dbPath + ".restoring"
+	if err := copyFile(backupPath, staging); err != nil {
+		_ = os.Remove(staging)
+		return fmt.Errorf("copying backup: %w", err)
+	}
+	if err := database.VerifyIntegrity(staging); err != nil {
+		_ = os.Remove(staging)
+		return fmt.Errorf("staged copy: %w", err)
+	}
+
+	// Move the current database aside, together with its WAL files: a leftover WAL would be replayed on top of the
+	// restored file at the next start.
+	suffix := ".pre-restore-" + time.Now().UTC().Format("20060102-150405")
+	for _, ext := range []string{"", "-wal", "-shm"} {
+		current := dbPath + ext
+		if _, err := os.Stat(current); errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			_ = os.Remove(staging)
+			return err
+		}
+		if err := os.Rename(current, current+suffix); err != nil {
+			_ = os.Remove(staging)
+			return fmt.Errorf("moving aside %s: %w", current, err)
+		}
+		fmt.Printf("moved %s to %s\n", current, filepath.Base(current+suffix)) //nolint:forbidigo
+	}
+
+	if err := os.Rename(staging, dbPath); err != nil {
+		return fmt.Errorf("swapping restored database: %w", err)
+	}
+	fmt.Printf("restored %s into %s\n", backupPath, dbPath) //nolint:forbidigo
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
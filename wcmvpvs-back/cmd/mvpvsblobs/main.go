@@ -0,0 +1,292 @@
+/*
+Mvpvsblobs moves the images of selfies, sponsors and shop into the blob store configured for `webapi`.
+
+Usage:
+
+	mvpvsblobs migrate [flags]
+
+The command reads the same environment variables as `webapi` (CFG_DB_FILENAME, CFG_STORAGE_BACKEND, CFG_STORAGE_DIR,
+CFG_STORAGE_S3_*), or the matching flags (see `mvpvsblobs migrate --help`), and copies into that store:
+
+  - the images saved before the blob store existed, referenced in the database by their absolute file path (they
+    were written under tmp/selfies in the working directory of the web server);
+  - with --from-dir, every object stored by a local blob store rooted in that directory, for example when switching
+    from the local backend to S3: the selfies with their thumbnails and branded renditions, the sponsor logos and the
+    shop images.
+
+The database is updated to point to the new objects and the source files are removed, unless --keep-source is given.
+With --dry-run nothing is written. Images of archived events saved before the blob store existed are moved when the
+event is restored and the command is run again.
+
+The web server should be stopped while migrating, or new uploads may be missed.
+
+Return values (exit codes):
+
+	0
+		Every image was moved (missing source files are reported, but are not errors)
+
+	> 0
+		The command failed
+*/
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/ardanlabs/conf"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type migrateConfiguration struct {
+	DB struct {
+		Filename string `conf:"default:/data/mvpvs.db"`
+	}
+	Storage struct {
+		Backend string `conf:"default:local"`
+		Dir     string `conf:"default:data/blobs"`
+		S3      struct {
+			Endpoint        string `conf:"env:STORAGE_S3_ENDPOINT,flag:storage-s3-endpoint"`
+			Region          string `conf:"default:us-east-1,env:STORAGE_S3_REGION,flag:storage-s3-region"`
+			Bucket          string `conf:"env:STORAGE_S3_BUCKET,flag:storage-s3-bucket"`
+			AccessKeyID     string `conf:"env:STORAGE_S3_ACCESS_KEY_ID,flag:storage-s3-access-key-id"`
+			SecretAccessKey string `conf:"mask,env:STORAGE_S3_SECRET_ACCESS_KEY,flag:storage-s3-secret-access-key"`
+			PathStyle       bool   `conf:"default:true,env:STORAGE_S3_PATH_STYLE,flag:storage-s3-path-style"`
+		}
+	}
+	FromDir    string
+	KeepSource bool
+	DryRun     bool
+}
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	if len(args) == 0 || args[0] != "migrate" {
+		return errors.New("usage: mvpvsblobs migrate [flags]")
+	}
+
+	var cfg migrateConfiguration
+	if err := conf.Parse(args[1:], "CFG", &cfg); err != nil {
+		if errors.Is(err, conf.ErrHelpWanted) {
+			usage, err := conf.Usage("CFG", &cfg)
+			if err != nil {
+				return err
+			}
+			fmt.Println(usage) //nolint:forbidigo
+			return nil
+		}
+		return err
+	}
+
+	target, err := blobstore.New(blobstore.Config{
+		Backend: cfg.Storage.Backend,
+		Dir:     cfg.Storage.Dir,
+		S3: blobstore.S3Config{
+			Endpoint:        cfg.Storage.S3.Endpoint,
+			Region:          cfg.Storage.S3.Region,
+			Bucket:          cfg.Storage.S3.Bucket,
+			AccessKeyID:     cfg.Storage.S3.AccessKeyID,
+			SecretAccessKey: cfg.Storage.S3.SecretAccessKey,
+			PathStyle:       cfg.Storage.S3.PathStyle,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("creating the blob store: %w", err)
+	}
+
+	var source *blobstore.Local
+	if cfg.FromDir != "" {
+		if source, err = blobstore.NewLocal(cfg.FromDir); err != nil {
+			return fmt.Errorf("opening %s: %w", cfg.FromDir, err)
+		}
+		if local, ok := target.(*blobstore.Local); ok && local.Root() == source.Root() {
+			return errors.New("the source directory is the same as the target store")
+		}
+	}
+
+	dbconn, err := sql.Open("sqlite3", cfg.DB.Filename)
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer dbconn.Close()
+	if _, err := dbconn.Exec(`PRAGMA busy_timeout=5000;`); err != nil {
+		return err
+	}
+	db, err := database.New(dbconn)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	selfies, err := db.ListAllSelfies()
+	if err != nil {
+		return err
+	}
+
+	var moved, missing, skipped int
+	movedKeys := map[string]bool{}
+	for _, selfie := range selfies {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		result, err := migrateSelfie(ctx, db, selfie, source, target, cfg.KeepSource, cfg.DryRun)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("selfie %d: %w", selfie.ID, err)
+		}
+		switch result {
+		case "moved":
+			moved++
+			movedKeys[selfie.ImagePath] = true
+		case "missing":
+			missing++
+			_, _ = fmt.Fprintf(os.Stderr, "selfie %d: image %s not found\n", selfie.ID, selfie.ImagePath)
+		default:
+			skipped++
+		}
+	}
+
+	others := 0
+	if source != nil {
+		if others, err = migrateObjects(source, target, movedKeys, cfg.KeepSource, cfg.DryRun); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("moved: %d, missing: %d, skipped: %d, other objects: %d\n", moved, missing, skipped, others) //nolint:forbidigo
+	return nil
+}
+
+// migrateObjects copies every object of the source store not moved with the selfies: thumbnails and branded
+// renditions, sponsor logos, shop images, and the images of selfies no longer in the database. It returns the number
+// of objects copied. The content type is guessed from the extension of the key, or else from the content.
+func migrateObjects(source *blobstore.Local, target blobstore.Store, skip map[string]bool, keepSource, dryRun bool) (int, error) {
+	var keys []string
+	err := filepath.WalkDir(source.Root(), func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return err
+		}
+		rel, err := filepath.Rel(source.Root(), file)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); !skip[key] && blobstore.ValidateKey(key) == nil {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing %s: %w", source.Root(), err)
+	}
+	if dryRun {
+		return len(keys), nil
+	}
+
+	for _, key := range keys {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		err := migrateObject(ctx, source, target, key, keepSource)
+		cancel()
+		if err != nil {
+			return 0, fmt.Errorf("object %s: %w", key, err)
+		}
+	}
+	return len(keys), nil
+}
+
+func migrateObject(ctx context.Context, source *blobstore.Local, target blobstore.Store, key string, keepSource bool) error {
+	reader, info, err := source.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	data, err := readAndClose(reader)
+	if err != nil {
+		return err
+	}
+	contentType := info.ContentType
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	if err := target.Put(ctx, key, data, contentType); err != nil {
+		return err
+	}
+	if !keepSource {
+		if err := source.Delete(ctx, key); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "object %s: cannot remove the source: %v\n", key, err)
+		}
+	}
+	return nil
+}
+
+// migrateSelfie moves the image of a selfie and returns "moved", "missing" or "skipped".
+func migrateSelfie(ctx context.Context, db database.AppDatabase, selfie database.Selfie, source *blobstore.Local, target blobstore.Store, keepSource, dryRun bool) (string, error) {
+	var data []byte
+	var key string
+	var removeSource func() error
+
+	switch {
+	case selfie.ImagePath == "":
+		return "skipped", nil
+	case filepath.IsAbs(selfie.ImagePath):
+		content, err := os.ReadFile(selfie.ImagePath)
+		if errors.Is(err, os.ErrNotExist) {
+			return "missing", nil
+		} else if err != nil {
+			return "", err
+		}
+		data = content
+		key = fmt.Sprintf("selfies/event_%d/%s", selfie.EventID, filepath.Base(selfie.ImagePath))
+		removeSource = func() error { return os.Remove(selfie.ImagePath) }
+	case source != nil:
+		reader, _, err := source.Get(ctx, selfie.ImagePath)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			return "missing", nil
+		} else if err != nil {
+			return "", err
+		}
+		data, err = readAndClose(reader)
+		if err != nil {
+			return "", err
+		}
+		key = selfie.ImagePath
+		removeSource = func() error { return source.Delete(ctx, key) }
+	default:
+		return "skipped", nil
+	}
+
+	if dryRun {
+		return "moved", nil
+	}
+	if err := target.Put(ctx, key, data, selfie.ContentType); err != nil {
+		return "", err
+	}
+	if key != selfie.ImagePath {
+		if err := db.UpdateSelfieImagePath(selfie.ID, key); err != nil {
+			return "", err
+		}
+	}
+	if !keepSource {
+		if err := removeSource(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "selfie %d: cannot remove the source image: %v\n", selfie.ID, err)
+		}
+	}
+	return "moved", nil
+}
+
+func readAndClose(reader io.ReadCloser) ([]byte, error) {
+	defer reader.Close()
+	return io.ReadAll(reader)
+}
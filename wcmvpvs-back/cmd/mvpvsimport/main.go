@@ -0,0 +1,118 @@
+/*
+Mvpvsimport loads teams, players or event fixtures in bulk from a CSV or JSON file into the SQLite database. It runs
+the same validation as the /admin/import/{kind} endpoint (see the rosterimport package for the columns).
+
+Usage:
+
+	mvpvsimport [flags] <file>
+
+The flags are:
+
+	-db <path>
+		Path of the database (default: /data/mvpvs.db).
+	-kind teams|players|events
+		What the file contains (required).
+	-format csv|json
+		Format of the file (default: from the file extension).
+	-mode insert|upsert
+		In insert mode rows matching existing ones are errors; in upsert mode they update them (default: insert).
+	-dry-run
+		Only validate the file, without writing anything.
+
+The report is printed as JSON on the standard output.
+
+Return values (exit codes):
+
+	0
+		The file was valid (and imported, unless -dry-run was given)
+
+	> 0
+		Some rows are invalid and nothing was written, or the command failed
+*/
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/rosterimport"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "error: ", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	fs := flag.NewFlagSet("mvpvsimport", flag.ContinueOnError)
+	dbPath := fs.String("db", "/data/mvpvs.db", "path of the database")
+	kind := fs.String("kind", "", "teams, players or events")
+	format := fs.String("format", "", "csv or json (default: from the file extension)")
+	mode := fs.String("mode", string(rosterimport.ModeInsert), "insert or upsert")
+	dryRun := fs.Bool("dry-run", false, "only validate the file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *kind == "" {
+		return errors.New("usage: mvpvsimport -kind teams|players|events [-db <path>] [-format csv|json] [-mode insert|upsert] [-dry-run] <file>")
+	}
+
+	filename := fs.Arg(0)
+	if *format == "" {
+		*format = filename
+	}
+	parsedFormat, ok := rosterimport.ParseFormat(*format)
+	if !ok {
+		return fmt.Errorf("cannot detect the format of %s, use -format", filename)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dbconn, err := sql.Open("sqlite3", *dbPath)
+	if err != nil {
+		return fmt.Errorf("opening SQLite: %w", err)
+	}
+	defer dbconn.Close()
+	if _, err := dbconn.Exec(`PRAGMA busy_timeout=5000;`); err != nil {
+		return err
+	}
+	if _, err := dbconn.Exec(`PRAGMA foreign_keys=ON;`); err != nil {
+		return err
+	}
+	db, err := database.New(dbconn)
+	if err != nil {
+		return fmt.Errorf("creating AppDatabase: %w", err)
+	}
+
+	report, err := rosterimport.Run(db, file, rosterimport.Options{
+		Kind:   rosterimport.Kind(*kind),
+		Format: parsedFormat,
+		Mode:   rosterimport.Mode(*mode),
+		DryRun: *dryRun,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%d invalid rows, nothing was imported", len(report.Errors))
+	}
+	return nil
+}
@@ -33,6 +33,77 @@ type WebAPIConfiguration struct {
 		Secret string `conf:"default:secret"`
 	}
 
+	Backup struct {
+		Dir       string
+		Interval  time.Duration `conf:"default:6h"`
+		Retention int           `conf:"default:28"`
+	}
+
+	Archive struct {
+		GracePeriod time.Duration `conf:"default:720h"`
+	}
+
+	Retention struct {
+		Votes            time.Duration `conf:"default:8760h"`
+		Selfies          time.Duration `conf:"default:2160h"`
+		ReactionTests    time.Duration `conf:"default:2160h"`
+		SponsorTelemetry time.Duration `conf:"default:8760h"`
+		ShopOrders       time.Duration `conf:"default:87600h"`
+	}
+
+	Storage struct {
+		Backend   string        `conf:"default:local"`
+		Dir       string        `conf:"default:data/blobs"`
+		URLExpiry time.Duration `conf:"default:15m"`
+		S3        struct {
+			Endpoint        string `conf:"env:STORAGE_S3_ENDPOINT,flag:storage-s3-endpoint"`
+			Region          string `conf:"default:us-east-1,env:STORAGE_S3_REGION,flag:storage-s3-region"`
+			Bucket          string `conf:"env:STORAGE_S3_BUCKET,flag:storage-s3-bucket"`
+			AccessKeyID     string `conf:"env:STORAGE_S3_ACCESS_KEY_ID,flag:storage-s3-access-key-id"`
+			SecretAccessKey string `conf:"mask,env:STORAGE_S3_SECRET_ACCESS_KEY,flag:storage-s3-secret-access-key"`
+			PathStyle       bool   `conf:"default:true,env:STORAGE_S3_PATH_STYLE,flag:storage-s3-path-style"`
+		}
+	}
+
+	Screen struct {
+		APIKey string `conf:"mask"`
+	}
+
+	TextFilter struct {
+		Languages []string `conf:"default:it;en"`
+	}
+
+	SponsorRedirect struct {
+		UTMSource   string `conf:"default:wcmvpvs"`
+		UTMMedium   string `conf:"default:sponsor"`
+		UTMCampaign string `conf:"default:event-{event_id}"`
+	}
+
+	Shop struct {
+		ReservationTTL time.Duration `conf:"default:15m"`
+		PublicURL      string
+	}
+
+	Payments struct {
+		Provider      string        `conf:"default:none"`
+		WebhookSecret string        `conf:"mask"`
+		Timeout       time.Duration `conf:"default:1h"`
+	}
+
+	Mail struct {
+		Transport   string `conf:"default:none"`
+		From        string `conf:"default:Wearing Cash <noreply@wearingcash.it>"`
+		Dir         string `conf:"default:data/mail"`
+		MaxAttempts int    `conf:"default:8"`
+		SMTP        struct {
+			Host     string
+			Port     int `conf:"default:587"`
+			Username string
+			Password string `conf:"mask"`
+			Security string `conf:"default:starttls"`
+		}
+	}
+
 	Tickets struct {
 		ValidationBaseURL string `conf:"default:https://mvp.wearingcash.it"`
 	}
@@ -36,8 +36,11 @@ import (
 	"syscall"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/payments"
 	"github.com/ardanlabs/conf"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/sirupsen/logrus"
@@ -166,12 +169,84 @@ func run() error {
 	// buffered channel so the goroutine can exit if we don't collect this error.
 	serverErrors := make(chan error, 1)
 
+	// Create the storage for the selfie images
+	blobs, err := blobstore.New(blobstore.Config{
+		Backend: cfg.Storage.Backend,
+		Dir:     cfg.Storage.Dir,
+		S3: blobstore.S3Config{
+			Endpoint:        cfg.Storage.S3.Endpoint,
+			Region:          cfg.Storage.S3.Region,
+			Bucket:          cfg.Storage.S3.Bucket,
+			AccessKeyID:     cfg.Storage.S3.AccessKeyID,
+			SecretAccessKey: cfg.Storage.S3.SecretAccessKey,
+			PathStyle:       cfg.Storage.S3.PathStyle,
+		},
+	})
+	if err != nil {
+		logger.WithError(err).Error("error creating the blob store")
+		return fmt.Errorf("creating the blob store: %w", err)
+	}
+
+	// Create the payment provider of the shop, if enabled
+	paymentProvider, err := payments.New(payments.Config{
+		Provider:      cfg.Payments.Provider,
+		WebhookSecret: cfg.Payments.WebhookSecret,
+	})
+	if err != nil {
+		logger.WithError(err).Error("error creating the payment provider")
+		return fmt.Errorf("creating the payment provider: %w", err)
+	}
+
+	// Create the sender of the transactional emails, if enabled
+	mailSender, err := mailer.New(mailer.Config{
+		Transport: cfg.Mail.Transport,
+		Dir:       cfg.Mail.Dir,
+		SMTP: mailer.SMTPConfig{
+			Host:     cfg.Mail.SMTP.Host,
+			Port:     cfg.Mail.SMTP.Port,
+			Username: cfg.Mail.SMTP.Username,
+			Password: cfg.Mail.SMTP.Password,
+			Security: cfg.Mail.SMTP.Security,
+		},
+	})
+	if err != nil {
+		logger.WithError(err).Error("error creating the mail sender")
+		return fmt.Errorf("creating the mail sender: %w", err)
+	}
+
 	// Create the API router
 	apirouter, err := api.New(api.Config{
 		Logger:                  logger,
 		Database:                db,
 		VoteSecret:              cfg.Vote.Secret,
 		TicketValidationBaseURL: cfg.Tickets.ValidationBaseURL,
+		BackupDir:               cfg.Backup.Dir,
+		BackupInterval:          cfg.Backup.Interval,
+		BackupRetention:         cfg.Backup.Retention,
+		ArchiveGracePeriod:      cfg.Archive.GracePeriod,
+		Retention: api.RetentionPolicy{
+			Votes:            cfg.Retention.Votes,
+			Selfies:          cfg.Retention.Selfies,
+			ReactionTests:    cfg.Retention.ReactionTests,
+			SponsorTelemetry: cfg.Retention.SponsorTelemetry,
+			ShopOrders:       cfg.Retention.ShopOrders,
+		},
+		Blobs:               blobs,
+		BlobURLExpiry:       cfg.Storage.URLExpiry,
+		ScreenAPIKey:        cfg.Screen.APIKey,
+		TextFilterLanguages: cfg.TextFilter.Languages,
+		SponsorUTM: api.SponsorUTM{
+			Source:   cfg.SponsorRedirect.UTMSource,
+			Medium:   cfg.SponsorRedirect.UTMMedium,
+			Campaign: cfg.SponsorRedirect.UTMCampaign,
+		},
+		ShopReservationTTL: cfg.Shop.ReservationTTL,
+		Payments:           paymentProvider,
+		ShopPaymentTimeout: cfg.Payments.Timeout,
+		ShopPublicURL:      cfg.Shop.PublicURL,
+		Mail:               mailSender,
+		MailFrom:           cfg.Mail.From,
+		MailMaxAttempts:    cfg.Mail.MaxAttempts,
 	})
 	if err != nil {
 		logger.WithError(err).Error("error creating the API server instance")
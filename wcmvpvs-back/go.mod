@@ -9,6 +9,7 @@ require (
         github.com/gorilla/handlers v1.5.2
         github.com/mattn/go-sqlite3 v1.14.23
         github.com/sirupsen/logrus v1.9.3
+        golang.org/x/image v0.18.0
         gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -6,9 +6,9 @@ import (
 	"errors"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
 )
 
 func (rt *_router) wrapAdmin(fn httpRouterHandler) http.HandlerFunc {
@@ -51,7 +51,7 @@ func (rt *_router) getAdminSession(token string) (adminSession, bool) {
 		return adminSession{}, false
 	}
 
-	if time.Now().After(session.ExpiresAt) {
+	if globaltime.Now().After(session.ExpiresAt) {
 		rt.adminSessionsMu.Lock()
 		delete(rt.adminSessions, token)
 		rt.adminSessionsMu.Unlock()
@@ -60,7 +60,7 @@ func (rt *_router) getAdminSession(token string) (adminSession, bool) {
 
 	// extend the session deadline on each successful validation
 	rt.adminSessionsMu.Lock()
-	session.ExpiresAt = time.Now().Add(rt.sessionTimeout)
+	session.ExpiresAt = globaltime.Now().Add(rt.sessionTimeout)
 	rt.adminSessions[token] = session
 	rt.adminSessionsMu.Unlock()
 
@@ -78,7 +78,7 @@ func (rt *_router) createAdminSession(adminID int, username, role string) (strin
 		AdminID:   adminID,
 		Username:  username,
 		Role:      role,
-		ExpiresAt: time.Now().Add(rt.sessionTimeout),
+		ExpiresAt: globaltime.Now().Add(rt.sessionTimeout),
 	}
 	rt.adminSessionsMu.Unlock()
 
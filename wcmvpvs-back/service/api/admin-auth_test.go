@@ -0,0 +1,96 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdminAuth(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{name: "missing header", status: http.StatusUnauthorized},
+		{name: "wrong scheme", headers: map[string]string{"Authorization": "Basic " + token}, status: http.StatusUnauthorized},
+		{name: "unknown token", headers: adminHeaders("not-a-session"), status: http.StatusUnauthorized},
+		{name: "valid token", headers: adminHeaders(token), status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := h.do(http.MethodGet, "/teams", nil, tc.headers); rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestAdminLogin(t *testing.T) {
+	h := newTestHarness(t)
+	h.createAdmin(testAdminUsername, "staff")
+
+	cases := []struct {
+		name    string
+		payload map[string]string
+		status  int
+	}{
+		{name: "wrong password", payload: map[string]string{"username": testAdminUsername, "password": "nope"}, status: http.StatusUnauthorized},
+		{name: "unknown user", payload: map[string]string{"username": "ghost", "password": testAdminPassword}, status: http.StatusUnauthorized},
+		{name: "missing password", payload: map[string]string{"username": testAdminUsername}, status: http.StatusBadRequest},
+		{name: "valid credentials", payload: map[string]string{"username": testAdminUsername, "password": testAdminPassword}, status: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := h.do(http.MethodPost, "/admin/login", tc.payload, nil); rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tc.status)
+			}
+		})
+	}
+}
+
+func TestAdminSessionExpiry(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	// Each successful request slides the deadline forward.
+	h.advance(h.router.sessionTimeout - time.Minute)
+	if rec := h.do(http.MethodGet, "/teams", nil, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	h.advance(h.router.sessionTimeout + time.Minute)
+	if rec := h.do(http.MethodGet, "/teams", nil, adminHeaders(token)); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expired: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestPurgeEventRequiresSuperadmin(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	staff := h.createAdmin("staff", "staff")
+	superadmin := h.createAdmin("root", "superadmin")
+
+	path := fmt.Sprintf("/admin/events/%d/purge", fixture.EventID)
+	cases := []struct {
+		name     string
+		token    string
+		password string
+		status   int
+	}{
+		{name: "staff", token: staff, password: testAdminPassword, status: http.StatusForbidden},
+		{name: "wrong password", token: superadmin, password: "nope", status: http.StatusForbidden},
+		{name: "superadmin", token: superadmin, password: testAdminPassword, status: http.StatusNoContent},
+		{name: "already purged", token: superadmin, password: testAdminPassword, status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		rec := h.do(http.MethodPost, path, map[string]string{"password": tc.password}, adminHeaders(tc.token))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+}
@@ -395,7 +395,9 @@ func (rt *_router) assignPrizeWinner(w http.ResponseWriter, r *http.Request, ctx
 	}
 
 	_ = json.NewEncoder(w).Encode(prize)
-	ctx.Logger.WithFields(map[string]interface{}{"event_id": eventID, "prize_id": prizeID, "vote_id": payload.VoteID}).Info("prize winner assigned")
+	logger := ctx.Logger.WithFields(map[string]interface{}{"event_id": eventID, "prize_id": prizeID, "vote_id": payload.VoteID})
+	logger.Info("prize winner assigned")
+	rt.queuePrizeWinnerMail(logger, prize)
 }
 
 func (rt *_router) clearPrizeWinner(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
@@ -564,11 +566,12 @@ func (rt *_router) listAllSponsors(w http.ResponseWriter, r *http.Request, ctx r
 
 func (rt *_router) createSponsor(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
 	var payload struct {
-		Name     string `json:"name"`
-		LogoData string `json:"logo_data"`
-		LinkURL  string `json:"link_url"`
-		Position int    `json:"position"`
-		IsActive bool   `json:"is_active"`
+		Name      string `json:"name"`
+		LogoData  string `json:"logo_data"`
+		LogoAsset string `json:"logo_asset"`
+		LinkURL   string `json:"link_url"`
+		Position  int    `json:"position"`
+		IsActive  bool   `json:"is_active"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		ctx.Logger.WithError(err).Warn("invalid payload while creating sponsor")
@@ -576,18 +579,29 @@ func (rt *_router) createSponsor(w http.ResponseWriter, r *http.Request, ctx req
 		return
 	}
 
+	logoData, logoAsset, err := rt.resolveSponsorLogo(r.Context(), payload.LogoData, payload.LogoAsset)
+	if errors.Is(err, errUnknownSponsorAsset) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot store sponsor logo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	sponsor := database.Sponsor{
-		Name:     strings.TrimSpace(payload.Name),
-		LogoData: payload.LogoData,
-		LinkURL:  strings.TrimSpace(payload.LinkURL),
-		Position: payload.Position,
-		IsActive: payload.IsActive,
+		Name:      strings.TrimSpace(payload.Name),
+		LogoData:  logoData,
+		LogoAsset: logoAsset,
+		LinkURL:   strings.TrimSpace(payload.LinkURL),
+		Position:  payload.Position,
+		IsActive:  payload.IsActive,
 	}
 
 	id, err := rt.db.CreateSponsor(sponsor)
 	if err != nil {
 		switch {
-		case errors.Is(err, database.ErrInvalidSponsorData), errors.Is(err, database.ErrInvalidSponsorPos), errors.Is(err, database.ErrMaxSponsors):
+		case errors.Is(err, database.ErrInvalidSponsorData), errors.Is(err, database.ErrInvalidSponsorPos):
 			w.WriteHeader(http.StatusBadRequest)
 		default:
 			ctx.Logger.WithError(err).Error("cannot create sponsor")
@@ -612,11 +626,12 @@ func (rt *_router) updateSponsor(w http.ResponseWriter, r *http.Request, ctx req
 	}
 
 	var payload struct {
-		Name     string `json:"name"`
-		LogoData string `json:"logo_data"`
-		LinkURL  string `json:"link_url"`
-		Position int    `json:"position"`
-		IsActive bool   `json:"is_active"`
+		Name      string `json:"name"`
+		LogoData  string `json:"logo_data"`
+		LogoAsset string `json:"logo_asset"`
+		LinkURL   string `json:"link_url"`
+		Position  int    `json:"position"`
+		IsActive  bool   `json:"is_active"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		ctx.Logger.WithError(err).Warn("invalid payload while updating sponsor")
@@ -624,13 +639,24 @@ func (rt *_router) updateSponsor(w http.ResponseWriter, r *http.Request, ctx req
 		return
 	}
 
+	logoData, logoAsset, err := rt.resolveSponsorLogo(r.Context(), payload.LogoData, payload.LogoAsset)
+	if errors.Is(err, errUnknownSponsorAsset) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot store sponsor logo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
 	sponsor := database.Sponsor{
-		ID:       id,
-		Name:     strings.TrimSpace(payload.Name),
-		LogoData: payload.LogoData,
-		LinkURL:  strings.TrimSpace(payload.LinkURL),
-		Position: payload.Position,
-		IsActive: payload.IsActive,
+		ID:        id,
+		Name:      strings.TrimSpace(payload.Name),
+		LogoData:  logoData,
+		LogoAsset: logoAsset,
+		LinkURL:   strings.TrimSpace(payload.LinkURL),
+		Position:  payload.Position,
+		IsActive:  payload.IsActive,
 	}
 
 	if err := rt.db.UpdateSponsor(sponsor); err != nil {
@@ -667,6 +693,8 @@ func (rt *_router) deleteSponsor(w http.ResponseWriter, r *http.Request, ctx req
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	// The accounts of the sponsor are deleted with it
+	rt.revokeSponsorSessions(id, 0)
 
 	w.WriteHeader(http.StatusNoContent)
 	ctx.Logger.WithField("sponsor_id", id).Info("sponsor deleted")
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAssignPrizeWinner(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(2)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	first := h.voteIDForCode(fixture.EventID, h.mustVote(fixture, "device-1").Code)
+	second := h.voteIDForCode(fixture.EventID, h.mustVote(fixture, "device-2").Code)
+
+	other := h.seedEvent(0)
+	foreign := h.voteIDForCode(other.EventID, h.mustVote(other, "device-3").Code)
+
+	assignPath := func(prizeID int) string {
+		return fmt.Sprintf("/events/%d/prizes/%d/assign", fixture.EventID, prizeID)
+	}
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		voteID int
+		status int
+	}{
+		{name: "assign first prize", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[0]), voteID: first, status: http.StatusOK},
+		{name: "prize already assigned", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[0]), voteID: second, status: http.StatusConflict},
+		{name: "ticket already winning", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[1]), voteID: first, status: http.StatusConflict},
+		{name: "ticket from another event", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[1]), voteID: foreign, status: http.StatusBadRequest},
+		{name: "unknown prize", method: http.MethodPost, path: assignPath(9999), voteID: second, status: http.StatusNotFound},
+		{name: "missing vote", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[1]), voteID: 0, status: http.StatusBadRequest},
+		{name: "clear first prize", method: http.MethodDelete, path: fmt.Sprintf("/events/%d/prizes/%d/winner", fixture.EventID, fixture.PrizeIDs[0]), status: http.StatusNoContent},
+		{name: "reassign after clear", method: http.MethodPost, path: assignPath(fixture.PrizeIDs[1]), voteID: first, status: http.StatusOK},
+	}
+
+	// Cases are sequential on purpose: prize assignments are carried over between them.
+	for _, tc := range cases {
+		var body interface{}
+		if tc.method == http.MethodPost {
+			body = map[string]int{"vote_id": tc.voteID}
+		}
+		rec := h.do(tc.method, tc.path, body, adminHeaders(token))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.status, rec.Body.String())
+		}
+	}
+
+	tickets := h.do(http.MethodGet, fmt.Sprintf("/events/%d/tickets", fixture.EventID), nil, adminHeaders(token))
+	var remaining []struct {
+		VoteID int `json:"vote_id"`
+	}
+	h.decode(tickets, &remaining)
+	if len(remaining) != 1 || remaining[0].VoteID != second {
+		t.Fatalf("remaining tickets = %+v, want only vote %d", remaining, second)
+	}
+}
@@ -16,13 +16,24 @@ func (rt *_router) Handler() chi.Router {
 	rt.router.Get("/liveness", rt.liveness)
 
 	rt.router.Post("/vote", rt.wrap(rt.postVote))
+	rt.router.Post("/vote/contact", rt.wrap(rt.saveVoteContact))
 	// Admin CRUD routes
 	rt.router.Post("/admin/login", rt.wrap(rt.adminLogin))
+	rt.router.Post("/sponsor-portal/login", rt.wrap(rt.sponsorLogin))
+	rt.router.Get("/sponsor-portal/me", rt.wrapSponsor(rt.getSponsorProfile))
+	rt.router.Get("/sponsor-portal/report", rt.wrapSponsor(rt.getSponsorPortalReport))
 
 	rt.router.Get("/public/players", rt.wrap(rt.listPublicPlayers))
 	rt.router.Get("/shop/products", rt.wrap(rt.listShopProducts))
 	rt.router.Get("/shop/products/{id}", rt.wrap(rt.getShopProduct))
+	rt.router.Get("/shop/categories", rt.wrap(rt.listShopCategories))
+	rt.router.Get("/shop/images/{hash}", rt.wrap(rt.getShopImage))
 	rt.router.Post("/shop/checkout", rt.wrap(rt.checkoutShopOrder))
+	rt.router.Post("/shop/reservations", rt.wrap(rt.reserveShopStock))
+	rt.router.Delete("/shop/reservations/{id}", rt.wrap(rt.releaseShopReservation))
+	rt.router.Post("/shop/payments/webhook", rt.wrap(rt.shopPaymentWebhook))
+	rt.router.Get("/payments/fake/{id}", rt.wrap(rt.fakeCheckoutPage))
+	rt.router.Post("/payments/fake/{id}", rt.wrap(rt.completeFakeCheckout))
 
 	rt.router.Get("/teams", rt.wrapAdmin(rt.listTeams))
 	rt.router.Post("/teams", rt.wrapAdmin(rt.createTeam))
@@ -36,10 +47,12 @@ func (rt *_router) Handler() chi.Router {
 
 	rt.router.Get("/active-event", rt.wrap(rt.getActiveEvent))
 	rt.router.Get("/sponsors", rt.wrap(rt.listPublicSponsors))
+	rt.router.Get("/sponsor-assets/{hash}/{rendition}", rt.wrap(rt.getSponsorAsset))
 
 	rt.router.Post("/events/{eventId}/sponsors/session", rt.wrap(rt.recordSponsorSessionEvent))
 	rt.router.Post("/events/{eventId}/sponsors/exposures", rt.wrap(rt.recordSponsorExposureEvent))
 	rt.router.Post("/events/{eventId}/sponsors/{sponsorId}/click", rt.wrap(rt.recordSponsorClick))
+	rt.router.Get("/go/{eventId}/{sponsorId}", rt.wrap(rt.redirectSponsorClick))
 
 	rt.router.Get("/events", rt.wrapAdmin(rt.listEvents))
 	rt.router.Post("/events", rt.wrapAdmin(rt.createEvent))
@@ -61,17 +74,55 @@ func (rt *_router) Handler() chi.Router {
 	rt.router.Get("/events/{eventId}/selfies/me", rt.wrap(rt.getOwnSelfie))
 	rt.router.Get("/events/{eventId}/selfies/approved", rt.wrap(rt.listApprovedSelfies))
 	rt.router.Get("/events/{eventId}/selfies/{selfieId}/image", rt.wrap(rt.getSelfieImage))
+	rt.router.Get("/events/{eventId}/selfies/{selfieId}/thumbnail", rt.wrap(rt.getSelfieThumbnail))
+	rt.router.Get("/events/{eventId}/selfies/{selfieId}/branded", rt.wrap(rt.getBrandedSelfie))
+	rt.router.Get("/events/{eventId}/selfie-contest", rt.wrap(rt.getSelfieContest))
+	rt.router.Post("/events/{eventId}/selfie-contest/votes", rt.wrap(rt.postSelfieContestVote))
+	rt.router.Get("/events/{eventId}/selfie-contest/winner", rt.wrap(rt.getSelfieContestWinner))
 	rt.router.Get("/events/{eventId}/reaction-test", rt.wrap(rt.getReactionTestStatus))
 	rt.router.Post("/events/{eventId}/reaction-test", rt.wrap(rt.postReactionTestResult))
 	rt.router.Post("/events/{eventId}/feedback", rt.wrap(rt.submitEventFeedback))
 	rt.router.Get("/admin/events/history", rt.wrapAdmin(rt.getEventHistory))
 	rt.router.Get("/admin/events/history/{eventId}/report", rt.wrapAdmin(rt.downloadEventHistoryReport))
 	rt.router.Get("/admin/events/{eventId}/sponsors/analytics", rt.wrapAdmin(rt.getSponsorAnalytics))
+	rt.router.Get("/admin/sponsors/telemetry", rt.wrapAdmin(rt.getTelemetryMetrics))
 	rt.router.Post("/admin/events/{id}/purge", rt.wrapAdmin(rt.purgeEvent))
+	rt.router.Post("/admin/events/{id}/archive", rt.wrapAdmin(rt.archiveEvent))
+	rt.router.Post("/admin/events/{id}/restore", rt.wrapAdmin(rt.restoreArchivedEvent))
+	rt.router.Get("/admin/events/{id}/export", rt.wrapAdmin(rt.exportEventData))
 	rt.router.Get("/admin/events/{eventId}/selfies", rt.wrapAdmin(rt.listAdminSelfies))
+	rt.router.Get("/admin/events/{eventId}/selfies/queue", rt.wrapAdmin(rt.getSelfieQueue))
+	rt.router.Get("/admin/events/{eventId}/selfies/album", rt.wrapAdmin(rt.downloadSelfieAlbum))
+	rt.router.Post("/admin/events/{eventId}/selfies/claim", rt.wrapAdmin(rt.claimSelfies))
+	rt.router.Delete("/admin/events/{eventId}/selfies/claim", rt.wrapAdmin(rt.releaseSelfieClaims))
+	rt.router.Post("/admin/events/{eventId}/selfies/bulk", rt.wrapAdmin(rt.bulkModerateSelfies))
+	rt.router.Get("/admin/events/{eventId}/selfie-frame", rt.wrapAdmin(rt.getAdminSelfieFrame))
+	rt.router.Put("/admin/events/{eventId}/selfie-frame", rt.wrapAdmin(rt.updateAdminSelfieFrame))
+	rt.router.Delete("/admin/events/{eventId}/selfie-frame", rt.wrapAdmin(rt.deleteAdminSelfieFrame))
+	rt.router.Put("/admin/events/{eventId}/selfie-contest", rt.wrapAdmin(rt.updateAdminSelfieContest))
+	rt.router.Post("/admin/events/{eventId}/selfie-contest/winner", rt.wrapAdmin(rt.announceSelfieContestWinner))
+	rt.router.Delete("/admin/events/{eventId}/selfie-contest/winner", rt.wrapAdmin(rt.clearSelfieContestWinner))
 	rt.router.Put("/admin/selfies/{selfieId}", rt.wrapAdmin(rt.updateSelfieModeration))
 	rt.router.Delete("/admin/selfies/{selfieId}", rt.wrapAdmin(rt.deleteSelfie))
 	rt.router.Get("/admin/selfies/{selfieId}/image", rt.wrapAdmin(rt.getAdminSelfieImage))
+	rt.router.Get("/admin/selfies/{selfieId}/thumbnail", rt.wrapAdmin(rt.getAdminSelfieThumbnail))
+	rt.router.Get("/admin/events/{eventId}/screen", rt.wrapAdmin(rt.getAdminScreenPlaylist))
+	rt.router.Put("/admin/events/{eventId}/screen", rt.wrapAdmin(rt.updateAdminScreenPlaylist))
+	rt.router.Get("/admin/backup/snapshot", rt.wrapAdmin(rt.downloadDatabaseSnapshot))
+	rt.router.Post("/admin/privacy/erasures", rt.wrapAdmin(rt.erasePersonalData))
+	rt.router.Get("/admin/privacy/erasures", rt.wrapAdmin(rt.listErasureReceipts))
+	rt.router.Post("/admin/import/{kind}", rt.wrapAdmin(rt.importRoster))
+	rt.router.Get("/admin/text-filter", rt.wrapAdmin(rt.getAdminTextFilter))
+	rt.router.Put("/admin/text-filter/actions", rt.wrapAdmin(rt.updateAdminTextFilterActions))
+	rt.router.Post("/admin/text-filter/words", rt.wrapAdmin(rt.addAdminTextFilterWord))
+	rt.router.Delete("/admin/text-filter/words/{id}", rt.wrapAdmin(rt.deleteAdminTextFilterWord))
+	rt.router.Post("/admin/text-filter/check", rt.wrapAdmin(rt.checkAdminText))
+	rt.router.Get("/admin/text-filter/flags", rt.wrapAdmin(rt.listFlaggedTexts))
+	rt.router.Put("/admin/text-filter/flags/{target}/{id}", rt.wrapAdmin(rt.reviewFlaggedText))
+
+	rt.router.Get("/screen/events/{eventId}/playlist", rt.wrapScreen(rt.getScreenPlaylist))
+	rt.router.Get("/screen/events/{eventId}/stream", rt.wrapScreen(rt.streamScreenPlaylist))
+	rt.router.Post("/screen/events/{eventId}/selfies/{selfieId}/played", rt.wrapScreen(rt.recordScreenPlay))
 
 	rt.router.Get("/votes", rt.wrapAdmin(rt.listVotes))
 	rt.router.Delete("/votes/{id}", rt.wrapAdmin(rt.deleteVote))
@@ -81,10 +132,41 @@ func (rt *_router) Handler() chi.Router {
 	rt.router.Put("/admins/{id}", rt.wrapAdmin(rt.updateAdmin))
 	rt.router.Delete("/admins/{id}", rt.wrapAdmin(rt.deleteAdmin))
 
+	rt.router.Get("/admin/shop/products", rt.wrapAdmin(rt.listAdminShopProducts))
+	rt.router.Post("/admin/shop/products", rt.wrapAdmin(rt.createShopProduct))
+	rt.router.Put("/admin/shop/products/{id}", rt.wrapAdmin(rt.updateShopProduct))
+	rt.router.Delete("/admin/shop/products/{id}", rt.wrapAdmin(rt.deleteShopProduct))
+	rt.router.Post("/admin/shop/products/{id}/variants", rt.wrapAdmin(rt.createShopVariant))
+	rt.router.Put("/admin/shop/products/{id}/variants/{variantId}", rt.wrapAdmin(rt.updateShopVariant))
+	rt.router.Delete("/admin/shop/products/{id}/variants/{variantId}", rt.wrapAdmin(rt.deleteShopVariant))
+	rt.router.Get("/admin/shop/products/{id}/stock", rt.wrapAdmin(rt.getShopStock))
+	rt.router.Post("/admin/shop/products/{id}/stock", rt.wrapAdmin(rt.adjustShopStock))
+	rt.router.Post("/admin/shop/images", rt.wrapAdmin(rt.uploadShopImage))
+	rt.router.Get("/admin/shop/orders", rt.wrapAdmin(rt.listShopOrders))
+	rt.router.Get("/admin/shop/orders/{id}", rt.wrapAdmin(rt.getShopOrder))
+	rt.router.Post("/admin/shop/orders/{id}/status", rt.wrapAdmin(rt.updateShopOrderStatus))
+	rt.router.Put("/admin/shop/orders/{id}/pickup-event", rt.wrapAdmin(rt.setShopOrderPickupEvent))
+	rt.router.Get("/admin/shop/fulfilment", rt.wrapAdmin(rt.getShopFulfilment))
+	rt.router.Get("/admin/shop/fulfilment/export", rt.wrapAdmin(rt.exportShopFulfilment))
+	rt.router.Get("/admin/mail/outbox", rt.wrapAdmin(rt.listMailOutbox))
+	rt.router.Post("/admin/mail/outbox/{id}/retry", rt.wrapAdmin(rt.retryMail))
+
 	rt.router.Get("/admin/sponsors", rt.wrapAdmin(rt.listAllSponsors))
 	rt.router.Post("/admin/sponsors", rt.wrapAdmin(rt.createSponsor))
 	rt.router.Put("/admin/sponsors/{id}", rt.wrapAdmin(rt.updateSponsor))
 	rt.router.Delete("/admin/sponsors/{id}", rt.wrapAdmin(rt.deleteSponsor))
+	rt.router.Post("/admin/sponsor-assets", rt.wrapAdmin(rt.uploadSponsorAsset))
+	rt.router.Get("/admin/sponsors/{id}/report", rt.wrapAdmin(rt.getAdminSponsorReport))
+	rt.router.Get("/admin/sponsors/{id}/accounts", rt.wrapAdmin(rt.listSponsorAccounts))
+	rt.router.Post("/admin/sponsors/{id}/accounts", rt.wrapAdmin(rt.createSponsorAccount))
+	rt.router.Delete("/admin/sponsor-accounts/{accountId}", rt.wrapAdmin(rt.deleteSponsorAccount))
+	rt.router.Get("/admin/sponsors/{id}/campaigns", rt.wrapAdmin(rt.listSponsorCampaigns))
+	rt.router.Post("/admin/sponsors/{id}/campaigns", rt.wrapAdmin(rt.createSponsorCampaign))
+	rt.router.Put("/admin/sponsor-campaigns/{campaignId}", rt.wrapAdmin(rt.updateSponsorCampaign))
+	rt.router.Delete("/admin/sponsor-campaigns/{campaignId}", rt.wrapAdmin(rt.deleteSponsorCampaign))
+	rt.router.Post("/admin/sponsor-campaigns/{campaignId}/creatives", rt.wrapAdmin(rt.createSponsorCreative))
+	rt.router.Put("/admin/sponsor-creatives/{creativeId}", rt.wrapAdmin(rt.updateSponsorCreative))
+	rt.router.Delete("/admin/sponsor-creatives/{creativeId}", rt.wrapAdmin(rt.deleteSponsorCreative))
 
 	return rt.router
 }
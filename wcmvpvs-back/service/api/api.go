@@ -38,10 +38,15 @@ package api
 
 import (
 	"errors"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/payments"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/textfilter"
 	"github.com/go-chi/chi/v5"
 	"github.com/sirupsen/logrus"
 )
@@ -59,6 +64,61 @@ type Config struct {
 
 	// TicketValidationBaseURL is the public base URL used to generate ticket validation links
 	TicketValidationBaseURL string
+
+	// BackupDir is the directory where periodic database backups are written. Empty disables scheduled backups
+	BackupDir string
+
+	// BackupInterval is the time between two scheduled backups
+	BackupInterval time.Duration
+
+	// BackupRetention is the number of scheduled backups to keep. Zero keeps all of them
+	BackupRetention int
+
+	// ArchiveGracePeriod is how long an archived event can be restored before its details are purged
+	ArchiveGracePeriod time.Duration
+
+	// Retention is the retention policy for personal data
+	Retention RetentionPolicy
+
+	// Blobs is where selfie images are stored
+	Blobs blobstore.Store
+
+	// BlobURLExpiry is the validity of the presigned URLs used to serve selfie images, when Blobs supports them.
+	// Zero always proxies the images
+	BlobURLExpiry time.Duration
+
+	// ScreenAPIKey authenticates the arena screen on the screen feed endpoints. Empty allows only admin sessions
+	ScreenAPIKey string
+
+	// TextFilterLanguages are the languages of the built-in word lists of the text filter. Empty uses all of them
+	TextFilterLanguages []string
+
+	// SponsorUTM are the UTM parameters appended to the sponsor links by the click redirect
+	SponsorUTM SponsorUTM
+
+	// ShopReservationTTL is how long a cart reservation holds the stock. Zero uses the default of 15 minutes
+	ShopReservationTTL time.Duration
+
+	// Payments collects the payments of the shop orders. Nil disables online payments: the orders are paid at the desk
+	// and marked as paid by the staff
+	Payments payments.Provider
+
+	// ShopPaymentTimeout is how long an order waits for its payment before being cancelled. Zero uses the default of
+	// one hour
+	ShopPaymentTimeout time.Duration
+
+	// ShopPublicURL is the public base URL of the shop pages, where the payment provider sends the customers back.
+	// Empty uses the origin of the checkout request
+	ShopPublicURL string
+
+	// Mail sends the transactional emails, like the order confirmations. Nil disables them
+	Mail mailer.Sender
+
+	// MailFrom is the sender address of the emails
+	MailFrom string
+
+	// MailMaxAttempts is how many times a message is tried before giving up. Zero uses the default of 8
+	MailMaxAttempts int
 }
 
 // Router is the package API interface representing an API handler builder
@@ -79,22 +139,92 @@ func New(cfg Config) (Router, error) {
 	if cfg.Database == nil {
 		return nil, errors.New("database is required")
 	}
+	if cfg.Blobs == nil {
+		return nil, errors.New("blob store is required")
+	}
 
 	// Create a new router where we will register HTTP endpoints. The server will pass requests to this router to be
 	// handled.
 	router := chi.NewRouter()
 
-	return &_router{
+	rt := &_router{
 		router:                  router,
 		baseLogger:              cfg.Logger,
 		db:                      cfg.Database,
 		VoteSecret:              cfg.VoteSecret,
 		ticketValidationBaseURL: cfg.TicketValidationBaseURL,
 		adminSessions:           map[string]adminSession{},
+		sponsorSessions:         map[string]sponsorSession{},
 		sessionTimeout:          12 * time.Hour,
 		voteRateByDevice:        map[string][]time.Time{},
 		voteRateByIP:            map[string][]time.Time{},
-	}, nil
+		contestVoteRateByDevice: map[string][]time.Time{},
+		contestVoteRateByIP:     map[string][]time.Time{},
+		shopReservationRateByIP: map[string][]time.Time{},
+		backupDir:               cfg.BackupDir,
+		backupInterval:          cfg.BackupInterval,
+		backupRetention:         cfg.BackupRetention,
+		archiveGracePeriod:      cfg.ArchiveGracePeriod,
+		retention:               cfg.Retention,
+		blobs:                   cfg.Blobs,
+		blobURLExpiry:           cfg.BlobURLExpiry,
+		screenAPIKey:            strings.TrimSpace(cfg.ScreenAPIKey),
+		textFilterLangs:         cfg.TextFilterLanguages,
+		sponsorUTM:              cfg.SponsorUTM,
+		shopReservationTTL:      cfg.ShopReservationTTL,
+		payments:                cfg.Payments,
+		shopPaymentTimeout:      cfg.ShopPaymentTimeout,
+		shopPublicURL:           cfg.ShopPublicURL,
+		mail:                    cfg.Mail,
+		mailFrom:                strings.TrimSpace(cfg.MailFrom),
+		mailMaxAttempts:         cfg.MailMaxAttempts,
+		sponsorClickSeen:        map[string]time.Time{},
+		brandingCalls:           map[string]*brandingCall{},
+		telemetry:               newSponsorTelemetry(cfg.Database, cfg.Logger),
+		jobsStop:                make(chan struct{}),
+	}
+	if rt.archiveGracePeriod <= 0 {
+		rt.archiveGracePeriod = defaultArchiveGracePeriod
+	}
+	if rt.shopReservationTTL <= 0 {
+		rt.shopReservationTTL = defaultShopReservationTTL
+	}
+	if rt.shopPaymentTimeout <= 0 {
+		rt.shopPaymentTimeout = defaultShopPaymentTimeout
+	}
+	if rt.mailMaxAttempts <= 0 {
+		rt.mailMaxAttempts = defaultMailMaxAttempts
+	}
+	if rt.mail != nil {
+		if _, err := mailer.ParseAddress(rt.mailFrom); err != nil {
+			return nil, errors.New("a valid mail sender address is required")
+		}
+	}
+	rt.jobsWG.Add(1)
+	go func() {
+		defer rt.jobsWG.Done()
+		rt.telemetry.run(rt.jobsStop)
+	}()
+	rt.startBackgroundJob("event archive purge", archivePurgeInterval, rt.purgeExpiredArchives)
+	rt.startBackgroundJob("sponsor plan pruning", sponsorPlanPruneInterval, rt.pruneSponsorPlans)
+	rt.startBackgroundJob("sponsor click pruning", sponsorClickPruneInterval, rt.pruneSponsorClicks)
+	rt.startBackgroundJob(sponsorLogoMigrationTask, sponsorLogoMigrationInterval, rt.migrateSponsorLogos)
+	if rt.payments != nil {
+		rt.startBackgroundJob("shop payment expiry", shopPaymentExpiryInterval, rt.expireShopPayments)
+	}
+	if rt.mail != nil {
+		rt.startBackgroundJob("mail outbox", mailOutboxInterval, rt.sendDueMail)
+	}
+	if rt.retention.enabled() {
+		rt.startBackgroundJob("retention policy", retentionJobInterval, rt.applyRetention)
+	}
+	if rt.backupDir != "" && rt.backupInterval > 0 {
+		rt.startBackgroundJob("database backup", rt.backupInterval, func() error {
+			_, err := rt.runScheduledBackup()
+			return err
+		})
+	}
+	return rt, nil
 }
 
 type _router struct {
@@ -114,9 +244,63 @@ type _router struct {
 	adminSessions   map[string]adminSession
 	sessionTimeout  time.Duration
 
+	sponsorSessionsMu sync.RWMutex
+	sponsorSessions   map[string]sponsorSession
+
 	voteRateMu       sync.Mutex
 	voteRateByDevice map[string][]time.Time
 	voteRateByIP     map[string][]time.Time
+
+	// contestVoteRateByDevice and contestVoteRateByIP limit the votes of the selfie contest, under voteRateMu
+	contestVoteRateByDevice map[string][]time.Time
+	contestVoteRateByIP     map[string][]time.Time
+
+	// shopReservationRateByIP limits the stock reservations of the shop, under voteRateMu
+	shopReservationRateByIP map[string][]time.Time
+
+	sponsorUTM SponsorUTM
+	telemetry  *sponsorTelemetry
+
+	// sponsorClickSeen holds the time of the last click of a device on a sponsor, to ignore the repeated ones
+	sponsorClickMu   sync.Mutex
+	sponsorClickSeen map[string]time.Time
+
+	backupDir       string
+	backupInterval  time.Duration
+	backupRetention int
+
+	archiveGracePeriod time.Duration
+
+	retention RetentionPolicy
+
+	shopReservationTTL time.Duration
+
+	payments           payments.Provider
+	shopPaymentTimeout time.Duration
+	shopPublicURL      string
+
+	mail            mailer.Sender
+	mailFrom        string
+	mailMaxAttempts int
+
+	blobs         blobstore.Store
+	blobURLExpiry time.Duration
+
+	screenAPIKey string
+	screenHub    screenHub
+
+	// brandingCalls are the renders of branded selfies in progress, by selfie, frame revision and image
+	brandingMu    sync.Mutex
+	brandingCalls map[string]*brandingCall
+
+	// textFilter is built on first use, and dropped when the admins change the word lists
+	textFilterLangs []string
+	textFilterMu    sync.Mutex
+	textFilter      *textfilter.Filter
+
+	jobsStop     chan struct{}
+	jobsStopOnce sync.Once
+	jobsWG       sync.WaitGroup
 }
 
 type adminSession struct {
@@ -125,3 +309,10 @@ type adminSession struct {
 	Role      string
 	ExpiresAt time.Time
 }
+
+type sponsorSession struct {
+	AccountID int
+	SponsorID int
+	Username  string
+	ExpiresAt time.Time
+}
@@ -0,0 +1,303 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testVoteSecret    = "test-secret"
+	testAdminUsername = "admin"
+	testAdminPassword = "password"
+)
+
+// testHarness bundles a fully wired API handler backed by a throwaway SQLite database. Tests talk to the handler
+// through plain HTTP requests, exactly like the frontend does.
+type testHarness struct {
+	t       *testing.T
+	db      database.AppDatabase
+	router  *_router
+	handler http.Handler
+}
+
+// newTestHarness creates a new SQLite database in a temporary directory, builds the API router on top of it and
+// moves the working directory to the same temporary directory so that files written by handlers (e.g. selfies) never
+// end up in the source tree. The fixed time is reset at the end of the test.
+func newTestHarness(t *testing.T) *testHarness {
+	t.Helper()
+
+	dir := t.TempDir()
+	dbconn, err := sql.Open("sqlite3", "file:"+filepath.Join(dir, "test.db")+"?_foreign_keys=on&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("cannot open test database: %v", err)
+	}
+	t.Cleanup(func() { _ = dbconn.Close() })
+
+	db, err := database.New(dbconn)
+	if err != nil {
+		t.Fatalf("cannot initialize test database: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("cannot read working directory: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("cannot move to temporary directory: %v", err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	blobs, err := blobstore.NewLocal(filepath.Join(dir, "blobs"))
+	if err != nil {
+		t.Fatalf("cannot create blob store: %v", err)
+	}
+
+	apirouter, err := New(Config{
+		Logger:                  logger,
+		Database:                db,
+		VoteSecret:              testVoteSecret,
+		TicketValidationBaseURL: "https://mvp.example.com",
+		Blobs:                   blobs,
+	})
+	if err != nil {
+		t.Fatalf("cannot create API router: %v", err)
+	}
+	t.Cleanup(func() { _ = apirouter.Close() })
+
+	globaltime.FixedTime = time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+	t.Cleanup(func() { globaltime.FixedTime = time.Time{} })
+
+	return &testHarness{
+		t:       t,
+		db:      db,
+		router:  apirouter.(*_router),
+		handler: apirouter.Handler(),
+	}
+}
+
+// advance moves the fixed clock forward.
+func (h *testHarness) advance(d time.Duration) {
+	globaltime.FixedTime = globaltime.FixedTime.Add(d)
+}
+
+// do performs a request against the handler. When body is not nil and not already a []byte, it is JSON-encoded.
+func (h *testHarness) do(method, path string, body interface{}, headers map[string]string) *httptest.ResponseRecorder {
+	h.t.Helper()
+
+	var reader io.Reader
+	switch value := body.(type) {
+	case nil:
+	case []byte:
+		reader = bytes.NewReader(value)
+	default:
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			h.t.Fatalf("cannot encode request body: %v", err)
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	rec := httptest.NewRecorder()
+	h.handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decode unmarshals the JSON body of a recorded response.
+func (h *testHarness) decode(rec *httptest.ResponseRecorder, target interface{}) {
+	h.t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), target); err != nil {
+		h.t.Fatalf("cannot decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// createAdmin stores an admin with the given role and returns a valid bearer token for it.
+func (h *testHarness) createAdmin(username, role string) string {
+	h.t.Helper()
+
+	if _, err := h.db.CreateAdmin(database.Admin{
+		Username:     username,
+		PasswordHash: hashAdminPassword(testAdminPassword),
+		Role:         role,
+	}); err != nil {
+		h.t.Fatalf("cannot create admin: %v", err)
+	}
+	return h.login(username)
+}
+
+// login opens a new admin session and returns its bearer token.
+func (h *testHarness) login(username string) string {
+	h.t.Helper()
+
+	rec := h.do(http.MethodPost, "/admin/login", map[string]string{
+		"username": username,
+		"password": testAdminPassword,
+	}, nil)
+	if rec.Code != http.StatusOK {
+		h.t.Fatalf("admin login failed with status %d", rec.Code)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	h.decode(rec, &resp)
+	return resp.Token
+}
+
+// adminHeaders returns the authorization header for the given token.
+func adminHeaders(token string) map[string]string {
+	return map[string]string{"Authorization": "Bearer " + token}
+}
+
+// testFixture contains the identifiers of the data seeded by seedEvent.
+type testFixture struct {
+	EventID  int
+	PlayerID int
+	PrizeIDs []int
+}
+
+// seedEvent creates two teams, one player and an active event with the requested number of prizes.
+func (h *testHarness) seedEvent(prizes int) testFixture {
+	h.t.Helper()
+
+	home, err := h.db.CreateTeam("Wearing Cash")
+	if err != nil {
+		h.t.Fatalf("cannot create team: %v", err)
+	}
+	away, err := h.db.CreateTeam("Avversari")
+	if err != nil {
+		h.t.Fatalf("cannot create team: %v", err)
+	}
+	playerID, err := h.db.CreatePlayer(database.Player{
+		FirstName:    "Mario",
+		LastName:     "Rossi",
+		Role:         "Schiacciatore",
+		JerseyNumber: 7,
+		TeamID:       home,
+	})
+	if err != nil {
+		h.t.Fatalf("cannot create player: %v", err)
+	}
+
+	eventPrizes := make([]database.EventPrize, 0, prizes)
+	for i := 1; i <= prizes; i++ {
+		eventPrizes = append(eventPrizes, database.EventPrize{Name: "Premio", Position: i})
+	}
+	eventID, err := h.db.CreateEvent(database.Event{
+		Team1ID:       home,
+		Team2ID:       away,
+		StartDateTime: "2024-06-01T20:30:00Z",
+		Location:      "Palazzetto",
+		ShowSelfie:    true,
+		Prizes:        eventPrizes,
+	})
+	if err != nil {
+		h.t.Fatalf("cannot create event: %v", err)
+	}
+	if err := h.db.SetActiveEvent(eventID); err != nil {
+		h.t.Fatalf("cannot activate event: %v", err)
+	}
+
+	fixture := testFixture{EventID: eventID, PlayerID: playerID}
+	stored, err := h.db.ListEventPrizes(eventID)
+	if err != nil {
+		h.t.Fatalf("cannot list prizes: %v", err)
+	}
+	for _, prize := range stored {
+		fixture.PrizeIDs = append(fixture.PrizeIDs, prize.ID)
+	}
+	return fixture
+}
+
+// voteResult mirrors the JSON returned by postVote.
+type voteResult struct {
+	Code      string `json:"code"`
+	Signature string `json:"signature"`
+	QRData    string `json:"qr_data"`
+	Message   string `json:"message"`
+}
+
+// vote submits a vote for the fixture event from the given device and IP address.
+func (h *testHarness) vote(fixture testFixture, deviceID, ip string) *httptest.ResponseRecorder {
+	h.t.Helper()
+	return h.do(http.MethodPost, "/vote", map[string]interface{}{
+		"player_id": fixture.PlayerID,
+		"event_id":  fixture.EventID,
+		"device_id": deviceID,
+	}, map[string]string{"X-Forwarded-For": ip})
+}
+
+// mustVote submits a vote and fails the test if it is not accepted.
+func (h *testHarness) mustVote(fixture testFixture, deviceID string) voteResult {
+	h.t.Helper()
+	rec := h.vote(fixture, deviceID, "10.0.0.1")
+	if rec.Code != http.StatusOK {
+		h.t.Fatalf("vote for %q failed with status %d: %s", deviceID, rec.Code, rec.Body.String())
+	}
+	var result voteResult
+	h.decode(rec, &result)
+	return result
+}
+
+// voteIDForCode looks up the vote row matching a ticket code.
+func (h *testHarness) voteIDForCode(eventID int, code string) int {
+	h.t.Helper()
+	ticket, err := h.db.ValidateTicket(eventID, code)
+	if err != nil {
+		h.t.Fatalf("cannot look up ticket %s: %v", code, err)
+	}
+	return ticket.VoteID
+}
+
+// approvedSelfie uploads a selfie from the device and approves it, returning its id.
+func (h *testHarness) approvedSelfie(fixture testFixture, deviceID, token string) int {
+	h.t.Helper()
+	upload := map[string]string{"image_base64": testPNGDataURL(h.t)}
+	rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": deviceID})
+	if rec.Code != http.StatusCreated {
+		h.t.Fatalf("selfie upload: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var selfie selfieResponse
+	h.decode(rec, &selfie)
+	if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", selfie.ID), map[string]bool{"show_on_screen": true}, adminHeaders(token)); rec.Code != http.StatusOK {
+		h.t.Fatalf("approve selfie: status = %d", rec.Code)
+	}
+	return selfie.ID
+}
+
+// issueSponsorPlan asks the sponsors of the event for the device and writes the plan issued, returning its id.
+func (h *testHarness) issueSponsorPlan(fixture testFixture, deviceID string) string {
+	h.t.Helper()
+	rec := h.do(http.MethodGet, fmt.Sprintf("/sponsors?event_id=%d&device_id=%s", fixture.EventID, deviceID), nil, nil)
+	if rec.Code != http.StatusOK {
+		h.t.Fatalf("sponsor plan: status = %d", rec.Code)
+	}
+	var schedule sponsorSchedule
+	h.decode(rec, &schedule)
+	h.router.telemetry.flush()
+	return schedule.PlanID
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"time"
+)
+
+// startBackgroundJob runs job every interval in its own goroutine, until Close is called. Errors are logged: a
+// failed run is retried at the next tick.
+func (rt *_router) startBackgroundJob(name string, interval time.Duration, job func() error) {
+	rt.jobsWG.Add(1)
+	go func() {
+		defer rt.jobsWG.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-rt.jobsStop:
+				return
+			case <-ticker.C:
+				if err := job(); err != nil {
+					rt.baseLogger.WithError(err).WithField("job", name).Error("background job failed")
+				}
+			}
+		}
+	}()
+}
+
+// stopBackgroundJobs stops every background job, waiting for the running ones to complete.
+func (rt *_router) stopBackgroundJobs() {
+	rt.jobsStopOnce.Do(func() {
+		close(rt.jobsStop)
+	})
+	rt.jobsWG.Wait()
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+const (
+	backupFilePrefix = "mvpvs-"
+	backupFileSuffix = ".db"
+	backupTimeLayout = "20060102-150405"
+)
+
+// runScheduledBackup writes a new backup into the backup directory and then applies the retention policy.
+func (rt *_router) runScheduledBackup() (string, error) {
+	if err := os.MkdirAll(rt.backupDir, 0o755); err != nil {
+		return "", fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	name := backupFilePrefix + globaltime.Now().UTC().Format(backupTimeLayout) + backupFileSuffix
+	target := filepath.Join(rt.backupDir, name)
+	// The backup is written under a temporary name so that a crash never leaves a half-written file that looks
+	// like a valid backup.
+	partial := target + ".partial"
+	_ = os.Remove(partial)
+	if err := rt.db.Backup(partial); err != nil {
+		_ = os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		_ = os.Remove(partial)
+		return "", err
+	}
+	rt.baseLogger.WithField("file", target).Info("database backup completed")
+
+	if err := pruneBackups(rt.backupDir, rt.backupRetention); err != nil {
+		rt.baseLogger.WithError(err).Warn("cannot apply backup retention")
+	}
+	return target, nil
+}
+
+// pruneBackups keeps only the newest `keep` backups in dir. Files not created by the scheduler are left alone.
+// A non-positive keep disables the pruning.
+func pruneBackups(dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupFilePrefix) || !strings.HasSuffix(name, backupFileSuffix) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, backupFilePrefix), backupFileSuffix)); err != nil {
+			continue
+		}
+		backups = append(backups, name)
+	}
+	if len(backups) <= keep {
+		return nil
+	}
+
+	// The timestamp layout sorts lexicographically, newest last.
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// downloadDatabaseSnapshot streams a consistent copy of the whole database. Only superadmins can download it, since
+// it contains admin credentials and device identifiers.
+func (rt *_router) downloadDatabaseSnapshot(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// Both the backup and the download of a real database take longer than the write timeout
+	clearWriteDeadline(w, ctx.Logger)
+
+	tmpDir, err := os.MkdirTemp("", "mvpvs-snapshot-")
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot create snapshot directory")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	snapshotPath := filepath.Join(tmpDir, "snapshot.db")
+	if err := rt.db.Backup(snapshotPath); err != nil {
+		ctx.Logger.WithError(err).Error("cannot create database snapshot")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	file, err := os.Open(snapshotPath)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot open database snapshot")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer func() { _ = file.Close() }()
+	info, err := file.Stat()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot stat database snapshot")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	filename := backupFilePrefix + globaltime.Now().UTC().Format(backupTimeLayout) + backupFileSuffix
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.Copy(w, file); err != nil {
+		ctx.Logger.WithError(err).Warn("cannot write database snapshot response")
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"database/sql"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestDatabaseSnapshot(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	h.mustVote(fixture, "device-1")
+	staff := h.createAdmin("staff", "staff")
+	superadmin := h.createAdmin("root", "superadmin")
+
+	if rec := h.do(http.MethodGet, "/admin/backup/snapshot", nil, adminHeaders(staff)); rec.Code != http.StatusForbidden {
+		t.Fatalf("staff: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	rec := h.do(http.MethodGet, "/admin/backup/snapshot", nil, adminHeaders(superadmin))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	snapshot := filepath.Join(t.TempDir(), "snapshot.db")
+	if err := os.WriteFile(snapshot, rec.Body.Bytes(), 0o644); err != nil {
+		t.Fatalf("cannot write snapshot: %v", err)
+	}
+	if err := database.VerifyIntegrity(snapshot); err != nil {
+		t.Fatalf("snapshot is not valid: %v", err)
+	}
+
+	conn, err := sql.Open("sqlite3", snapshot)
+	if err != nil {
+		t.Fatalf("cannot open snapshot: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+	var votes int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM votes`).Scan(&votes); err != nil {
+		t.Fatalf("cannot count votes: %v", err)
+	}
+	if votes != 1 {
+		t.Fatalf("snapshot votes = %d, want 1", votes)
+	}
+}
+
+func TestScheduledBackupRetention(t *testing.T) {
+	h := newTestHarness(t)
+	h.router.backupDir = filepath.Join(t.TempDir(), "backups")
+	h.router.backupRetention = 2
+
+	var created []string
+	for i := 0; i < 3; i++ {
+		path, err := h.router.runScheduledBackup()
+		if err != nil {
+			t.Fatalf("backup %d failed: %v", i, err)
+		}
+		created = append(created, path)
+		h.advance(time.Hour)
+	}
+
+	entries, err := os.ReadDir(h.router.backupDir)
+	if err != nil {
+		t.Fatalf("cannot read backup directory: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("backups kept = %d, want 2", len(entries))
+	}
+	if _, err := os.Stat(created[0]); !os.IsNotExist(err) {
+		t.Fatalf("oldest backup %s was not pruned", created[0])
+	}
+	for _, path := range created[1:] {
+		if err := database.VerifyIntegrity(path); err != nil {
+			t.Fatalf("backup %s is not valid: %v", path, err)
+		}
+	}
+}
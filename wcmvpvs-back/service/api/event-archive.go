@@ -0,0 +1,160 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultArchiveGracePeriod = 30 * 24 * time.Hour
+	archivePurgeInterval      = time.Hour
+)
+
+type eventArchiveInfo struct {
+	ArchivedBy string `json:"archived_by"`
+	ArchivedAt string `json:"archived_at"`
+	PurgeAfter string `json:"purge_after"`
+	PurgedAt   string `json:"purged_at,omitempty"`
+	Restorable bool   `json:"restorable"`
+}
+
+func buildEventArchiveInfo(archive database.EventArchive) *eventArchiveInfo {
+	return &eventArchiveInfo{
+		ArchivedBy: archive.ArchivedBy,
+		ArchivedAt: archive.ArchivedAt,
+		PurgeAfter: archive.PurgeAfter,
+		PurgedAt:   archive.PurgedAt,
+		Restorable: archive.Restorable,
+	}
+}
+
+// archivedHistoryEntry rebuilds the history entry saved when the event was archived.
+func archivedHistoryEntry(archive database.EventArchive) (historyEntryWrapper, error) {
+	var entry eventHistoryEntry
+	if err := json.Unmarshal([]byte(archive.Summary), &entry); err != nil {
+		return historyEntryWrapper{}, err
+	}
+	entry.Archive = buildEventArchiveInfo(archive)
+	startTime, _ := parseEventStart(entry.StartDateTime)
+	return historyEntryWrapper{entry: entry, startTime: startTime}, nil
+}
+
+func (rt *_router) archiveEvent(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	eventID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || eventID <= 0 {
+		ctx.Logger.WithField("event_id", chi.URLParam(r, "id")).Warn("invalid event id while archiving")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events while archiving")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var target *database.Event
+	for i := range events {
+		if events[i].ID == eventID {
+			target = &events[i]
+			break
+		}
+	}
+	if target == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !target.IsConcluded {
+		_ = writeJSONMessage(w, http.StatusConflict, "Solo gli eventi conclusi possono essere archiviati.")
+		return
+	}
+
+	// The sponsor telemetry still buffered belongs to the archive
+	rt.telemetry.flush()
+	wrapper, err := rt.buildEventHistoryEntry(ctx, *target)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	summary, err := json.Marshal(wrapper.entry)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode archived history entry")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	now := globaltime.Now()
+	if err := rt.db.ArchiveEvent(eventID, string(summary), ctx.AdminUsername, now, now.Add(rt.archiveGracePeriod)); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ctx.Logger.WithError(err).Error("cannot archive event")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	archive, err := rt.db.GetEventArchive(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load event archive")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, buildEventArchiveInfo(archive)); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode archive response")
+	}
+}
+
+func (rt *_router) restoreArchivedEvent(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	eventID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || eventID <= 0 {
+		ctx.Logger.WithField("event_id", chi.URLParam(r, "id")).Warn("invalid event id while restoring archive")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.RestoreArchivedEvent(eventID, globaltime.Now()); err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			w.WriteHeader(http.StatusNotFound)
+		case errors.Is(err, database.ErrArchiveNotRestorable):
+			_ = writeJSONMessage(w, http.StatusConflict, "Il periodo di ripristino per questo evento è scaduto.")
+		default:
+			ctx.Logger.WithError(err).Error("cannot restore archived event")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// purgeExpiredArchives drops the details of archives past their grace period, together with their selfie files.
+func (rt *_router) purgeExpiredArchives() error {
+	imagePaths, err := rt.db.PurgeExpiredEventArchives(globaltime.Now())
+	if err != nil {
+		return err
+	}
+	rt.removeSelfieFiles(imagePaths)
+	return nil
+}
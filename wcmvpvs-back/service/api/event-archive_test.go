@@ -0,0 +1,167 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestEventArchiveLifecycle(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(1)
+	superadmin := h.createAdmin("root", "superadmin")
+	voteID := h.voteIDForCode(fixture.EventID, h.mustVote(fixture, "device-1").Code)
+	h.mustVote(fixture, "device-2")
+
+	assign := fmt.Sprintf("/events/%d/prizes/%d/assign", fixture.EventID, fixture.PrizeIDs[0])
+	if rec := h.do(http.MethodPost, assign, map[string]int{"vote_id": voteID}, adminHeaders(superadmin)); rec.Code != http.StatusOK {
+		t.Fatalf("assign: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	// The sponsor plans and the shop orders collected at the event are archived with it
+	sponsorID, err := h.db.CreateSponsor(database.Sponsor{Name: "Sponsor", LogoData: "logo", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+	rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsors/%d/campaigns", sponsorID), map[string]interface{}{"event_ids": []int{fixture.EventID}}, adminHeaders(superadmin))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create campaign: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var campaign database.SponsorCampaign
+	h.decode(rec, &campaign)
+	plan := h.issueSponsorPlan(fixture, "device-1")
+	rec = h.do(http.MethodPost, "/admin/shop/products", map[string]interface{}{"name": "Sciarpa", "price_cents": 1500}, adminHeaders(superadmin))
+	var scarf database.ShopProduct
+	h.decode(rec, &scarf)
+	rec = h.do(http.MethodPost, "/shop/checkout", map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "pickup_event_id": fixture.EventID,
+		"items": []map[string]int{{"product_id": scarf.ID, "quantity": 1}}}, nil)
+	var checkout checkoutResponsePayload
+	h.decode(rec, &checkout)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	linked := func(want bool) {
+		t.Helper()
+		order, err := h.db.GetShopOrder(checkout.Order.ID)
+		if err != nil || (order.PickupEventID == fixture.EventID) != want {
+			t.Fatalf("pickup event of the order = %d (%v), want linked %v", order.PickupEventID, err, want)
+		}
+		stored, err := h.db.GetSponsorCampaign(campaign.ID)
+		if err != nil || (len(stored.EventIDs) == 1) != want {
+			t.Fatalf("campaign events = %v (%v), want linked %v", stored.EventIDs, err, want)
+		}
+		if _, err := h.db.GetSponsorPlan(plan); (err == nil) != want {
+			t.Fatalf("sponsor plan lookup: %v, want found %v", err, want)
+		}
+		counts, err := h.db.GetSponsorPlanCounts(fixture.EventID, "device-1")
+		if err != nil || (counts.Plans == 1 && counts.DevicePlans == 1 && counts.ByCampaign[campaign.ID] == 1) != want {
+			t.Fatalf("sponsor plan counts = %+v (%v), want present %v", counts, err, want)
+		}
+	}
+	linked(true)
+
+	// A selfie of device-2, played on screen and voted by device-1, wins the contest
+	selfieID := h.approvedSelfie(fixture, "device-2", superadmin)
+	if _, err := h.db.RecordScreenPlay(fixture.EventID, selfieID, globaltime.Now()); err != nil {
+		t.Fatalf("cannot record screen play: %v", err)
+	}
+	if _, err := h.db.SaveSelfieContest(database.SelfieContest{EventID: fixture.EventID, Status: database.SelfieContestOpen, UpdatedAt: globaltime.Now().Format(time.RFC3339)}); err != nil {
+		t.Fatalf("cannot open contest: %v", err)
+	}
+	if err := h.db.AddSelfieContestVote(fixture.EventID, selfieID, "device-1", globaltime.Now()); err != nil {
+		t.Fatalf("cannot vote selfie: %v", err)
+	}
+	if _, err := h.db.AnnounceSelfieContestWinner(fixture.EventID, selfieID, globaltime.Now()); err != nil {
+		t.Fatalf("cannot announce winner: %v", err)
+	}
+
+	archivePath := fmt.Sprintf("/admin/events/%d/archive", fixture.EventID)
+	restorePath := fmt.Sprintf("/admin/events/%d/restore", fixture.EventID)
+
+	if rec := h.do(http.MethodPost, archivePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusConflict {
+		t.Fatalf("archive before conclusion: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if err := h.db.ConcludeEvent(fixture.EventID); err != nil {
+		t.Fatalf("cannot conclude event: %v", err)
+	}
+
+	history := func() []eventHistoryEntry {
+		var entries []eventHistoryEntry
+		h.decode(h.do(http.MethodGet, "/admin/events/history", nil, adminHeaders(superadmin)), &entries)
+		return entries
+	}
+
+	if rec := h.do(http.MethodPost, archivePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusOK {
+		t.Fatalf("archive: status = %d, want %d (%s)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	if count, err := h.db.GetEventVoteCount(fixture.EventID); err != nil || count != 0 {
+		t.Fatalf("votes after archive = %d (%v), want 0", count, err)
+	}
+	linked(false)
+	entries := history()
+	if len(entries) != 1 || entries[0].Archive == nil || !entries[0].Archive.Restorable || entries[0].TotalVotes != 2 {
+		t.Fatalf("unexpected history after archive: %+v", entries)
+	}
+	report := h.do(http.MethodGet, fmt.Sprintf("/admin/events/history/%d/report", fixture.EventID), nil, adminHeaders(superadmin))
+	if report.Code != http.StatusOK {
+		t.Fatalf("archived report: status = %d, want %d", report.Code, http.StatusOK)
+	}
+
+	if rec := h.do(http.MethodPost, restorePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusNoContent {
+		t.Fatalf("restore: status = %d, want %d (%s)", rec.Code, http.StatusNoContent, rec.Body.String())
+	}
+	if count, err := h.db.GetEventVoteCount(fixture.EventID); err != nil || count != 2 {
+		t.Fatalf("votes after restore = %d (%v), want 2", count, err)
+	}
+	linked(true)
+	prizes, err := h.db.ListEventPrizes(fixture.EventID)
+	if err != nil || len(prizes) != 1 || prizes[0].Winner == nil {
+		t.Fatalf("prize winner not restored: %+v (%v)", prizes, err)
+	}
+	if entries := history(); len(entries) != 1 || entries[0].Archive != nil {
+		t.Fatalf("unexpected history after restore: %+v", entries)
+	}
+
+	if rec := h.do(http.MethodPost, archivePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusOK {
+		t.Fatalf("second archive: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	// Erasing the devices drops the sponsor plan and the winning selfie from the archive, with the rows referencing
+	// them, and the event can still be restored
+	for _, device := range []string{"device-1", "device-2"} {
+		if rec := h.do(http.MethodPost, "/admin/privacy/erasures", map[string]string{"device_id": device}, adminHeaders(superadmin)); rec.Code != http.StatusCreated {
+			t.Fatalf("erasure of %s: status = %d (%s)", device, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := h.do(http.MethodPost, restorePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusNoContent {
+		t.Fatalf("restore after erasure: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if _, err := h.db.GetSponsorPlan(plan); err == nil {
+		t.Fatalf("erased sponsor plan restored")
+	}
+	if contest, err := h.db.GetSelfieContest(fixture.EventID); err != nil || contest.WinnerSelfieID != 0 {
+		t.Fatalf("contest after erasure = %+v (%v), want no winner", contest, err)
+	}
+	if ranking, err := h.db.ListSelfieContestRanking(fixture.EventID); err != nil || len(ranking) != 0 {
+		t.Fatalf("contest ranking after erasure = %+v (%v), want empty", ranking, err)
+	}
+	if rec := h.do(http.MethodPost, archivePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusOK {
+		t.Fatalf("third archive: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	h.advance(h.router.archiveGracePeriod)
+	if err := h.router.purgeExpiredArchives(); err != nil {
+		t.Fatalf("cannot purge archives: %v", err)
+	}
+	// The session expired while waiting for the grace period.
+	superadmin = h.login("root")
+	if rec := h.do(http.MethodPost, restorePath, nil, adminHeaders(superadmin)); rec.Code != http.StatusConflict {
+		t.Fatalf("restore after grace period: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	entries = history()
+	if len(entries) != 1 || entries[0].Archive == nil || entries[0].Archive.Restorable || entries[0].TotalVotes != 2 {
+		t.Fatalf("unexpected history after purge: %+v", entries)
+	}
+}
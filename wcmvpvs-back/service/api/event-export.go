@@ -0,0 +1,316 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	exportFormatCSV    = "csv"
+	exportFormatJSON   = "json"
+	exportFormatNDJSON = "ndjson"
+	exportFormatZip    = "zip"
+)
+
+var exportContentTypes = map[string]string{
+	exportFormatCSV:    "text/csv; charset=utf-8",
+	exportFormatJSON:   "application/json",
+	exportFormatNDJSON: "application/x-ndjson",
+	exportFormatZip:    "application/zip",
+}
+
+type exportManifest struct {
+	EventID     int                     `json:"event_id"`
+	GeneratedAt string                  `json:"generated_at"`
+	GeneratedBy string                  `json:"generated_by"`
+	Format      string                  `json:"format"`
+	Datasets    []exportManifestDataset `json:"datasets"`
+}
+
+type exportManifestDataset struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+	Rows    int      `json:"rows"`
+	SHA256  string   `json:"sha256"`
+}
+
+// exportEventData streams the raw data of an event. `format` is csv (default), json, ndjson or zip; all formats but
+// zip export the single `dataset`, while zip bundles every dataset as CSV together with a manifest.json.
+func (rt *_router) exportEventData(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	eventID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || eventID <= 0 {
+		ctx.Logger.WithField("event_id", chi.URLParam(r, "id")).Warn("invalid event id while exporting")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	format := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("format")))
+	if format == "" {
+		format = exportFormatCSV
+	}
+	if _, ok := exportContentTypes[format]; !ok {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato non supportato: usa csv, json, ndjson o zip.")
+		return
+	}
+	dataset := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("dataset")))
+	if format != exportFormatZip && !isExportDataset(dataset) {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Dataset non valido: usa "+strings.Join(database.ExportDatasets, ", ")+".")
+		return
+	}
+
+	if status := rt.checkExportableEvent(ctx, eventID); status != http.StatusOK {
+		if status == http.StatusConflict {
+			_ = writeJSONMessage(w, status, "L'evento è archiviato: ripristinalo per esportarne i dati.")
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	clearWriteDeadline(w, ctx.Logger)
+	now := globaltime.Now().UTC()
+	name := fmt.Sprintf("evento-%d-%s", eventID, now.Format("20060102-150405"))
+	if format != exportFormatZip {
+		name += "-" + dataset
+	}
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.%s\"", name, format))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent: errors from here on can only truncate the response.
+	buffered := bufio.NewWriterSize(w, 32<<10)
+	if format == exportFormatZip {
+		err = rt.writeExportBundle(buffered, eventID, ctx.AdminUsername, now)
+	} else {
+		writer := newExportWriter(buffered, format)
+		if _, err = rt.db.ExportEventDataset(eventID, dataset, writer); err == nil {
+			if closer, ok := writer.(io.Closer); ok {
+				err = closer.Close()
+			}
+		}
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("event_id", eventID).Warn("cannot write event export")
+		return
+	}
+	ctx.Logger.WithField("event_id", eventID).WithField("format", format).WithField("dataset", dataset).
+		WithField("admin", ctx.AdminUsername).Info("event data exported")
+}
+
+func isExportDataset(name string) bool {
+	for _, dataset := range database.ExportDatasets {
+		if dataset == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkExportableEvent returns http.StatusOK when the event exists, http.StatusConflict when it has been archived
+// (its rows are not in the tables anymore) and http.StatusNotFound otherwise.
+func (rt *_router) checkExportableEvent(ctx reqcontext.RequestContext, eventID int) int {
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events while exporting")
+		return http.StatusInternalServerError
+	}
+	for _, event := range events {
+		if event.ID == eventID {
+			return http.StatusOK
+		}
+	}
+	if _, err := rt.db.GetEventArchive(eventID); err == nil {
+		return http.StatusConflict
+	}
+	return http.StatusNotFound
+}
+
+func (rt *_router) writeExportBundle(out io.Writer, eventID int, admin string, now time.Time) error {
+	bundle := zip.NewWriter(out)
+	manifest := exportManifest{
+		EventID:     eventID,
+		GeneratedAt: now.Format(time.RFC3339),
+		GeneratedBy: admin,
+		Format:      exportFormatCSV,
+	}
+
+	for _, dataset := range database.ExportDatasets {
+		file := dataset + ".csv"
+		entry, err := bundle.CreateHeader(&zip.FileHeader{Name: file, Method: zip.Deflate, Modified: now})
+		if err != nil {
+			return err
+		}
+		hash := sha256.New()
+		writer := &columnsRecorder{ExportWriter: newExportWriter(io.MultiWriter(entry, hash), exportFormatCSV)}
+		rows, err := rt.db.ExportEventDataset(eventID, dataset, writer)
+		if err != nil {
+			return fmt.Errorf("exporting %s: %w", dataset, err)
+		}
+		manifest.Datasets = append(manifest.Datasets, exportManifestDataset{
+			Name:    dataset,
+			File:    file,
+			Columns: writer.columns,
+			Rows:    rows,
+			SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		})
+	}
+
+	entry, err := bundle.CreateHeader(&zip.FileHeader{Name: "manifest.json", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+	return bundle.Close()
+}
+
+type columnsRecorder struct {
+	database.ExportWriter
+	columns []string
+}
+
+func (c *columnsRecorder) WriteHeader(columns []string) error {
+	c.columns = append([]string(nil), columns...)
+	return c.ExportWriter.WriteHeader(columns)
+}
+
+func newExportWriter(out io.Writer, format string) database.ExportWriter {
+	switch format {
+	case exportFormatJSON:
+		return &jsonExportWriter{out: out, array: true}
+	case exportFormatNDJSON:
+		return &jsonExportWriter{out: out}
+	default:
+		return &csvExportWriter{out: csv.NewWriter(out)}
+	}
+}
+
+type csvExportWriter struct {
+	out    *csv.Writer
+	record []string
+}
+
+func (c *csvExportWriter) WriteHeader(columns []string) error {
+	c.record = make([]string, len(columns))
+	if err := c.out.Write(columns); err != nil {
+		return err
+	}
+	c.out.Flush()
+	return c.out.Error()
+}
+
+func (c *csvExportWriter) WriteRow(values []interface{}) error {
+	for i, value := range values {
+		switch v := value.(type) {
+		case nil:
+			c.record[i] = ""
+		case string:
+			c.record[i] = v
+		case int64:
+			c.record[i] = strconv.FormatInt(v, 10)
+		case float64:
+			c.record[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		case time.Time:
+			c.record[i] = v.UTC().Format(time.RFC3339)
+		default:
+			c.record[i] = fmt.Sprint(v)
+		}
+	}
+	if err := c.out.Write(c.record); err != nil {
+		return err
+	}
+	c.out.Flush()
+	return c.out.Error()
+}
+
+// jsonExportWriter writes one object per row, keeping the column order. With array set the objects are wrapped in
+// a JSON array, otherwise they are written one per line (NDJSON).
+type jsonExportWriter struct {
+	out   io.Writer
+	array bool
+	keys  [][]byte
+	rows  int
+}
+
+func (j *jsonExportWriter) WriteHeader(columns []string) error {
+	j.keys = make([][]byte, len(columns))
+	for i, column := range columns {
+		key, err := json.Marshal(column)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	if j.array {
+		if _, err := io.WriteString(j.out, "["); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *jsonExportWriter) WriteRow(values []interface{}) error {
+	var line strings.Builder
+	if j.array && j.rows > 0 {
+		line.WriteString(",")
+	}
+	if j.array {
+		line.WriteString("\n")
+	}
+	line.WriteString("{")
+	for i, value := range values {
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			line.WriteString(",")
+		}
+		line.Write(j.keys[i])
+		line.WriteString(":")
+		line.Write(encoded)
+	}
+	line.WriteString("}")
+	if !j.array {
+		line.WriteString("\n")
+	}
+	j.rows++
+	_, err := io.WriteString(j.out, line.String())
+	return err
+}
+
+// Close terminates the JSON array, once every row has been written.
+func (j *jsonExportWriter) Close() error {
+	if !j.array {
+		return nil
+	}
+	_, err := io.WriteString(j.out, "\n]\n")
+	return err
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestExportEventData(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	staff := h.createAdmin("staff", "staff")
+	superadmin := h.createAdmin("root", "superadmin")
+	h.mustVote(fixture, "device-1")
+	h.mustVote(fixture, "device-2")
+
+	path := fmt.Sprintf("/admin/events/%d/export", fixture.EventID)
+	cases := []struct {
+		name   string
+		token  string
+		path   string
+		status int
+	}{
+		{name: "staff", token: staff, path: path + "?dataset=votes", status: http.StatusForbidden},
+		{name: "unknown dataset", token: superadmin, path: path + "?dataset=players", status: http.StatusBadRequest},
+		{name: "unknown format", token: superadmin, path: path + "?dataset=votes&format=xml", status: http.StatusBadRequest},
+		{name: "unknown event", token: superadmin, path: "/admin/events/999/export?dataset=votes", status: http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if rec := h.do(http.MethodGet, tc.path, nil, adminHeaders(tc.token)); rec.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+	}
+
+	rec := h.do(http.MethodGet, path+"?dataset=votes", nil, adminHeaders(superadmin))
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if rec.Code != http.StatusOK || err != nil || len(records) != 3 || records[0][0] != "id" || records[1][3] != "Mario" {
+		t.Fatalf("csv export: status %d, records %v (%v)", rec.Code, records, err)
+	}
+
+	var votes []map[string]interface{}
+	h.decode(h.do(http.MethodGet, path+"?dataset=votes&format=json", nil, adminHeaders(superadmin)), &votes)
+	if len(votes) != 2 || votes[1]["device_id"] != "device-2" {
+		t.Fatalf("json export: %v", votes)
+	}
+
+	var feedback []map[string]interface{}
+	h.decode(h.do(http.MethodGet, path+"?dataset=feedback&format=json", nil, adminHeaders(superadmin)), &feedback)
+	if len(feedback) != 0 {
+		t.Fatalf("json export of empty dataset: %v", feedback)
+	}
+
+	rec = h.do(http.MethodGet, path+"?dataset=votes&format=ndjson", nil, adminHeaders(superadmin))
+	lines := 0
+	for scanner := bufio.NewScanner(rec.Body); scanner.Scan(); lines++ {
+		var row map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("ndjson line %d: %v", lines+1, err)
+		}
+	}
+	if lines != 2 {
+		t.Fatalf("ndjson export: %d lines, want 2", lines)
+	}
+
+	// The orders are exported with the event where they are collected, not with the one of the day they are placed
+	teams, err := h.db.ListTeams()
+	if err != nil || len(teams) != 2 {
+		t.Fatalf("teams = %v (%v)", teams, err)
+	}
+	nextEvent, err := h.db.CreateEvent(database.Event{Team1ID: teams[0].ID, Team2ID: teams[1].ID, StartDateTime: "2024-06-08T20:30:00Z", Location: "Palazzetto"})
+	if err != nil {
+		t.Fatalf("cannot create event: %v", err)
+	}
+	rec = h.do(http.MethodPost, "/admin/shop/products", map[string]interface{}{"name": "Sciarpa", "price_cents": 1500}, adminHeaders(staff))
+	var scarf database.ShopProduct
+	h.decode(rec, &scarf)
+	for _, pickup := range []int{fixture.EventID, nextEvent, nextEvent} {
+		body := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "pickup_event_id": pickup,
+			"items": []map[string]int{{"product_id": scarf.ID, "quantity": 1}}}
+		if rec := h.do(http.MethodPost, "/shop/checkout", body, nil); rec.Code != http.StatusCreated {
+			t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+		}
+	}
+	for eventID, want := range map[int]int{fixture.EventID: 1, nextEvent: 2} {
+		var orders []map[string]interface{}
+		h.decode(h.do(http.MethodGet, fmt.Sprintf("/admin/events/%d/export?dataset=orders&format=json", eventID), nil, adminHeaders(superadmin)), &orders)
+		if len(orders) != want {
+			t.Fatalf("orders of event %d: %v, want %d", eventID, orders, want)
+		}
+	}
+
+	rec = h.do(http.MethodGet, path+"?format=zip", nil, adminHeaders(superadmin))
+	bundle, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatalf("zip export: %v", err)
+	}
+	var manifest exportManifest
+	for _, file := range bundle.File {
+		if file.Name != "manifest.json" {
+			continue
+		}
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatalf("cannot open manifest: %v", err)
+		}
+		content, _ := io.ReadAll(reader)
+		if err := json.Unmarshal(content, &manifest); err != nil {
+			t.Fatalf("cannot decode manifest: %v", err)
+		}
+	}
+	if len(bundle.File) != 8 || len(manifest.Datasets) != 7 || manifest.Datasets[0].Rows != 2 || manifest.GeneratedBy != "root" {
+		t.Fatalf("unexpected bundle: %d files, manifest %+v", len(bundle.File), manifest)
+	}
+}
@@ -59,6 +59,7 @@ type eventHistoryEntry struct {
 	Prizes             []eventHistoryPrize           `json:"prizes"`
 	HasPrizeDraw       bool                          `json:"has_prize_draw"`
 	FeedbackSummary    *eventFeedbackSummaryResponse `json:"feedback_summary,omitempty"`
+	Archive            *eventArchiveInfo             `json:"archive,omitempty"`
 }
 
 type historyEntryWrapper struct {
@@ -82,7 +83,9 @@ func (rt *_router) recordSponsorClick(w http.ResponseWriter, r *http.Request, ct
 	}
 
 	var payload struct {
-		DeviceID string `json:"device_id"`
+		DeviceID   string `json:"device_id"`
+		CampaignID int    `json:"campaign_id"`
+		CreativeID int    `json:"creative_id"`
 	}
 	if r.Body != nil {
 		defer r.Body.Close()
@@ -90,15 +93,20 @@ func (rt *_router) recordSponsorClick(w http.ResponseWriter, r *http.Request, ct
 			ctx.Logger.WithError(err).Warn("invalid sponsor click payload")
 		}
 	}
+	impression := database.SponsorImpression{SponsorID: sponsorID}
+	if payload.CampaignID > 0 {
+		impression.CampaignID = payload.CampaignID
+		if payload.CreativeID > 0 {
+			impression.CreativeID = payload.CreativeID
+		}
+	}
 
 	deviceID := strings.TrimSpace(payload.DeviceID)
 	if deviceID == "" {
 		deviceID = rt.deviceIDFromRequest(r)
 	}
 
-	if err := rt.db.RecordSponsorClick(eventID, sponsorID, deviceID); err != nil {
-		ctx.Logger.WithError(err).Warn("cannot record sponsor click")
-	}
+	rt.countSponsorClick(r, ctx, eventID, impression, deviceID)
 
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -234,6 +242,21 @@ func (rt *_router) getEventHistory(w http.ResponseWriter, r *http.Request, ctx r
 		wrappers = append(wrappers, *wrapper)
 	}
 
+	archives, err := rt.db.ListEventArchives()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list archived events for history")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	for _, archive := range archives {
+		wrapper, err := archivedHistoryEntry(archive)
+		if err != nil {
+			ctx.Logger.WithError(err).WithField("event_id", archive.EventID).Warn("cannot decode archived history entry")
+			continue
+		}
+		wrappers = append(wrappers, wrapper)
+	}
+
 	sort.SliceStable(wrappers, func(i, j int) bool {
 		return wrappers[i].startTime.After(wrappers[j].startTime)
 	})
@@ -272,15 +295,34 @@ func (rt *_router) downloadEventHistoryReport(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	if target == nil || !target.IsConcluded {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-
-	wrapper, err := rt.buildEventHistoryEntry(ctx, *target)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	var wrapper *historyEntryWrapper
+	if target == nil {
+		archive, err := rt.db.GetEventArchive(eventID)
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).WithField("event_id", eventID).Error("cannot load archived event for history report")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		archived, err := archivedHistoryEntry(archive)
+		if err != nil {
+			ctx.Logger.WithError(err).WithField("event_id", eventID).Error("cannot decode archived history entry")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		wrapper = &archived
+	} else {
+		if !target.IsConcluded {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		wrapper, err = rt.buildEventHistoryEntry(ctx, *target)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
 	}
 
 	pdfBytes, err := buildEventHistoryPDF(wrapper.entry)
@@ -574,7 +616,11 @@ var italianMonthNames = []string{
 }
 
 func buildEventHistoryPDF(entry eventHistoryEntry) ([]byte, error) {
-	lines := assembleHistoryReportLines(entry)
+	return renderReportPDF(assembleHistoryReportLines(entry))
+}
+
+// renderReportPDF lays the lines out on A4 pages and writes the PDF document.
+func renderReportPDF(lines []pdfLine) ([]byte, error) {
 	pages := layoutPDFLines(lines)
 	pageCount := len(pages)
 	if pageCount == 0 {
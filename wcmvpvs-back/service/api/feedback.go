@@ -91,13 +91,25 @@ func (rt *_router) submitEventFeedback(w http.ResponseWriter, r *http.Request, c
 		return
 	}
 
+	filtered, err := rt.filterText(database.TextFilterTargetFeedbackSuggestion, suggestion)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot filter feedback suggestion")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if filtered.Rejected {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Il suggerimento contiene parole non consentite.")
+		return
+	}
+
 	feedback := database.EventFeedback{
 		EventID:           eventID,
 		Experience:        experience,
 		TeamSpirit:        teamSpirit,
 		PerksInterest:     perksInterest,
 		MiniGamesInterest: miniGamesInterest,
-		Suggestion:        suggestion,
+		Suggestion:        filtered.Text,
+		SuggestionFlagged: filtered.Flagged,
 	}
 
 	if err := rt.db.RecordEventFeedback(feedback); err != nil {
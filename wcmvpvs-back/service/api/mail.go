@@ -0,0 +1,272 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+	"github.com/go-chi/chi/v5"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	mailOutboxInterval     = 30 * time.Second
+	mailBatchSize          = 20
+	defaultMailMaxAttempts = 8
+	mailRetryDelay         = time.Minute
+	maxMailRetryDelay      = 6 * time.Hour
+	mailOutboxRetention    = 30 * 24 * time.Hour
+	defaultMailOutboxLimit = 100
+	maxMailOutboxLimit     = 500
+)
+
+// queueMail renders the template and adds the message to the outbox, once for each dedupe key. Failures are only
+// logged, as the email never decides the outcome of the request that triggered it.
+func (rt *_router) queueMail(logger logrus.FieldLogger, kind, dedupeKey, recipient, locale string, data interface{}) {
+	if rt.mail == nil {
+		return
+	}
+	logger = logger.WithFields(map[string]interface{}{"kind": kind, "dedupe_key": dedupeKey})
+	address, err := mailer.ParseAddress(recipient)
+	if err != nil {
+		logger.Warn("mail not queued: invalid recipient")
+		return
+	}
+	content, err := mailer.Render(kind, locale, data)
+	if err != nil {
+		logger.WithError(err).Error("cannot render mail")
+		return
+	}
+
+	msg, queued, err := rt.db.EnqueueMail(database.MailMessage{
+		Kind:      kind,
+		DedupeKey: dedupeKey,
+		Recipient: address.Address,
+		Subject:   content.Subject,
+		TextBody:  content.Text,
+		HTMLBody:  content.HTML,
+	}, globaltime.Now())
+	if err != nil {
+		logger.WithError(err).Error("cannot queue mail")
+		return
+	}
+	if queued {
+		logger.WithField("mail_id", msg.ID).Info("mail queued")
+	}
+}
+
+// queueShopOrderMail queues a message about the order, confirmation or pickup notice, in the customer's language.
+func (rt *_router) queueShopOrderMail(logger logrus.FieldLogger, kind string, order database.ShopOrder) {
+	if rt.mail == nil || order.CustomerEmail == "" {
+		return
+	}
+	data := mailer.OrderData{OrderID: order.ID, CustomerName: order.CustomerName, TotalCents: order.TotalCents}
+	for _, item := range order.Items {
+		data.Items = append(data.Items, mailer.OrderItem{
+			Name:       item.ProductName,
+			Variant:    item.VariantName,
+			Quantity:   item.Quantity,
+			TotalCents: item.UnitPriceCents * item.Quantity,
+		})
+	}
+	if order.PickupEventID > 0 {
+		event, found, err := rt.findEvent(order.PickupEventID)
+		if err != nil {
+			logger.WithError(err).Error("cannot load the pickup event of the order")
+		} else if found {
+			data.PickupEvent = buildEventTitle(event)
+			data.PickupStart, _ = parseEventStart(event.StartDateTime)
+		}
+	}
+	rt.queueMail(logger, kind, fmt.Sprintf("%s:%d", kind, order.ID), order.CustomerEmail, order.Locale, data)
+}
+
+// queuePrizeWinnerMail tells the winner of the prize, when they left an email with their vote.
+func (rt *_router) queuePrizeWinnerMail(logger logrus.FieldLogger, prize database.EventPrize) {
+	if rt.mail == nil || prize.Winner == nil {
+		return
+	}
+	contact, err := rt.db.GetVoteContact(prize.Winner.VoteID)
+	if err != nil {
+		logger.WithError(err).Error("cannot load the contact of the prize winner")
+		return
+	}
+	if contact.Email == "" {
+		return
+	}
+	data := mailer.PrizeData{PrizeName: prize.Name, Position: prize.Position, TicketCode: prize.Winner.TicketCode}
+	event, found, err := rt.findEvent(prize.EventID)
+	if err != nil {
+		logger.WithError(err).Error("cannot load the event of the prize")
+		return
+	} else if found {
+		data.EventTitle = buildEventTitle(event)
+		data.EventStart, _ = parseEventStart(event.StartDateTime)
+	}
+	dedupeKey := fmt.Sprintf("%s:%d:%d", mailer.TemplatePrizeWinner, prize.ID, prize.Winner.VoteID)
+	rt.queueMail(logger, mailer.TemplatePrizeWinner, dedupeKey, contact.Email, contact.Locale, data)
+}
+
+// requestLocale returns the supported locale of the emails, the one given or else the first language the browser
+// accepts.
+func requestLocale(r *http.Request, locale string) string {
+	if locale = strings.TrimSpace(locale); locale == "" {
+		locale = strings.SplitN(r.Header.Get("Accept-Language"), ",", 2)[0]
+	}
+	return mailer.NormalizeLocale(locale)
+}
+
+func (rt *_router) findEvent(id int) (database.Event, bool, error) {
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		return database.Event{}, false, err
+	}
+	for _, event := range events {
+		if event.ID == id {
+			return event, true, nil
+		}
+	}
+	return database.Event{}, false, nil
+}
+
+// sendDueMail sends the messages of the outbox that are due. A failed message is retried later, waiting twice as
+// long after each attempt, until the maximum number of attempts. Old messages are purged from the outbox.
+func (rt *_router) sendDueMail() error {
+	now := globaltime.Now()
+	messages, err := rt.db.ListDueMail(mailBatchSize, now)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		logger := rt.baseLogger.WithFields(logrus.Fields{"mail_id": msg.ID, "kind": msg.Kind})
+		sendErr := rt.mail.Send(context.Background(), mailer.Message{
+			ID:      "outbox-" + strconv.Itoa(msg.ID),
+			From:    rt.mailFrom,
+			To:      msg.Recipient,
+			Subject: msg.Subject,
+			Text:    msg.TextBody,
+			HTML:    msg.HTMLBody,
+			Date:    now,
+		})
+		if sendErr == nil {
+			if err := rt.db.MarkMailSent(msg.ID, now); err != nil {
+				return err
+			}
+			logger.Info("mail sent")
+			continue
+		}
+
+		var retryAt time.Time
+		if attempts := msg.Attempts + 1; attempts < rt.mailMaxAttempts {
+			delay := mailRetryDelay << uint(attempts-1)
+			if delay > maxMailRetryDelay || delay <= 0 {
+				delay = maxMailRetryDelay
+			}
+			retryAt = now.Add(delay)
+		}
+		if err := rt.db.MarkMailFailed(msg.ID, sendErr.Error(), retryAt, now); err != nil {
+			return err
+		}
+		if retryAt.IsZero() {
+			logger.WithError(sendErr).Error("mail failed, giving up")
+		} else {
+			logger.WithError(sendErr).WithField("retry_at", retryAt).Warn("mail failed, will retry")
+		}
+	}
+
+	_, err = rt.db.PurgeMailOutbox(now.Add(-mailOutboxRetention))
+	return err
+}
+
+type voteContactPayload struct {
+	EventID   int    `json:"event_id"`
+	Code      string `json:"code"`
+	Signature string `json:"signature"`
+	Email     string `json:"email"`
+	Locale    string `json:"locale"`
+}
+
+// saveVoteContact saves the email where the voter is told when their ticket wins a prize. The ticket signature proves
+// that the request comes from whoever holds the ticket.
+func (rt *_router) saveVoteContact(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	var payload voteContactPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.EventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Richiesta non valida.")
+		return
+	}
+	code := strings.TrimSpace(payload.Code)
+	if code == "" || !strings.EqualFold(signCode(rt.VoteSecret, code), strings.TrimSpace(payload.Signature)) {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Ticket non valido.")
+		return
+	}
+	address, err := mailer.ParseAddress(payload.Email)
+	if err != nil {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Indirizzo email non valido.")
+		return
+	}
+
+	switch err := rt.db.SetVoteContact(payload.EventID, code, address.Address, requestLocale(r, payload.Locale)); {
+	case errors.Is(err, sql.ErrNoRows):
+		_ = writeJSONMessage(w, http.StatusNotFound, "Ticket non trovato.")
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot save vote contact")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSONMessage(w, http.StatusOK, "Ti scriveremo se il tuo ticket verrà estratto.")
+	ctx.Logger.WithField("event_id", payload.EventID).Info("vote contact saved")
+}
+
+// listMailOutbox returns the most recent messages of the outbox, only those with `status` when given.
+func (rt *_router) listMailOutbox(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	status := strings.TrimSpace(r.URL.Query().Get("status"))
+	switch status {
+	case "", database.MailStatusPending, database.MailStatusSent, database.MailStatusFailed:
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stato non valido.")
+		return
+	}
+	limit, ok := parsePositiveLimit(r.URL.Query().Get("limit"), defaultMailOutboxLimit, maxMailOutboxLimit)
+	if !ok {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Limite non valido.")
+		return
+	}
+	messages, err := rt.db.ListMailOutbox(status, limit)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list mail outbox")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, messages)
+}
+
+// retryMail puts a failed message back in the outbox, to be sent at the next run.
+func (rt *_router) retryMail(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	msg, err := rt.db.RetryMail(id, globaltime.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot retry mail")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, msg)
+	ctx.Logger.WithFields(map[string]interface{}{"mail_id": id, "admin": ctx.AdminUsername}).Info("mail queued again")
+}
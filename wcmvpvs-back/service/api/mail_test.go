@@ -0,0 +1,153 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+)
+
+// fakeSender records the messages it sends, failing while err is set.
+type fakeSender struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (s *fakeSender) Send(_ context.Context, msg mailer.Message) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+func TestTransactionalMail(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(1)
+	token := h.createAdmin(testAdminUsername, "staff")
+	sender := &fakeSender{}
+	h.router.mail, h.router.mailFrom, h.router.mailMaxAttempts = sender, "Wearing Cash <noreply@example.com>", 3
+
+	product, err := h.db.SaveShopProduct(database.ShopProduct{Name: "Sciarpa", PriceCents: 1500, IsActive: true}, globaltime.Now())
+	if err != nil {
+		t.Fatalf("cannot create product: %v", err)
+	}
+	stock := 5
+	if _, err := h.db.AdjustShopStock(database.ShopStockChange{ProductID: product.ID, Stock: &stock, Reason: "Carico"}, globaltime.Now()); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+	outbox := func(status string) []database.MailMessage {
+		t.Helper()
+		rec := h.do(http.MethodGet, "/admin/mail/outbox?status="+status, nil, adminHeaders(token))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("outbox: status = %d (%s)", rec.Code, rec.Body.String())
+		}
+		var messages []database.MailMessage
+		h.decode(rec, &messages)
+		return messages
+	}
+	send := func() {
+		t.Helper()
+		if err := h.router.sendDueMail(); err != nil {
+			t.Fatalf("sendDueMail: %v", err)
+		}
+	}
+
+	// The order is confirmed in the language of the browser, and announced when ready for pickup
+	body := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "items": []map[string]int{{"product_id": product.ID, "quantity": 1}}}
+	rec := h.do(http.MethodPost, "/shop/checkout", body, map[string]string{"Accept-Language": "en-GB,en;q=0.9"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var checkout checkoutResponsePayload
+	h.decode(rec, &checkout)
+	for _, status := range []string{database.ShopOrderStatusPaid, database.ShopOrderStatusReadyForPickup} {
+		if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/shop/orders/%d/status", checkout.Order.ID), map[string]string{"status": status}, adminHeaders(token)); rec.Code != http.StatusOK {
+			t.Fatalf("order status %s: status = %d (%s)", status, rec.Code, rec.Body.String())
+		}
+	}
+	order, err := h.db.GetShopOrder(checkout.Order.ID)
+	if err != nil {
+		t.Fatalf("cannot load order: %v", err)
+	}
+	h.router.queueShopOrderMail(h.router.baseLogger, mailer.TemplateOrderConfirmation, order)
+
+	pending := outbox(database.MailStatusPending)
+	if len(pending) != 2 {
+		t.Fatalf("pending messages = %+v, want the confirmation and the pickup notice once each", pending)
+	}
+	subjects := map[string]string{}
+	for _, msg := range pending {
+		subjects[msg.Kind] = msg.Subject
+	}
+	if want := fmt.Sprintf("Your order #%d is confirmed", order.ID); subjects[mailer.TemplateOrderConfirmation] != want {
+		t.Fatalf("confirmation subject = %q, want %q", subjects[mailer.TemplateOrderConfirmation], want)
+	}
+	if !strings.Contains(subjects[mailer.TemplatePickupReady], "ready for pickup") {
+		t.Fatalf("pickup subject = %q", subjects[mailer.TemplatePickupReady])
+	}
+
+	// The voter leaves an email on the ticket, and is told when it wins
+	ticket := h.mustVote(fixture, "device-1")
+	contact := map[string]interface{}{"event_id": fixture.EventID, "code": ticket.Code, "signature": signCode("other", ticket.Code), "email": "lucia@example.com"}
+	if rec := h.do(http.MethodPost, "/vote/contact", contact, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("contact with a forged signature: status = %d", rec.Code)
+	}
+	contact["signature"], contact["email"] = ticket.Signature, "not an email"
+	if rec := h.do(http.MethodPost, "/vote/contact", contact, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("contact with an invalid email: status = %d", rec.Code)
+	}
+	contact["email"] = "lucia@example.com"
+	if rec := h.do(http.MethodPost, "/vote/contact", contact, nil); rec.Code != http.StatusOK {
+		t.Fatalf("contact: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	assign := fmt.Sprintf("/events/%d/prizes/%d/assign", fixture.EventID, fixture.PrizeIDs[0])
+	if rec := h.do(http.MethodPost, assign, map[string]int{"vote_id": h.voteIDForCode(fixture.EventID, ticket.Code)}, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("assign prize: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if pending = outbox(database.MailStatusPending); len(pending) != 3 || pending[0].Kind != mailer.TemplatePrizeWinner || pending[0].Recipient != "lucia@example.com" || pending[0].Subject != "Hai vinto: Premio" {
+		t.Fatalf("pending messages after the prize = %+v", pending)
+	}
+
+	// A failing server delays the messages, until the sender gives up
+	sender.err = errors.New("connection refused")
+	send()
+	if pending = outbox(database.MailStatusPending); len(pending) != 3 || pending[0].Attempts != 1 || pending[0].LastError != "connection refused" {
+		t.Fatalf("pending messages after a failure = %+v", pending)
+	}
+	send()
+	if pending = outbox(database.MailStatusPending); pending[0].Attempts != 1 {
+		t.Fatalf("message retried before its time: %+v", pending[0])
+	}
+	h.advance(time.Minute)
+	send()
+	h.advance(2 * time.Minute)
+	send()
+	failed := outbox(database.MailStatusFailed)
+	if len(failed) != 3 || failed[0].Attempts != 3 {
+		t.Fatalf("failed messages = %+v", failed)
+	}
+
+	// The staff puts a message back in the outbox once the server works again
+	sender.err = nil
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/mail/outbox/%d/retry", failed[0].ID), nil, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("retry: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	send()
+	if len(sender.sent) != 1 || sender.sent[0].To != "lucia@example.com" || sender.sent[0].From != h.router.mailFrom {
+		t.Fatalf("sent messages = %+v", sender.sent)
+	}
+	if sent := outbox(database.MailStatusSent); len(sent) != 1 || sent[0].ID != failed[0].ID {
+		t.Fatalf("sent messages in the outbox = %+v", sent)
+	}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/mail/outbox/%d/retry", failed[0].ID), nil, adminHeaders(token)); rec.Code != http.StatusNotFound {
+		t.Fatalf("retry of a sent message: status = %d", rec.Code)
+	}
+}
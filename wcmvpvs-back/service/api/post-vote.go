@@ -6,9 +6,9 @@ import (
 	"errors"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
 )
 
 // postVote handles a vote submission
@@ -58,7 +58,7 @@ func (rt *_router) postVote(w http.ResponseWriter, r *http.Request, ctx reqconte
 	}
 
 	clientIP := rt.getClientIP(r)
-	if limited, message := rt.shouldThrottleVoteAttempt(req.DeviceID, clientIP, time.Now()); limited {
+	if limited, message := rt.shouldThrottleVoteAttempt(req.DeviceID, clientIP, globaltime.Now()); limited {
 		ctx.Logger.WithFields(map[string]interface{}{
 			"device_id": req.DeviceID,
 			"client_ip": clientIP,
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPostVote(t *testing.T) {
+	cases := []struct {
+		name    string
+		prepare func(h *testHarness, fixture testFixture)
+		payload func(fixture testFixture) map[string]interface{}
+		status  int
+	}{
+		{
+			name: "accepted",
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID, "device_id": "device-1"}
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "missing device",
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID, "device_id": "  "}
+			},
+			status: http.StatusBadRequest,
+		},
+		{
+			name: "duplicate device",
+			prepare: func(h *testHarness, fixture testFixture) {
+				h.mustVote(fixture, "device-1")
+			},
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID, "device_id": "device-1"}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "wrong event",
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID + 1, "device_id": "device-1"}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "voting closed",
+			prepare: func(h *testHarness, fixture testFixture) {
+				if err := h.db.CloseEventVoting(fixture.EventID); err != nil {
+					t.Fatalf("cannot close voting: %v", err)
+				}
+			},
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID, "device_id": "device-1"}
+			},
+			status: http.StatusConflict,
+		},
+		{
+			name: "no active event",
+			prepare: func(h *testHarness, fixture testFixture) {
+				if err := h.db.ClearActiveEvent(); err != nil {
+					t.Fatalf("cannot clear active event: %v", err)
+				}
+			},
+			payload: func(fixture testFixture) map[string]interface{} {
+				return map[string]interface{}{"player_id": fixture.PlayerID, "event_id": fixture.EventID, "device_id": "device-1"}
+			},
+			status: http.StatusConflict,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := newTestHarness(t)
+			fixture := h.seedEvent(0)
+			if tc.prepare != nil {
+				tc.prepare(h, fixture)
+			}
+
+			rec := h.do(http.MethodPost, "/vote", tc.payload(fixture), map[string]string{"X-Forwarded-For": "10.0.0.2"})
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tc.status, rec.Body.String())
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+
+			var result voteResult
+			h.decode(rec, &result)
+			if len(result.Code) != ticketCodeDigits {
+				t.Fatalf("unexpected ticket code %q", result.Code)
+			}
+			if result.Signature != signCode(testVoteSecret, result.Code) {
+				t.Fatalf("ticket signature does not match the configured secret")
+			}
+			if result.QRData == "" {
+				t.Fatalf("missing QR validation URL")
+			}
+		})
+	}
+}
+
+func TestPostVoteThrottling(t *testing.T) {
+	t.Run("device", func(t *testing.T) {
+		h := newTestHarness(t)
+		fixture := h.seedEvent(0)
+
+		h.mustVote(fixture, "device-1")
+		for attempt := 2; attempt <= voteDeviceLimit; attempt++ {
+			if rec := h.vote(fixture, "device-1", "10.0.0.1"); rec.Code != http.StatusConflict {
+				t.Fatalf("attempt %d: status = %d, want %d", attempt, rec.Code, http.StatusConflict)
+			}
+		}
+		if rec := h.vote(fixture, "device-1", "10.0.0.1"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+
+		h.advance(voteDeviceWindow + time.Second)
+		if rec := h.vote(fixture, "device-1", "10.0.0.1"); rec.Code != http.StatusConflict {
+			t.Fatalf("after window: status = %d, want %d", rec.Code, http.StatusConflict)
+		}
+	})
+
+	t.Run("ip", func(t *testing.T) {
+		h := newTestHarness(t)
+		fixture := h.seedEvent(0)
+
+		for i := 0; i < voteIPLimit; i++ {
+			if rec := h.vote(fixture, fmt.Sprintf("device-%d", i), "10.0.0.9"); rec.Code != http.StatusOK {
+				t.Fatalf("vote %d: status = %d, want %d", i, rec.Code, http.StatusOK)
+			}
+		}
+		if rec := h.vote(fixture, "device-extra", "10.0.0.9"); rec.Code != http.StatusTooManyRequests {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusTooManyRequests)
+		}
+		if rec := h.vote(fixture, "device-other-ip", "10.0.0.10"); rec.Code != http.StatusOK {
+			t.Fatalf("other ip: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+
+		h.advance(voteIPWindow + time.Second)
+		if rec := h.vote(fixture, "device-extra", "10.0.0.9"); rec.Code != http.StatusOK {
+			t.Fatalf("after window: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("selfie contest", func(t *testing.T) {
+		h := newTestHarness(t)
+		fixture := h.seedEvent(0)
+		contestVote := func(deviceID string) int {
+			t.Helper()
+			path := fmt.Sprintf("/events/%d/selfie-contest/votes", fixture.EventID)
+			headers := map[string]string{"X-Device-ID": deviceID, "X-Forwarded-For": "10.0.0.9"}
+			return h.do(http.MethodPost, path, map[string]int{"selfie_id": 1}, headers).Code
+		}
+
+		for i := 0; i < voteIPLimit; i++ {
+			if code := contestVote(fmt.Sprintf("fan-%d", i)); code != http.StatusNotFound {
+				t.Fatalf("contest vote %d: status = %d, want %d", i, code, http.StatusNotFound)
+			}
+		}
+		if code := contestVote("fan-extra"); code != http.StatusTooManyRequests {
+			t.Fatalf("contest vote: status = %d, want %d", code, http.StatusTooManyRequests)
+		}
+		if rec := h.vote(fixture, "device-1", "10.0.0.9"); rec.Code != http.StatusOK {
+			t.Fatalf("mvp vote after the contest votes: status = %d, want %d", rec.Code, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/gofrs/uuid"
+)
+
+const retentionJobInterval = time.Hour
+
+// RetentionPolicy contains how long personal data is kept, by data type. After the window, device identifiers and
+// customer data are anonymized while aggregates are kept; selfies are deleted. Zero keeps the data forever.
+type RetentionPolicy struct {
+	Votes            time.Duration
+	Selfies          time.Duration
+	ReactionTests    time.Duration
+	SponsorTelemetry time.Duration
+	ShopOrders       time.Duration
+}
+
+func (p RetentionPolicy) enabled() bool {
+	return p.Votes > 0 || p.Selfies > 0 || p.ReactionTests > 0 || p.SponsorTelemetry > 0 || p.ShopOrders > 0
+}
+
+func (p RetentionPolicy) cutoffs(now time.Time) database.RetentionCutoffs {
+	cutoff := func(window time.Duration) time.Time {
+		if window <= 0 {
+			return time.Time{}
+		}
+		return now.Add(-window)
+	}
+	return database.RetentionCutoffs{
+		Votes:            cutoff(p.Votes),
+		Selfies:          cutoff(p.Selfies),
+		ReactionTests:    cutoff(p.ReactionTests),
+		SponsorTelemetry: cutoff(p.SponsorTelemetry),
+		ShopOrders:       cutoff(p.ShopOrders),
+	}
+}
+
+// applyRetention anonymizes the data past its retention window and removes the image files of expired selfies.
+func (rt *_router) applyRetention() error {
+	report, err := rt.db.ApplyRetention(rt.retention.cutoffs(globaltime.Now()))
+	if err != nil {
+		return err
+	}
+	rt.removeSelfieFiles(report.SelfieImagePaths)
+
+	for table, rows := range report.Rows {
+		if rows > 0 {
+			rt.baseLogger.WithField("table", table).WithField("rows", rows).Info("retention policy applied")
+		}
+	}
+	return nil
+}
+
+// erasePersonalData handles "forget this device/email" requests and returns the erasure receipt.
+func (rt *_router) erasePersonalData(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	var payload struct {
+		DeviceID string `json:"device_id"`
+		Email    string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	deviceID := strings.TrimSpace(payload.DeviceID)
+	email := strings.TrimSpace(payload.Email)
+	if (deviceID == "") == (email == "") {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Indica un identificativo dispositivo oppure un indirizzo email.")
+		return
+	}
+
+	receiptID, err := uuid.NewV4()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot generate erasure receipt id")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	req := database.ErasureRequest{
+		ReceiptID:   receiptID.String(),
+		SubjectType: database.ErasureSubjectDevice,
+		Subject:     deviceID,
+		RequestedBy: ctx.AdminUsername,
+		RequestedAt: globaltime.Now(),
+	}
+	if email != "" {
+		req.SubjectType = database.ErasureSubjectEmail
+		req.Subject = email
+	}
+
+	receipt, err := rt.db.ErasePersonalData(req)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidErasureRequest) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		ctx.Logger.WithError(err).Error("cannot erase personal data")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.removeSelfieFiles(receipt.SelfieImagePaths)
+
+	ctx.Logger.WithField("receipt_id", receipt.ID).WithField("subject_type", receipt.SubjectType).Info("personal data erased")
+	if err := writeJSON(w, http.StatusCreated, receipt); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode erasure receipt")
+	}
+}
+
+func (rt *_router) listErasureReceipts(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	receipts, err := rt.db.ListErasureReceipts()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list erasure receipts")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if receipts == nil {
+		receipts = []database.ErasureReceipt{}
+	}
+	if err := writeJSON(w, http.StatusOK, receipts); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode erasure receipts")
+	}
+}
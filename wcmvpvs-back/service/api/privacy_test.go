@@ -0,0 +1,138 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestErasePersonalData(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	staff := h.createAdmin("staff", "staff")
+	superadmin := h.createAdmin("root", "superadmin")
+	h.mustVote(fixture, "device-1")
+	h.mustVote(fixture, "device-2")
+	upload := map[string]string{"image_base64": testPNGDataURL(t)}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": "device-1"}); rec.Code != http.StatusCreated {
+		t.Fatalf("selfie upload: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	contestSelfie := h.approvedSelfie(fixture, "device-2", staff)
+	if err := h.db.AddSelfieContestVote(fixture.EventID, contestSelfie, "device-1", globaltime.Now()); err != nil {
+		t.Fatalf("cannot vote selfie: %v", err)
+	}
+	h.issueSponsorPlan(fixture, "device-1")
+
+	cases := []struct {
+		name    string
+		token   string
+		payload map[string]string
+		status  int
+	}{
+		{name: "staff", token: staff, payload: map[string]string{"device_id": "device-1"}, status: http.StatusForbidden},
+		{name: "no subject", token: superadmin, payload: map[string]string{}, status: http.StatusBadRequest},
+		{name: "both subjects", token: superadmin, payload: map[string]string{"device_id": "device-1", "email": "a@b.it"}, status: http.StatusBadRequest},
+		{name: "device", token: superadmin, payload: map[string]string{"device_id": "device-1"}, status: http.StatusCreated},
+	}
+	var receipt database.ErasureReceipt
+	for _, tc := range cases {
+		rec := h.do(http.MethodPost, "/admin/privacy/erasures", tc.payload, adminHeaders(tc.token))
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rec.Code, tc.status)
+		}
+		if rec.Code == http.StatusCreated {
+			h.decode(rec, &receipt)
+		}
+	}
+
+	rows := map[string]int{}
+	for _, action := range receipt.Actions {
+		rows[action.Table+"/"+action.Action] = action.Rows
+	}
+	if rows["votes/anonymized"] != 1 || rows["selfies/deleted"] != 1 || rows["selfie_contest_votes/deleted"] != 1 || rows["sponsor_plans/deleted"] != 1 || rows["sponsor_plan_counts/deleted"] != 1 || receipt.SubjectHash == "" {
+		t.Fatalf("unexpected receipt %+v", receipt)
+	}
+
+	if voted, err := h.db.HasDeviceVoted(fixture.EventID, "device-1"); err != nil || voted {
+		t.Fatalf("device still linked to its vote (%v)", err)
+	}
+	if count, err := h.db.GetEventVoteCount(fixture.EventID); err != nil || count != 2 {
+		t.Fatalf("vote count = %d (%v), want 2", count, err)
+	}
+	if _, err := h.db.GetSelfieContestVote(fixture.EventID, "device-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("device still linked to its contest vote (%v)", err)
+	}
+
+	var receipts []database.ErasureReceipt
+	h.decode(h.do(http.MethodGet, "/admin/privacy/erasures", nil, adminHeaders(superadmin)), &receipts)
+	if len(receipts) != 1 || receipts[0].ID != receipt.ID {
+		t.Fatalf("stored receipts = %+v", receipts)
+	}
+}
+
+func TestRetentionPolicy(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	h.mustVote(fixture, "device-1")
+	contestSelfie := h.approvedSelfie(fixture, "device-1", h.createAdmin(testAdminUsername, "staff"))
+	if err := h.db.AddSelfieContestVote(fixture.EventID, contestSelfie, "device-1", globaltime.Now()); err != nil {
+		t.Fatalf("cannot vote selfie: %v", err)
+	}
+	plan := h.issueSponsorPlan(fixture, "device-1")
+	products, err := h.db.ListShopProducts(globaltime.Now())
+	if err != nil || len(products) == 0 {
+		t.Fatalf("cannot list seeded products: %v", err)
+	}
+	if _, err := h.db.CreateShopOrder(database.ShopOrder{
+		CustomerName:  "Mario Rossi",
+		CustomerEmail: "mario@example.com",
+		TotalCents:    products[0].PriceCents,
+	}, []database.ShopOrderItem{{ProductID: products[0].ID, ProductName: products[0].Name, Quantity: 1, UnitPriceCents: products[0].PriceCents}}, "", globaltime.Now()); err != nil {
+		t.Fatalf("cannot create order: %v", err)
+	}
+
+	// Rows are timestamped by SQLite with the real clock.
+	h.router.retention = RetentionPolicy{Votes: 24 * time.Hour, SponsorTelemetry: 24 * time.Hour, ShopOrders: 48 * time.Hour}
+	globaltime.FixedTime = time.Now().Add(36 * time.Hour)
+	if err := h.router.applyRetention(); err != nil {
+		t.Fatalf("cannot apply retention: %v", err)
+	}
+
+	if voted, err := h.db.HasDeviceVoted(fixture.EventID, "device-1"); err != nil || voted {
+		t.Fatalf("vote device not anonymized (%v)", err)
+	}
+	if count, err := h.db.GetEventVoteCount(fixture.EventID); err != nil || count != 1 {
+		t.Fatalf("vote count = %d (%v), want 1", count, err)
+	}
+	if _, err := h.db.GetSelfieContestVote(fixture.EventID, "device-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("contest vote device not anonymized (%v)", err)
+	}
+	if ranking, err := h.db.ListSelfieContestRanking(fixture.EventID); err != nil || len(ranking) != 1 || ranking[0].Votes != 1 {
+		t.Fatalf("contest ranking = %+v (%v), want the anonymized vote counted", ranking, err)
+	}
+	if _, err := h.db.GetSponsorPlan(plan); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("sponsor plan not deleted (%v)", err)
+	}
+	if counts, err := h.db.GetSponsorPlanCounts(fixture.EventID, "device-1"); err != nil || counts.Plans != 1 || counts.DevicePlans != 0 {
+		t.Fatalf("sponsor plan counts = %+v (%v), want only the count of the event", counts, err)
+	}
+
+	receipt, err := h.db.ErasePersonalData(database.ErasureRequest{
+		ReceiptID:   "check",
+		SubjectType: database.ErasureSubjectEmail,
+		Subject:     "MARIO@example.com",
+		RequestedAt: globaltime.Now(),
+	})
+	if err != nil {
+		t.Fatalf("cannot erase email: %v", err)
+	}
+	if receipt.Actions[0].Table != "shop_orders" || receipt.Actions[0].Rows != 1 {
+		t.Fatalf("shop order anonymized before its retention window: %+v", receipt.Actions)
+	}
+}
@@ -27,4 +27,10 @@ type RequestContext struct {
 
 	// AdminUsername is the username associated with the authenticated admin. Empty if unauthenticated.
 	AdminUsername string
+
+	// SponsorID is filled for requests authenticated with a sponsor portal account. Zero otherwise.
+	SponsorID int
+
+	// SponsorUsername is the username of the sponsor portal account. Empty if unauthenticated.
+	SponsorUsername string
 }
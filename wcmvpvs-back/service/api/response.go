@@ -2,7 +2,11 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 type jsonMessage struct {
@@ -21,3 +25,11 @@ func writeJSON(w http.ResponseWriter, status int, payload interface{}) error {
 func writeJSONMessage(w http.ResponseWriter, status int, message string) error {
 	return writeJSON(w, status, jsonMessage{Message: message})
 }
+
+// clearWriteDeadline lets a download outlive the write timeout of the server, which is sized for the JSON responses.
+func clearWriteDeadline(w http.ResponseWriter, logger logrus.FieldLogger) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		logger.WithError(err).Warn("cannot clear the write deadline")
+	}
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/rosterimport"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxRosterImportSize = 4 << 20
+
+// importRoster loads teams, players or events from a CSV or JSON body. The format comes from the `format` query
+// parameter or, when missing, from the Content-Type. With `dry_run=true` the rows are only validated. When any row
+// is invalid nothing is written and the report is returned with 422.
+func (rt *_router) importRoster(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	query := r.URL.Query()
+
+	format, ok := rosterimport.ParseFormat(query.Get("format"))
+	if !ok {
+		format, ok = rosterimport.ParseFormat(r.Header.Get("Content-Type"))
+	}
+	if !ok {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato non supportato: usa csv o json.")
+		return
+	}
+
+	mode := rosterimport.Mode(strings.ToLower(strings.TrimSpace(query.Get("mode"))))
+	dryRun := strings.EqualFold(query.Get("dry_run"), "true") || query.Get("dry_run") == "1"
+
+	reader := http.MaxBytesReader(w, r.Body, maxRosterImportSize)
+	defer reader.Close()
+
+	report, err := rosterimport.Run(rt.db, reader, rosterimport.Options{
+		Kind:   rosterimport.Kind(chi.URLParam(r, "kind")),
+		Format: format,
+		Mode:   mode,
+		DryRun: dryRun,
+	})
+	switch {
+	case errors.Is(err, rosterimport.ErrInvalidOptions):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Parametri di importazione non validi.")
+		return
+	case errors.Is(err, rosterimport.ErrMalformedInput):
+		ctx.Logger.WithError(err).Warn("malformed roster import file")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Il file non è leggibile: "+strings.TrimPrefix(err.Error(), rosterimport.ErrMalformedInput.Error()+": "))
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot import roster")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if len(report.Errors) > 0 {
+		_ = writeJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	if report.Applied {
+		ctx.Logger.WithField("kind", report.Kind).WithField("created", report.Created).WithField("updated", report.Updated).
+			WithField("admin", ctx.AdminUsername).Info("roster imported")
+	}
+	_ = writeJSON(w, http.StatusOK, report)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/rosterimport"
+)
+
+func TestImportRoster(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin("staff", "staff")
+	if _, err := h.db.CreateTeam("Wearing Cash"); err != nil {
+		t.Fatalf("cannot create team: %v", err)
+	}
+
+	teams := []byte("name\nWearing Cash\nAvversari\n")
+	var report rosterimport.Report
+	rec := h.do(http.MethodPost, "/admin/import/teams?format=csv", teams, adminHeaders(token))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("insert existing team: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	h.decode(rec, &report)
+	if len(report.Errors) != 1 || report.Errors[0].Row != 2 || report.Applied {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if list, _ := h.db.ListTeams(); len(list) != 1 {
+		t.Fatalf("teams written despite errors: %+v", list)
+	}
+
+	rec = h.do(http.MethodPost, "/admin/import/teams?format=csv&mode=upsert", teams, adminHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("upsert teams: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	h.decode(rec, &report)
+	if report.Created != 1 || report.Updated != 1 || !report.Applied {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	players := []byte(`[
+		{"first_name": "Mario", "last_name": "Rossi", "role": "Palleggiatore", "jersey_number": 7, "team": "wearing cash"},
+		{"first_name": "Luca", "last_name": "Bianchi", "jersey_number": 7, "team": "Wearing Cash"},
+		{"first_name": "Paolo", "last_name": "Verdi", "team": "Sconosciuti"}
+	]`)
+	rec = h.do(http.MethodPost, "/admin/import/players?dry_run=true", players, adminHeaders(token))
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("players dry run: status = %d, want %d", rec.Code, http.StatusUnprocessableEntity)
+	}
+	h.decode(rec, &report)
+	if len(report.Errors) != 2 || report.Errors[0].Field != "jersey_number" || report.Errors[1].Field != "team" {
+		t.Fatalf("unexpected errors %+v", report.Errors)
+	}
+
+	events := []byte("home_team,away_team,start_datetime,location,show_selfie\nWearing Cash,Avversari,2024-06-08T20:30,Palazzetto,false\n")
+	rec = h.do(http.MethodPost, "/admin/import/events?format=csv&dry_run=true", events, adminHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("events dry run: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if list, _ := h.db.ListEvents(); len(list) != 0 {
+		t.Fatalf("events written in dry run: %+v", list)
+	}
+	if rec = h.do(http.MethodPost, "/admin/import/events?format=csv", events, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("events import: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	list, err := h.db.ListEvents()
+	if err != nil || len(list) != 1 || list[0].ShowSelfie || !list[0].ShowVoteTrend || list[0].Location != "Palazzetto" {
+		t.Fatalf("unexpected events %+v (%v)", list, err)
+	}
+
+	// An upsert only changes the columns of the file
+	partial := []byte("home_team,away_team,start_datetime,show_vote_trend\nWearing Cash,Avversari,2024-06-08T20:30,false\n")
+	if rec = h.do(http.MethodPost, "/admin/import/events?format=csv&mode=upsert", partial, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("events upsert: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	list, err = h.db.ListEvents()
+	if err != nil || len(list) != 1 || list[0].ShowSelfie || list[0].ShowVoteTrend || list[0].Location != "Palazzetto" {
+		t.Fatalf("unexpected events after upsert %+v (%v)", list, err)
+	}
+
+	roster := []byte(`[{"first_name": "Mario", "last_name": "Rossi", "role": "Palleggiatore", "jersey_number": 7, "image_url": "/img/rossi.png", "team": "Wearing Cash"}]`)
+	if rec = h.do(http.MethodPost, "/admin/import/players", roster, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("players import: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	roster = []byte(`[{"first_name": "Mario", "last_name": "Rossi", "role": "Opposto", "team": "Wearing Cash"}]`)
+	if rec = h.do(http.MethodPost, "/admin/import/players?mode=upsert", roster, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("players upsert: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	stored, err := h.db.ListPlayers()
+	if err != nil || len(stored) != 1 || stored[0].Role != "Opposto" || stored[0].JerseyNumber != 7 || stored[0].ImageURL != "/img/rossi.png" {
+		t.Fatalf("unexpected players after upsert %+v (%v)", stored, err)
+	}
+}
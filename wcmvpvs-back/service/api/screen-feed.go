@@ -0,0 +1,334 @@
+package api
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+// Orders of the selfies rotating on the arena screen. Pinned selfies always come first.
+const (
+	screenOrderLeastPlayed = "least_played"
+	screenOrderNewest      = "newest"
+	screenOrderOldest      = "oldest"
+	screenOrderRandom      = "random"
+)
+
+const (
+	defaultScreenDwellSeconds = 8
+	minScreenDwellSeconds     = 3
+	maxScreenDwellSeconds     = 300
+	maxScreenRepeats          = 1000
+	maxScreenPins             = 20
+)
+
+type screenPlaylistItem struct {
+	ID           int    `json:"id"`
+	Caption      string `json:"caption"`
+	ImageURL     string `json:"image_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
+
+	// BrandedURL is the selfie with the frame of the event, when the event has one
+	BrandedURL string `json:"branded_url,omitempty"`
+
+	Pinned      bool   `json:"pinned"`
+	Plays       int    `json:"plays"`
+	SubmittedAt string `json:"submitted_at"`
+}
+
+type screenPlaylistResponse struct {
+	EventID      int                  `json:"event_id"`
+	Order        string               `json:"order"`
+	DwellSeconds int                  `json:"dwell_seconds"`
+	MaxRepeats   int                  `json:"max_repeats"`
+	GeneratedAt  string               `json:"generated_at"`
+	Items        []screenPlaylistItem `json:"items"`
+}
+
+func defaultScreenPlaylist(eventID int) database.ScreenPlaylist {
+	return database.ScreenPlaylist{
+		EventID:         eventID,
+		Order:           screenOrderLeastPlayed,
+		DwellSeconds:    defaultScreenDwellSeconds,
+		PinnedSelfieIDs: []int{},
+	}
+}
+
+func isValidScreenOrder(order string) bool {
+	switch order {
+	case screenOrderLeastPlayed, screenOrderNewest, screenOrderOldest, screenOrderRandom:
+		return true
+	}
+	return false
+}
+
+// wrapScreen authenticates the arena screen. It accepts the screen API key, in the X-Screen-Key header or in the key
+// query parameter (EventSource cannot send headers), as well as an admin session.
+func (rt *_router) wrapScreen(fn httpRouterHandler) http.HandlerFunc {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+		key := strings.TrimSpace(r.Header.Get("X-Screen-Key"))
+		if key == "" {
+			key = strings.TrimSpace(r.URL.Query().Get("key"))
+		}
+		authorized := rt.screenAPIKey != "" && key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(rt.screenAPIKey)) == 1
+		if !authorized {
+			if _, ok := rt.getAdminSession(parseBearerToken(r.Header.Get("Authorization"))); !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		fn(w, r, ctx)
+	})
+}
+
+// loadScreenPlaylist returns the screen settings of the event, falling back to the defaults.
+func (rt *_router) loadScreenPlaylist(eventID int) (database.ScreenPlaylist, error) {
+	settings, err := rt.db.GetScreenPlaylist(eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return defaultScreenPlaylist(eventID), nil
+	}
+	return settings, err
+}
+
+// buildScreenPlaylist computes what the screen has to show now: pinned selfies in their order, then the others in the
+// configured order, leaving out those already shown MaxRepeats times.
+func (rt *_router) buildScreenPlaylist(eventID int) (screenPlaylistResponse, error) {
+	settings, err := rt.loadScreenPlaylist(eventID)
+	if err != nil {
+		return screenPlaylistResponse{}, err
+	}
+	selfies, err := rt.db.ListScreenSelfies(eventID)
+	if err != nil {
+		return screenPlaylistResponse{}, err
+	}
+	frame, err := rt.db.GetSelfieFrame(eventID)
+	hasFrame := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return screenPlaylistResponse{}, err
+	}
+
+	pinPosition := make(map[int]int, len(settings.PinnedSelfieIDs))
+	for i, id := range settings.PinnedSelfieIDs {
+		pinPosition[id] = i
+	}
+	var pinned, rotating []database.ScreenSelfie
+	for _, selfie := range selfies {
+		if _, ok := pinPosition[selfie.ID]; ok {
+			pinned = append(pinned, selfie)
+			continue
+		}
+		if settings.MaxRepeats > 0 && selfie.Plays >= settings.MaxRepeats {
+			continue
+		}
+		rotating = append(rotating, selfie)
+	}
+	sort.SliceStable(pinned, func(i, j int) bool {
+		return pinPosition[pinned[i].ID] < pinPosition[pinned[j].ID]
+	})
+
+	// ListScreenSelfies returns the oldest first
+	switch settings.Order {
+	case screenOrderNewest:
+		for i, j := 0, len(rotating)-1; i < j; i, j = i+1, j-1 {
+			rotating[i], rotating[j] = rotating[j], rotating[i]
+		}
+	case screenOrderRandom:
+		rand.Shuffle(len(rotating), func(i, j int) {
+			rotating[i], rotating[j] = rotating[j], rotating[i]
+		})
+	case screenOrderLeastPlayed:
+		sort.SliceStable(rotating, func(i, j int) bool {
+			if rotating[i].Plays != rotating[j].Plays {
+				return rotating[i].Plays < rotating[j].Plays
+			}
+			return rotating[i].LastPlayedAt < rotating[j].LastPlayedAt
+		})
+	}
+
+	response := screenPlaylistResponse{
+		EventID:      eventID,
+		Order:        settings.Order,
+		DwellSeconds: settings.DwellSeconds,
+		MaxRepeats:   settings.MaxRepeats,
+		GeneratedAt:  globaltime.Now().UTC().Format(time.RFC3339),
+		Items:        make([]screenPlaylistItem, 0, len(pinned)+len(rotating)),
+	}
+	for _, selfie := range append(pinned, rotating...) {
+		_, isPinned := pinPosition[selfie.ID]
+		brandedURL := ""
+		if hasFrame {
+			brandedURL = buildBrandedSelfiePath(selfie.Selfie, frame)
+		}
+		response.Items = append(response.Items, screenPlaylistItem{
+			ID:           selfie.ID,
+			Caption:      selfie.Caption,
+			ImageURL:     rt.buildSelfieImagePath(selfie.Selfie),
+			ThumbnailURL: buildSelfieAssetPath(selfie.Selfie, "thumbnail"),
+			BrandedURL:   brandedURL,
+			Pinned:       isPinned,
+			Plays:        selfie.Plays,
+			SubmittedAt:  selfie.CreatedAt,
+		})
+	}
+	return response, nil
+}
+
+func (rt *_router) getScreenPlaylist(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento non valido.")
+		return
+	}
+
+	playlist, err := rt.buildScreenPlaylist(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot build screen playlist")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, http.StatusOK, playlist); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode screen playlist")
+	}
+}
+
+// recordScreenPlay is called by the screen every time it shows a selfie. When the selfie reaches the maximum number
+// of repeats the playlist changes, so the open streams are notified.
+func (rt *_router) recordScreenPlay(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	selfieID, err := strconv.Atoi(chi.URLParam(r, "selfieId"))
+	if err != nil || selfieID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	plays, err := rt.db.RecordScreenPlay(eventID, selfieID, globaltime.Now())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		ctx.Logger.WithError(err).Error("cannot record screen play")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	settings, err := rt.loadScreenPlaylist(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot load screen playlist settings")
+	} else if settings.MaxRepeats > 0 && plays == settings.MaxRepeats {
+		rt.screenHub.notify(eventID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (rt *_router) getAdminScreenPlaylist(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	settings, err := rt.loadScreenPlaylist(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load screen playlist settings")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, settings); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode screen playlist settings")
+	}
+}
+
+func (rt *_router) updateAdminScreenPlaylist(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Order           string `json:"order"`
+		DwellSeconds    int    `json:"dwell_seconds"`
+		MaxRepeats      int    `json:"max_repeats"`
+		PinnedSelfieIDs []int  `json:"pinned_selfie_ids"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	settings := defaultScreenPlaylist(eventID)
+	if order := strings.TrimSpace(payload.Order); order != "" {
+		if !isValidScreenOrder(order) {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Ordine di rotazione non valido.")
+			return
+		}
+		settings.Order = order
+	}
+	if payload.DwellSeconds != 0 {
+		if payload.DwellSeconds < minScreenDwellSeconds || payload.DwellSeconds > maxScreenDwellSeconds {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Il tempo di permanenza deve essere compreso tra 3 e 300 secondi.")
+			return
+		}
+		settings.DwellSeconds = payload.DwellSeconds
+	}
+	if payload.MaxRepeats < 0 || payload.MaxRepeats > maxScreenRepeats {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Numero massimo di ripetizioni non valido.")
+		return
+	}
+	settings.MaxRepeats = payload.MaxRepeats
+	seen := map[int]bool{}
+	for _, id := range payload.PinnedSelfieIDs {
+		if id > 0 && !seen[id] {
+			seen[id] = true
+			settings.PinnedSelfieIDs = append(settings.PinnedSelfieIDs, id)
+		}
+	}
+	if len(settings.PinnedSelfieIDs) > maxScreenPins {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Puoi fissare al massimo 20 selfie.")
+		return
+	}
+
+	exists, err := rt.eventExists(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events while saving screen playlist")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	settings.UpdatedAt = globaltime.Now().UTC().Format(time.RFC3339)
+	if err := rt.db.SaveScreenPlaylist(settings); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "I selfie fissati devono appartenere all'evento.")
+			return
+		}
+		ctx.Logger.WithError(err).Error("cannot save screen playlist")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.screenHub.notify(eventID)
+
+	if err := writeJSON(w, http.StatusOK, settings); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode screen playlist settings")
+	}
+}
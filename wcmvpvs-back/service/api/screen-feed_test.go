@@ -0,0 +1,156 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestScreenPlaylist(t *testing.T) {
+	h := newTestHarness(t)
+	h.router.screenAPIKey = "screen-key"
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var ids []int
+	for i := 1; i <= 3; i++ {
+		selfie, err := h.db.SaveSelfie(database.NewSelfie{EventID: fixture.EventID, DeviceID: fmt.Sprintf("device-%d", i), Caption: fmt.Sprintf("Selfie %d", i), ImagePath: fmt.Sprintf("selfies/event_%d/%d.jpg", fixture.EventID, i), ContentType: "image/jpeg"})
+		if err != nil {
+			t.Fatalf("cannot save selfie: %v", err)
+		}
+		ids = append(ids, selfie.ID)
+		h.advance(time.Minute)
+	}
+	for _, id := range ids[:2] {
+		if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", id), map[string]bool{"show_on_screen": true}, adminHeaders(token)); rec.Code != http.StatusOK {
+			t.Fatalf("show on screen: status = %d", rec.Code)
+		}
+	}
+
+	playlistPath := fmt.Sprintf("/screen/events/%d/playlist", fixture.EventID)
+	playlist := func() screenPlaylistResponse {
+		t.Helper()
+		rec := h.do(http.MethodGet, playlistPath, nil, map[string]string{"X-Screen-Key": "screen-key"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("playlist: status = %d", rec.Code)
+		}
+		var response screenPlaylistResponse
+		h.decode(rec, &response)
+		return response
+	}
+	itemIDs := func(p screenPlaylistResponse) []int {
+		out := []int{}
+		for _, item := range p.Items {
+			out = append(out, item.ID)
+		}
+		return out
+	}
+
+	for name, headers := range map[string]map[string]string{
+		"no key":    nil,
+		"wrong key": {"X-Screen-Key": "nope"},
+	} {
+		if rec := h.do(http.MethodGet, playlistPath, nil, headers); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status = %d, want %d", name, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if rec := h.do(http.MethodGet, playlistPath+"?key=screen-key", nil, nil); rec.Code != http.StatusOK {
+		t.Fatalf("key in query: status = %d", rec.Code)
+	}
+
+	if got := playlist(); fmt.Sprint(itemIDs(got)) != fmt.Sprint(ids[:2]) || got.DwellSeconds != defaultScreenDwellSeconds {
+		t.Fatalf("default playlist = %v (dwell %d)", itemIDs(got), got.DwellSeconds)
+	}
+
+	settingsPath := fmt.Sprintf("/admin/events/%d/screen", fixture.EventID)
+	if rec := h.do(http.MethodPut, settingsPath, map[string]interface{}{"order": "sideways"}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid order: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	settings := map[string]interface{}{"order": "newest", "dwell_seconds": 12, "max_repeats": 1, "pinned_selfie_ids": []int{ids[0]}}
+	if rec := h.do(http.MethodPut, settingsPath, settings, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("save settings: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	got := playlist()
+	if fmt.Sprint(itemIDs(got)) != fmt.Sprint([]int{ids[0], ids[1]}) || !got.Items[0].Pinned || got.DwellSeconds != 12 {
+		t.Fatalf("configured playlist = %+v", got)
+	}
+
+	// Pinned selfies ignore the repeat limit
+	for _, id := range ids[:2] {
+		rec := h.do(http.MethodPost, fmt.Sprintf("/screen/events/%d/selfies/%d/played", fixture.EventID, id), nil, map[string]string{"X-Screen-Key": "screen-key"})
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("record play: status = %d", rec.Code)
+		}
+	}
+	if got := playlist(); fmt.Sprint(itemIDs(got)) != fmt.Sprint([]int{ids[0]}) {
+		t.Fatalf("playlist after repeats = %v, want %v", itemIDs(got), []int{ids[0]})
+	}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/screen/events/%d/selfies/%d/played", fixture.EventID, ids[2]), nil, map[string]string{"X-Screen-Key": "screen-key"}); rec.Code != http.StatusNotFound {
+		t.Fatalf("play of a selfie not on screen: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestScreenStream(t *testing.T) {
+	h := newTestHarness(t)
+	h.router.screenAPIKey = "screen-key"
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	selfie, err := h.db.SaveSelfie(database.NewSelfie{EventID: fixture.EventID, DeviceID: "device-1", Caption: "Forza!", ImagePath: fmt.Sprintf("selfies/event_%d/1.jpg", fixture.EventID), ContentType: "image/jpeg"})
+	if err != nil {
+		t.Fatalf("cannot save selfie: %v", err)
+	}
+
+	server := httptest.NewServer(h.handler)
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/screen/events/%d/stream?key=screen-key", server.URL, fixture.EventID), nil)
+	if err != nil {
+		t.Fatalf("cannot build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("cannot open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream: status %d, type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	nextPlaylist := func() screenPlaylistResponse {
+		t.Helper()
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("cannot read stream: %v", err)
+			}
+			if data := strings.TrimPrefix(line, "data: "); data != line {
+				var playlist screenPlaylistResponse
+				if err := json.Unmarshal([]byte(data), &playlist); err != nil {
+					t.Fatalf("cannot decode playlist %q: %v", data, err)
+				}
+				return playlist
+			}
+		}
+	}
+
+	if initial := nextPlaylist(); len(initial.Items) != 0 {
+		t.Fatalf("initial playlist has %d items, want 0", len(initial.Items))
+	}
+	if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", selfie.ID), map[string]bool{"show_on_screen": true}, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("show on screen: status = %d", rec.Code)
+	}
+	if pushed := nextPlaylist(); len(pushed.Items) != 1 || pushed.Items[0].ID != selfie.ID {
+		t.Fatalf("pushed playlist = %+v", pushed)
+	}
+}
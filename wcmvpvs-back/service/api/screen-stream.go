@@ -0,0 +1,142 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// screenKeepAliveInterval keeps idle streams open through proxies, which drop silent connections
+	screenKeepAliveInterval = 25 * time.Second
+
+	// screenReconnectDelay is how long the browser waits before reopening a dropped stream
+	screenReconnectDelay = 3 * time.Second
+)
+
+// screenHub tells the open screen streams that the playlist of an event changed. Notifications are coalesced: a
+// stream busy sending the playlist receives at most one pending notification.
+type screenHub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan struct{}]struct{}
+}
+
+// subscribe registers a listener for the event. The returned function must be called to unregister it.
+func (h *screenHub) subscribe(eventID int) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+
+	h.mu.Lock()
+	if h.subscribers == nil {
+		h.subscribers = map[int]map[chan struct{}]struct{}{}
+	}
+	if h.subscribers[eventID] == nil {
+		h.subscribers[eventID] = map[chan struct{}]struct{}{}
+	}
+	h.subscribers[eventID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		delete(h.subscribers[eventID], ch)
+		if len(h.subscribers[eventID]) == 0 {
+			delete(h.subscribers, eventID)
+		}
+		h.mu.Unlock()
+	}
+}
+
+func (h *screenHub) notify(eventID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[eventID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// streamScreenPlaylist sends the playlist of the event as Server-Sent Events: once when the stream opens, then
+// every time it changes (for example when a moderator toggles show_on_screen).
+func (rt *_router) streamScreenPlaylist(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento non valido.")
+		return
+	}
+
+	updates, unsubscribe := rt.screenHub.subscribe(eventID)
+	defer unsubscribe()
+
+	playlist, err := rt.buildScreenPlaylist(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot build screen playlist")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// The stream lives well beyond the write timeout of the server
+	controller := http.NewResponseController(w)
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		ctx.Logger.WithError(err).Warn("cannot clear the write deadline of the screen stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", screenReconnectDelay.Milliseconds()); err != nil {
+		return
+	}
+
+	if err := writeScreenEvent(w, playlist); err != nil {
+		return
+	}
+	if err := controller.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(screenKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-rt.jobsStop:
+			// The router is shutting down; the screen will reconnect to the next instance
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-updates:
+			playlist, err := rt.buildScreenPlaylist(eventID)
+			if err != nil {
+				ctx.Logger.WithError(err).Error("cannot build screen playlist")
+				continue
+			}
+			if err := writeScreenEvent(w, playlist); err != nil {
+				return
+			}
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func writeScreenEvent(w http.ResponseWriter, playlist screenPlaylistResponse) error {
+	data, err := json.Marshal(playlist)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: playlist\ndata: %s\n\n", data)
+	return err
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+// selfieAlbumColumns are the columns of selfies.csv, the manifest of the album. file is empty when the image could
+// not be read.
+var selfieAlbumColumns = []string{"file", "selfie_id", "status", "caption", "submitted_at", "moderated_by", "moderated_at", "show_on_screen"}
+
+// downloadSelfieAlbum streams a zip with the images of the selfies of an event and a selfies.csv manifest. `status`
+// is approved (default), pending, rejected or all. The images are read and written one at a time, so the archive is
+// never kept in memory.
+func (rt *_router) downloadSelfieAlbum(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	switch status {
+	case "":
+		status = database.SelfieStatusApproved
+	case "all", database.SelfieStatusApproved, database.SelfieStatusPending, database.SelfieStatusRejected:
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stato non valido: usa approved, pending, rejected o all.")
+		return
+	}
+
+	if status := rt.checkExportableEvent(ctx, eventID); status != http.StatusOK {
+		if status == http.StatusConflict {
+			_ = writeJSONMessage(w, status, "L'evento è archiviato: ripristinalo per scaricare i selfie.")
+			return
+		}
+		w.WriteHeader(status)
+		return
+	}
+
+	selfies, err := rt.db.ListEventSelfies(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list selfies for the album")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	album := make([]database.Selfie, 0, len(selfies))
+	for _, selfie := range selfies {
+		if status == "all" || selfie.Status == status {
+			album = append(album, selfie)
+		}
+	}
+	sort.SliceStable(album, func(i, j int) bool { return album[i].ID < album[j].ID })
+
+	clearWriteDeadline(w, ctx.Logger)
+	now := globaltime.Now().UTC()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"selfie-evento-%d-%s-%s.zip\"", eventID, status, now.Format("20060102-150405")))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent: errors from here on can only truncate the response.
+	buffered := bufio.NewWriterSize(w, 32<<10)
+	missing, err := rt.writeSelfieAlbum(r, buffered, album, now)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("event_id", eventID).Warn("cannot write selfie album")
+		return
+	}
+	ctx.Logger.WithFields(map[string]interface{}{
+		"event_id": eventID,
+		"status":   status,
+		"selfies":  len(album),
+		"missing":  missing,
+		"admin":    ctx.AdminUsername,
+	}).Info("selfie album downloaded")
+}
+
+// writeSelfieAlbum writes the zip of the selfies and returns how many images could not be found.
+func (rt *_router) writeSelfieAlbum(r *http.Request, out io.Writer, selfies []database.Selfie, now time.Time) (int, error) {
+	bundle := zip.NewWriter(out)
+	files := make([]string, len(selfies))
+	missing := 0
+	for i, selfie := range selfies {
+		content, err := rt.openSelfieImage(r.Context(), selfie)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			missing++
+			continue
+		} else if err != nil {
+			return missing, fmt.Errorf("opening selfie %d: %w", selfie.ID, err)
+		}
+
+		ext := allowedSelfieTypes[selfie.ContentType]
+		if ext == "" {
+			ext = path.Ext(selfie.ImagePath)
+		}
+		files[i] = fmt.Sprintf("selfie-%d%s", selfie.ID, ext)
+		// The images are already compressed
+		entry, err := bundle.CreateHeader(&zip.FileHeader{Name: files[i], Method: zip.Store, Modified: now})
+		if err == nil {
+			_, err = io.Copy(entry, content)
+		}
+		content.Close()
+		if err != nil {
+			return missing, fmt.Errorf("writing selfie %d: %w", selfie.ID, err)
+		}
+	}
+
+	entry, err := bundle.CreateHeader(&zip.FileHeader{Name: "selfies.csv", Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return missing, err
+	}
+	manifest := newExportWriter(entry, exportFormatCSV)
+	if err := manifest.WriteHeader(selfieAlbumColumns); err != nil {
+		return missing, err
+	}
+	for i, selfie := range selfies {
+		row := []interface{}{files[i], int64(selfie.ID), selfie.Status, selfie.Caption, selfie.CreatedAt, selfie.ModeratedBy, selfie.ModeratedAt, strconv.FormatBool(selfie.ShowOnScreen)}
+		if err := manifest.WriteRow(row); err != nil {
+			return missing, err
+		}
+	}
+	return missing, bundle.Close()
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+)
+
+// slowBlobStore reads the blobs after a delay, like a remote store under load.
+type slowBlobStore struct {
+	blobstore.Store
+	delay time.Duration
+}
+
+func (s slowBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, blobstore.Info, error) {
+	time.Sleep(s.delay)
+	return s.Store.Get(ctx, key)
+}
+
+func TestSelfieAlbum(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var ids []int
+	for _, device := range []string{"device-1", "device-2"} {
+		h.mustVote(fixture, device)
+		upload := map[string]string{"caption": "Forza " + device, "image_base64": testPNGDataURL(t)}
+		rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": device})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upload: status = %d", rec.Code)
+		}
+		var selfie selfieResponse
+		h.decode(rec, &selfie)
+		ids = append(ids, selfie.ID)
+	}
+	if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", ids[1]), map[string]bool{"show_on_screen": true}, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d", rec.Code)
+	}
+
+	albumPath := fmt.Sprintf("/admin/events/%d/selfies/album", fixture.EventID)
+	if rec := h.do(http.MethodGet, albumPath+"?status=hidden", nil, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid status: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	album := func(query string) map[string]*zip.File {
+		t.Helper()
+		rec := h.do(http.MethodGet, albumPath+query, nil, adminHeaders(token))
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+			t.Fatalf("album%s: status = %d", query, rec.Code)
+		}
+		reader, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if err != nil {
+			t.Fatalf("cannot read album: %v", err)
+		}
+		files := map[string]*zip.File{}
+		for _, file := range reader.File {
+			files[file.Name] = file
+		}
+		return files
+	}
+
+	files := album("")
+	if len(files) != 2 || files[fmt.Sprintf("selfie-%d.jpg", ids[1])] == nil {
+		t.Fatalf("approved album files = %v", files)
+	}
+	manifest, err := files["selfies.csv"].Open()
+	if err != nil {
+		t.Fatalf("cannot open manifest: %v", err)
+	}
+	records, err := csv.NewReader(manifest).ReadAll()
+	if err != nil {
+		t.Fatalf("cannot read manifest: %v", err)
+	}
+	if len(records) != 2 || records[1][0] != fmt.Sprintf("selfie-%d.jpg", ids[1]) || records[1][2] != "approved" || records[1][3] != "Forza device-2" {
+		t.Fatalf("manifest = %v", records)
+	}
+
+	if files := album("?status=all"); len(files) != 3 {
+		t.Fatalf("complete album files = %v", files)
+	}
+}
+
+func TestSelfieAlbumOutlivesWriteTimeout(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	h.mustVote(fixture, "device-1")
+	selfieID := h.approvedSelfie(fixture, "device-1", token)
+	h.router.blobs = slowBlobStore{Store: h.router.blobs, delay: 200 * time.Millisecond}
+
+	server := httptest.NewUnstartedServer(h.handler)
+	server.Config.WriteTimeout = 50 * time.Millisecond
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+fmt.Sprintf("/admin/events/%d/selfies/album", fixture.EventID), nil)
+	if err != nil {
+		t.Fatalf("cannot build request: %v", err)
+	}
+	for key, value := range adminHeaders(token) {
+		req.Header.Set(key, value)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("album request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("album: status = %d (%v)", resp.StatusCode, err)
+	}
+	reader, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("album cut by the write timeout: %v", err)
+	}
+	if len(reader.File) != 2 || reader.File[0].Name != fmt.Sprintf("selfie-%d.jpg", selfieID) {
+		t.Fatalf("album files = %v", reader.File)
+	}
+}
@@ -0,0 +1,432 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+type selfieContestEntry struct {
+	Rank         int     `json:"rank"`
+	SelfieID     int     `json:"selfie_id"`
+	Caption      string  `json:"caption"`
+	ImageURL     string  `json:"image_url"`
+	ThumbnailURL string  `json:"thumbnail_url"`
+	Votes        int     `json:"votes"`
+	Percentage   float64 `json:"percentage"`
+}
+
+type selfieContestResponse struct {
+	EventID    int    `json:"event_id"`
+	Status     string `json:"status"`
+	PrizeName  string `json:"prize_name,omitempty"`
+	TotalVotes int    `json:"total_votes"`
+
+	// OwnVoteSelfieID is the selfie the requesting device voted for, zero if it has not voted
+	OwnVoteSelfieID int `json:"own_vote_selfie_id"`
+
+	Winner      *selfieContestEntry  `json:"winner,omitempty"`
+	AnnouncedAt string               `json:"announced_at,omitempty"`
+	Ranking     []selfieContestEntry `json:"ranking"`
+	UpdatedAt   string               `json:"updated_at"`
+}
+
+func isSelfieContestDeviceCollision(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "selfie_contest_votes.event_id, selfie_contest_votes.device_id")
+}
+
+// buildSelfieContestRanking ranks the entries, already sorted by ListSelfieContestRanking. Selfies with the same
+// votes share the rank.
+func buildSelfieContestRanking(entries []database.SelfieContestEntry) ([]selfieContestEntry, int) {
+	total := 0
+	for _, entry := range entries {
+		total += entry.Votes
+	}
+	ranking := make([]selfieContestEntry, 0, len(entries))
+	for i, entry := range entries {
+		rank := i + 1
+		if i > 0 && entry.Votes == entries[i-1].Votes {
+			rank = ranking[i-1].Rank
+		}
+		percentage := 0.0
+		if total > 0 {
+			percentage = math.Round((float64(entry.Votes)/float64(total))*1000) / 10
+		}
+		ranking = append(ranking, selfieContestEntry{
+			Rank:         rank,
+			SelfieID:     entry.ID,
+			Caption:      entry.Caption,
+			ImageURL:     buildSelfieAssetPath(entry.Selfie, "image"),
+			ThumbnailURL: buildSelfieAssetPath(entry.Selfie, "thumbnail"),
+			Votes:        entry.Votes,
+			Percentage:   percentage,
+		})
+	}
+	return ranking, total
+}
+
+func (rt *_router) buildSelfieContestResponse(contest database.SelfieContest, deviceID string) (selfieContestResponse, error) {
+	entries, err := rt.db.ListSelfieContestRanking(contest.EventID)
+	if err != nil {
+		return selfieContestResponse{}, err
+	}
+	ranking, total := buildSelfieContestRanking(entries)
+	response := selfieContestResponse{
+		EventID:     contest.EventID,
+		Status:      contest.Status,
+		TotalVotes:  total,
+		AnnouncedAt: contest.AnnouncedAt,
+		Ranking:     ranking,
+		UpdatedAt:   globaltime.Now().UTC().Format(time.RFC3339),
+	}
+	for i := range ranking {
+		if ranking[i].SelfieID == contest.WinnerSelfieID {
+			winner := ranking[i]
+			response.Winner = &winner
+		}
+	}
+
+	if contest.PrizeID > 0 {
+		prizes, err := rt.db.ListEventPrizes(contest.EventID)
+		if err != nil {
+			return selfieContestResponse{}, err
+		}
+		for _, prize := range prizes {
+			if prize.ID == contest.PrizeID {
+				response.PrizeName = prize.Name
+			}
+		}
+	}
+
+	if deviceID != "" {
+		selfieID, err := rt.db.GetSelfieContestVote(contest.EventID, deviceID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return selfieContestResponse{}, err
+		}
+		response.OwnVoteSelfieID = selfieID
+	}
+	return response, nil
+}
+
+// getSelfieContest returns the live ranking of the photo contest of the event.
+func (rt *_router) getSelfieContest(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento non valido.")
+		return
+	}
+
+	contest, err := rt.db.GetSelfieContest(eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = writeJSONMessage(w, http.StatusNotFound, "Nessun contest fotografico per questa partita.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response, err := rt.buildSelfieContestResponse(contest, rt.deviceIDFromRequest(r))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot build selfie contest ranking")
+		_ = writeJSONMessage(w, http.StatusInternalServerError, "Impossibile aggiornare la classifica in questo momento.")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie contest")
+	}
+}
+
+// getSelfieContestWinner returns the winner of the contest, once announced.
+func (rt *_router) getSelfieContestWinner(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento non valido.")
+		return
+	}
+
+	contest, err := rt.db.GetSelfieContest(eventID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && contest.WinnerSelfieID == 0) {
+		_ = writeJSONMessage(w, http.StatusNotFound, "Il vincitore del contest non è ancora stato annunciato.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response, err := rt.buildSelfieContestResponse(contest, "")
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot build selfie contest ranking")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if response.Winner == nil {
+		// The winning selfie has been deleted or hidden after the announcement
+		_ = writeJSONMessage(w, http.StatusNotFound, "Il vincitore del contest non è ancora stato annunciato.")
+		return
+	}
+	winner := struct {
+		EventID     int                `json:"event_id"`
+		Winner      selfieContestEntry `json:"winner"`
+		PrizeName   string             `json:"prize_name,omitempty"`
+		AnnouncedAt string             `json:"announced_at"`
+	}{EventID: eventID, Winner: *response.Winner, PrizeName: response.PrizeName, AnnouncedAt: response.AnnouncedAt}
+	if err := writeJSON(w, http.StatusOK, winner); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie contest winner")
+	}
+}
+
+// postSelfieContestVote records the vote of a fan for an approved selfie. Every device votes once per contest, and
+// not for its own selfie.
+func (rt *_router) postSelfieContestVote(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento non valido.")
+		return
+	}
+	deviceID := rt.deviceIDFromRequest(r)
+	if deviceID == "" {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Identificativo dispositivo mancante.")
+		return
+	}
+	var payload struct {
+		SelfieID int `json:"selfie_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.SelfieID <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Selfie non valido.")
+		return
+	}
+
+	clientIP := rt.getClientIP(r)
+	if limited, message := rt.shouldThrottleContestVote(deviceID, clientIP, globaltime.Now()); limited {
+		ctx.Logger.WithFields(map[string]interface{}{
+			"device_id": deviceID,
+			"client_ip": clientIP,
+		}).Warn("selfie contest vote throttled")
+		_ = writeJSONMessage(w, http.StatusTooManyRequests, message)
+		return
+	}
+
+	contest, err := rt.db.GetSelfieContest(eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = writeJSONMessage(w, http.StatusNotFound, "Nessun contest fotografico per questa partita.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if contest.Status != database.SelfieContestOpen {
+		_ = writeJSONMessage(w, http.StatusForbidden, "Le votazioni del contest sono chiuse.")
+		return
+	}
+
+	selfie, err := rt.db.GetSelfieByID(payload.SelfieID)
+	if err == nil && selfie.EventID == eventID && selfie.DeviceID == deviceID {
+		_ = writeJSONMessage(w, http.StatusForbidden, "Non puoi votare il tuo selfie.")
+		return
+	}
+
+	if err := rt.db.AddSelfieContestVote(eventID, payload.SelfieID, deviceID, globaltime.Now()); err != nil {
+		switch {
+		case errors.Is(err, database.ErrSelfieNotInContest):
+			_ = writeJSONMessage(w, http.StatusNotFound, "Selfie non trovato.")
+		case isSelfieContestDeviceCollision(err):
+			ctx.Logger.WithError(err).Warn("duplicate selfie contest vote for device")
+			_ = writeJSONMessage(w, http.StatusConflict, "Hai già votato in questo contest.")
+		default:
+			ctx.Logger.WithError(err).Error("cannot store selfie contest vote")
+			_ = writeJSONMessage(w, http.StatusInternalServerError, "Servizio non disponibile. Riprova tra pochi istanti.")
+		}
+		return
+	}
+
+	_ = writeJSONMessage(w, http.StatusCreated, "Voto registrato con successo.")
+}
+
+// updateAdminSelfieContest enables the photo contest of the event, opens or closes the voting and links the prize.
+func (rt *_router) updateAdminSelfieContest(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Status  string `json:"status"`
+		PrizeID int    `json:"prize_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	contest := database.SelfieContest{
+		EventID:   eventID,
+		Status:    strings.ToLower(strings.TrimSpace(payload.Status)),
+		PrizeID:   payload.PrizeID,
+		UpdatedAt: globaltime.Now().UTC().Format(time.RFC3339),
+	}
+	switch contest.Status {
+	case "":
+		contest.Status = database.SelfieContestOpen
+	case database.SelfieContestOpen, database.SelfieContestClosed:
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stato non valido: usa open o closed.")
+		return
+	}
+
+	exists, err := rt.eventExists(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events while saving selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	current, err := rt.db.GetSelfieContest(eventID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		ctx.Logger.WithError(err).Error("cannot load selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if current.WinnerSelfieID > 0 && (contest.Status == database.SelfieContestOpen || contest.PrizeID != current.PrizeID) {
+		_ = writeJSONMessage(w, http.StatusConflict, "Il vincitore è già stato annunciato: annulla l'annuncio per modificare il contest.")
+		return
+	}
+
+	if contest.PrizeID < 0 {
+		contest.PrizeID = 0
+	}
+	if contest.PrizeID > 0 {
+		prizes, err := rt.db.ListEventPrizes(eventID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("cannot list prizes while saving selfie contest")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		found := false
+		for _, prize := range prizes {
+			if prize.ID == contest.PrizeID {
+				found = true
+			}
+		}
+		if !found {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Il premio deve appartenere all'evento.")
+			return
+		}
+	}
+
+	saved, err := rt.db.SaveSelfieContest(contest)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot save selfie contest")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, saved); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie contest")
+	}
+}
+
+// announceSelfieContestWinner closes the contest and announces the winner: the given selfie, or the most voted one.
+// A tie for the first place must be broken by choosing the selfie.
+func (rt *_router) announceSelfieContestWinner(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		SelfieID int `json:"selfie_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	selfieID := payload.SelfieID
+	if selfieID <= 0 {
+		entries, err := rt.db.ListSelfieContestRanking(eventID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("cannot rank selfie contest")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if len(entries) == 0 || entries[0].Votes == 0 {
+			_ = writeJSONMessage(w, http.StatusConflict, "Il contest non ha ancora ricevuto voti.")
+			return
+		}
+		if len(entries) > 1 && entries[1].Votes == entries[0].Votes {
+			_ = writeJSONMessage(w, http.StatusConflict, "Parità al primo posto: indica il selfie vincitore.")
+			return
+		}
+		selfieID = entries[0].ID
+	}
+
+	contest, err := rt.db.AnnounceSelfieContestWinner(eventID, selfieID, globaltime.Now())
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			_ = writeJSONMessage(w, http.StatusNotFound, "Nessun contest fotografico per questa partita.")
+		case errors.Is(err, database.ErrSelfieNotInContest):
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Il selfie deve essere approvato e appartenere all'evento.")
+		case errors.Is(err, database.ErrSelfieContestAnnounced):
+			_ = writeJSONMessage(w, http.StatusConflict, "Il vincitore è già stato annunciato.")
+		case errors.Is(err, database.ErrSelfieContestPrizeMissing):
+			_ = writeJSONMessage(w, http.StatusConflict, "Il premio collegato al contest non esiste più.")
+		case errors.Is(err, database.ErrPrizeAlreadyAssigned), errors.Is(err, database.ErrPrizeWinnerConflict):
+			_ = writeJSONMessage(w, http.StatusConflict, "Il premio è già stato assegnato o l'autore del selfie ha già vinto un altro premio.")
+		case errors.Is(err, database.ErrPrizeVoteMismatch):
+			_ = writeJSONMessage(w, http.StatusConflict, "L'autore del selfie non ha un voto valido per ricevere il premio.")
+		default:
+			ctx.Logger.WithError(err).Error("cannot announce selfie contest winner")
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	ctx.Logger.WithFields(map[string]interface{}{"event_id": eventID, "selfie_id": selfieID}).Info("selfie contest winner announced")
+
+	response, err := rt.buildSelfieContestResponse(contest, "")
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot build selfie contest ranking")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie contest")
+	}
+}
+
+func (rt *_router) clearSelfieContestWinner(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.ClearSelfieContestWinner(eventID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot clear selfie contest winner")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSelfieContest(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(1)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var ids []int
+	var authorVote voteResult
+	for _, device := range []string{"device-1", "device-2"} {
+		vote := h.mustVote(fixture, device)
+		if device == "device-1" {
+			authorVote = vote
+		}
+		upload := map[string]string{"caption": "Forza!", "image_base64": testPNGDataURL(t)}
+		rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": device})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upload: status = %d", rec.Code)
+		}
+		var selfie selfieResponse
+		h.decode(rec, &selfie)
+		ids = append(ids, selfie.ID)
+	}
+	bulk := map[string]interface{}{"action": "approve", "selfie_ids": ids}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/events/%d/selfies/bulk", fixture.EventID), bulk, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d", rec.Code)
+	}
+
+	contestPath := fmt.Sprintf("/events/%d/selfie-contest", fixture.EventID)
+	votePath := contestPath + "/votes"
+	vote := func(device string, selfieID int) int {
+		t.Helper()
+		return h.do(http.MethodPost, votePath, map[string]int{"selfie_id": selfieID}, map[string]string{"X-Device-ID": device}).Code
+	}
+	if code := vote("fan-1", ids[0]); code != http.StatusNotFound {
+		t.Fatalf("vote without contest: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	settings := map[string]interface{}{"status": "open", "prize_id": fixture.PrizeIDs[0]}
+	if rec := h.do(http.MethodPut, "/admin"+contestPath, settings, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("open contest: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	for _, c := range []struct {
+		device   string
+		selfieID int
+		want     int
+	}{
+		{"fan-1", ids[0], http.StatusCreated},
+		{"fan-1", ids[1], http.StatusConflict},
+		{"device-1", ids[0], http.StatusForbidden},
+		{"device-1", ids[1], http.StatusCreated},
+		{"fan-2", ids[0], http.StatusCreated},
+		{"fan-3", 9999, http.StatusNotFound},
+	} {
+		if code := vote(c.device, c.selfieID); code != c.want {
+			t.Fatalf("vote of %s for %d: status = %d, want %d", c.device, c.selfieID, code, c.want)
+		}
+	}
+
+	// A rejected selfie leaves the contest and gives the votes back
+	moderate := func(action string) {
+		t.Helper()
+		bulk := map[string]interface{}{"action": action, "selfie_ids": []int{ids[1]}}
+		if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/events/%d/selfies/bulk", fixture.EventID), bulk, adminHeaders(token)); rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d", action, rec.Code)
+		}
+	}
+	moderate("reject")
+	if _, err := h.db.GetSelfieContestVote(fixture.EventID, "device-1"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("vote for the rejected selfie still stored (%v)", err)
+	}
+	moderate("approve")
+	if code := vote("device-1", ids[1]); code != http.StatusCreated {
+		t.Fatalf("vote after the rejected selfie: status = %d, want %d", code, http.StatusCreated)
+	}
+
+	var contest selfieContestResponse
+	h.decode(h.do(http.MethodGet, contestPath, nil, map[string]string{"X-Device-ID": "fan-1"}), &contest)
+	if contest.TotalVotes != 3 || len(contest.Ranking) != 2 || contest.Ranking[0].SelfieID != ids[0] || contest.Ranking[0].Votes != 2 || contest.OwnVoteSelfieID != ids[0] || contest.PrizeName != "Premio" {
+		t.Fatalf("contest ranking = %+v", contest)
+	}
+	if rec := h.do(http.MethodGet, contestPath+"/winner", nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("winner before announcement: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	if rec := h.do(http.MethodPost, "/admin"+contestPath+"/winner", nil, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("announce: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var winner struct {
+		Winner    selfieContestEntry `json:"winner"`
+		PrizeName string             `json:"prize_name"`
+	}
+	h.decode(h.do(http.MethodGet, contestPath+"/winner", nil, nil), &winner)
+	if winner.Winner.SelfieID != ids[0] || winner.PrizeName != "Premio" {
+		t.Fatalf("winner = %+v", winner)
+	}
+	prizes, err := h.db.ListEventPrizes(fixture.EventID)
+	if err != nil {
+		t.Fatalf("cannot list prizes: %v", err)
+	}
+	if prizes[0].Winner == nil || prizes[0].Winner.TicketCode != authorVote.Code {
+		t.Fatalf("prize winner = %+v, want the vote of the selfie author", prizes[0].Winner)
+	}
+	if code := vote("fan-3", ids[1]); code != http.StatusForbidden {
+		t.Fatalf("vote after the announcement: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	if rec := h.do(http.MethodDelete, "/admin"+contestPath+"/winner", nil, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("clear winner: status = %d", rec.Code)
+	}
+	stored, err := h.db.GetSelfieContest(fixture.EventID)
+	if err != nil || stored.WinnerSelfieID != 0 || stored.Status != database.SelfieContestClosed {
+		t.Fatalf("contest after clearing the winner = %+v (%v)", stored, err)
+	}
+	if prizes, _ := h.db.ListEventPrizes(fixture.EventID); prizes[0].Winner != nil {
+		t.Fatalf("prize still assigned after clearing the winner: %+v", prizes[0].Winner)
+	}
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/imaging"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxFrameOverlayBytes is the maximum size of the decoded overlay PNG of a selfie frame
+const maxFrameOverlayBytes = 4 << 20
+
+// buildBrandedSelfiePath returns the public path of the branded rendition of the selfie. The frame revision is part
+// of the path, so that screens and browsers fetch the new rendition when the frame changes.
+func buildBrandedSelfiePath(selfie database.Selfie, frame database.SelfieFrame) string {
+	return fmt.Sprintf("/events/%d/selfies/%d/branded?v=%d", selfie.EventID, selfie.ID, frame.Revision)
+}
+
+func (rt *_router) eventExists(eventID int) (bool, error) {
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		return false, err
+	}
+	for _, event := range events {
+		if event.ID == eventID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (rt *_router) getAdminSelfieFrame(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	frame, err := rt.db.GetSelfieFrame(eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		_ = writeJSONMessage(w, http.StatusNotFound, "Nessuna cornice configurata per l'evento.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load selfie frame")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, frame); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie frame")
+	}
+}
+
+// updateAdminSelfieFrame creates or replaces the frame of the event. The selfies are branded again, with the new
+// frame, the next time they are requested.
+func (rt *_router) updateAdminSelfieFrame(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		OverlayData     string `json:"overlay_data"`
+		CaptionPosition string `json:"caption_position"`
+		SponsorID       int    `json:"sponsor_id"`
+		LogoPosition    string `json:"logo_position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	frame := database.SelfieFrame{
+		EventID:         eventID,
+		OverlayData:     strings.TrimSpace(payload.OverlayData),
+		CaptionPosition: strings.ToLower(strings.TrimSpace(payload.CaptionPosition)),
+		SponsorID:       payload.SponsorID,
+		LogoPosition:    strings.ToLower(strings.TrimSpace(payload.LogoPosition)),
+		UpdatedAt:       globaltime.Now().UTC().Format(time.RFC3339),
+	}
+	switch frame.CaptionPosition {
+	case "":
+		frame.CaptionPosition = imaging.CaptionBottom
+	case imaging.CaptionNone, imaging.CaptionTop, imaging.CaptionBottom:
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Posizione della didascalia non valida: usa none, top o bottom.")
+		return
+	}
+	switch frame.LogoPosition {
+	case "":
+		frame.LogoPosition = imaging.LogoBottomRight
+	case imaging.LogoTopLeft, imaging.LogoTopRight, imaging.LogoBottomLeft, imaging.LogoBottomRight:
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Posizione del logo non valida.")
+		return
+	}
+
+	if frame.OverlayData != "" {
+		data, contentType, err := decodeBase64Image(frame.OverlayData)
+		if err != nil || contentType != "image/png" || len(data) > maxFrameOverlayBytes {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "La cornice deve essere un'immagine PNG di massimo 4 MB.")
+			return
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "La cornice deve essere un'immagine PNG di massimo 4 MB.")
+			return
+		}
+		if cfg.Width > imaging.DefaultOptions.MaxSide || cfg.Height > imaging.DefaultOptions.MaxSide {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "La cornice può misurare al massimo 2048x2048 pixel.")
+			return
+		}
+	}
+	if frame.SponsorID < 0 {
+		frame.SponsorID = 0
+	}
+	if frame.SponsorID > 0 {
+		if _, err := rt.db.GetSponsor(frame.SponsorID); errors.Is(err, sql.ErrNoRows) {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Sponsor non trovato.")
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("cannot load sponsor for selfie frame")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	exists, err := rt.eventExists(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events while saving selfie frame")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	saved, err := rt.db.SaveSelfieFrame(frame)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot save selfie frame")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.screenHub.notify(eventID)
+
+	if err := writeJSON(w, http.StatusOK, saved); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie frame")
+	}
+}
+
+func (rt *_router) deleteAdminSelfieFrame(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.DeleteSelfieFrame(eventID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete selfie frame")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.screenHub.notify(eventID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getBrandedSelfie sends an approved selfie with the frame of its event. The branded image is stored next to the
+// original and made again only when the frame, or the selfie, changes. Selfies saved before the blob store existed are
+// sent without the frame.
+func (rt *_router) getBrandedSelfie(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	selfieID, err := strconv.Atoi(chi.URLParam(r, "selfieId"))
+	if err != nil || selfieID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	selfie, err := rt.db.GetSelfieByID(selfieID)
+	if err != nil || selfie.EventID != eventID || !selfie.Approved {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	frame, err := rt.db.GetSelfieFrame(eventID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load selfie frame")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	key := strings.TrimSpace(selfie.ImagePath)
+	contentType := strings.TrimSpace(selfie.ContentType)
+	if key == "" || isLegacySelfiePath(key) || (contentType != "image/jpeg" && contentType != "image/png" && contentType != "image/webp") {
+		rt.serveSelfieFile(w, r, ctx, selfie, true, false)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=60")
+
+	brandedKey := selfieBrandedKey(key)
+	fresh, err := rt.brandedSelfieFresh(r.Context(), selfie, frame)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot check branded selfie")
+	}
+	if !fresh {
+		err := rt.renderBrandedSelfie(ctx, selfie, frame)
+		if errors.Is(err, blobstore.ErrNotFound) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		} else if err != nil {
+			ctx.Logger.WithError(err).Error("cannot brand selfie")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	rt.serveSelfieBlob(w, r, ctx, brandedKey, "image/jpeg")
+}
+
+// brandingCall is a render of a branded selfie, that the concurrent requests for the same image wait for.
+type brandingCall struct {
+	done chan struct{}
+	err  error
+}
+
+// renderBrandedSelfie makes and stores the branded image of the selfie. Every screen and phone asks for it as soon as
+// the selfie is approved, so the concurrent requests for the same selfie and frame revision share a single render.
+// The render does not depend on the request that started it, since the others are waiting for it.
+func (rt *_router) renderBrandedSelfie(ctx reqcontext.RequestContext, selfie database.Selfie, frame database.SelfieFrame) error {
+	source := strings.TrimSpace(selfie.ImagePath)
+	key := fmt.Sprintf("%d/%d/%s", selfie.ID, frame.Revision, source)
+	rt.brandingMu.Lock()
+	call, running := rt.brandingCalls[key]
+	if !running {
+		call = &brandingCall{done: make(chan struct{})}
+		rt.brandingCalls[key] = call
+	}
+	rt.brandingMu.Unlock()
+	if running {
+		<-call.done
+		return call.err
+	}
+
+	defer func() {
+		rt.brandingMu.Lock()
+		delete(rt.brandingCalls, key)
+		rt.brandingMu.Unlock()
+		close(call.done)
+	}()
+
+	// A render may have just finished, between the freshness check of the request and the lock
+	background := context.Background()
+	if fresh, err := rt.brandedSelfieFresh(background, selfie, frame); err == nil && fresh {
+		return nil
+	}
+	branded, err := rt.brandSelfie(background, ctx, selfie, frame)
+	if err != nil {
+		call.err = err
+		return err
+	}
+	if err := rt.blobs.Put(background, selfieBrandedKey(source), branded, "image/jpeg"); err != nil {
+		call.err = fmt.Errorf("persisting branded selfie: %w", err)
+		return call.err
+	}
+	rendition := database.SelfieRendition{SelfieID: selfie.ID, EventID: selfie.EventID, SourcePath: source, FrameRevision: frame.Revision}
+	if err := rt.db.SaveSelfieRendition(rendition); err != nil {
+		ctx.Logger.WithError(err).Warn("cannot record branded selfie")
+	}
+	return nil
+}
+
+// brandedSelfieFresh tells whether the stored branded rendition of the selfie was made from its current image with
+// the current frame.
+func (rt *_router) brandedSelfieFresh(ctx context.Context, selfie database.Selfie, frame database.SelfieFrame) (bool, error) {
+	rendition, err := rt.db.GetSelfieRendition(selfie.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if rendition.FrameRevision != frame.Revision || rendition.SourcePath != selfie.ImagePath {
+		return false, nil
+	}
+	if _, err := rt.blobs.Stat(ctx, selfieBrandedKey(selfie.ImagePath)); errors.Is(err, blobstore.ErrNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// brandSelfie composites the frame onto the selfie image. A sponsor logo that cannot be decoded (like an SVG logo) is
+// left out.
+func (rt *_router) brandSelfie(ctx context.Context, reqCtx reqcontext.RequestContext, selfie database.Selfie, frame database.SelfieFrame) ([]byte, error) {
+	content, _, err := rt.blobs.Get(ctx, selfie.ImagePath)
+	if err != nil {
+		return nil, err
+	}
+	picture, err := io.ReadAll(content)
+	content.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	branding := imaging.Frame{LogoPosition: frame.LogoPosition}
+	if frame.CaptionPosition != imaging.CaptionNone {
+		branding.Caption = selfie.Caption
+		branding.CaptionPosition = frame.CaptionPosition
+	}
+	if frame.OverlayData != "" {
+		data, _, err := decodeBase64Image(frame.OverlayData)
+		if err != nil {
+			return nil, fmt.Errorf("decoding frame overlay: %w", err)
+		}
+		if branding.Overlay, err = imaging.Decode(data, imaging.DefaultOptions.MaxPixels); err != nil {
+			return nil, fmt.Errorf("decoding frame overlay: %w", err)
+		}
+	}
+	if frame.SponsorID > 0 {
+		branding.Logo, err = rt.decodeSponsorLogo(ctx, frame.SponsorID)
+		if err != nil {
+			reqCtx.Logger.WithError(err).WithField("sponsor_id", frame.SponsorID).Warn("cannot use sponsor logo on selfie frame")
+		}
+	}
+
+	return imaging.Brand(picture, branding, imaging.DefaultOptions)
+}
+
+func (rt *_router) decodeSponsorLogo(ctx context.Context, sponsorID int) (image.Image, error) {
+	sponsor, err := rt.db.GetSponsor(sponsorID)
+	if err != nil {
+		return nil, err
+	}
+	if sponsor.LogoAsset == "" {
+		data, _, err := decodeBase64Image(sponsor.LogoData)
+		if err != nil {
+			return nil, err
+		}
+		return imaging.Decode(data, imaging.DefaultOptions.MaxPixels)
+	}
+
+	content, _, err := rt.blobs.Get(ctx, sponsorAssetKey(sponsor.LogoAsset, "large"))
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+	data, err := io.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+	return imaging.Decode(data, imaging.DefaultOptions.MaxPixels)
+}
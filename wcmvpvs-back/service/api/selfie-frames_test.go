@@ -0,0 +1,145 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+// countingPutStore counts the blobs it stores, slowly enough that concurrent requests overlap.
+type countingPutStore struct {
+	blobstore.Store
+	puts int32
+}
+
+func (s *countingPutStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	atomic.AddInt32(&s.puts, 1)
+	time.Sleep(20 * time.Millisecond)
+	return s.Store.Put(ctx, key, data, contentType)
+}
+
+func TestBrandedSelfie(t *testing.T) {
+	h := newTestHarness(t)
+	h.router.screenAPIKey = "screen-key"
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	sponsorID, err := h.db.CreateSponsor(database.Sponsor{Name: "Main sponsor", LogoData: testPNGDataURL(t), Position: 1, IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+
+	var overlay bytes.Buffer
+	if err := png.Encode(&overlay, image.NewRGBA(image.Rect(0, 0, 40, 50))); err != nil {
+		t.Fatalf("cannot encode overlay: %v", err)
+	}
+	framePath := fmt.Sprintf("/admin/events/%d/selfie-frame", fixture.EventID)
+	if rec := h.do(http.MethodPut, framePath, map[string]string{"caption_position": "middle"}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid caption position: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	settings := map[string]interface{}{
+		"overlay_data":     "data:image/png;base64," + base64.StdEncoding.EncodeToString(overlay.Bytes()),
+		"caption_position": "top",
+		"sponsor_id":       sponsorID,
+		"logo_position":    "bottom-left",
+	}
+	rec := h.do(http.MethodPut, framePath, settings, adminHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("save frame: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var frame database.SelfieFrame
+	h.decode(rec, &frame)
+	if frame.Revision != 1 || frame.SponsorID != sponsorID {
+		t.Fatalf("saved frame = %+v", frame)
+	}
+
+	h.mustVote(fixture, "device-1")
+	upload := map[string]string{"caption": "Forza ragazzi!", "image_base64": testPNGDataURL(t)}
+	rec = h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": "device-1"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d", rec.Code)
+	}
+	var selfie selfieResponse
+	h.decode(rec, &selfie)
+
+	brandedPath := fmt.Sprintf("/events/%d/selfies/%d/branded", fixture.EventID, selfie.ID)
+	if rec := h.do(http.MethodGet, brandedPath, nil, map[string]string{"X-Device-ID": "device-1"}); rec.Code != http.StatusNotFound {
+		t.Fatalf("branded pending selfie: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", selfie.ID), map[string]bool{"show_on_screen": true}, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("approve: status = %d", rec.Code)
+	}
+
+	branded := func() image.Image {
+		t.Helper()
+		rec := h.do(http.MethodGet, brandedPath, nil, nil)
+		if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+			t.Fatalf("branded: status = %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+		}
+		img, err := jpeg.Decode(rec.Body)
+		if err != nil {
+			t.Fatalf("cannot decode branded selfie: %v", err)
+		}
+		return img
+	}
+	if img := branded(); img.Bounds().Dx() != 40 || img.Bounds().Dy() != 50 {
+		t.Fatalf("branded size = %v, want the overlay size", img.Bounds())
+	}
+	if rendition, err := h.db.GetSelfieRendition(selfie.ID); err != nil || rendition.FrameRevision != 1 {
+		t.Fatalf("rendition = %+v (%v), want revision 1", rendition, err)
+	}
+
+	// A new sponsor logo makes the selfies branded again
+	if err := h.db.UpdateSponsor(database.Sponsor{ID: sponsorID, Name: "Main sponsor", LogoData: testPNGDataURL(t), Position: 1, IsActive: true}); err != nil {
+		t.Fatalf("cannot update sponsor: %v", err)
+	}
+	var playlist screenPlaylistResponse
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/screen/events/%d/playlist", fixture.EventID), nil, map[string]string{"X-Screen-Key": "screen-key"}), &playlist)
+	if len(playlist.Items) != 1 || !strings.HasSuffix(playlist.Items[0].BrandedURL, "/branded?v=2") {
+		t.Fatalf("screen playlist = %+v, want the branded url of revision 2", playlist.Items)
+	}
+	// The screens asking for it at once wait for a single render
+	store := &countingPutStore{Store: h.router.blobs}
+	h.router.blobs = store
+	var wg sync.WaitGroup
+	codes := make([]int, 8)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = h.do(http.MethodGet, brandedPath, nil, nil).Code
+		}(i)
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("concurrent branded request %d: status = %d", i, code)
+		}
+	}
+	if puts := atomic.LoadInt32(&store.puts); puts != 1 {
+		t.Fatalf("branded selfie stored %d times, want once", puts)
+	}
+	branded()
+	if rendition, err := h.db.GetSelfieRendition(selfie.ID); err != nil || rendition.FrameRevision != 2 {
+		t.Fatalf("rendition = %+v (%v), want revision 2", rendition, err)
+	}
+
+	if rec := h.do(http.MethodDelete, framePath, nil, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete frame: status = %d", rec.Code)
+	}
+	if rec := h.do(http.MethodGet, brandedPath, nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("branded without frame: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,238 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/imaging"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// selfieClaimDuration is how long a moderator keeps the selfies taken from the queue
+	selfieClaimDuration = 5 * time.Minute
+
+	defaultSelfieQueueLimit    = 50
+	maxSelfieQueueLimit        = 200
+	defaultSelfieClaimLimit    = 10
+	maxSelfieBulkSize          = 200
+	maxRejectionReasonLength   = 200
+	selfieDuplicateMaxDistance = 6
+)
+
+// selfieClaimActive tells whether someone is reviewing the selfie at the given time.
+func selfieClaimActive(selfie database.Selfie, now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, selfie.ClaimedUntil)
+	return err == nil && until.After(now)
+}
+
+func sanitizeRejectionReason(value string) string {
+	runes := []rune(strings.TrimSpace(value))
+	if len(runes) > maxRejectionReasonLength {
+		return string(runes[:maxRejectionReasonLength])
+	}
+	return string(runes)
+}
+
+// findDuplicateSelfie returns the oldest selfie uploaded by another device with the same picture (a perceptual hash
+// at most selfieDuplicateMaxDistance bits away), or zero.
+func findDuplicateSelfie(hash, deviceID string, candidates []database.Selfie) int {
+	duplicate := database.Selfie{}
+	for _, candidate := range candidates {
+		if candidate.DeviceID == deviceID {
+			continue
+		}
+		distance := imaging.HashDistance(hash, candidate.PerceptualHash)
+		if distance < 0 || distance > selfieDuplicateMaxDistance {
+			continue
+		}
+		if duplicate.ID == 0 || candidate.ID < duplicate.ID {
+			duplicate = candidate
+		}
+	}
+	return duplicate.ID
+}
+
+func parsePositiveLimit(value string, fallback, max int) (int, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		return 0, false
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit, true
+}
+
+type selfieQueueResponse struct {
+	Status string                     `json:"status"`
+	Counts database.SelfieQueueCounts `json:"counts"`
+	Items  []adminSelfieResponse      `json:"items"`
+}
+
+// getSelfieQueue lists the selfies of the event in a moderation status (pending by default), with the number of
+// selfies in each status.
+func (rt *_router) getSelfieQueue(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	status := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("status")))
+	if status == "" {
+		status = database.SelfieStatusPending
+	}
+	if status != database.SelfieStatusPending && status != database.SelfieStatusApproved && status != database.SelfieStatusRejected {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stato non valido: usa pending, approved o rejected.")
+		return
+	}
+	limit, ok := parsePositiveLimit(r.URL.Query().Get("limit"), defaultSelfieQueueLimit, maxSelfieQueueLimit)
+	if !ok {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Limite non valido.")
+		return
+	}
+
+	selfies, err := rt.db.ListSelfieQueue(eventID, status, limit)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list selfie queue")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	counts, err := rt.db.CountSelfieQueue(eventID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot count selfie queue")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := selfieQueueResponse{Status: status, Counts: counts, Items: rt.buildAdminSelfieResponses(ctx, selfies)}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode selfie queue")
+	}
+}
+
+// claimSelfies reserves pending selfies for the current moderator, so that two moderators do not review the same
+// selfies. Claims expire after selfieClaimDuration; claiming again extends them.
+func (rt *_router) claimSelfies(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Limit int `json:"limit"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	limit := payload.Limit
+	if limit <= 0 {
+		limit = defaultSelfieClaimLimit
+	}
+	if limit > maxSelfieQueueLimit {
+		limit = maxSelfieQueueLimit
+	}
+
+	now := globaltime.Now()
+	selfies, err := rt.db.ClaimSelfies(eventID, ctx.AdminUsername, limit, now, now.Add(selfieClaimDuration))
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot claim selfies")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, rt.buildAdminSelfieResponses(ctx, selfies)); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode claimed selfies")
+	}
+}
+
+func (rt *_router) releaseSelfieClaims(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err := rt.db.ReleaseSelfieClaims(eventID, ctx.AdminUsername); err != nil {
+		ctx.Logger.WithError(err).Error("cannot release selfie claims")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bulkModerateSelfies approves or rejects many selfies of the event at once. Selfies claimed by other moderators are
+// skipped and reported in the response.
+func (rt *_router) bulkModerateSelfies(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Action          string `json:"action"`
+		SelfieIDs       []int  `json:"selfie_ids"`
+		RejectionReason string `json:"rejection_reason"`
+		ShowOnScreen    bool   `json:"show_on_screen"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	moderation := database.SelfieModeration{
+		Moderator: ctx.AdminUsername,
+		At:        globaltime.Now(),
+	}
+	switch strings.ToLower(strings.TrimSpace(payload.Action)) {
+	case "approve":
+		moderation.Status = database.SelfieStatusApproved
+		moderation.ShowOnScreen = payload.ShowOnScreen
+	case "reject":
+		moderation.Status = database.SelfieStatusRejected
+		moderation.RejectionReason = sanitizeRejectionReason(payload.RejectionReason)
+	default:
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Azione non valida: usa approve o reject.")
+		return
+	}
+	if len(payload.SelfieIDs) == 0 || len(payload.SelfieIDs) > maxSelfieBulkSize {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Seleziona da 1 a 200 selfie.")
+		return
+	}
+
+	result, err := rt.db.ModerateSelfies(eventID, payload.SelfieIDs, moderation)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot moderate selfies")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if len(result.Updated) > 0 {
+		rt.screenHub.notify(eventID)
+	}
+	if err := writeJSON(w, http.StatusOK, result); err != nil {
+		ctx.Logger.WithError(err).Error("cannot encode bulk moderation result")
+	}
+}
+
+func (rt *_router) buildAdminSelfieResponses(ctx reqcontext.RequestContext, selfies []database.Selfie) []adminSelfieResponse {
+	responses := make([]adminSelfieResponse, 0, len(selfies))
+	for _, selfie := range selfies {
+		selfie, err := rt.ensureSelfieURL(selfie)
+		if err != nil {
+			ctx.Logger.WithError(err).Warn("cannot ensure selfie url")
+		}
+		responses = append(responses, rt.buildAdminSelfieResponse(selfie))
+	}
+	return responses
+}
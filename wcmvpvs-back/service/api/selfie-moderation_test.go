@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSelfieModerationQueue(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	alice := h.createAdmin("alice", "staff")
+	bob := h.createAdmin("bob", "staff")
+
+	upload := map[string]string{"image_base64": testPNGDataURL(t)}
+	var ids []int
+	for _, device := range []string{"device-1", "device-2"} {
+		h.mustVote(fixture, device)
+		rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": device})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("upload: status = %d", rec.Code)
+		}
+		var created selfieResponse
+		h.decode(rec, &created)
+		if created.Status != database.SelfieStatusPending {
+			t.Fatalf("new selfie status = %q, want pending", created.Status)
+		}
+		ids = append(ids, created.ID)
+	}
+
+	claim := func(token string) []adminSelfieResponse {
+		t.Helper()
+		rec := h.do(http.MethodPost, fmt.Sprintf("/admin/events/%d/selfies/claim", fixture.EventID), map[string]int{"limit": 1}, adminHeaders(token))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("claim: status = %d", rec.Code)
+		}
+		var claimed []adminSelfieResponse
+		h.decode(rec, &claimed)
+		return claimed
+	}
+	if claimed := claim(alice); len(claimed) != 1 || claimed[0].ID != ids[0] || claimed[0].ClaimedBy != "alice" {
+		t.Fatalf("alice claimed %+v, want selfie %d", claimed, ids[0])
+	}
+	claimed := claim(bob)
+	if len(claimed) != 1 || claimed[0].ID != ids[1] {
+		t.Fatalf("bob claimed %+v, want selfie %d", claimed, ids[1])
+	}
+	if claimed[0].DuplicateOfID != ids[0] {
+		t.Fatalf("duplicate of = %d, want %d", claimed[0].DuplicateOfID, ids[0])
+	}
+
+	if rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", ids[0]), map[string]bool{"approved": true}, adminHeaders(bob)); rec.Code != http.StatusConflict {
+		t.Fatalf("moderating a selfie claimed by another moderator: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	bulkPath := fmt.Sprintf("/admin/events/%d/selfies/bulk", fixture.EventID)
+	reject := map[string]interface{}{"action": "reject", "selfie_ids": []int{ids[0], ids[1], 9999}, "rejection_reason": "Foto non adatta"}
+	rec := h.do(http.MethodPost, bulkPath, reject, adminHeaders(alice))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("bulk reject: status = %d", rec.Code)
+	}
+	var result database.SelfieModerationResult
+	h.decode(rec, &result)
+	if fmt.Sprint(result.Updated) != fmt.Sprint([]int{ids[0]}) || fmt.Sprint(result.Claimed) != fmt.Sprint([]int{ids[1]}) || fmt.Sprint(result.Missing) != "[9999]" {
+		t.Fatalf("bulk reject result = %+v", result)
+	}
+
+	rec = h.do(http.MethodGet, fmt.Sprintf("/events/%d/selfies/me", fixture.EventID), nil, map[string]string{"X-Device-ID": "device-1"})
+	var own selfieResponse
+	h.decode(rec, &own)
+	if own.Status != database.SelfieStatusRejected || own.RejectionReason != "Foto non adatta" {
+		t.Fatalf("own selfie = %+v, want rejected with reason", own)
+	}
+
+	if rec := h.do(http.MethodDelete, fmt.Sprintf("/admin/events/%d/selfies/claim", fixture.EventID), nil, adminHeaders(bob)); rec.Code != http.StatusNoContent {
+		t.Fatalf("release: status = %d", rec.Code)
+	}
+	approve := map[string]interface{}{"action": "approve", "selfie_ids": []int{ids[1]}}
+	h.decode(h.do(http.MethodPost, bulkPath, approve, adminHeaders(alice)), &result)
+	if fmt.Sprint(result.Updated) != fmt.Sprint([]int{ids[1]}) {
+		t.Fatalf("bulk approve result = %+v", result)
+	}
+
+	var queue selfieQueueResponse
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/admin/events/%d/selfies/queue?status=rejected", fixture.EventID), nil, adminHeaders(alice)), &queue)
+	want := database.SelfieQueueCounts{Approved: 1, Rejected: 1}
+	if queue.Counts != want || len(queue.Items) != 1 || queue.Items[0].ID != ids[0] || queue.Items[0].ModeratedBy != "alice" {
+		t.Fatalf("rejected queue = %+v", queue)
+	}
+}
@@ -0,0 +1,243 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+// legacySelfieDir is where selfies were written before the blob store existed. Those rows have an absolute file path
+// as image_path, instead of a blob key, until `mvpvsblobs migrate` moves them.
+var legacySelfieDir = filepath.Join("tmp", "selfies")
+
+func selfieImageKey(eventID int, filename string) string {
+	return fmt.Sprintf("selfies/event_%d/%s", eventID, filename)
+}
+
+// selfieThumbnailKey returns the key of the thumbnail generated for the image stored at imageKey.
+func selfieThumbnailKey(imageKey string) string {
+	return strings.TrimSuffix(imageKey, path.Ext(imageKey)) + "_thumb.jpg"
+}
+
+// selfieBrandedKey returns the key of the branded rendition of the image stored at imageKey.
+func selfieBrandedKey(imageKey string) string {
+	return strings.TrimSuffix(imageKey, path.Ext(imageKey)) + "_branded.jpg"
+}
+
+func isLegacySelfiePath(imagePath string) bool {
+	return filepath.IsAbs(imagePath)
+}
+
+// removeSelfieFiles deletes the selfie images referenced by image_path values, logging the failures.
+func (rt *_router) removeSelfieFiles(paths []string) {
+	for _, path := range paths {
+		if err := rt.deleteSelfieImage(context.Background(), path); err != nil {
+			rt.baseLogger.WithError(err).WithField("path", path).Warn("cannot remove selfie image")
+		}
+	}
+}
+
+func (rt *_router) deleteSelfieImage(ctx context.Context, imagePath string) error {
+	imagePath = strings.TrimSpace(imagePath)
+	switch {
+	case imagePath == "":
+		return nil
+	case isLegacySelfiePath(imagePath):
+		if err := os.Remove(imagePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	default:
+		for _, key := range []string{selfieThumbnailKey(imagePath), selfieBrandedKey(imagePath)} {
+			if err := rt.blobs.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return rt.blobs.Delete(ctx, imagePath)
+	}
+}
+
+// openSelfieImage opens the original image of the selfie, from the blob store or, for legacy selfies, from the disk.
+// Missing images, and legacy paths outside legacySelfieDir, return blobstore.ErrNotFound.
+func (rt *_router) openSelfieImage(ctx context.Context, selfie database.Selfie) (io.ReadCloser, error) {
+	key := strings.TrimSpace(selfie.ImagePath)
+	if key == "" {
+		return nil, blobstore.ErrNotFound
+	}
+	if !isLegacySelfiePath(key) {
+		content, _, err := rt.blobs.Get(ctx, key)
+		return content, err
+	}
+
+	baseDir, err := filepath.Abs(legacySelfieDir)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(filepath.Clean(key), baseDir+string(os.PathSeparator)) {
+		return nil, blobstore.ErrNotFound
+	}
+	file, err := os.Open(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, blobstore.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (rt *_router) getSelfieFileSize(selfie database.Selfie) int64 {
+	path := strings.TrimSpace(selfie.ImagePath)
+	if path == "" {
+		return 0
+	}
+	if isLegacySelfiePath(path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0
+		}
+		return info.Size()
+	}
+	info, err := rt.blobs.Stat(context.Background(), path)
+	if err != nil {
+		return 0
+	}
+	return info.Size
+}
+
+// serveSelfieFile sends the selfie image, or its thumbnail. Selfies without a thumbnail (saved before thumbnails
+// existed, or in a format that cannot be resized) get the full image.
+func (rt *_router) serveSelfieFile(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, selfie database.Selfie, allowCache, thumbnail bool) {
+	key := strings.TrimSpace(selfie.ImagePath)
+	if key == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if allowCache {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	if isLegacySelfiePath(key) {
+		rt.serveLegacySelfieFile(w, r, ctx, selfie)
+		return
+	}
+	contentType := strings.TrimSpace(selfie.ContentType)
+	if thumbnail {
+		if _, err := rt.blobs.Stat(r.Context(), selfieThumbnailKey(key)); err == nil {
+			key = selfieThumbnailKey(key)
+			contentType = "image/jpeg"
+		} else if !errors.Is(err, blobstore.ErrNotFound) {
+			ctx.Logger.WithError(err).Warn("cannot check selfie thumbnail")
+		}
+	}
+	rt.serveSelfieBlob(w, r, ctx, key, contentType)
+}
+
+// serveSelfieBlob sends a selfie image stored in the blob store. When the store supports presigned URLs and
+// rt.blobURLExpiry is set, the client is redirected to the store; otherwise the image is proxied.
+func (rt *_router) serveSelfieBlob(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, key, contentType string) {
+	if rt.blobURLExpiry > 0 {
+		presigned, err := rt.blobs.PresignedURL(key, rt.blobURLExpiry)
+		if err != nil {
+			ctx.Logger.WithError(err).Warn("cannot presign selfie url")
+		} else if presigned != "" {
+			http.Redirect(w, r, presigned, http.StatusFound)
+			return
+		}
+	}
+
+	content, info, err := rt.blobs.Get(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot read selfie image")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	if contentType == "" {
+		contentType = info.ContentType
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, filepath.Base(key), info.ModTime, seeker)
+		return
+	}
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		if _, err := io.Copy(w, content); err != nil {
+			ctx.Logger.WithError(err).Warn("cannot write selfie image")
+		}
+	}
+}
+
+func (rt *_router) serveLegacySelfieFile(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, selfie database.Selfie) {
+	absPath, err := filepath.Abs(selfie.ImagePath)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("invalid selfie path")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	baseDir, err := filepath.Abs(legacySelfieDir)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot resolve selfies directory")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	prefix := baseDir + string(os.PathSeparator)
+	if absPath != baseDir && !strings.HasPrefix(absPath, prefix) {
+		ctx.Logger.Warn("selfie path outside storage directory")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	file, err := os.Open(absPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	contentType := strings.TrimSpace(selfie.ContentType)
+	if contentType == "" {
+		buf := make([]byte, 512)
+		n, _ := file.Read(buf)
+		if n > 0 {
+			contentType = http.DetectContentType(buf[:n])
+		}
+		_, _ = file.Seek(0, io.SeekStart)
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	http.ServeContent(w, r, filepath.Base(absPath), info.ModTime(), file)
+}
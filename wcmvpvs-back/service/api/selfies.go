@@ -10,13 +10,13 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/imaging"
 	"github.com/go-chi/chi/v5"
 	"github.com/gofrs/uuid"
 )
@@ -37,16 +37,31 @@ type selfieResponse struct {
 	EventID      int    `json:"event_id"`
 	Caption      string `json:"caption"`
 	ImageURL     string `json:"image_url"`
+	ThumbnailURL string `json:"thumbnail_url"`
 	ContentType  string `json:"content_type"`
 	Approved     bool   `json:"approved"`
 	ShowOnScreen bool   `json:"show_on_screen"`
 	SubmittedAt  string `json:"submitted_at"`
+
+	// Status is pending, approved or rejected; RejectionReason tells the fan why the selfie was rejected
+	Status          string `json:"status"`
+	RejectionReason string `json:"rejection_reason,omitempty"`
 }
 
 type adminSelfieResponse struct {
 	selfieResponse
 	DeviceToken   string `json:"device_token"`
 	FileSizeBytes int64  `json:"file_size_bytes"`
+	ModeratedBy   string `json:"moderated_by,omitempty"`
+	ModeratedAt   string `json:"moderated_at,omitempty"`
+	ClaimedBy     string `json:"claimed_by,omitempty"`
+	ClaimedUntil  string `json:"claimed_until,omitempty"`
+
+	// DuplicateOfID is an earlier selfie of the event with the same picture
+	DuplicateOfID int `json:"duplicate_of_id,omitempty"`
+
+	// CaptionFlagged is set when the text filter found offensive words in the caption
+	CaptionFlagged bool `json:"caption_flagged,omitempty"`
 }
 
 func (rt *_router) deviceIDFromRequest(r *http.Request) string {
@@ -66,18 +81,6 @@ func sanitizeCaption(value string) string {
 	return trimmed
 }
 
-func (rt *_router) ensureSelfieDir(eventID int) (string, error) {
-	baseDir := filepath.Join("tmp", "selfies", fmt.Sprintf("event_%d", eventID))
-	absDir, err := filepath.Abs(baseDir)
-	if err != nil {
-		return "", err
-	}
-	if err := os.MkdirAll(absDir, 0o755); err != nil {
-		return "", err
-	}
-	return absDir, nil
-}
-
 func detectContentType(data []byte, fallback string) string {
 	if len(data) == 0 {
 		return fallback
@@ -90,11 +93,17 @@ func detectContentType(data []byte, fallback string) string {
 }
 
 func (rt *_router) buildSelfieImagePath(selfie database.Selfie) string {
+	return buildSelfieAssetPath(selfie, "image")
+}
+
+// buildSelfieAssetPath returns the public path of the selfie image ("image") or thumbnail ("thumbnail"). Selfies not
+// approved yet are only visible to the device that uploaded them, so the path carries the device ID.
+func buildSelfieAssetPath(selfie database.Selfie, asset string) string {
 	if selfie.ID == 0 || selfie.EventID == 0 {
 		return ""
 	}
 
-	base := fmt.Sprintf("/events/%d/selfies/%d/image", selfie.EventID, selfie.ID)
+	base := fmt.Sprintf("/events/%d/selfies/%d/%s", selfie.EventID, selfie.ID, asset)
 	if selfie.Approved {
 		return base
 	}
@@ -258,13 +267,35 @@ func buildSelfieResponsePayload(selfie database.Selfie) selfieResponse {
 		EventID:      selfie.EventID,
 		Caption:      selfie.Caption,
 		ImageURL:     selfie.ImageURL,
+		ThumbnailURL: buildSelfieAssetPath(selfie, "thumbnail"),
 		ContentType:  selfie.ContentType,
 		Approved:     selfie.Approved,
 		ShowOnScreen: selfie.ShowOnScreen,
 		SubmittedAt:  selfie.CreatedAt,
+
+		Status:          selfie.Status,
+		RejectionReason: selfie.RejectionReason,
 	}
 }
 
+func (rt *_router) buildAdminSelfieResponse(selfie database.Selfie) adminSelfieResponse {
+	response := adminSelfieResponse{
+		selfieResponse: buildSelfieResponsePayload(selfie),
+		DeviceToken:    selfie.DeviceID,
+		FileSizeBytes:  rt.getSelfieFileSize(selfie),
+		ModeratedBy:    selfie.ModeratedBy,
+		ModeratedAt:    selfie.ModeratedAt,
+		DuplicateOfID:  selfie.DuplicateOfID,
+		CaptionFlagged: selfie.CaptionFlagged,
+	}
+	// Expired claims are not worth showing
+	if selfie.ClaimedBy != "" && selfieClaimActive(selfie, globaltime.Now()) {
+		response.ClaimedBy = selfie.ClaimedBy
+		response.ClaimedUntil = selfie.ClaimedUntil
+	}
+	return response
+}
+
 func (rt *_router) getVoteStatus(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
 	eventID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || eventID <= 0 {
@@ -319,45 +350,82 @@ func (rt *_router) postSelfie(w http.ResponseWriter, r *http.Request, ctx reqcon
 		_ = writeJSONMessage(w, http.StatusBadRequest, "Immagine non valida o troppo pesante.")
 		return
 	}
-	ext, err := validateSelfieContentType(contentType)
-	if err != nil {
+	if _, err := validateSelfieContentType(contentType); err != nil {
 		ctx.Logger.WithError(err).Warn("unsupported selfie content type")
 		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato immagine non supportato.")
 		return
 	}
-	contentType = strings.ToLower(strings.TrimSpace(contentType))
-
-	storageDir, err := rt.ensureSelfieDir(eventID)
+	filtered, err := rt.filterText(database.TextFilterTargetSelfieCaption, caption)
 	if err != nil {
-		ctx.Logger.WithError(err).Error("cannot ensure selfie directory")
+		ctx.Logger.WithError(err).Error("cannot filter selfie caption")
 		_ = writeJSONMessage(w, http.StatusInternalServerError, "Servizio non disponibile al momento.")
 		return
 	}
+	if filtered.Rejected {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "La didascalia contiene parole non consentite.")
+		return
+	}
+	caption = filtered.Text
+
+	// Metadata (like the GPS position) is removed and the image is normalized before storing it.
+	processed, err := imaging.Process(data, contentType, imaging.DefaultOptions)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot process selfie image")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Immagine non valida o troppo pesante.")
+		return
+	}
+	contentType = processed.ContentType
+
 	fileID, err := uuid.NewV4()
 	if err != nil {
 		ctx.Logger.WithError(err).Error("cannot generate selfie identifier")
 		_ = writeJSONMessage(w, http.StatusInternalServerError, "Servizio non disponibile al momento.")
 		return
 	}
-	filename := fmt.Sprintf("%s%s", fileID.String(), ext)
-	fullPath := filepath.Join(storageDir, filename)
+	imageKey := selfieImageKey(eventID, fileID.String()+allowedSelfieTypes[contentType])
 
-	if err := os.WriteFile(fullPath, data, 0o644); err != nil {
+	if err := rt.blobs.Put(r.Context(), imageKey, processed.Image, contentType); err != nil {
 		ctx.Logger.WithError(err).Error("cannot persist selfie image")
 		_ = writeJSONMessage(w, http.StatusInternalServerError, "Impossibile salvare l'immagine.")
 		return
 	}
+	if processed.Thumbnail != nil {
+		if err := rt.blobs.Put(r.Context(), selfieThumbnailKey(imageKey), processed.Thumbnail, "image/jpeg"); err != nil {
+			ctx.Logger.WithError(err).Error("cannot persist selfie thumbnail")
+			_ = writeJSONMessage(w, http.StatusInternalServerError, "Impossibile salvare l'immagine.")
+			_ = rt.blobs.Delete(r.Context(), imageKey)
+			return
+		}
+	}
 
 	existing, err := rt.db.GetSelfieForDevice(eventID, deviceID)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		ctx.Logger.WithError(err).Error("cannot lookup previous selfie")
 	}
 
-	selfie, err := rt.db.SaveSelfie(eventID, deviceID, caption, fullPath, contentType)
+	duplicateOf := 0
+	if processed.Hash != "" {
+		candidates, err := rt.db.ListEventSelfies(eventID)
+		if err != nil {
+			ctx.Logger.WithError(err).Warn("cannot look for duplicate selfies")
+		}
+		duplicateOf = findDuplicateSelfie(processed.Hash, deviceID, candidates)
+	}
+
+	selfie, err := rt.db.SaveSelfie(database.NewSelfie{
+		EventID:        eventID,
+		DeviceID:       deviceID,
+		Caption:        caption,
+		ImagePath:      imageKey,
+		ContentType:    contentType,
+		PerceptualHash: processed.Hash,
+		DuplicateOfID:  duplicateOf,
+		CaptionFlagged: filtered.Flagged,
+	})
 	if err != nil {
 		ctx.Logger.WithError(err).Error("cannot store selfie metadata")
 		_ = writeJSONMessage(w, http.StatusInternalServerError, "Impossibile salvare il selfie.")
-		_ = os.Remove(fullPath)
+		_ = rt.deleteSelfieImage(r.Context(), imageKey)
 		return
 	}
 
@@ -367,7 +435,12 @@ func (rt *_router) postSelfie(w http.ResponseWriter, r *http.Request, ctx reqcon
 	}
 
 	if existing.ID > 0 && existing.ImagePath != "" && existing.ImagePath != selfie.ImagePath {
-		_ = os.Remove(existing.ImagePath)
+		if err := rt.deleteSelfieImage(r.Context(), existing.ImagePath); err != nil {
+			ctx.Logger.WithError(err).Warn("cannot remove previous selfie image")
+		}
+	}
+	if existing.ShowOnScreen {
+		rt.screenHub.notify(eventID)
 	}
 
 	response := buildSelfieResponsePayload(selfie)
@@ -443,19 +516,7 @@ func (rt *_router) listAdminSelfies(w http.ResponseWriter, r *http.Request, ctx
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	responses := make([]adminSelfieResponse, 0, len(selfies))
-	for _, selfie := range selfies {
-		selfie, err = rt.ensureSelfieURL(selfie)
-		if err != nil {
-			ctx.Logger.WithError(err).Warn("cannot ensure selfie url")
-		}
-		responses = append(responses, adminSelfieResponse{
-			selfieResponse: buildSelfieResponsePayload(selfie),
-			DeviceToken:    selfie.DeviceID,
-			FileSizeBytes:  rt.getSelfieFileSize(selfie),
-		})
-	}
-	if err := writeJSON(w, http.StatusOK, responses); err != nil {
+	if err := writeJSON(w, http.StatusOK, rt.buildAdminSelfieResponses(ctx, selfies)); err != nil {
 		ctx.Logger.WithError(err).Error("cannot encode admin selfie list")
 	}
 }
@@ -467,8 +528,9 @@ func (rt *_router) updateSelfieModeration(w http.ResponseWriter, r *http.Request
 		return
 	}
 	var payload struct {
-		Approved     *bool `json:"approved"`
-		ShowOnScreen *bool `json:"show_on_screen"`
+		Approved        *bool  `json:"approved"`
+		ShowOnScreen    *bool  `json:"show_on_screen"`
+		RejectionReason string `json:"rejection_reason"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -486,22 +548,39 @@ func (rt *_router) updateSelfieModeration(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	approved := selfie.Approved
-	showOnScreen := selfie.ShowOnScreen
+	moderation := database.SelfieModeration{
+		Status:          selfie.Status,
+		ShowOnScreen:    selfie.ShowOnScreen,
+		RejectionReason: selfie.RejectionReason,
+		Moderator:       ctx.AdminUsername,
+		At:              globaltime.Now(),
+	}
 	if payload.Approved != nil {
-		approved = *payload.Approved
+		moderation.Status = database.SelfieStatusRejected
+		if *payload.Approved {
+			moderation.Status = database.SelfieStatusApproved
+		}
+		moderation.RejectionReason = sanitizeRejectionReason(payload.RejectionReason)
 	}
 	if payload.ShowOnScreen != nil {
-		showOnScreen = *payload.ShowOnScreen
+		moderation.ShowOnScreen = *payload.ShowOnScreen
 	}
-	if showOnScreen && !approved {
-		approved = true
+	if moderation.ShowOnScreen {
+		moderation.Status = database.SelfieStatusApproved
 	}
-	if err := rt.db.UpdateSelfieStatus(selfieID, approved, showOnScreen); err != nil {
+	result, err := rt.db.ModerateSelfies(selfie.EventID, []int{selfieID}, moderation)
+	if err != nil {
 		ctx.Logger.WithError(err).Error("cannot update selfie status")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if len(result.Claimed) > 0 {
+		_ = writeJSONMessage(w, http.StatusConflict, "Il selfie è in revisione da parte di un altro moderatore.")
+		return
+	}
+	if selfie.ShowOnScreen || moderation.ShowOnScreen {
+		rt.screenHub.notify(selfie.EventID)
+	}
 
 	updated, err := rt.db.GetSelfieByID(selfieID)
 	if err != nil {
@@ -514,12 +593,7 @@ func (rt *_router) updateSelfieModeration(w http.ResponseWriter, r *http.Request
 		ctx.Logger.WithError(err).Warn("cannot ensure selfie url")
 	}
 
-	response := adminSelfieResponse{
-		selfieResponse: buildSelfieResponsePayload(updated),
-		DeviceToken:    updated.DeviceID,
-		FileSizeBytes:  rt.getSelfieFileSize(updated),
-	}
-	if err := writeJSON(w, http.StatusOK, response); err != nil {
+	if err := writeJSON(w, http.StatusOK, rt.buildAdminSelfieResponse(updated)); err != nil {
 		ctx.Logger.WithError(err).Error("cannot encode moderation response")
 	}
 }
@@ -547,30 +621,27 @@ func (rt *_router) deleteSelfie(w http.ResponseWriter, r *http.Request, ctx reqc
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if selfie.ShowOnScreen {
+		rt.screenHub.notify(selfie.EventID)
+	}
 
-	imagePath := strings.TrimSpace(selfie.ImagePath)
-	if imagePath != "" {
-		if err := os.Remove(imagePath); err != nil && !errors.Is(err, os.ErrNotExist) {
-			ctx.Logger.WithError(err).Warn("cannot remove selfie file")
-		}
+	if err := rt.deleteSelfieImage(r.Context(), selfie.ImagePath); err != nil {
+		ctx.Logger.WithError(err).Warn("cannot remove selfie file")
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (rt *_router) getSelfieFileSize(selfie database.Selfie) int64 {
-	path := strings.TrimSpace(selfie.ImagePath)
-	if path == "" {
-		return 0
-	}
-	info, err := os.Stat(path)
-	if err != nil {
-		return 0
-	}
-	return info.Size()
+func (rt *_router) getSelfieImage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	rt.servePublicSelfie(w, r, ctx, false)
 }
 
-func (rt *_router) getSelfieImage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+func (rt *_router) getSelfieThumbnail(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	rt.servePublicSelfie(w, r, ctx, true)
+}
+
+// servePublicSelfie sends the image of an approved selfie, or of any selfie to the device that uploaded it.
+func (rt *_router) servePublicSelfie(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, thumbnail bool) {
 	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
 	if err != nil || eventID <= 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -601,10 +672,18 @@ func (rt *_router) getSelfieImage(w http.ResponseWriter, r *http.Request, ctx re
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	rt.serveSelfieFile(w, r, ctx, selfie, selfie.Approved)
+	rt.serveSelfieFile(w, r, ctx, selfie, selfie.Approved, thumbnail)
 }
 
 func (rt *_router) getAdminSelfieImage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	rt.serveAdminSelfie(w, r, ctx, false)
+}
+
+func (rt *_router) getAdminSelfieThumbnail(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	rt.serveAdminSelfie(w, r, ctx, true)
+}
+
+func (rt *_router) serveAdminSelfie(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, thumbnail bool) {
 	selfieID, err := strconv.Atoi(chi.URLParam(r, "selfieId"))
 	if err != nil || selfieID <= 0 {
 		w.WriteHeader(http.StatusBadRequest)
@@ -620,66 +699,5 @@ func (rt *_router) getAdminSelfieImage(w http.ResponseWriter, r *http.Request, c
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	rt.serveSelfieFile(w, r, ctx, selfie, false)
-}
-
-func (rt *_router) serveSelfieFile(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, selfie database.Selfie, allowCache bool) {
-	if strings.TrimSpace(selfie.ImagePath) == "" {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	absPath, err := filepath.Abs(selfie.ImagePath)
-	if err != nil {
-		ctx.Logger.WithError(err).Warn("invalid selfie path")
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	baseDir, err := filepath.Abs(filepath.Join("tmp", "selfies"))
-	if err != nil {
-		ctx.Logger.WithError(err).Warn("cannot resolve selfies directory")
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	prefix := baseDir + string(os.PathSeparator)
-	if absPath != baseDir && !strings.HasPrefix(absPath, prefix) {
-		ctx.Logger.Warn("selfie path outside storage directory")
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	file, err := os.Open(absPath)
-	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	defer file.Close()
-
-	info, err := file.Stat()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	contentType := strings.TrimSpace(selfie.ContentType)
-	if contentType == "" {
-		buf := make([]byte, 512)
-		n, _ := file.Read(buf)
-		if n > 0 {
-			contentType = http.DetectContentType(buf[:n])
-			_, _ = file.Seek(0, io.SeekStart)
-		}
-	} else {
-		_, _ = file.Seek(0, io.SeekStart)
-	}
-	if contentType == "" {
-		contentType = "application/octet-stream"
-	}
-
-	if allowCache {
-		w.Header().Set("Cache-Control", "public, max-age=60")
-	} else {
-		w.Header().Set("Cache-Control", "no-store")
-	}
-	w.Header().Set("Content-Type", contentType)
-
-	http.ServeContent(w, r, filepath.Base(absPath), info.ModTime(), file)
+	rt.serveSelfieFile(w, r, ctx, selfie, false, thumbnail)
 }
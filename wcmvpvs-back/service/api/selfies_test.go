@@ -0,0 +1,155 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+)
+
+// testPNGDataURL returns a tiny PNG encoded as a data URL, as sent by the frontend.
+func testPNGDataURL(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func TestSelfieModeration(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	upload := map[string]string{"caption": "Forza!", "image_base64": testPNGDataURL(t)}
+	uploadPath := fmt.Sprintf("/events/%d/selfies", fixture.EventID)
+	approvedPath := fmt.Sprintf("/events/%d/selfies/approved", fixture.EventID)
+
+	if rec := h.do(http.MethodPost, uploadPath, upload, map[string]string{"X-Device-ID": "device-1"}); rec.Code != http.StatusForbidden {
+		t.Fatalf("before voting: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	h.mustVote(fixture, "device-1")
+	rec := h.do(http.MethodPost, uploadPath, upload, map[string]string{"X-Device-ID": "device-1"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, want %d (%s)", rec.Code, http.StatusCreated, rec.Body.String())
+	}
+	var created selfieResponse
+	h.decode(rec, &created)
+	if created.Approved || created.ContentType != "image/jpeg" || created.ThumbnailURL == "" {
+		t.Fatalf("unexpected new selfie %+v", created)
+	}
+
+	approvedCount := func() int {
+		var approved []selfieResponse
+		h.decode(h.do(http.MethodGet, approvedPath, nil, nil), &approved)
+		return len(approved)
+	}
+	if n := approvedCount(); n != 0 {
+		t.Fatalf("approved selfies before moderation = %d, want 0", n)
+	}
+
+	cases := []struct {
+		name         string
+		payload      map[string]bool
+		approved     bool
+		showOnScreen bool
+	}{
+		{name: "approve", payload: map[string]bool{"approved": true}, approved: true},
+		{name: "reject", payload: map[string]bool{"approved": false}},
+		{name: "show on screen implies approval", payload: map[string]bool{"show_on_screen": true}, approved: true, showOnScreen: true},
+	}
+	for _, tc := range cases {
+		rec := h.do(http.MethodPut, fmt.Sprintf("/admin/selfies/%d", created.ID), tc.payload, adminHeaders(token))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status = %d, want %d", tc.name, rec.Code, http.StatusOK)
+		}
+		var updated adminSelfieResponse
+		h.decode(rec, &updated)
+		if updated.Approved != tc.approved || updated.ShowOnScreen != tc.showOnScreen {
+			t.Fatalf("%s: approved=%v show=%v, want approved=%v show=%v", tc.name, updated.Approved, updated.ShowOnScreen, tc.approved, tc.showOnScreen)
+		}
+		want := 0
+		if tc.approved {
+			want = 1
+		}
+		if n := approvedCount(); n != want {
+			t.Fatalf("%s: approved selfies = %d, want %d", tc.name, n, want)
+		}
+	}
+}
+
+// presigningStore simulates a store able to generate direct download URLs, like S3.
+type presigningStore struct {
+	blobstore.Store
+}
+
+func (presigningStore) PresignedURL(key string, _ time.Duration) (string, error) {
+	return "https://blobs.example.com/" + key + "?signature=test", nil
+}
+
+func TestSelfieStorage(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	h.mustVote(fixture, "device-1")
+
+	upload := map[string]string{"image_base64": testPNGDataURL(t)}
+	rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/selfies", fixture.EventID), upload, map[string]string{"X-Device-ID": "device-1"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d, want %d", rec.Code, http.StatusCreated)
+	}
+	var created selfieResponse
+	h.decode(rec, &created)
+
+	selfie, err := h.db.GetSelfieByID(created.ID)
+	if err != nil {
+		t.Fatalf("cannot load selfie: %v", err)
+	}
+	if !strings.HasPrefix(selfie.ImagePath, fmt.Sprintf("selfies/event_%d/", fixture.EventID)) {
+		t.Fatalf("image path %q is not a blob key", selfie.ImagePath)
+	}
+
+	imagePath := fmt.Sprintf("/admin/selfies/%d/image", created.ID)
+	rec = h.do(http.MethodGet, imagePath, nil, adminHeaders(token))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" || rec.Body.Len() == 0 {
+		t.Fatalf("proxied image: status %d, type %q, %d bytes", rec.Code, rec.Header().Get("Content-Type"), rec.Body.Len())
+	}
+	thumbnailKey := selfieThumbnailKey(selfie.ImagePath)
+	if _, err := h.router.blobs.Stat(context.Background(), thumbnailKey); err != nil {
+		t.Fatalf("thumbnail not stored: %v", err)
+	}
+	rec = h.do(http.MethodGet, created.ThumbnailURL, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("public thumbnail: status %d, type %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	local := h.router.blobs
+	h.router.blobs = presigningStore{Store: local}
+	h.router.blobURLExpiry = time.Minute
+	rec = h.do(http.MethodGet, imagePath, nil, adminHeaders(token))
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "https://blobs.example.com/"+selfie.ImagePath) {
+		t.Fatalf("presigned image: status %d, location %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	if rec := h.do(http.MethodDelete, fmt.Sprintf("/admin/selfies/%d", created.ID), nil, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	for _, key := range []string{selfie.ImagePath, thumbnailKey} {
+		if _, err := local.Stat(context.Background(), key); !errors.Is(err, blobstore.ErrNotFound) {
+			t.Fatalf("%s still stored after delete (%v)", key, err)
+		}
+	}
+}
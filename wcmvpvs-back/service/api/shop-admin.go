@@ -0,0 +1,197 @@
+package api
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/imaging"
+	"github.com/go-chi/chi/v5"
+)
+
+const shopImageMaxUploadSize = 8 << 20 // 8 MiB
+
+// shopImageOptions bound the product images: they are shown at most full width on a phone.
+var shopImageOptions = imaging.Options{
+	MaxSide:          1600,
+	ThumbnailSide:    480,
+	Quality:          85,
+	ThumbnailQuality: 75,
+	MaxPixels:        imaging.DefaultOptions.MaxPixels,
+}
+
+// shopProductPayload is the body of the product create and update requests. IsActive defaults to true.
+type shopProductPayload struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	PriceCents  int    `json:"price_cents"`
+	ImageURL    string `json:"image_url"`
+	Category    string `json:"category"`
+	SortOrder   int    `json:"sort_order"`
+	IsActive    *bool  `json:"is_active"`
+}
+
+func (p shopProductPayload) apply(product database.ShopProduct) database.ShopProduct {
+	product.Name = p.Name
+	product.Description = p.Description
+	product.PriceCents = p.PriceCents
+	product.ImageURL = p.ImageURL
+	product.Category = p.Category
+	product.SortOrder = p.SortOrder
+	product.IsActive = p.IsActive == nil || *p.IsActive
+	return product
+}
+
+func shopImageKey(hash string) string {
+	return fmt.Sprintf("shop/%s", hash)
+}
+
+// listAdminShopProducts returns every product, hidden ones included; the deleted ones only with
+// `include_deleted=true`.
+func (rt *_router) listAdminShopProducts(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	includeDeleted, _ := strconv.ParseBool(r.URL.Query().Get("include_deleted"))
+	products, err := rt.db.ListAllShopProducts(includeDeleted, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list shop products")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, products)
+}
+
+func (rt *_router) createShopProduct(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	var payload shopProductPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	product, err := rt.db.SaveShopProduct(payload.apply(database.ShopProduct{}), globaltime.Now())
+	if !rt.checkShopProductSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusCreated, product)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": product.ID, "admin": ctx.AdminUsername}).Info("shop product created")
+}
+
+func (rt *_router) updateShopProduct(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopProductPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	product, err := rt.db.SaveShopProduct(payload.apply(database.ShopProduct{ID: id}), globaltime.Now())
+	if !rt.checkShopProductSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, product)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": product.ID, "admin": ctx.AdminUsername}).Info("shop product updated")
+}
+
+// deleteShopProduct removes the product from the shop. The row is kept, so the orders keep referring to it.
+func (rt *_router) deleteShopProduct(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.DeleteShopProduct(id, globaltime.Now()); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete shop product")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": id, "admin": ctx.AdminUsername}).Info("shop product deleted")
+}
+
+// checkShopProductSave writes the error response of a product save, and tells whether it succeeded.
+func (rt *_router) checkShopProductSave(w http.ResponseWriter, ctx reqcontext.RequestContext, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrInvalidShopProduct):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Dati del prodotto non validi: nome e prezzo sono obbligatori.")
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		ctx.Logger.WithError(err).Error("cannot save shop product")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
+
+// uploadShopImage stores the product image sent as the multipart field `file`, without its metadata, and returns the
+// URL to set as `image_url` of the product.
+func (rt *_router) uploadShopImage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, shopImageMaxUploadSize+(1<<20))
+	if err := r.ParseMultipartForm(shopImageMaxUploadSize); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid shop image upload")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Caricamento dell'immagine non valido.")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Nessuna immagine ricevuta.")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, shopImageMaxUploadSize+1))
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot read shop image upload")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(data) > shopImageMaxUploadSize {
+		_ = writeJSONMessage(w, http.StatusRequestEntityTooLarge, "L'immagine supera la dimensione massima di 8 MB.")
+		return
+	}
+
+	processed, err := imaging.Process(data, detectContentType(data, header.Header.Get("Content-Type")), shopImageOptions)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("rejected shop image")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato dell'immagine non supportato: usa PNG, JPEG o WebP.")
+		return
+	}
+	sum := sha256.Sum256(processed.Image)
+	hash := hex.EncodeToString(sum[:])
+	if err := rt.blobs.Put(r.Context(), shopImageKey(hash), processed.Image, processed.ContentType); err != nil {
+		ctx.Logger.WithError(err).Error("cannot store shop image")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeJSON(w, http.StatusCreated, struct {
+		ImageURL string `json:"image_url"`
+	}{ImageURL: "/shop/images/" + hash})
+	ctx.Logger.WithField("hash", hash).Info("shop image uploaded")
+}
+
+// getShopImage serves an uploaded product image. Its URL is the hash of the content, so it can be cached forever.
+func (rt *_router) getShopImage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	hash := chi.URLParam(r, "hash")
+	if !isSponsorAssetHash(hash) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rt.serveImmutableBlob(w, r, ctx, shopImageKey(hash), fmt.Sprintf(`"%s"`, hash))
+}
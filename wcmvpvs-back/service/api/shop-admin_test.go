@@ -0,0 +1,116 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestShopProductAdmin(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var picture bytes.Buffer
+	if err := png.Encode(&picture, image.NewNRGBA(image.Rect(0, 0, 2000, 1000))); err != nil {
+		t.Fatalf("cannot encode image: %v", err)
+	}
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "scarf.png")
+	if err != nil {
+		t.Fatalf("cannot create form file: %v", err)
+	}
+	_, _ = part.Write(picture.Bytes())
+	_ = writer.Close()
+	headers := adminHeaders(token)
+	headers["Content-Type"] = writer.FormDataContentType()
+	rec := h.do(http.MethodPost, "/admin/shop/images", body.Bytes(), headers)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var uploaded struct {
+		ImageURL string `json:"image_url"`
+	}
+	h.decode(rec, &uploaded)
+	rec = h.do(http.MethodGet, uploaded.ImageURL, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/jpeg" {
+		t.Fatalf("image: status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	if cfg, err := jpeg.DecodeConfig(rec.Body); err != nil || cfg.Width != 1600 || cfg.Height != 800 {
+		t.Fatalf("image size = %+v (%v)", cfg, err)
+	}
+
+	create := func(payload map[string]interface{}) database.ShopProduct {
+		t.Helper()
+		rec := h.do(http.MethodPost, "/admin/shop/products", payload, adminHeaders(token))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create product %v: status = %d (%s)", payload, rec.Code, rec.Body.String())
+		}
+		var product database.ShopProduct
+		h.decode(rec, &product)
+		return product
+	}
+	scarf := create(map[string]interface{}{"name": "Sciarpa", "price_cents": 1500, "image_url": uploaded.ImageURL, "category": "Accessori", "sort_order": -1})
+	hidden := create(map[string]interface{}{"name": "Maglia 2026", "price_cents": 4500, "category": "Maglie", "is_active": false})
+	if rec := h.do(http.MethodPost, "/admin/shop/products", map[string]interface{}{"name": "Gratis"}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("product without price: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	var products []database.ShopProduct
+	h.decode(h.do(http.MethodGet, "/shop/products", nil, nil), &products)
+	if len(products) == 0 || products[0].ID != scarf.ID {
+		t.Fatalf("public products = %+v, want the scarf first", products)
+	}
+	for _, product := range products {
+		if product.ID == hidden.ID {
+			t.Fatalf("hidden product listed: %+v", product)
+		}
+	}
+	h.decode(h.do(http.MethodGet, "/shop/products?category=accessori", nil, nil), &products)
+	if len(products) != 1 || products[0].ID != scarf.ID {
+		t.Fatalf("accessories = %+v", products)
+	}
+	if rec := h.do(http.MethodGet, fmt.Sprintf("/shop/products/%d", hidden.ID), nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("hidden product: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	checkout := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "items": []map[string]int{{"product_id": scarf.ID, "quantity": 2}}}
+	if rec := h.do(http.MethodPost, "/shop/checkout", checkout, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+
+	product := fmt.Sprintf("/admin/shop/products/%d", scarf.ID)
+	if rec := h.do(http.MethodDelete, product, nil, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+	if rec := h.do(http.MethodPost, "/shop/checkout", checkout, nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("checkout of a deleted product: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := h.do(http.MethodPut, product, map[string]interface{}{"name": "Sciarpa", "price_cents": 1500}, adminHeaders(token)); rec.Code != http.StatusNotFound {
+		t.Fatalf("update of a deleted product: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+
+	h.decode(h.do(http.MethodGet, "/admin/shop/products", nil, adminHeaders(token)), &products)
+	for _, product := range products {
+		if product.ID == scarf.ID {
+			t.Fatalf("deleted product listed: %+v", product)
+		}
+	}
+	h.decode(h.do(http.MethodGet, "/admin/shop/products?include_deleted=true", nil, adminHeaders(token)), &products)
+	var deleted *database.ShopProduct
+	for i := range products {
+		if products[i].ID == scarf.ID {
+			deleted = &products[i]
+		}
+	}
+	if deleted == nil || deleted.DeletedAt == "" || deleted.IsActive {
+		t.Fatalf("deleted product = %+v", deleted)
+	}
+}
@@ -0,0 +1,339 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultShopOrderLimit = 50
+	maxShopOrderLimit     = 200
+)
+
+// fulfilmentStatuses are the orders the merch desk has to hand over, unless the fulfilment view asks for others.
+var fulfilmentStatuses = []string{database.ShopOrderStatusPaid, database.ShopOrderStatusReadyForPickup}
+
+type shopOrderListResponse struct {
+	Orders []database.ShopOrder `json:"orders"`
+	Total  int                  `json:"total"`
+}
+
+type shopOrderStatusPayload struct {
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+type shopOrderPickupPayload struct {
+	EventID int `json:"event_id"`
+}
+
+// shopFulfilmentGroup holds the orders collected at an event, with the total of each item to prepare. The orders
+// without a pickup event have EventID zero.
+type shopFulfilmentGroup struct {
+	EventID       int                  `json:"event_id"`
+	Title         string               `json:"title"`
+	StartDateTime string               `json:"start_datetime,omitempty"`
+	Items         []shopFulfilmentItem `json:"items"`
+	Orders        []database.ShopOrder `json:"orders"`
+}
+
+type shopFulfilmentItem struct {
+	ProductID   int    `json:"product_id"`
+	VariantID   int    `json:"variant_id,omitempty"`
+	ProductName string `json:"product_name"`
+	VariantName string `json:"variant_name,omitempty"`
+	Quantity    int    `json:"quantity"`
+}
+
+// listShopOrders returns the orders, newest first, filtered by `status` (comma separated), `q` (order number, name or
+// email), `pickup_event_id` (`none` for the orders without one) and the creation dates `from` and `to`, paged by
+// `limit` and `offset`.
+func (rt *_router) listShopOrders(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	filter, ok := parseShopOrderFilter(w, r)
+	if !ok {
+		return
+	}
+	limit, ok := parsePositiveLimit(r.URL.Query().Get("limit"), defaultShopOrderLimit, maxShopOrderLimit)
+	if !ok {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Limite non valido.")
+		return
+	}
+	offset, err := strconv.Atoi(r.URL.Query().Get("offset"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	filter.Limit, filter.Offset = limit, offset
+
+	orders, total, err := rt.db.ListShopOrders(filter)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list shop orders")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, shopOrderListResponse{Orders: orders, Total: total})
+}
+
+// getShopOrder returns the order with its status history.
+func (rt *_router) getShopOrder(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	order, err := rt.db.GetShopOrder(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load shop order")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, order)
+}
+
+// updateShopOrderStatus moves the order to the next status, if the transition is allowed.
+func (rt *_router) updateShopOrderStatus(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopOrderStatusPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || !database.IsShopOrderStatus(payload.Status) {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stato dell'ordine non valido.")
+		return
+	}
+	if rt.payments != nil && payload.Status == database.ShopOrderStatusPaid {
+		_ = writeJSONMessage(w, http.StatusConflict, "Con i pagamenti online l'ordine risulta pagato solo alla conferma del provider.")
+		return
+	}
+
+	order, err := rt.db.UpdateShopOrderStatus(id, payload.Status, ctx.AdminUsername, payload.Note, globaltime.Now())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrInvalidShopOrderTransition):
+		_ = writeJSONMessage(w, http.StatusConflict, "L'ordine non può passare a questo stato da quello attuale.")
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot update shop order status")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, order)
+	logger := ctx.Logger.WithFields(map[string]interface{}{"order_id": id, "status": order.Status, "admin": ctx.AdminUsername})
+	logger.Info("shop order status changed")
+	if order.Status == database.ShopOrderStatusReadyForPickup {
+		rt.queueShopOrderMail(logger, mailer.TemplatePickupReady, order)
+	}
+}
+
+// setShopOrderPickupEvent changes the event where the order is collected; event_id zero clears it.
+func (rt *_router) setShopOrderPickupEvent(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopOrderPickupPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.EventID < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	switch err := rt.db.SetShopOrderPickupEvent(id, payload.EventID); {
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrInvalidPickupEvent):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Evento di ritiro non valido: scegli un evento non ancora concluso.")
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot set shop order pickup event")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.getShopOrder(w, r, ctx)
+}
+
+// getShopFulfilment returns the orders to hand over grouped by pickup event, by date of the event, with the totals of
+// the items to prepare. It accepts the filters of listShopOrders; the status defaults to paid and ready for pickup.
+func (rt *_router) getShopFulfilment(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	groups, ok := rt.loadShopFulfilment(w, r, ctx)
+	if !ok {
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, groups)
+}
+
+// exportShopFulfilment writes the fulfilment view as CSV for the merch desk, a row per order item.
+func (rt *_router) exportShopFulfilment(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	groups, ok := rt.loadShopFulfilment(w, r, ctx)
+	if !ok {
+		return
+	}
+
+	var buf bytes.Buffer
+	out := csv.NewWriter(&buf)
+	_ = out.Write([]string{"pickup_event_id", "pickup_event", "order_id", "status", "created_at", "customer_name", "customer_email", "product", "variant", "quantity", "notes"})
+	for _, group := range groups {
+		for _, order := range group.Orders {
+			for _, item := range order.Items {
+				_ = out.Write([]string{
+					strconv.Itoa(group.EventID), group.Title, strconv.Itoa(order.ID), order.Status, order.CreatedAt,
+					order.CustomerName, order.CustomerEmail, item.ProductName, item.VariantName, strconv.Itoa(item.Quantity), order.CustomerNotes,
+				})
+			}
+		}
+	}
+	out.Flush()
+	if err := out.Error(); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write shop fulfilment export")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"ritiro-ordini-%s.csv\"", globaltime.Now().Format("20060102")))
+	_, _ = w.Write(buf.Bytes())
+}
+
+func (rt *_router) loadShopFulfilment(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) ([]shopFulfilmentGroup, bool) {
+	filter, ok := parseShopOrderFilter(w, r)
+	if !ok {
+		return nil, false
+	}
+	if len(filter.Statuses) == 0 {
+		filter.Statuses = fulfilmentStatuses
+	}
+	orders, _, err := rt.db.ListShopOrders(filter)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list shop orders")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list events")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, false
+	}
+	return buildShopFulfilment(orders, events), true
+}
+
+// buildShopFulfilment groups the orders by pickup event, the events by start time and the orders without an event
+// last. Within a group the orders are oldest first, as they are handed over.
+func buildShopFulfilment(orders []database.ShopOrder, events []database.Event) []shopFulfilmentGroup {
+	eventsByID := make(map[int]database.Event, len(events))
+	for _, event := range events {
+		eventsByID[event.ID] = event
+	}
+
+	groups := []shopFulfilmentGroup{}
+	index := make(map[int]int)
+	for i := len(orders) - 1; i >= 0; i-- {
+		order := orders[i]
+		g, ok := index[order.PickupEventID]
+		if !ok {
+			group := shopFulfilmentGroup{EventID: order.PickupEventID, Title: "Senza evento di ritiro", Items: []shopFulfilmentItem{}}
+			if event, found := eventsByID[order.PickupEventID]; found {
+				group.Title = buildEventTitle(event)
+				group.StartDateTime = event.StartDateTime
+			}
+			g = len(groups)
+			index[order.PickupEventID] = g
+			groups = append(groups, group)
+		}
+		groups[g].Orders = append(groups[g].Orders, order)
+		for _, item := range order.Items {
+			groups[g].Items = addFulfilmentItem(groups[g].Items, item)
+		}
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if (groups[i].EventID == 0) != (groups[j].EventID == 0) {
+			return groups[j].EventID == 0
+		}
+		ti, _ := parseEventStart(groups[i].StartDateTime)
+		tj, _ := parseEventStart(groups[j].StartDateTime)
+		return ti.Before(tj)
+	})
+	return groups
+}
+
+func addFulfilmentItem(items []shopFulfilmentItem, item database.ShopOrderItem) []shopFulfilmentItem {
+	for i := range items {
+		if items[i].ProductID == item.ProductID && items[i].VariantID == item.VariantID {
+			items[i].Quantity += item.Quantity
+			return items
+		}
+	}
+	return append(items, shopFulfilmentItem{
+		ProductID:   item.ProductID,
+		VariantID:   item.VariantID,
+		ProductName: item.ProductName,
+		VariantName: item.VariantName,
+		Quantity:    item.Quantity,
+	})
+}
+
+// parseShopOrderFilter reads the order filters from the query string, writing the error response when one is not
+// valid.
+func parseShopOrderFilter(w http.ResponseWriter, r *http.Request) (database.ShopOrderFilter, bool) {
+	query := r.URL.Query()
+	filter := database.ShopOrderFilter{Query: query.Get("q")}
+	if statuses := strings.TrimSpace(query.Get("status")); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if !database.IsShopOrderStatus(status) {
+				_ = writeJSONMessage(w, http.StatusBadRequest, "Stato dell'ordine non valido.")
+				return filter, false
+			}
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+	switch value := strings.TrimSpace(query.Get("pickup_event_id")); value {
+	case "":
+	case "none":
+		filter.PickupEventID = -1
+	default:
+		id, err := strconv.Atoi(value)
+		if err != nil || id <= 0 {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Evento di ritiro non valido.")
+			return filter, false
+		}
+		filter.PickupEventID = id
+	}
+	for _, bound := range []struct {
+		name   string
+		target *string
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := strings.TrimSpace(query.Get(bound.name))
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Data non valida: usa il formato AAAA-MM-GG.")
+			return filter, false
+		}
+		*bound.target = value
+	}
+	return filter, true
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestShopOrderLifecycle(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	product, err := h.db.SaveShopProduct(database.ShopProduct{Name: "Sciarpa", PriceCents: 1500, IsActive: true}, globaltime.Now())
+	if err != nil {
+		t.Fatalf("cannot create product: %v", err)
+	}
+	stock := 3
+	if _, err := h.db.AdjustShopStock(database.ShopStockChange{ProductID: product.ID, Stock: &stock, Reason: "Carico"}, globaltime.Now()); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+
+	checkout := func(name, email string) database.ShopOrder {
+		t.Helper()
+		body := map[string]interface{}{"customer_name": name, "customer_email": email, "items": []map[string]int{{"product_id": product.ID, "quantity": 1}}}
+		rec := h.do(http.MethodPost, "/shop/checkout", body, nil)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+		}
+		var response checkoutResponsePayload
+		h.decode(rec, &response)
+		return response.Order
+	}
+	mario := checkout("Mario Rossi", "mario@example.com")
+	luigi := checkout("Luigi Verdi", "luigi@example.com")
+	if mario.Status != database.ShopOrderStatusPendingPayment || mario.PickupEventID != fixture.EventID {
+		t.Fatalf("new order = %+v, want pending payment at the next event", mario)
+	}
+
+	setStatus := func(orderID int, status string) int {
+		return h.do(http.MethodPost, fmt.Sprintf("/admin/shop/orders/%d/status", orderID), map[string]string{"status": status}, adminHeaders(token)).Code
+	}
+	if code := setStatus(mario.ID, database.ShopOrderStatusCollected); code != http.StatusConflict {
+		t.Fatalf("collecting an unpaid order: status = %d, want %d", code, http.StatusConflict)
+	}
+	for _, status := range []string{database.ShopOrderStatusPaid, database.ShopOrderStatusReadyForPickup} {
+		if code := setStatus(mario.ID, status); code != http.StatusOK {
+			t.Fatalf("moving to %s: status = %d", status, code)
+		}
+	}
+	if code := setStatus(luigi.ID, database.ShopOrderStatusCancelled); code != http.StatusOK {
+		t.Fatalf("cancelling: status = %d", code)
+	}
+	if restocked, err := h.db.GetAnyShopProduct(product.ID, globaltime.Now()); err != nil || *restocked.Stock != 2 {
+		t.Fatalf("stock after the cancellation = %v (%v), want 2", restocked.Stock, err)
+	}
+
+	var order database.ShopOrder
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/admin/shop/orders/%d", mario.ID), nil, adminHeaders(token)), &order)
+	if len(order.History) != 2 || order.History[1].ToStatus != database.ShopOrderStatusReadyForPickup || order.History[1].Admin != testAdminUsername {
+		t.Fatalf("order history = %+v", order.History)
+	}
+
+	var list shopOrderListResponse
+	h.decode(h.do(http.MethodGet, "/admin/shop/orders?q=LUIGI", nil, adminHeaders(token)), &list)
+	if list.Total != 1 || list.Orders[0].ID != luigi.ID {
+		t.Fatalf("search = %+v", list)
+	}
+	h.decode(h.do(http.MethodGet, "/admin/shop/orders?status=cancelled,paid", nil, adminHeaders(token)), &list)
+	if list.Total != 1 || list.Orders[0].ID != luigi.ID {
+		t.Fatalf("status filter = %+v", list)
+	}
+	if rec := h.do(http.MethodGet, "/admin/shop/orders?status=lost", nil, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown status filter: status = %d", rec.Code)
+	}
+
+	var groups []shopFulfilmentGroup
+	h.decode(h.do(http.MethodGet, "/admin/shop/fulfilment", nil, adminHeaders(token)), &groups)
+	if len(groups) != 1 || groups[0].EventID != fixture.EventID || len(groups[0].Orders) != 1 || groups[0].Items[0].Quantity != 1 {
+		t.Fatalf("fulfilment = %+v", groups)
+	}
+
+	rec := h.do(http.MethodGet, "/admin/shop/fulfilment/export", nil, adminHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("export: status = %d", rec.Code)
+	}
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil || len(records) != 2 || records[1][2] != fmt.Sprint(mario.ID) || records[1][7] != "Sciarpa" {
+		t.Fatalf("export = %v (%v)", records, err)
+	}
+}
@@ -0,0 +1,256 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/payments"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultShopPaymentTimeout = time.Hour
+	shopPaymentExpiryInterval = 5 * time.Minute
+	maxShopWebhookBodySize    = 64 << 10
+	shopPaymentCurrency       = "EUR"
+)
+
+// startShopPayment creates the payment intent of a new order on the provider and returns the page where the customer
+// pays. When the provider fails, or the payment cannot be saved, the order is cancelled, so that its items go back to
+// the stock, and the error response is written.
+func (rt *_router) startShopPayment(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, order database.ShopOrder) (database.ShopPayment, bool) {
+	intent, err := rt.payments.CreateIntent(r.Context(), payments.IntentRequest{
+		OrderID:       order.ID,
+		AmountCents:   order.TotalCents,
+		Currency:      shopPaymentCurrency,
+		Description:   fmt.Sprintf("Ordine #%d", order.ID),
+		CustomerEmail: order.CustomerEmail,
+		ReturnURL:     rt.shopPageURL(r, fmt.Sprintf("/shop/checkout/success?order=%d", order.ID)),
+		CancelURL:     rt.shopPageURL(r, fmt.Sprintf("/shop/checkout?payment=cancelled&order=%d", order.ID)),
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("order_id", order.ID).Error("cannot create payment intent")
+		rt.cancelUnpaidShopOrder(ctx, order.ID)
+		_ = writeJSONMessage(w, http.StatusBadGateway, "pagamento non disponibile, riprova più tardi")
+		return database.ShopPayment{}, false
+	}
+
+	payment, err := rt.db.CreateShopPayment(database.ShopPayment{
+		OrderID:     order.ID,
+		Provider:    rt.payments.Name(),
+		IntentID:    intent.ID,
+		AmountCents: order.TotalCents,
+		CheckoutURL: intent.CheckoutURL,
+	}, globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("order_id", order.ID).Error("cannot save shop payment")
+		rt.cancelUnpaidShopOrder(ctx, order.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return database.ShopPayment{}, false
+	}
+	return payment, true
+}
+
+// cancelUnpaidShopOrder cancels an order whose payment could not be started, giving its items back to the shop: the
+// customer never receives the payment page, so the order would otherwise hold them until the payment timeout.
+func (rt *_router) cancelUnpaidShopOrder(ctx reqcontext.RequestContext, orderID int) {
+	if _, err := rt.db.UpdateShopOrderStatus(orderID, database.ShopOrderStatusCancelled, "", "Pagamento non avviato", globaltime.Now()); err != nil {
+		ctx.Logger.WithError(err).WithField("order_id", orderID).Error("cannot cancel the unpaid shop order")
+	}
+}
+
+// shopPageURL returns the absolute URL of a page of the shop, where the provider sends the customer back. Without a
+// configured public URL it uses the origin of the shop page that made the request.
+func (rt *_router) shopPageURL(r *http.Request, path string) string {
+	base := strings.TrimSuffix(strings.TrimSpace(rt.shopPublicURL), "/")
+	if base == "" {
+		base = strings.TrimSuffix(r.Header.Get("Origin"), "/")
+	}
+	if base == "" || base == "null" {
+		scheme := "http"
+		if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+			scheme = "https"
+		}
+		base = scheme + "://" + r.Host
+	}
+	return base + path
+}
+
+// shopPaymentWebhook receives the outcome of the payments from the provider. Deliveries of events already received
+// are acknowledged without applying them again.
+func (rt *_router) shopPaymentWebhook(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if rt.payments == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxShopWebhookBodySize+1))
+	if err != nil || len(body) > maxShopWebhookBodySize {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(rt.applyShopPaymentWebhook(ctx, r.Header, body))
+}
+
+// applyShopPaymentWebhook verifies a webhook call of the provider and applies its event, returning the status code of
+// the response.
+func (rt *_router) applyShopPaymentWebhook(ctx reqcontext.RequestContext, header http.Header, body []byte) int {
+	event, err := rt.payments.ParseWebhook(header, body)
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("rejected payment webhook")
+		return http.StatusBadRequest
+	}
+	if event.Type != payments.EventPaymentSucceeded && event.Type != payments.EventPaymentFailed {
+		ctx.Logger.WithField("type", event.Type).Info("ignored payment webhook event")
+		return http.StatusOK
+	}
+
+	logger := ctx.Logger.WithFields(map[string]interface{}{"event_id": event.ID, "intent_id": event.IntentID, "type": event.Type})
+	payment, applied, err := rt.db.ApplyShopPaymentEvent(database.ShopPaymentEvent{
+		Provider:    rt.payments.Name(),
+		EventID:     event.ID,
+		IntentID:    event.IntentID,
+		Succeeded:   event.Type == payments.EventPaymentSucceeded,
+		AmountCents: event.AmountCents,
+	}, globaltime.Now())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		logger.Warn("payment webhook for an unknown intent")
+		return http.StatusNotFound
+	case errors.Is(err, database.ErrShopPaymentMismatch):
+		logger.WithField("amount_cents", event.AmountCents).Error("payment webhook with a wrong amount")
+		return http.StatusBadRequest
+	case err != nil:
+		logger.WithError(err).Error("cannot apply payment webhook")
+		return http.StatusInternalServerError
+	}
+	if !applied {
+		logger.Info("payment webhook already applied")
+		return http.StatusOK
+	}
+
+	logger = logger.WithField("order_id", payment.OrderID)
+	if order, err := rt.db.GetShopOrder(payment.OrderID); err != nil {
+		logger.WithError(err).Error("cannot load the paid shop order")
+	} else if payment.Status == database.ShopPaymentStatusSucceeded && order.Status != database.ShopOrderStatusPaid {
+		// The order was cancelled, or expired, before the payment arrived: the staff has to refund it
+		logger.WithField("order_status", order.Status).Warn("payment received for a closed shop order")
+		return http.StatusOK
+	} else if order.Status == database.ShopOrderStatusPaid {
+		rt.queueShopOrderMail(logger, mailer.TemplateOrderConfirmation, order)
+	}
+	logger.WithField("status", payment.Status).Info("shop payment completed")
+	return http.StatusOK
+}
+
+// expireShopPayments cancels the orders whose payment did not complete within the payment timeout, like those of the
+// customers who left the checkout page of the provider.
+func (rt *_router) expireShopPayments() error {
+	now := globaltime.Now()
+	expired, err := rt.db.ExpireShopPayments(now.Add(-rt.shopPaymentTimeout), now)
+	if err != nil {
+		return err
+	}
+	for _, payment := range expired {
+		rt.baseLogger.WithFields(map[string]interface{}{"order_id": payment.OrderID, "intent_id": payment.IntentID}).Info("shop payment expired")
+	}
+	return nil
+}
+
+var fakeCheckoutTemplate = template.Must(template.New("fake-checkout").Parse(`<!DOCTYPE html>
+<html lang="it">
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>Pagamento di prova</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, Helvetica, Arial, sans-serif; background: #0b0b0b; color: #f5f5f5; margin: 0; min-height: 100vh; display: flex; align-items: center; justify-content: center; padding: 2rem; }
+        main { max-width: 420px; width: 100%; text-align: center; }
+        .amount { font-size: 2.5rem; font-weight: 700; margin: 1rem 0; }
+        form { display: inline-block; margin: 0.5rem; }
+        button { font-size: 1rem; padding: 0.75rem 1.5rem; border: 0; border-radius: 999px; cursor: pointer; }
+        .pay { background: #f5c400; color: #0b0b0b; }
+        .cancel { background: #333; color: #f5f5f5; }
+    </style>
+</head>
+<body>
+    <main>
+        <p>Ambiente di prova: nessun addebito reale.</p>
+        <h1>{{.Description}}</h1>
+        {{if .Open}}
+        <p class="amount">{{.Amount}}</p>
+        <form method="post"><input type="hidden" name="outcome" value="paid"><button class="pay" type="submit">Paga</button></form>
+        <form method="post"><input type="hidden" name="outcome" value="cancelled"><button class="cancel" type="submit">Annulla</button></form>
+        {{else}}
+        <p>Questo pagamento è già stato completato.</p>
+        {{end}}
+    </main>
+</body>
+</html>
+`))
+
+// fakeCheckoutPage is the checkout page of the fake provider, where the customer pays or cancels without any card.
+func (rt *_router) fakeCheckoutPage(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	fake, ok := rt.payments.(*payments.Fake)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	intent, err := fake.Intent(chi.URLParam(r, "id"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = fakeCheckoutTemplate.Execute(w, map[string]interface{}{
+		"Description": intent.Description,
+		"Amount":      fmt.Sprintf("%d,%02d €", intent.AmountCents/100, intent.AmountCents%100),
+		"Open":        intent.Status == payments.FakeIntentOpen,
+	})
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot render fake checkout page")
+	}
+}
+
+// completeFakeCheckout closes the checkout of the fake provider with the outcome chosen by the customer. The signed
+// webhook of the provider is delivered in-process, then the customer is sent back to the shop.
+func (rt *_router) completeFakeCheckout(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	fake, ok := rt.payments.(*payments.Fake)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := fake.Complete(chi.URLParam(r, "id"), r.PostForm.Get("outcome") == "paid")
+	switch {
+	case errors.Is(err, payments.ErrUnknownIntent):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, payments.ErrIntentClosed):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot complete fake checkout")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if status := rt.applyShopPaymentWebhook(ctx, webhook.Header, webhook.Body); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+	http.Redirect(w, r, webhook.RedirectURL, http.StatusSeeOther)
+}
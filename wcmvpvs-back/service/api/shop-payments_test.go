@@ -0,0 +1,143 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/payments"
+)
+
+func TestShopPayments(t *testing.T) {
+	h := newTestHarness(t)
+	h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	fake, err := payments.NewFake("webhook-secret")
+	if err != nil {
+		t.Fatalf("cannot create fake provider: %v", err)
+	}
+	h.router.payments = fake
+
+	product, err := h.db.SaveShopProduct(database.ShopProduct{Name: "Sciarpa", PriceCents: 1500, IsActive: true}, globaltime.Now())
+	if err != nil {
+		t.Fatalf("cannot create product: %v", err)
+	}
+	stock := 2
+	if _, err := h.db.AdjustShopStock(database.ShopStockChange{ProductID: product.ID, Stock: &stock, Reason: "Carico"}, globaltime.Now()); err != nil {
+		t.Fatalf("cannot set stock: %v", err)
+	}
+
+	checkout := func() checkoutResponsePayload {
+		t.Helper()
+		body := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "items": []map[string]int{{"product_id": product.ID, "quantity": 1}}}
+		rec := h.do(http.MethodPost, "/shop/checkout", body, map[string]string{"Origin": "https://shop.example.com"})
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("checkout: status = %d (%s)", rec.Code, rec.Body.String())
+		}
+		var response checkoutResponsePayload
+		h.decode(rec, &response)
+		if !strings.HasPrefix(response.PaymentURL, payments.FakeCheckoutPath) {
+			t.Fatalf("payment url = %q", response.PaymentURL)
+		}
+		return response
+	}
+	complete := func(paymentURL, outcome string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, paymentURL, strings.NewReader(url.Values{"outcome": {outcome}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		h.handler.ServeHTTP(rec, req)
+		return rec
+	}
+	loadOrder := func(id int) database.ShopOrder {
+		t.Helper()
+		order, err := h.db.GetShopOrder(id)
+		if err != nil {
+			t.Fatalf("cannot load order: %v", err)
+		}
+		return order
+	}
+
+	paid := checkout()
+	if code := h.do(http.MethodPost, fmt.Sprintf("/admin/shop/orders/%d/status", paid.Order.ID), map[string]string{"status": database.ShopOrderStatusPaid}, adminHeaders(token)).Code; code != http.StatusConflict {
+		t.Fatalf("marking an online order as paid: status = %d, want %d", code, http.StatusConflict)
+	}
+	if rec := h.do(http.MethodGet, paid.PaymentURL, nil, nil); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "15,00 €") {
+		t.Fatalf("checkout page: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	rec := complete(paid.PaymentURL, "paid")
+	if want := fmt.Sprintf("https://shop.example.com/shop/checkout/success?order=%d", paid.Order.ID); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != want {
+		t.Fatalf("payment: status = %d, location = %q, want %q", rec.Code, rec.Header().Get("Location"), want)
+	}
+	if order := loadOrder(paid.Order.ID); order.Status != database.ShopOrderStatusPaid || order.Payments[0].Status != database.ShopPaymentStatusSucceeded {
+		t.Fatalf("paid order = %+v", order)
+	}
+
+	// The provider delivers the same event again, and a forged one
+	intentID := strings.TrimPrefix(paid.PaymentURL, payments.FakeCheckoutPath)
+	event, _ := json.Marshal(payments.Event{ID: "evt_repeated", Type: payments.EventPaymentSucceeded, IntentID: intentID, AmountCents: 1500})
+	for i := 0; i < 2; i++ {
+		headers := map[string]string{payments.SignatureHeader: payments.Sign("webhook-secret", event, globaltime.Now())}
+		if rec := h.do(http.MethodPost, "/shop/payments/webhook", event, headers); rec.Code != http.StatusOK {
+			t.Fatalf("webhook delivery %d: status = %d", i+1, rec.Code)
+		}
+	}
+	forged := map[string]string{payments.SignatureHeader: payments.Sign("guessed-secret", event, globaltime.Now())}
+	if rec := h.do(http.MethodPost, "/shop/payments/webhook", event, forged); rec.Code != http.StatusBadRequest {
+		t.Fatalf("forged webhook: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if order := loadOrder(paid.Order.ID); len(order.History) != 1 {
+		t.Fatalf("history after the repeated webhooks = %+v", order.History)
+	}
+
+	cancelled := checkout()
+	if rec := complete(cancelled.PaymentURL, "cancelled"); rec.Code != http.StatusSeeOther || !strings.Contains(rec.Header().Get("Location"), "payment=cancelled") {
+		t.Fatalf("cancel: status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if order := loadOrder(cancelled.Order.ID); order.Status != database.ShopOrderStatusCancelled {
+		t.Fatalf("cancelled payment left the order %s", order.Status)
+	}
+
+	// The payment cannot be saved, as the provider returns an intent already used
+	h.router.payments = repeatedIntentProvider{Provider: fake, intentID: intentID}
+	body := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "items": []map[string]int{{"product_id": product.ID, "quantity": 1}}}
+	if rec := h.do(http.MethodPost, "/shop/checkout", body, nil); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("checkout with an unsaved payment: status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if restocked, err := h.db.GetAnyShopProduct(product.ID, globaltime.Now()); err != nil || *restocked.Stock != 1 {
+		t.Fatalf("stock after the unsaved payment = %v (%v), want 1", restocked.Stock, err)
+	}
+	h.router.payments = fake
+
+	abandoned := checkout()
+	h.advance(defaultShopPaymentTimeout + time.Second)
+	if err := h.router.expireShopPayments(); err != nil {
+		t.Fatalf("cannot expire payments: %v", err)
+	}
+	if order := loadOrder(abandoned.Order.ID); order.Status != database.ShopOrderStatusCancelled || order.Payments[0].Status != database.ShopPaymentStatusFailed {
+		t.Fatalf("abandoned order = %+v", order)
+	}
+	if restocked, err := h.db.GetAnyShopProduct(product.ID, globaltime.Now()); err != nil || *restocked.Stock != 1 {
+		t.Fatalf("stock = %v (%v), want 1", restocked.Stock, err)
+	}
+}
+
+// repeatedIntentProvider creates the intents on the wrapped provider, but always returns the same intent ID.
+type repeatedIntentProvider struct {
+	payments.Provider
+	intentID string
+}
+
+func (p repeatedIntentProvider) CreateIntent(ctx context.Context, req payments.IntentRequest) (payments.Intent, error) {
+	intent, err := p.Provider.CreateIntent(ctx, req)
+	intent.ID = p.intentID
+	return intent, err
+}
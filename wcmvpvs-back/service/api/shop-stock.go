@@ -0,0 +1,290 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultShopReservationTTL  = 15 * time.Minute
+	maxShopReservationIDLength = 64
+
+	// maxShopReservationItemQuantity and maxShopReservationQuantity cap the pieces a single cart can hold
+	maxShopReservationItemQuantity = 10
+	maxShopReservationQuantity     = 30
+
+	shopReservationIPLimit  = 30
+	shopReservationIPWindow = time.Minute
+)
+
+type shopReservationPayload struct {
+	// ReservationID is the reservation to replace, empty to create a new one
+	ReservationID string                `json:"reservation_id"`
+	Items         []checkoutItemPayload `json:"items"`
+}
+
+type shopReservationResponse struct {
+	ReservationID string `json:"reservation_id"`
+	ExpiresAt     string `json:"expires_at"`
+}
+
+// shopVariantPayload is the body of the variant create and update requests. IsActive defaults to true.
+type shopVariantPayload struct {
+	Name      string `json:"name"`
+	SortOrder int    `json:"sort_order"`
+	IsActive  *bool  `json:"is_active"`
+}
+
+func (p shopVariantPayload) apply(variant database.ShopProductVariant) database.ShopProductVariant {
+	variant.Name = p.Name
+	variant.SortOrder = p.SortOrder
+	variant.IsActive = p.IsActive == nil || *p.IsActive
+	return variant
+}
+
+// shopStockPayload is the body of a stock change: Delta is added to the stock, unless Stock is given to replace it.
+type shopStockPayload struct {
+	VariantID int    `json:"variant_id"`
+	Delta     int    `json:"delta"`
+	Stock     *int   `json:"stock"`
+	Reason    string `json:"reason"`
+}
+
+type shopStockResponse struct {
+	Product     database.ShopProduct           `json:"product"`
+	Adjustments []database.ShopStockAdjustment `json:"adjustments"`
+}
+
+// reserveShopStock holds the items of the cart for the reservation time, so that they are not sold to someone else
+// while the customer fills in the checkout. Sending the reservation_id again replaces the items and renews it.
+func (rt *_router) reserveShopStock(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	clientIP := rt.getClientIP(r)
+	if rt.shouldThrottleShopReservation(clientIP, globaltime.Now()) {
+		ctx.Logger.WithField("client_ip", clientIP).Warn("shop reservation throttled")
+		_ = writeJSONMessage(w, http.StatusTooManyRequests, "troppe prenotazioni ravvicinate, attendi qualche istante e riprova")
+		return
+	}
+
+	var payload shopReservationPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "payload non valido")
+		return
+	}
+	reservationID := strings.TrimSpace(payload.ReservationID)
+	if len(reservationID) > maxShopReservationIDLength {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "prenotazione non valida")
+		return
+	}
+	if reservationID == "" {
+		var err error
+		if reservationID, err = generateSessionToken(); err != nil {
+			ctx.Logger.WithError(err).Error("cannot create shop reservation id")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	items, ok := rt.shopOrderItems(w, ctx, payload.Items)
+	if !ok {
+		return
+	}
+	total := 0
+	for _, item := range items {
+		if item.Quantity > maxShopReservationItemQuantity {
+			_ = writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("puoi prenotare al massimo %d pezzi per articolo", maxShopReservationItemQuantity))
+			return
+		}
+		total += item.Quantity
+	}
+	if total > maxShopReservationQuantity {
+		_ = writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("puoi prenotare al massimo %d pezzi in tutto", maxShopReservationQuantity))
+		return
+	}
+	now := globaltime.Now()
+	expiresAt := now.Add(rt.shopReservationTTL)
+	if err := rt.db.ReserveShopStock(reservationID, items, expiresAt, now); err != nil {
+		rt.writeShopStockError(w, ctx, items, err)
+		return
+	}
+
+	_ = writeJSON(w, http.StatusOK, shopReservationResponse{ReservationID: reservationID, ExpiresAt: expiresAt.UTC().Format(time.RFC3339)})
+	ctx.Logger.WithField("items", len(items)).Info("shop stock reserved")
+}
+
+// releaseShopReservation gives the items of an abandoned cart back to the shop before the reservation expires.
+func (rt *_router) releaseShopReservation(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if err := rt.db.ReleaseShopReservation(chi.URLParam(r, "id")); err != nil {
+		ctx.Logger.WithError(err).Error("cannot release shop reservation")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// getShopStock returns the stock of the product and of its variants, with the log of the changes.
+func (rt *_router) getShopStock(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rt.writeShopStock(w, ctx, id)
+}
+
+// adjustShopStock changes the stock of the product, or of one of its variants, and logs the change with the reason and
+// the admin who made it.
+func (rt *_router) adjustShopStock(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopStockPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	adjustment, err := rt.db.AdjustShopStock(database.ShopStockChange{
+		ProductID: id,
+		VariantID: payload.VariantID,
+		Delta:     payload.Delta,
+		Stock:     payload.Stock,
+		Reason:    payload.Reason,
+		Admin:     ctx.AdminUsername,
+	}, globaltime.Now())
+	switch {
+	case errors.Is(err, database.ErrInvalidShopStock):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Variazione non valida: indica un motivo e non scendere sotto zero.")
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot adjust shop stock")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ctx.Logger.WithFields(map[string]interface{}{
+		"product_id": id,
+		"variant_id": payload.VariantID,
+		"delta":      adjustment.Delta,
+		"admin":      ctx.AdminUsername,
+	}).Info("shop stock adjusted")
+	rt.writeShopStock(w, ctx, id)
+}
+
+func (rt *_router) writeShopStock(w http.ResponseWriter, ctx reqcontext.RequestContext, productID int) {
+	product, err := rt.db.GetAnyShopProduct(productID, globaltime.Now())
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load shop product")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	adjustments, err := rt.db.ListShopStockAdjustments(productID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list shop stock adjustments")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, shopStockResponse{Product: product, Adjustments: adjustments})
+}
+
+func (rt *_router) createShopVariant(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopVariantPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	variant, err := rt.db.SaveShopProductVariant(payload.apply(database.ShopProductVariant{ProductID: productID}), globaltime.Now())
+	if !rt.checkShopVariantSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusCreated, variant)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": productID, "variant_id": variant.ID, "admin": ctx.AdminUsername}).Info("shop variant created")
+}
+
+func (rt *_router) updateShopVariant(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
+	if err != nil || variantID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload shopVariantPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	variant, err := rt.db.SaveShopProductVariant(payload.apply(database.ShopProductVariant{ID: variantID, ProductID: productID}), globaltime.Now())
+	if !rt.checkShopVariantSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, variant)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": productID, "variant_id": variant.ID, "admin": ctx.AdminUsername}).Info("shop variant updated")
+}
+
+func (rt *_router) deleteShopVariant(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	productID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || productID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	variantID, err := strconv.Atoi(chi.URLParam(r, "variantId"))
+	if err != nil || variantID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.DeleteShopProductVariant(productID, variantID, globaltime.Now()); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete shop variant")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ctx.Logger.WithFields(map[string]interface{}{"product_id": productID, "variant_id": variantID, "admin": ctx.AdminUsername}).Info("shop variant deleted")
+}
+
+// checkShopVariantSave writes the error response of a variant save, and tells whether it succeeded.
+func (rt *_router) checkShopVariantSave(w http.ResponseWriter, ctx reqcontext.RequestContext, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrInvalidShopVariant):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Dati della variante non validi: il nome è obbligatorio.")
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		ctx.Logger.WithError(err).Error("cannot save shop variant")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
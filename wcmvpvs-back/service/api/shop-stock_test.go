@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestShopStock(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	rec := h.do(http.MethodPost, "/admin/shop/products", map[string]interface{}{"name": "Maglia 2026", "price_cents": 6000}, adminHeaders(token))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create product: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var shirt database.ShopProduct
+	h.decode(rec, &shirt)
+	if shirt.Stock != nil {
+		t.Fatalf("new product stock = %d, want untracked", *shirt.Stock)
+	}
+
+	rec = h.do(http.MethodPost, fmt.Sprintf("/admin/shop/products/%d/variants", shirt.ID), map[string]interface{}{"name": "M"}, adminHeaders(token))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create variant: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var medium database.ShopProductVariant
+	h.decode(rec, &medium)
+
+	stock := fmt.Sprintf("/admin/shop/products/%d/stock", shirt.ID)
+	for _, change := range []map[string]interface{}{
+		{"variant_id": medium.ID, "stock": 5, "reason": "Primo carico"},
+		{"variant_id": medium.ID, "delta": -2, "reason": "Omaggi ai giocatori"},
+	} {
+		if rec := h.do(http.MethodPost, stock, change, adminHeaders(token)); rec.Code != http.StatusOK {
+			t.Fatalf("stock change %v: status = %d (%s)", change, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := h.do(http.MethodPost, stock, map[string]interface{}{"variant_id": medium.ID, "delta": -10, "reason": "Errore"}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("negative stock: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	var levels shopStockResponse
+	h.decode(h.do(http.MethodGet, stock, nil, adminHeaders(token)), &levels)
+	if len(levels.Adjustments) != 2 || levels.Adjustments[0].Delta != -2 || levels.Adjustments[0].StockAfter != 3 || levels.Adjustments[0].Admin != testAdminUsername {
+		t.Fatalf("stock adjustments = %+v", levels.Adjustments)
+	}
+
+	item := func(variantID, quantity int) []map[string]int {
+		return []map[string]int{{"product_id": shirt.ID, "variant_id": variantID, "quantity": quantity}}
+	}
+	checkout := func(items []map[string]int, reservationID string) int {
+		body := map[string]interface{}{"customer_name": "Mario", "customer_email": "mario@example.com", "items": items, "reservation_id": reservationID}
+		return h.do(http.MethodPost, "/shop/checkout", body, nil).Code
+	}
+	if code := checkout(item(0, 1), ""); code != http.StatusBadRequest {
+		t.Fatalf("checkout without variant: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// Another customer holds two of the three shirts
+	rec = h.do(http.MethodPost, "/shop/reservations", map[string]interface{}{"items": item(medium.ID, 2)}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reservation: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var reservation shopReservationResponse
+	h.decode(rec, &reservation)
+
+	rec = h.do(http.MethodPost, "/shop/checkout", map[string]interface{}{"customer_name": "Luigi", "customer_email": "luigi@example.com", "items": item(medium.ID, 2)}, nil)
+	var shortage shopStockErrorResponse
+	h.decode(rec, &shortage)
+	if rec.Code != http.StatusConflict || shortage.Available != 1 || shortage.Message != "disponibilità insufficiente per Maglia 2026 (M): ne restano solo 1" {
+		t.Fatalf("oversold checkout: status = %d, body = %+v", rec.Code, shortage)
+	}
+	if code := checkout(item(medium.ID, 2), reservation.ReservationID); code != http.StatusCreated {
+		t.Fatalf("checkout of the reservation: status = %d", code)
+	}
+
+	// The last shirt is held by a reservation that expires
+	rec = h.do(http.MethodPost, "/shop/reservations", map[string]interface{}{"items": item(medium.ID, 1)}, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("second reservation: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if code := checkout(item(medium.ID, 1), ""); code != http.StatusConflict {
+		t.Fatalf("checkout of a reserved shirt: status = %d, want %d", code, http.StatusConflict)
+	}
+	h.advance(defaultShopReservationTTL + 1)
+	if code := checkout(item(medium.ID, 1), ""); code != http.StatusCreated {
+		t.Fatalf("checkout after the reservation expired: status = %d", code)
+	}
+
+	product, err := h.db.GetShopProduct(shirt.ID, globaltime.Now())
+	if err != nil {
+		t.Fatalf("cannot load product: %v", err)
+	}
+	if len(product.Variants) != 1 || *product.Variants[0].Stock != 0 || *product.Variants[0].Available != 0 {
+		t.Fatalf("variants after the orders = %+v", product.Variants)
+	}
+}
+
+func TestShopReservationLimits(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var products []database.ShopProduct
+	for _, name := range []string{"Sciarpa", "Cappellino", "Bandiera", "Maglia"} {
+		rec := h.do(http.MethodPost, "/admin/shop/products", map[string]interface{}{"name": name, "price_cents": 1500}, adminHeaders(token))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create product: status = %d (%s)", rec.Code, rec.Body.String())
+		}
+		var product database.ShopProduct
+		h.decode(rec, &product)
+		products = append(products, product)
+	}
+	reserve := func(ip string, quantities ...int) int {
+		items := make([]map[string]int, 0, len(quantities))
+		for i, quantity := range quantities {
+			items = append(items, map[string]int{"product_id": products[i].ID, "quantity": quantity})
+		}
+		return h.do(http.MethodPost, "/shop/reservations", map[string]interface{}{"items": items}, map[string]string{"X-Forwarded-For": ip}).Code
+	}
+
+	if code := reserve("10.0.0.1", maxShopReservationItemQuantity+1); code != http.StatusBadRequest {
+		t.Fatalf("too many pieces of an item: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := reserve("10.0.0.1", 8, 8, 8, 8); code != http.StatusBadRequest {
+		t.Fatalf("too many pieces in the cart: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := reserve("10.0.0.1", maxShopReservationItemQuantity, 5); code != http.StatusOK {
+		t.Fatalf("reservation within the caps: status = %d", code)
+	}
+
+	for i := 3; i < shopReservationIPLimit; i++ {
+		if code := reserve("10.0.0.1", 1); code != http.StatusOK {
+			t.Fatalf("reservation %d: status = %d", i, code)
+		}
+	}
+	if code := reserve("10.0.0.1", 1); code != http.StatusTooManyRequests {
+		t.Fatalf("reservation over the limit: status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := reserve("10.0.0.2", 1); code != http.StatusOK {
+		t.Fatalf("reservation from another address: status = %d", code)
+	}
+	h.advance(shopReservationIPWindow + time.Second)
+	if code := reserve("10.0.0.1", 1); code != http.StatusOK {
+		t.Fatalf("reservation after the window: status = %d", code)
+	}
+}
@@ -4,17 +4,21 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/mailer"
 	"github.com/go-chi/chi/v5"
 )
 
 type checkoutItemPayload struct {
 	ProductID int `json:"product_id"`
+	VariantID int `json:"variant_id"`
 	Quantity  int `json:"quantity"`
 }
 
@@ -23,25 +27,70 @@ type checkoutRequestPayload struct {
 	CustomerEmail string                `json:"customer_email"`
 	CustomerNotes string                `json:"customer_notes"`
 	Items         []checkoutItemPayload `json:"items"`
+
+	// ReservationID is the cart reservation the order consumes, if any
+	ReservationID string `json:"reservation_id"`
+
+	// PickupEventID is the event where the order is collected, the next one when zero
+	PickupEventID int `json:"pickup_event_id"`
+
+	// Locale is the language of the emails about the order, from the Accept-Language header when empty
+	Locale string `json:"locale"`
 }
 
 type checkoutResponsePayload struct {
 	Order database.ShopOrder `json:"order"`
+
+	// PaymentURL is the checkout page of the payment provider, where the customer is sent to pay the order. It is empty
+	// when online payments are disabled and the order is paid at the desk
+	PaymentURL string `json:"payment_url,omitempty"`
 }
 
+// listShopProducts returns the products on sale, only those of `category` when given.
 func (rt *_router) listShopProducts(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
-	products, err := rt.db.ListShopProducts()
+	products, err := rt.db.ListShopProducts(globaltime.Now())
 	if err != nil {
 		ctx.Logger.WithError(err).Error("cannot list shop products")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if category := strings.TrimSpace(r.URL.Query().Get("category")); category != "" {
+		filtered := products[:0]
+		for _, product := range products {
+			if strings.EqualFold(product.Category, category) {
+				filtered = append(filtered, product)
+			}
+		}
+		products = filtered
+	}
 
 	w.Header().Set("content-type", "application/json")
 	_ = json.NewEncoder(w).Encode(products)
 	ctx.Logger.WithField("products", len(products)).Info("listed shop products")
 }
 
+// listShopCategories returns the categories of the products on sale, in the order of their first product.
+func (rt *_router) listShopCategories(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	products, err := rt.db.ListShopProducts(globaltime.Now())
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list shop products")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	categories := []string{}
+	seen := make(map[string]struct{})
+	for _, product := range products {
+		key := strings.ToLower(product.Category)
+		if _, ok := seen[key]; ok || product.Category == "" {
+			continue
+		}
+		seen[key] = struct{}{}
+		categories = append(categories, product.Category)
+	}
+	_ = writeJSON(w, http.StatusOK, categories)
+}
+
 func (rt *_router) getShopProduct(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
 	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil || id <= 0 {
@@ -49,7 +98,7 @@ func (rt *_router) getShopProduct(w http.ResponseWriter, r *http.Request, ctx re
 		return
 	}
 
-	product, err := rt.db.GetShopProduct(id)
+	product, err := rt.db.GetShopProduct(id, globaltime.Now())
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			w.WriteHeader(http.StatusNotFound)
@@ -87,81 +136,183 @@ func (rt *_router) checkoutShopOrder(w http.ResponseWriter, r *http.Request, ctx
 		return
 	}
 
-	type aggregatedItem struct {
-		quantity int
+	notes, err := rt.filterText(database.TextFilterTargetOrderNotes, payload.CustomerNotes)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot filter order notes")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if notes.Rejected {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "le note contengono parole non consentite")
+		return
 	}
 
-	aggregated := make(map[int]*aggregatedItem)
-	orderedIDs := make([]int, 0, len(payload.Items))
-	for _, item := range payload.Items {
-		if item.ProductID <= 0 || item.Quantity <= 0 {
-			_ = writeJSONMessage(w, http.StatusBadRequest, "articolo non valido nel carrello")
+	orderItems, ok := rt.shopOrderItems(w, ctx, payload.Items)
+	if !ok {
+		return
+	}
+	totalCents := 0
+	for _, item := range orderItems {
+		totalCents += item.UnitPriceCents * item.Quantity
+	}
+
+	if totalCents <= 0 {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "impossibile calcolare il totale dell'ordine")
+		return
+	}
+
+	now := globaltime.Now()
+	if payload.PickupEventID == 0 {
+		if payload.PickupEventID, err = rt.db.NextPickupEventID(now); err != nil {
+			ctx.Logger.WithError(err).Error("cannot find the next pickup event")
+			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
+	}
 
-		if existing, ok := aggregated[item.ProductID]; ok {
-			existing.quantity += item.Quantity
-			continue
+	order, err := rt.db.CreateShopOrder(database.ShopOrder{
+		CustomerName:  payload.CustomerName,
+		CustomerEmail: payload.CustomerEmail,
+		CustomerNotes: notes.Text,
+		NotesFlagged:  notes.Flagged,
+		TotalCents:    totalCents,
+		PickupEventID: payload.PickupEventID,
+		Locale:        requestLocale(r, payload.Locale),
+	}, orderItems, strings.TrimSpace(payload.ReservationID), now)
+	if errors.Is(err, database.ErrInvalidPickupEvent) {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "evento di ritiro non valido")
+		return
+	} else if err != nil {
+		rt.writeShopStockError(w, ctx, orderItems, err)
+		return
+	}
+
+	response := checkoutResponsePayload{Order: order}
+	if rt.payments != nil {
+		payment, ok := rt.startShopPayment(w, r, ctx, order)
+		if !ok {
+			return
 		}
+		response.PaymentURL = payment.CheckoutURL
+	} else {
+		// Without online payments the order is confirmed right away, and paid at the desk
+		rt.queueShopOrderMail(ctx.Logger.WithField("order_id", order.ID), mailer.TemplateOrderConfirmation, order)
+	}
 
-		aggregated[item.ProductID] = &aggregatedItem{quantity: item.Quantity}
-		orderedIDs = append(orderedIDs, item.ProductID)
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response)
+	ctx.Logger.WithFields(map[string]interface{}{
+		"order_id":    order.ID,
+		"total_cents": order.TotalCents,
+		"items":       len(order.Items),
+	}).Info("shop order created")
+}
+
+// shopOrderItems builds the order items of the cart, merging the lines of the same product and variant, with the
+// current names and prices. It writes the error response and returns false when an item is not on sale.
+func (rt *_router) shopOrderItems(w http.ResponseWriter, ctx reqcontext.RequestContext, items []checkoutItemPayload) ([]database.ShopOrderItem, bool) {
+	type itemKey struct{ product, variant int }
+	quantities := make(map[itemKey]int)
+	keys := make([]itemKey, 0, len(items))
+	for _, item := range items {
+		if item.ProductID <= 0 || item.Quantity <= 0 || item.VariantID < 0 {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "articolo non valido nel carrello")
+			return nil, false
+		}
+		key := itemKey{item.ProductID, item.VariantID}
+		if _, ok := quantities[key]; !ok {
+			keys = append(keys, key)
+		}
+		quantities[key] += item.Quantity
 	}
 
-	if len(aggregated) == 0 {
+	if len(keys) == 0 {
 		_ = writeJSONMessage(w, http.StatusBadRequest, "carrello vuoto")
-		return
+		return nil, false
 	}
 
-	orderItems := make([]database.ShopOrderItem, 0, len(aggregated))
-	totalCents := 0
-
-	for _, productID := range orderedIDs {
-		info := aggregated[productID]
-		product, err := rt.db.GetShopProduct(productID)
+	now := globaltime.Now()
+	orderItems := make([]database.ShopOrderItem, 0, len(keys))
+	for _, key := range keys {
+		product, err := rt.db.GetShopProduct(key.product, now)
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
 				_ = writeJSONMessage(w, http.StatusBadRequest, "uno dei prodotti selezionati non è più disponibile")
-				return
+				return nil, false
 			}
 			ctx.Logger.WithError(err).Error("cannot retrieve product for checkout")
 			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return nil, false
 		}
 
-		orderItems = append(orderItems, database.ShopOrderItem{
+		item := database.ShopOrderItem{
 			ProductID:       product.ID,
 			ProductName:     product.Name,
 			ProductImageURL: product.ImageURL,
-			Quantity:        info.quantity,
+			VariantID:       key.variant,
+			Quantity:        quantities[key],
 			UnitPriceCents:  product.PriceCents,
-		})
-		totalCents += product.PriceCents * info.quantity
-	}
-
-	if totalCents <= 0 {
-		_ = writeJSONMessage(w, http.StatusBadRequest, "impossibile calcolare il totale dell'ordine")
-		return
+		}
+		if len(product.Variants) > 0 || key.variant > 0 {
+			found := false
+			for _, variant := range product.Variants {
+				if variant.ID == key.variant {
+					item.VariantName = variant.Name
+					found = true
+				}
+			}
+			if key.variant == 0 {
+				_ = writeJSONMessage(w, http.StatusBadRequest, fmt.Sprintf("scegli la variante di %s", product.Name))
+				return nil, false
+			}
+			if !found {
+				_ = writeJSONMessage(w, http.StatusBadRequest, "uno dei prodotti selezionati non è più disponibile")
+				return nil, false
+			}
+		}
+		orderItems = append(orderItems, item)
 	}
+	return orderItems, true
+}
 
-	order, err := rt.db.CreateShopOrder(database.ShopOrder{
-		CustomerName:  payload.CustomerName,
-		CustomerEmail: payload.CustomerEmail,
-		CustomerNotes: payload.CustomerNotes,
-		TotalCents:    totalCents,
-	}, orderItems)
-	if err != nil {
-		ctx.Logger.WithError(err).Error("cannot create shop order")
+// writeShopStockError writes the error response of an order or reservation of the items, telling the customer how
+// many pieces are left when the stock is not enough.
+func (rt *_router) writeShopStockError(w http.ResponseWriter, ctx reqcontext.RequestContext, items []database.ShopOrderItem, err error) {
+	var stockErr *database.ShopStockError
+	switch {
+	case errors.As(err, &stockErr):
+		name := "un prodotto"
+		for _, item := range items {
+			if item.ProductID == stockErr.ProductID && item.VariantID == stockErr.VariantID {
+				name = item.ProductName
+				if item.VariantName != "" {
+					name = fmt.Sprintf("%s (%s)", item.ProductName, item.VariantName)
+				}
+			}
+		}
+		message := fmt.Sprintf("prodotto esaurito: %s", name)
+		if stockErr.Available > 0 {
+			message = fmt.Sprintf("disponibilità insufficiente per %s: ne restano solo %d", name, stockErr.Available)
+		}
+		_ = writeJSON(w, http.StatusConflict, shopStockErrorResponse{
+			Message:   message,
+			ProductID: stockErr.ProductID,
+			VariantID: stockErr.VariantID,
+			Available: stockErr.Available,
+		})
+	case errors.Is(err, sql.ErrNoRows):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "uno dei prodotti selezionati non è più disponibile")
+	default:
+		ctx.Logger.WithError(err).Error("cannot take the items from the shop stock")
 		w.WriteHeader(http.StatusInternalServerError)
-		return
 	}
+}
 
-	w.Header().Set("content-type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	_ = json.NewEncoder(w).Encode(checkoutResponsePayload{Order: order})
-	ctx.Logger.WithFields(map[string]interface{}{
-		"order_id":    order.ID,
-		"total_cents": order.TotalCents,
-		"items":       len(order.Items),
-	}).Info("shop order created")
+// shopStockErrorResponse is the body of the 409 responses for items out of stock.
+type shopStockErrorResponse struct {
+	Message   string `json:"message"`
+	ProductID int    `json:"product_id"`
+	VariantID int    `json:"variant_id,omitempty"`
+	Available int    `json:"available"`
 }
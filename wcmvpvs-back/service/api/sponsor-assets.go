@@ -0,0 +1,286 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/blobstore"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/imaging"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	sponsorLogoMaxUploadSize = 4 << 20 // 4 MiB
+
+	sponsorLogoMigrationTask     = "sponsor logo migration"
+	sponsorLogoMigrationInterval = time.Minute
+)
+
+// sponsorLogoSides are the renditions of every sponsor logo, with the side of the square they fit in.
+var sponsorLogoSides = map[string]int{
+	"small":  160,
+	"medium": 400,
+	"large":  800,
+}
+
+var (
+	// errInvalidSponsorLogo is returned for logos that are not PNG, JPEG or WebP images, or cannot be decoded.
+	errInvalidSponsorLogo = errors.New("invalid sponsor logo")
+
+	// errUnknownSponsorAsset is returned when a sponsor or a creative refers to an asset never uploaded.
+	errUnknownSponsorAsset = errors.New("unknown sponsor asset")
+)
+
+// sponsorAssetResponse is an uploaded logo with the public URL of each of its renditions.
+type sponsorAssetResponse struct {
+	database.SponsorAsset
+	URLs map[string]string `json:"urls"`
+}
+
+func sponsorAssetKey(hash, rendition string) string {
+	return fmt.Sprintf("sponsors/%s/%s", hash, rendition)
+}
+
+// sponsorAssetURLs returns the public path of every rendition of the asset. The paths never change for a hash, so
+// clients can cache them forever.
+func sponsorAssetURLs(hash string) map[string]string {
+	urls := make(map[string]string, len(sponsorLogoSides))
+	for rendition := range sponsorLogoSides {
+		urls[rendition] = fmt.Sprintf("/sponsor-assets/%s/%s", hash, rendition)
+	}
+	return urls
+}
+
+func isSponsorAssetHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil && strings.ToLower(hash) == hash
+}
+
+// storeSponsorLogo validates the logo and writes its renditions to the blob store, under the SHA-256 of the uploaded
+// bytes. Uploading the same logo twice returns the asset stored the first time.
+func (rt *_router) storeSponsorLogo(ctx context.Context, data []byte, contentType string) (database.SponsorAsset, error) {
+	logo, err := imaging.ProcessLogo(data, contentType, sponsorLogoSides, imaging.DefaultOptions.MaxPixels)
+	if err != nil {
+		return database.SponsorAsset{}, fmt.Errorf("%w: %v", errInvalidSponsorLogo, err)
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	for rendition, encoded := range logo.Renditions {
+		if err := rt.blobs.Put(ctx, sponsorAssetKey(hash, rendition), encoded, logo.ContentType); err != nil {
+			return database.SponsorAsset{}, fmt.Errorf("storing sponsor logo %s: %w", rendition, err)
+		}
+	}
+	return rt.db.SaveSponsorAsset(database.SponsorAsset{
+		Hash:        hash,
+		ContentType: logo.ContentType,
+		Width:       logo.Width,
+		Height:      logo.Height,
+		Size:        len(data),
+	}, globaltime.Now())
+}
+
+// resolveSponsorLogo returns the logo to store for a sponsor or a creative, as the inline data and the asset hash. A
+// given asset must exist; an inline logo is moved to the blob store when it is a PNG, JPEG or WebP image, and kept
+// inline otherwise (SVG logos, for instance).
+func (rt *_router) resolveSponsorLogo(ctx context.Context, logoData, logoAsset string) (string, string, error) {
+	logoAsset = strings.TrimSpace(logoAsset)
+	if logoAsset != "" {
+		if _, err := rt.db.GetSponsorAsset(logoAsset); errors.Is(err, sql.ErrNoRows) {
+			return "", "", errUnknownSponsorAsset
+		} else if err != nil {
+			return "", "", err
+		}
+		return "", logoAsset, nil
+	}
+
+	data, contentType, err := decodeBase64Image(logoData)
+	if err != nil {
+		return logoData, "", nil
+	}
+	asset, err := rt.storeSponsorLogo(ctx, data, contentType)
+	if errors.Is(err, errInvalidSponsorLogo) {
+		return logoData, "", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	return "", asset.Hash, nil
+}
+
+// migrateSponsorLogos moves the logos of the sponsors and of the creatives still stored inline to the blob store. The
+// logos that cannot be converted stay inline and are served as before. It runs in the background until it completes
+// once: the logos that failed for other reasons, like an unreachable blob store, are retried at the next run.
+func (rt *_router) migrateSponsorLogos() error {
+	if done, err := rt.db.IsMaintenanceTaskDone(sponsorLogoMigrationTask); err != nil || done {
+		return err
+	}
+	ctx := context.Background()
+	logger := rt.baseLogger.WithField("job", sponsorLogoMigrationTask)
+
+	sponsors, err := rt.db.ListSponsors()
+	if err != nil {
+		return fmt.Errorf("listing sponsors: %w", err)
+	}
+	failed := 0
+	for _, sponsor := range sponsors {
+		if sponsor.LogoAsset != "" || strings.TrimSpace(sponsor.LogoData) == "" {
+			continue
+		}
+		if sponsor.LogoData, sponsor.LogoAsset, err = rt.resolveSponsorLogo(ctx, sponsor.LogoData, ""); err != nil {
+			logger.WithError(err).WithField("sponsor_id", sponsor.ID).Warn("cannot migrate sponsor logo")
+			failed++
+			continue
+		}
+		if sponsor.LogoAsset == "" {
+			logger.WithField("sponsor_id", sponsor.ID).Warn("sponsor logo left inline: unsupported format")
+			continue
+		}
+		if err := rt.db.UpdateSponsor(sponsor); err != nil {
+			logger.WithError(err).WithField("sponsor_id", sponsor.ID).Warn("cannot migrate sponsor logo")
+			failed++
+		}
+	}
+
+	campaigns, err := rt.db.ListSponsorCampaigns(0)
+	if err != nil {
+		return fmt.Errorf("listing sponsor campaigns: %w", err)
+	}
+	for _, campaign := range campaigns {
+		for _, creative := range campaign.Creatives {
+			if creative.LogoAsset != "" || strings.TrimSpace(creative.LogoData) == "" {
+				continue
+			}
+			if creative.LogoData, creative.LogoAsset, err = rt.resolveSponsorLogo(ctx, creative.LogoData, ""); err != nil {
+				logger.WithError(err).WithField("creative_id", creative.ID).Warn("cannot migrate sponsor creative logo")
+				failed++
+				continue
+			}
+			if creative.LogoAsset == "" {
+				logger.WithField("creative_id", creative.ID).Warn("sponsor creative logo left inline: unsupported format")
+				continue
+			}
+			if _, err := rt.db.SaveSponsorCreative(creative, globaltime.Now()); err != nil {
+				logger.WithError(err).WithField("creative_id", creative.ID).Warn("cannot migrate sponsor creative logo")
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d sponsor logos not migrated", failed)
+	}
+	return rt.db.CompleteMaintenanceTask(sponsorLogoMigrationTask, globaltime.Now())
+}
+
+// uploadSponsorAsset stores the logo sent as the multipart field `file` and returns its renditions.
+func (rt *_router) uploadSponsorAsset(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	r.Body = http.MaxBytesReader(w, r.Body, sponsorLogoMaxUploadSize+(1<<20))
+	if err := r.ParseMultipartForm(sponsorLogoMaxUploadSize); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid sponsor logo upload")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Caricamento del logo non valido.")
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Nessun file del logo ricevuto.")
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, sponsorLogoMaxUploadSize+1))
+	if err != nil {
+		ctx.Logger.WithError(err).Warn("cannot read sponsor logo upload")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(data) > sponsorLogoMaxUploadSize {
+		_ = writeJSONMessage(w, http.StatusRequestEntityTooLarge, "Il logo supera la dimensione massima di 4 MB.")
+		return
+	}
+
+	asset, err := rt.storeSponsorLogo(r.Context(), data, detectContentType(data, header.Header.Get("Content-Type")))
+	if errors.Is(err, errInvalidSponsorLogo) {
+		ctx.Logger.WithError(err).Warn("rejected sponsor logo")
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato del logo non supportato: usa PNG, JPEG o WebP.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot store sponsor logo")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeJSON(w, http.StatusCreated, sponsorAssetResponse{SponsorAsset: asset, URLs: sponsorAssetURLs(asset.Hash)})
+	ctx.Logger.WithField("hash", asset.Hash).Info("sponsor logo uploaded")
+}
+
+// getSponsorAsset serves a rendition of a sponsor logo. The content of a URL never changes, so the response can be
+// cached forever and revalidated with its ETag.
+func (rt *_router) getSponsorAsset(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	hash := chi.URLParam(r, "hash")
+	rendition := chi.URLParam(r, "rendition")
+	if _, ok := sponsorLogoSides[rendition]; !ok || !isSponsorAssetHash(hash) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	rt.serveImmutableBlob(w, r, ctx, sponsorAssetKey(hash, rendition), fmt.Sprintf(`"%s-%s"`, hash, rendition))
+}
+
+// serveImmutableBlob serves a blob whose key never changes content: the response can be cached forever and
+// revalidated with the ETag.
+func (rt *_router) serveImmutableBlob(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, key, etag string) {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	content, info, err := rt.blobs.Get(r.Context(), key)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		w.Header().Del("Cache-Control")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).WithField("key", key).Error("cannot read blob")
+		w.Header().Del("Cache-Control")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if seeker, ok := content.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, path.Base(key), info.ModTime, seeker)
+		return
+	}
+	if info.Size > 0 {
+		w.Header().Set("Content-Length", fmt.Sprint(info.Size))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		if _, err := io.Copy(w, content); err != nil {
+			ctx.Logger.WithError(err).WithField("key", key).Warn("cannot write blob")
+		}
+	}
+}
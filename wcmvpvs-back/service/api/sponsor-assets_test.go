@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSponsorAssets(t *testing.T) {
+	h := newTestHarness(t)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	upload := func(filename string, data []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatalf("cannot create form file: %v", err)
+		}
+		_, _ = part.Write(data)
+		_ = writer.Close()
+		headers := adminHeaders(token)
+		headers["Content-Type"] = writer.FormDataContentType()
+		return h.do(http.MethodPost, "/admin/sponsor-assets", body.Bytes(), headers)
+	}
+
+	var logo bytes.Buffer
+	if err := png.Encode(&logo, image.NewNRGBA(image.Rect(0, 0, 1000, 500))); err != nil {
+		t.Fatalf("cannot encode logo: %v", err)
+	}
+	rec := upload("logo.png", logo.Bytes())
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("upload: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var asset sponsorAssetResponse
+	h.decode(rec, &asset)
+	if len(asset.Hash) != 64 || asset.ContentType != "image/png" || asset.Width != 1000 || asset.Height != 500 {
+		t.Fatalf("asset = %+v", asset)
+	}
+	if asset.URLs["large"] != fmt.Sprintf("/sponsor-assets/%s/large", asset.Hash) {
+		t.Fatalf("urls = %v", asset.URLs)
+	}
+	if rec := upload("logo.txt", []byte("not a logo")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("text upload: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec = h.do(http.MethodGet, asset.URLs["large"], nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("large rendition: status = %d, headers = %v", rec.Code, rec.Header())
+	}
+	large, err := png.DecodeConfig(rec.Body)
+	if err != nil || large.Width != 800 || large.Height != 400 {
+		t.Fatalf("large rendition = %+v, %v", large, err)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec := h.do(http.MethodGet, asset.URLs["large"], nil, map[string]string{"If-None-Match": etag}); rec.Code != http.StatusNotModified {
+		t.Fatalf("revalidation: status = %d, want %d", rec.Code, http.StatusNotModified)
+	}
+	if rec := h.do(http.MethodGet, fmt.Sprintf("/sponsor-assets/%s/huge", asset.Hash), nil, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown rendition: status = %d", rec.Code)
+	}
+
+	sponsor := map[string]interface{}{"name": "Uploaded", "logo_asset": asset.Hash, "is_active": true}
+	if rec := h.do(http.MethodPost, "/admin/sponsors", sponsor, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("create sponsor: status = %d", rec.Code)
+	}
+	sponsor["logo_asset"] = "0000000000000000000000000000000000000000000000000000000000000000"
+	if rec := h.do(http.MethodPost, "/admin/sponsors", sponsor, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown asset: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	// Logos stored inline before the upload existed are moved to the blob store at startup
+	inlineID, err := h.db.CreateSponsor(database.Sponsor{Name: "Inline", LogoData: testPNGDataURL(t), IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create inline sponsor: %v", err)
+	}
+	svgID, err := h.db.CreateSponsor(database.Sponsor{Name: "Vector", LogoData: "data:image/svg+xml;base64,PHN2Zy8+", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create svg sponsor: %v", err)
+	}
+	if err := h.router.migrateSponsorLogos(); err != nil {
+		t.Fatalf("cannot migrate sponsor logos: %v", err)
+	}
+	migrated, err := h.db.GetSponsor(inlineID)
+	if err != nil || migrated.LogoData != "" || migrated.LogoAsset == "" {
+		t.Fatalf("migrated sponsor = %+v, %v", migrated, err)
+	}
+	if vector, err := h.db.GetSponsor(svgID); err != nil || vector.LogoAsset != "" || vector.LogoData == "" {
+		t.Fatalf("svg sponsor = %+v, %v, want it left inline", vector, err)
+	}
+	// The migration completed: it is recorded and does not run again
+	if done, err := h.db.IsMaintenanceTaskDone(sponsorLogoMigrationTask); err != nil || !done {
+		t.Fatalf("migration done = %v (%v), want it recorded", done, err)
+	}
+
+	var rotation []publicSponsor
+	h.decode(h.do(http.MethodGet, "/sponsors", nil, nil), &rotation)
+	if len(rotation) != 3 || rotation[0].LogoURL != asset.URLs["medium"] || rotation[0].LogoData != "" || rotation[2].LogoURL != "" {
+		t.Fatalf("rotation = %+v", rotation)
+	}
+
+	lateID, err := h.db.CreateSponsor(database.Sponsor{Name: "Late", LogoData: testPNGDataURL(t), IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create inline sponsor: %v", err)
+	}
+	if err := h.router.migrateSponsorLogos(); err != nil {
+		t.Fatalf("cannot run the migration again: %v", err)
+	}
+	if late, err := h.db.GetSponsor(lateID); err != nil || late.LogoAsset != "" {
+		t.Fatalf("sponsor created after the migration = %+v, %v, want it untouched", late, err)
+	}
+}
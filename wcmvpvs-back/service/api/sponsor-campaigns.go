@@ -0,0 +1,236 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+// sponsorCampaignPayload is the body of the campaign create and update requests. IsActive defaults to true, the
+// exposure rules to none.
+type sponsorCampaignPayload struct {
+	Name     string `json:"name"`
+	EventIDs []int  `json:"event_ids"`
+	StartsAt string `json:"starts_at"`
+	EndsAt   string `json:"ends_at"`
+	Weight   int    `json:"weight"`
+	Priority int    `json:"priority"`
+	IsActive *bool  `json:"is_active"`
+
+	MaxViewsPerDevice int    `json:"max_views_per_device"`
+	MinDwellMs        int    `json:"min_dwell_ms"`
+	ShareOfVoice      int    `json:"share_of_voice"`
+	ExclusiveScreen   string `json:"exclusive_screen"`
+}
+
+// sponsorCreativePayload is the body of the creative create and update requests. IsActive defaults to true; the logo is
+// resolved by resolveSponsorLogo.
+type sponsorCreativePayload struct {
+	Name      string `json:"name"`
+	LogoData  string `json:"logo_data"`
+	LogoAsset string `json:"logo_asset"`
+	LinkURL   string `json:"link_url"`
+	Weight    int    `json:"weight"`
+	IsActive  *bool  `json:"is_active"`
+}
+
+func (p sponsorCampaignPayload) apply(campaign database.SponsorCampaign) database.SponsorCampaign {
+	campaign.Name = p.Name
+	campaign.EventIDs = p.EventIDs
+	campaign.StartsAt = p.StartsAt
+	campaign.EndsAt = p.EndsAt
+	campaign.Weight = p.Weight
+	campaign.Priority = p.Priority
+	campaign.IsActive = p.IsActive == nil || *p.IsActive
+	campaign.MaxViewsPerDevice = p.MaxViewsPerDevice
+	campaign.MinDwellMs = p.MinDwellMs
+	campaign.ShareOfVoice = p.ShareOfVoice
+	campaign.ExclusiveScreen = p.ExclusiveScreen
+	return campaign
+}
+
+func (p sponsorCreativePayload) apply(creative database.SponsorCreative) database.SponsorCreative {
+	creative.Name = p.Name
+	creative.LinkURL = p.LinkURL
+	creative.Weight = p.Weight
+	creative.IsActive = p.IsActive == nil || *p.IsActive
+	return creative
+}
+
+func (rt *_router) listSponsorCampaigns(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, err := rt.db.GetSponsor(sponsorID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load sponsor")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	campaigns, err := rt.db.ListSponsorCampaigns(sponsorID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list sponsor campaigns")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, campaigns)
+}
+
+func (rt *_router) createSponsorCampaign(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload sponsorCampaignPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid payload while creating sponsor campaign")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := rt.db.SaveSponsorCampaign(payload.apply(database.SponsorCampaign{SponsorID: sponsorID}), globaltime.Now())
+	if !rt.checkSponsorCampaignSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusCreated, campaign)
+	ctx.Logger.WithField("sponsor_id", sponsorID).WithField("campaign_id", campaign.ID).Info("sponsor campaign created")
+}
+
+func (rt *_router) updateSponsorCampaign(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	campaignID, err := strconv.Atoi(chi.URLParam(r, "campaignId"))
+	if err != nil || campaignID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload sponsorCampaignPayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid payload while updating sponsor campaign")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	campaign, err := rt.db.GetSponsorCampaign(campaignID)
+	if err == nil {
+		campaign, err = rt.db.SaveSponsorCampaign(payload.apply(campaign), globaltime.Now())
+	}
+	if !rt.checkSponsorCampaignSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, campaign)
+	ctx.Logger.WithField("campaign_id", campaignID).Info("sponsor campaign updated")
+}
+
+func (rt *_router) deleteSponsorCampaign(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	campaignID, err := strconv.Atoi(chi.URLParam(r, "campaignId"))
+	if err != nil || campaignID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := rt.db.DeleteSponsorCampaign(campaignID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete sponsor campaign")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ctx.Logger.WithField("campaign_id", campaignID).Info("sponsor campaign deleted")
+}
+
+func (rt *_router) createSponsorCreative(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	campaignID, err := strconv.Atoi(chi.URLParam(r, "campaignId"))
+	if err != nil || campaignID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload sponsorCreativePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid payload while creating sponsor creative")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	creative := payload.apply(database.SponsorCreative{CampaignID: campaignID})
+	creative.LogoData, creative.LogoAsset, err = rt.resolveSponsorLogo(r.Context(), payload.LogoData, payload.LogoAsset)
+	if err == nil {
+		creative, err = rt.db.SaveSponsorCreative(creative, globaltime.Now())
+	}
+	if !rt.checkSponsorCampaignSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusCreated, creative)
+	ctx.Logger.WithField("campaign_id", campaignID).WithField("creative_id", creative.ID).Info("sponsor creative created")
+}
+
+func (rt *_router) updateSponsorCreative(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	creativeID, err := strconv.Atoi(chi.URLParam(r, "creativeId"))
+	if err != nil || creativeID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload sponsorCreativePayload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		ctx.Logger.WithError(err).Warn("invalid payload while updating sponsor creative")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	creative := payload.apply(database.SponsorCreative{ID: creativeID})
+	creative.LogoData, creative.LogoAsset, err = rt.resolveSponsorLogo(r.Context(), payload.LogoData, payload.LogoAsset)
+	if err == nil {
+		creative, err = rt.db.SaveSponsorCreative(creative, globaltime.Now())
+	}
+	if !rt.checkSponsorCampaignSave(w, ctx, err) {
+		return
+	}
+	_ = writeJSON(w, http.StatusOK, creative)
+	ctx.Logger.WithField("creative_id", creativeID).Info("sponsor creative updated")
+}
+
+func (rt *_router) deleteSponsorCreative(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	creativeID, err := strconv.Atoi(chi.URLParam(r, "creativeId"))
+	if err != nil || creativeID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := rt.db.DeleteSponsorCreative(creativeID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete sponsor creative")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+	ctx.Logger.WithField("creative_id", creativeID).Info("sponsor creative deleted")
+}
+
+// checkSponsorCampaignSave writes the error response of a campaign or creative save, and tells whether it succeeded.
+func (rt *_router) checkSponsorCampaignSave(w http.ResponseWriter, ctx reqcontext.RequestContext, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, database.ErrInvalidSponsorCampaign), errors.Is(err, errUnknownSponsorAsset):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Dati della campagna non validi: controlla logo, peso, date e regole di esposizione.")
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		ctx.Logger.WithError(err).Error("cannot save sponsor campaign")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestSponsorCampaigns(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	// More than the four sponsors allowed before the campaigns
+	var sponsorIDs []int
+	for i := 1; i <= 6; i++ {
+		body := map[string]interface{}{"name": fmt.Sprintf("Sponsor %d", i), "logo_data": "logo-sponsor", "link_url": "https://example.com", "is_active": true}
+		rec := h.do(http.MethodPost, "/admin/sponsors", body, adminHeaders(token))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("create sponsor %d: status = %d", i, rec.Code)
+		}
+		var created struct {
+			ID int `json:"id"`
+		}
+		h.decode(rec, &created)
+		sponsorIDs = append(sponsorIDs, created.ID)
+	}
+
+	createCampaign := func(sponsorID int, body map[string]interface{}) database.SponsorCampaign {
+		t.Helper()
+		rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsors/%d/campaigns", sponsorID), body, adminHeaders(token))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create campaign %v: status = %d (%s)", body, rec.Code, rec.Body.String())
+		}
+		var campaign database.SponsorCampaign
+		h.decode(rec, &campaign)
+		return campaign
+	}
+	now := globaltime.Now().UTC()
+	featured := createCampaign(sponsorIDs[0], map[string]interface{}{"name": "Derby", "event_ids": []int{fixture.EventID}, "priority": 10, "weight": 3})
+	createCampaign(sponsorIDs[1], map[string]interface{}{"name": "Other match", "event_ids": []int{fixture.EventID + 1}})
+	createCampaign(sponsorIDs[2], map[string]interface{}{"name": "Last season", "ends_at": now.Add(-time.Hour).Format(time.RFC3339)})
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsors/%d/campaigns", sponsorIDs[3]), map[string]interface{}{"starts_at": "2026-01-02T00:00:00Z", "ends_at": "2026-01-01T00:00:00Z"}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("reversed dates: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsor-campaigns/%d/creatives", featured.ID), map[string]interface{}{"name": "Banner", "logo_data": "logo-derby", "link_url": "https://example.com/derby"}, adminHeaders(token))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("create creative: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	var creative database.SponsorCreative
+	h.decode(rec, &creative)
+
+	var rotation []publicSponsor
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/sponsors?event_id=%d", fixture.EventID), nil, nil), &rotation)
+	if len(rotation) != 4 {
+		t.Fatalf("rotation = %+v, want the featured sponsor and the three without campaigns", rotation)
+	}
+	first := rotation[0]
+	if first.ID != sponsorIDs[0] || first.CampaignID != featured.ID || first.CreativeID != creative.ID || first.LogoData != "logo-derby" || first.LinkURL != "https://example.com/derby" || first.Position != 1 {
+		t.Fatalf("first sponsor = %+v", first)
+	}
+	for i, sponsor := range rotation[1:] {
+		if sponsor.ID != sponsorIDs[i+3] || sponsor.CampaignID != 0 {
+			t.Fatalf("sponsor %d = %+v, want sponsor %d without campaign", i+1, sponsor, sponsorIDs[i+3])
+		}
+	}
+
+	exposure := map[string]interface{}{
+		"device_id":   "device-1",
+		"type":        "seen",
+		"impressions": []map[string]int{{"sponsor_id": first.ID, "campaign_id": first.CampaignID, "creative_id": first.CreativeID}},
+		"sponsor_ids": []int{first.ID, sponsorIDs[3]},
+	}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/sponsors/exposures", fixture.EventID), exposure, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("exposure: status = %d", rec.Code)
+	}
+	click := map[string]interface{}{"device_id": "device-1", "campaign_id": first.CampaignID, "creative_id": first.CreativeID}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/sponsors/%d/click", fixture.EventID, first.ID), click, nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("click: status = %d", rec.Code)
+	}
+
+	var analytics sponsorAnalyticsResponse
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/admin/events/%d/sponsors/analytics", fixture.EventID), nil, adminHeaders(token)), &analytics)
+	want := []database.SponsorCampaignStat{
+		{SponsorID: first.ID, SponsorName: "Sponsor 1", CampaignID: featured.ID, CampaignName: "Derby", CreativeID: creative.ID, CreativeName: "Banner", Seen: 1, Clicks: 1},
+		{SponsorID: sponsorIDs[3], SponsorName: "Sponsor 4", Seen: 1},
+	}
+	if len(analytics.Campaigns) != len(want) || analytics.Campaigns[0] != want[0] || analytics.Campaigns[1] != want[1] {
+		t.Fatalf("campaign stats = %+v, want %+v", analytics.Campaigns, want)
+	}
+
+	// Once its only campaign is deleted, the sponsor is shown again with its own logo
+	if rec := h.do(http.MethodDelete, fmt.Sprintf("/admin/sponsor-campaigns/%d", featured.ID), nil, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete campaign: status = %d", rec.Code)
+	}
+	h.decode(h.do(http.MethodGet, fmt.Sprintf("/sponsors?event_id=%d", fixture.EventID), nil, nil), &rotation)
+	if len(rotation) != 4 || rotation[0].ID != sponsorIDs[0] || rotation[0].CampaignID != 0 || rotation[0].LogoData != "logo-sponsor" {
+		t.Fatalf("rotation after delete = %+v", rotation)
+	}
+}
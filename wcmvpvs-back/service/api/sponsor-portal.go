@@ -0,0 +1,239 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+type sponsorAccountResponse struct {
+	ID        int    `json:"id"`
+	SponsorID int    `json:"sponsor_id"`
+	Username  string `json:"username"`
+	CreatedAt string `json:"created_at"`
+}
+
+// wrapSponsor authenticates the requests of the sponsor portal. Its sessions are kept apart from the admin ones, so a
+// sponsor token never opens the admin endpoints.
+func (rt *_router) wrapSponsor(fn httpRouterHandler) http.HandlerFunc {
+	return rt.wrap(func(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+		token := parseBearerToken(r.Header.Get("Authorization"))
+		session, ok := rt.getSponsorSession(token)
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		ctx.SponsorID = session.SponsorID
+		ctx.SponsorUsername = session.Username
+		ctx.Logger = ctx.Logger.WithField("sponsor_id", session.SponsorID)
+
+		fn(w, r, ctx)
+	})
+}
+
+func (rt *_router) getSponsorSession(token string) (sponsorSession, bool) {
+	if token == "" {
+		return sponsorSession{}, false
+	}
+
+	rt.sponsorSessionsMu.Lock()
+	defer rt.sponsorSessionsMu.Unlock()
+	session, ok := rt.sponsorSessions[token]
+	if !ok {
+		return sponsorSession{}, false
+	}
+	if globaltime.Now().After(session.ExpiresAt) {
+		delete(rt.sponsorSessions, token)
+		return sponsorSession{}, false
+	}
+	session.ExpiresAt = globaltime.Now().Add(rt.sessionTimeout)
+	rt.sponsorSessions[token] = session
+	return session, true
+}
+
+// revokeSponsorSessions ends the sessions of the deleted accounts: those of the account, or of every account of the
+// sponsor when accountID is zero.
+func (rt *_router) revokeSponsorSessions(sponsorID, accountID int) {
+	rt.sponsorSessionsMu.Lock()
+	defer rt.sponsorSessionsMu.Unlock()
+	for token, session := range rt.sponsorSessions {
+		if (accountID > 0 && session.AccountID == accountID) || (accountID == 0 && session.SponsorID == sponsorID) {
+			delete(rt.sponsorSessions, token)
+		}
+	}
+}
+
+func (rt *_router) sponsorLogin(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	var payload struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Username == "" || payload.Password == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	account, err := rt.db.GetSponsorAccountByUsername(payload.Username)
+	if errors.Is(err, sql.ErrNoRows) {
+		ctx.Logger.WithField("username", payload.Username).Warn("sponsor login failed: user not found")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot retrieve sponsor account")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !adminPasswordMatches(account.PasswordHash, payload.Password) {
+		ctx.Logger.WithField("username", payload.Username).Warn("sponsor login failed: wrong password")
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	sponsor, err := rt.db.GetSponsor(account.SponsorID)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("sponsor_id", account.SponsorID).Error("cannot load sponsor of the account")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	token, err := generateSessionToken()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot create sponsor session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.sponsorSessionsMu.Lock()
+	rt.sponsorSessions[token] = sponsorSession{
+		AccountID: account.ID,
+		SponsorID: account.SponsorID,
+		Username:  account.Username,
+		ExpiresAt: globaltime.Now().Add(rt.sessionTimeout),
+	}
+	rt.sponsorSessionsMu.Unlock()
+
+	_ = writeJSON(w, http.StatusOK, struct {
+		Token       string `json:"token"`
+		Username    string `json:"username"`
+		SponsorID   int    `json:"sponsor_id"`
+		SponsorName string `json:"sponsor_name"`
+	}{Token: token, Username: account.Username, SponsorID: sponsor.ID, SponsorName: sponsor.Name})
+	ctx.Logger.WithFields(map[string]interface{}{"username": account.Username, "sponsor_id": sponsor.ID}).Info("sponsor logged in")
+}
+
+// getSponsorProfile returns the sponsor of the logged account.
+func (rt *_router) getSponsorProfile(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsor, err := rt.db.GetSponsor(ctx.SponsorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load sponsor profile")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	logoURL := ""
+	if sponsor.LogoAsset != "" {
+		logoURL = sponsorAssetURLs(sponsor.LogoAsset)["medium"]
+	}
+	_ = writeJSON(w, http.StatusOK, struct {
+		SponsorID   int    `json:"sponsor_id"`
+		SponsorName string `json:"sponsor_name"`
+		Username    string `json:"username"`
+		LogoURL     string `json:"logo_url,omitempty"`
+	}{SponsorID: sponsor.ID, SponsorName: sponsor.Name, Username: ctx.SponsorUsername, LogoURL: logoURL})
+}
+
+func (rt *_router) listSponsorAccounts(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	accounts, err := rt.db.ListSponsorAccounts(sponsorID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list sponsor accounts")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response := make([]sponsorAccountResponse, 0, len(accounts))
+	for _, account := range accounts {
+		response = append(response, sponsorAccountResponse{ID: account.ID, SponsorID: account.SponsorID, Username: account.Username, CreatedAt: account.CreatedAt})
+	}
+	_ = writeJSON(w, http.StatusOK, response)
+}
+
+func (rt *_router) createSponsorAccount(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var payload struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(payload.Password) == "" {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Username e password sono obbligatori.")
+		return
+	}
+
+	account := database.SponsorAccount{SponsorID: sponsorID, Username: payload.Username, PasswordHash: hashAdminPassword(payload.Password)}
+	id, err := rt.db.CreateSponsorAccount(account, globaltime.Now())
+	switch {
+	case errors.Is(err, database.ErrInvalidSponsorAccount):
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Username e password sono obbligatori.")
+		return
+	case errors.Is(err, database.ErrSponsorAccountExists):
+		_ = writeJSONMessage(w, http.StatusConflict, "Username già in uso.")
+		return
+	case errors.Is(err, sql.ErrNoRows):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		ctx.Logger.WithError(err).Error("cannot create sponsor account")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	_ = writeJSON(w, http.StatusCreated, struct {
+		ID int `json:"id"`
+	}{ID: id})
+	ctx.Logger.WithFields(map[string]interface{}{"sponsor_id": sponsorID, "account_id": id, "admin": ctx.AdminUsername}).Info("sponsor account created")
+}
+
+func (rt *_router) deleteSponsorAccount(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	accountID, err := strconv.Atoi(chi.URLParam(r, "accountId"))
+	if err != nil || accountID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if err := rt.db.DeleteSponsorAccount(accountID); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete sponsor account")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.revokeSponsorSessions(0, accountID)
+
+	w.WriteHeader(http.StatusNoContent)
+	ctx.Logger.WithFields(map[string]interface{}{"account_id": accountID, "admin": ctx.AdminUsername}).Info("sponsor account deleted")
+}
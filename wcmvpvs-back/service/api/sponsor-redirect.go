@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// sponsorClickDedupWindow is how long the clicks of a device on the same sponsor count as one
+	sponsorClickDedupWindow = 10 * time.Second
+
+	// sponsorClickPruneInterval is how often the clicks older than sponsorClickDedupWindow are forgotten
+	sponsorClickPruneInterval = time.Minute
+)
+
+// crawlerUserAgents are fragments of the user agents of crawlers, link previews and scripts, whose clicks are not
+// counted.
+var crawlerUserAgents = []string{
+	"bot", "crawler", "spider", "slurp", "facebookexternalhit", "facebookcatalog", "whatsapp", "telegram", "skypeuripreview",
+	"embedly", "quora link preview", "bitlybot", "vkshare", "pinterest", "headlesschrome", "lighthouse", "preview",
+	"curl/", "wget/", "python-requests", "python-urllib", "go-http-client", "okhttp", "java/", "libwww-perl",
+}
+
+// SponsorUTM are the UTM parameters appended to the sponsor links by the click redirect. Empty values are not
+// appended, and parameters already in a link are kept. `{event_id}` in Campaign is replaced with the event ID.
+type SponsorUTM struct {
+	Source   string
+	Medium   string
+	Campaign string
+}
+
+func isCrawlerUserAgent(userAgent string) bool {
+	normalized := strings.ToLower(userAgent)
+	for _, fragment := range crawlerUserAgents {
+		if strings.Contains(normalized, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// countSponsorClick records the click unless it comes from a crawler or repeats a click of the same device on the same
+// sponsor within sponsorClickDedupWindow. It tells whether the click was counted.
+func (rt *_router) countSponsorClick(r *http.Request, ctx reqcontext.RequestContext, eventID int, impression database.SponsorImpression, deviceID string) bool {
+	if isCrawlerUserAgent(r.UserAgent()) {
+		ctx.Logger.WithField("user_agent", r.UserAgent()).Debug("sponsor click from crawler ignored")
+		return false
+	}
+
+	now := globaltime.Now()
+	if deviceID != "" {
+		key := fmt.Sprintf("%d:%d:%s", eventID, impression.SponsorID, deviceID)
+
+		rt.sponsorClickMu.Lock()
+		at, seen := rt.sponsorClickSeen[key]
+		duplicate := seen && now.Sub(at) < sponsorClickDedupWindow
+		if !duplicate {
+			rt.sponsorClickSeen[key] = now
+		}
+		rt.sponsorClickMu.Unlock()
+
+		if duplicate {
+			ctx.Logger.WithField("sponsor_id", impression.SponsorID).Debug("duplicate sponsor click ignored")
+			return false
+		}
+	}
+
+	click := database.SponsorClickRecord{EventID: eventID, Impression: impression, DeviceID: deviceID, At: now}
+	if !rt.telemetry.enqueue(database.SponsorTelemetryBatch{Clicks: []database.SponsorClickRecord{click}}) {
+		ctx.Logger.Warn("sponsor telemetry buffer full, click dropped")
+		return false
+	}
+	return true
+}
+
+// pruneSponsorClicks forgets the clicks past sponsorClickDedupWindow, which no longer make a new click a duplicate.
+func (rt *_router) pruneSponsorClicks() error {
+	now := globaltime.Now()
+	rt.sponsorClickMu.Lock()
+	defer rt.sponsorClickMu.Unlock()
+	for key, at := range rt.sponsorClickSeen {
+		if now.Sub(at) >= sponsorClickDedupWindow {
+			delete(rt.sponsorClickSeen, key)
+		}
+	}
+	return nil
+}
+
+// redirectSponsorClick counts a click on a sponsor of the event and sends the fan to the link of the sponsor, or of
+// the creative in `creative_id`, tagged with the UTM parameters. Crawlers are redirected without counting the click.
+func (rt *_router) redirectSponsorClick(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	eventID, err := strconv.Atoi(chi.URLParam(r, "eventId"))
+	if err != nil || eventID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "sponsorId"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	sponsor, err := rt.db.GetSponsor(sponsorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load sponsor for click redirect")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	impression := database.SponsorImpression{SponsorID: sponsorID}
+	link := sponsor.LinkURL
+	query := r.URL.Query()
+	if campaignID, _ := strconv.Atoi(query.Get("campaign_id")); campaignID > 0 {
+		campaign, err := rt.db.GetSponsorCampaign(campaignID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			ctx.Logger.WithError(err).Warn("cannot load sponsor campaign for click redirect")
+		}
+		if err == nil && campaign.SponsorID == sponsorID {
+			impression.CampaignID = campaignID
+			creativeID, _ := strconv.Atoi(query.Get("creative_id"))
+			for _, creative := range campaign.Creatives {
+				if creativeID > 0 && creative.ID == creativeID {
+					impression.CreativeID = creativeID
+					if creative.LinkURL != "" {
+						link = creative.LinkURL
+					}
+				}
+			}
+		}
+	}
+
+	target, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		ctx.Logger.WithField("sponsor_id", sponsorID).Warn("sponsor without a valid link")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rt.tagSponsorLink(target, eventID)
+
+	rt.countSponsorClick(r, ctx, eventID, impression, rt.deviceIDFromRequest(r))
+
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target.String(), http.StatusFound)
+}
+
+// tagSponsorLink appends the UTM parameters of rt.sponsorUTM to the link.
+func (rt *_router) tagSponsorLink(link *url.URL, eventID int) {
+	query := link.Query()
+	for name, value := range map[string]string{
+		"utm_source":   rt.sponsorUTM.Source,
+		"utm_medium":   rt.sponsorUTM.Medium,
+		"utm_campaign": strings.ReplaceAll(rt.sponsorUTM.Campaign, "{event_id}", strconv.Itoa(eventID)),
+	} {
+		if value = strings.TrimSpace(value); value != "" && query.Get(name) == "" {
+			query.Set(name, value)
+		}
+	}
+	link.RawQuery = query.Encode()
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSponsorClickRedirect(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	h.router.sponsorUTM = SponsorUTM{Source: "wcmvpvs", Medium: "sponsor", Campaign: "event-{event_id}"}
+
+	sponsorID, err := h.db.CreateSponsor(database.Sponsor{Name: "Shop", LogoData: "logo", LinkURL: "https://example.com/shop?ref=mvp&utm_medium=partner", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+	unlinkedID, err := h.db.CreateSponsor(database.Sponsor{Name: "No link", LogoData: "logo", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+
+	click := func(sponsorID int, userAgent string) *http.Response {
+		t.Helper()
+		path := fmt.Sprintf("/go/%d/%d?device_id=device-1", fixture.EventID, sponsorID)
+		return h.do(http.MethodGet, path, nil, map[string]string{"User-Agent": userAgent}).Result()
+	}
+	browser := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148 Safari/604.1"
+
+	resp := click(sponsorID, browser)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("redirect: status = %d", resp.StatusCode)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || location.Host != "example.com" || location.Path != "/shop" {
+		t.Fatalf("location = %q", resp.Header.Get("Location"))
+	}
+	want := url.Values{"ref": {"mvp"}, "utm_source": {"wcmvpvs"}, "utm_medium": {"partner"}, "utm_campaign": {fmt.Sprintf("event-%d", fixture.EventID)}}
+	if location.RawQuery != want.Encode() {
+		t.Fatalf("query = %q, want %q", location.RawQuery, want.Encode())
+	}
+
+	// A second tap right away and the link preview of a chat app are redirected without counting
+	click(sponsorID, browser)
+	if resp := click(sponsorID, "WhatsApp/2.23.20.0"); resp.StatusCode != http.StatusFound {
+		t.Fatalf("crawler redirect: status = %d", resp.StatusCode)
+	}
+	h.advance(sponsorClickDedupWindow + time.Second)
+	click(sponsorID, browser)
+
+	// Only the click just counted is still remembered after the pruning
+	if err := h.router.pruneSponsorClicks(); err != nil {
+		t.Fatalf("cannot prune sponsor clicks: %v", err)
+	}
+	if seen := len(h.router.sponsorClickSeen); seen != 1 {
+		t.Fatalf("remembered clicks = %d, want 1", seen)
+	}
+
+	if resp := click(unlinkedID, browser); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("sponsor without link: status = %d, want %d", resp.StatusCode, http.StatusNotFound)
+	}
+
+	h.router.telemetry.flush()
+	stats, err := h.db.GetSponsorClickStats(fixture.EventID)
+	if err != nil {
+		t.Fatalf("cannot load click stats: %v", err)
+	}
+	clicks := 0
+	for _, stat := range stats {
+		if stat.SponsorID == sponsorID {
+			clicks = stat.Clicks
+		}
+	}
+	if clicks != 2 {
+		t.Fatalf("clicks = %d, want 2", clicks)
+	}
+}
@@ -0,0 +1,356 @@
+package api
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/go-chi/chi/v5"
+)
+
+// sponsorSeasonStartMonth is the month a season starts: the events from July 2025 to June 2026 are the 2025/26 season
+const sponsorSeasonStartMonth = time.July
+
+// sponsorReportStats are the exposures, the watch time and the clicks of a sponsor. CTR is the percentage of clicks
+// over the exposures.
+type sponsorReportStats struct {
+	Seen        int     `json:"seen"`
+	Watched     int     `json:"watched"`
+	WatchTimeMs int64   `json:"watch_time_ms"`
+	Clicks      int     `json:"clicks"`
+	CTR         float64 `json:"ctr"`
+}
+
+type sponsorReportEvent struct {
+	EventID       int    `json:"event_id"`
+	Title         string `json:"title"`
+	StartDateTime string `json:"start_datetime"`
+	Season        string `json:"season"`
+	Archived      bool   `json:"archived"`
+	sponsorReportStats
+}
+
+type sponsorReportSeason struct {
+	Season string `json:"season"`
+	Events int    `json:"events"`
+	sponsorReportStats
+}
+
+// sponsorReport is the performance of a sponsor in the concluded and archived events, per event and per season.
+// Season is the season the report is limited to, empty for every season.
+type sponsorReport struct {
+	SponsorID   int                   `json:"sponsor_id"`
+	SponsorName string                `json:"sponsor_name"`
+	Season      string                `json:"season,omitempty"`
+	GeneratedAt string                `json:"generated_at"`
+	Totals      sponsorReportStats    `json:"totals"`
+	Seasons     []sponsorReportSeason `json:"seasons"`
+	Events      []sponsorReportEvent  `json:"events"`
+}
+
+func (s *sponsorReportStats) add(other sponsorReportStats) {
+	s.Seen += other.Seen
+	s.Watched += other.Watched
+	s.WatchTimeMs += other.WatchTimeMs
+	s.Clicks += other.Clicks
+	if s.Seen > 0 {
+		s.CTR = float64(s.Clicks) / float64(s.Seen) * 100
+	}
+}
+
+// sponsorSeason returns the first year of the season of the time, zero for the zero time.
+func sponsorSeason(t time.Time) int {
+	if t.IsZero() {
+		return 0
+	}
+	if t.Month() < sponsorSeasonStartMonth {
+		return t.Year() - 1
+	}
+	return t.Year()
+}
+
+// sponsorSeasonLabel formats the season starting in the year, like 2025/26. It is empty for zero.
+func sponsorSeasonLabel(year int) string {
+	if year == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%02d", year, (year+1)%100)
+}
+
+// parseSponsorSeason reads the first year of a season written as 2025, 2025/26 or 2025-26. It returns zero for an
+// empty value.
+func parseSponsorSeason(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	parts := strings.FieldsFunc(value, func(r rune) bool { return r == '/' || r == '-' })
+	if len(parts) == 0 {
+		return 0, errors.New("invalid season")
+	}
+	year, err := strconv.Atoi(parts[0])
+	if err != nil || year < 2000 || year > 2999 || len(parts) > 2 {
+		return 0, errors.New("invalid season")
+	}
+	if len(parts) == 2 && parts[1] != fmt.Sprintf("%02d", (year+1)%100) && parts[1] != strconv.Itoa(year+1) {
+		return 0, errors.New("invalid season")
+	}
+	return year, nil
+}
+
+// buildSponsorReport collects the performance of the sponsor from the concluded events and from the summaries of the
+// archived ones. Season limits it to the season starting in that year, zero for every season.
+func (rt *_router) buildSponsorReport(sponsor database.Sponsor, season int) (sponsorReport, error) {
+	report := sponsorReport{
+		SponsorID:   sponsor.ID,
+		SponsorName: sponsor.Name,
+		Season:      sponsorSeasonLabel(season),
+		GeneratedAt: globaltime.Now().UTC().Format(time.RFC3339),
+		Seasons:     []sponsorReportSeason{},
+		Events:      []sponsorReportEvent{},
+	}
+	startTimes := map[int]time.Time{}
+
+	collect := func(eventID int, title, start string, stats []database.SponsorCampaignStat, archived bool) {
+		startTime, normalizedStart := parseEventStart(start)
+		if season > 0 && sponsorSeason(startTime) != season {
+			return
+		}
+		row := sponsorReportEvent{EventID: eventID, Title: title, StartDateTime: normalizedStart, Season: sponsorSeasonLabel(sponsorSeason(startTime)), Archived: archived}
+		for _, stat := range stats {
+			if stat.SponsorID == sponsor.ID {
+				row.add(sponsorReportStats{Seen: stat.Seen, Watched: stat.Watched, WatchTimeMs: stat.WatchTimeMs, Clicks: stat.Clicks})
+			}
+		}
+		if row.Seen == 0 && row.Watched == 0 && row.Clicks == 0 {
+			return
+		}
+		report.Events = append(report.Events, row)
+		startTimes[eventID] = startTime
+	}
+
+	// The telemetry still buffered belongs to the report
+	rt.telemetry.flush()
+	events, err := rt.db.ListEvents()
+	if err != nil {
+		return report, err
+	}
+	for _, event := range events {
+		if !event.IsConcluded {
+			continue
+		}
+		stats, err := rt.db.GetSponsorCampaignStats(event.ID)
+		if err != nil {
+			return report, err
+		}
+		collect(event.ID, buildEventTitle(event), event.StartDateTime, stats, false)
+	}
+
+	archives, err := rt.db.ListEventArchives()
+	if err != nil {
+		return report, err
+	}
+	for _, archive := range archives {
+		wrapper, err := archivedHistoryEntry(archive)
+		if err != nil {
+			rt.baseLogger.WithError(err).WithField("event_id", archive.EventID).Warn("cannot decode archived history entry for sponsor report")
+			continue
+		}
+		collect(wrapper.entry.ID, wrapper.entry.Title, wrapper.entry.StartDateTime, wrapper.entry.SponsorAnalytics.Campaigns, true)
+	}
+
+	sort.SliceStable(report.Events, func(i, j int) bool {
+		a, b := startTimes[report.Events[i].EventID], startTimes[report.Events[j].EventID]
+		if a.Equal(b) {
+			return report.Events[i].EventID < report.Events[j].EventID
+		}
+		return a.Before(b)
+	})
+	for _, row := range report.Events {
+		report.Totals.add(row.sponsorReportStats)
+		last := len(report.Seasons) - 1
+		if last < 0 || report.Seasons[last].Season != row.Season {
+			report.Seasons = append(report.Seasons, sponsorReportSeason{Season: row.Season})
+			last++
+		}
+		report.Seasons[last].Events++
+		report.Seasons[last].add(row.sponsorReportStats)
+	}
+	return report, nil
+}
+
+// getAdminSponsorReport returns the report of any sponsor.
+func (rt *_router) getAdminSponsorReport(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	sponsorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || sponsorID <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	rt.writeSponsorReport(w, r, ctx, sponsorID)
+}
+
+// getSponsorPortalReport returns the report of the sponsor of the logged account.
+func (rt *_router) getSponsorPortalReport(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	rt.writeSponsorReport(w, r, ctx, ctx.SponsorID)
+}
+
+// writeSponsorReport replies with the report of the sponsor in the `format` of the query, json (default), csv or pdf,
+// limited to the `season` of the query when given.
+func (rt *_router) writeSponsorReport(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext, sponsorID int) {
+	query := r.URL.Query()
+	season, err := parseSponsorSeason(query.Get("season"))
+	if err != nil {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Stagione non valida: usa per esempio 2025 o 2025/26.")
+		return
+	}
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	if format != "" && format != "json" && format != "csv" && format != "pdf" {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Formato non supportato: usa json, csv o pdf.")
+		return
+	}
+
+	sponsor, err := rt.db.GetSponsor(sponsorID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).WithField("sponsor_id", sponsorID).Error("cannot load sponsor for report")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	report, err := rt.buildSponsorReport(sponsor, season)
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("sponsor_id", sponsorID).Error("cannot build sponsor report")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var body []byte
+	contentType := ""
+	switch format {
+	case "csv":
+		body, err = buildSponsorReportCSV(report)
+		contentType = "text/csv; charset=utf-8"
+	case "pdf":
+		body, err = renderReportPDF(assembleSponsorReportLines(report))
+		contentType = "application/pdf"
+	default:
+		_ = writeJSON(w, http.StatusOK, report)
+		return
+	}
+	if err != nil {
+		ctx.Logger.WithError(err).WithField("sponsor_id", sponsorID).Error("cannot render sponsor report")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", buildSponsorReportFilename(report, format)))
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	if _, err := w.Write(body); err != nil {
+		ctx.Logger.WithError(err).WithField("sponsor_id", sponsorID).Warn("cannot write sponsor report response")
+	}
+}
+
+// buildSponsorReportCSV writes a row per event, a row per season and a row with the totals, told apart by the first
+// column.
+func buildSponsorReportCSV(report sponsorReport) ([]byte, error) {
+	var buf bytes.Buffer
+	out := csv.NewWriter(&buf)
+	record := func(kind, season, eventID, title, start string, stats sponsorReportStats) {
+		_ = out.Write([]string{
+			kind, season, eventID, title, start,
+			strconv.Itoa(stats.Seen), strconv.Itoa(stats.Watched), strconv.FormatInt(stats.WatchTimeMs, 10), strconv.Itoa(stats.Clicks),
+			strconv.FormatFloat(stats.CTR, 'f', 2, 64),
+		})
+	}
+
+	_ = out.Write([]string{"row", "season", "event_id", "event", "start_datetime", "seen", "watched", "watch_time_ms", "clicks", "ctr"})
+	for _, row := range report.Events {
+		record("event", row.Season, strconv.Itoa(row.EventID), row.Title, row.StartDateTime, row.sponsorReportStats)
+	}
+	for _, season := range report.Seasons {
+		record("season", season.Season, "", "", "", season.sponsorReportStats)
+	}
+	record("total", report.Season, "", "", "", report.Totals)
+
+	out.Flush()
+	return buf.Bytes(), out.Error()
+}
+
+func assembleSponsorReportLines(report sponsorReport) []pdfLine {
+	lines := make([]pdfLine, 0, 40+len(report.Events)*2)
+	name := strings.TrimSpace(report.SponsorName)
+	if name == "" {
+		name = fmt.Sprintf("Sponsor #%d", report.SponsorID)
+	}
+	addParagraphLine(&lines, fmt.Sprintf("Report sponsor – %s", name), "bold", 18, 0, 10)
+	addParagraphLine(&lines, fmt.Sprintf("Report generato il %s", formatHistoryDateForReport(report.GeneratedAt)), "regular", 11, 0, 4)
+	if report.Season != "" {
+		addParagraphLine(&lines, fmt.Sprintf("Stagione: %s", report.Season), "regular", 11, 0, 6)
+	} else {
+		addParagraphLine(&lines, "Stagioni: tutte", "regular", 11, 0, 6)
+	}
+
+	addSectionTitle(&lines, "Riepilogo")
+	if len(report.Events) == 0 {
+		addParagraphLine(&lines, "Nessuna esposizione registrata per lo sponsor nel periodo selezionato.", "regular", 11, 0, 6)
+		return lines
+	}
+	addBulletLine(&lines, fmt.Sprintf("Eventi: %s", formatItalianNumber(len(report.Events))), 11, 4)
+	for _, bullet := range sponsorReportStatsLines(report.Totals) {
+		addBulletLine(&lines, bullet, 11, 4)
+	}
+
+	for _, season := range report.Seasons {
+		title := "Eventi senza data"
+		if season.Season != "" {
+			title = fmt.Sprintf("Stagione %s", season.Season)
+		}
+		addSectionTitle(&lines, title)
+		addParagraphLine(&lines, fmt.Sprintf("%s eventi • %s", formatItalianNumber(season.Events), strings.Join(sponsorReportStatsLines(season.sponsorReportStats), " • ")), "regular", 10.5, 0, 6)
+		for _, row := range report.Events {
+			if row.Season != season.Season {
+				continue
+			}
+			addParagraphLine(&lines, fmt.Sprintf("%s – %s", row.Title, formatHistoryDateForReport(row.StartDateTime)), "bold", 11, 0, 3)
+			addBulletLine(&lines, strings.Join(sponsorReportStatsLines(row.sponsorReportStats), " • "), 10, 5)
+		}
+	}
+
+	addParagraphLine(&lines, "Grazie per aver sostenuto la squadra con WMVP Voting System.", "regular", 10, 0, 6)
+	return lines
+}
+
+func sponsorReportStatsLines(stats sponsorReportStats) []string {
+	return []string{
+		fmt.Sprintf("Visualizzazioni: %s", formatItalianNumber(stats.Seen)),
+		fmt.Sprintf("Visioni complete: %s", formatItalianNumber(stats.Watched)),
+		fmt.Sprintf("Tempo di visione: %s", formatWatchDuration(float64(stats.WatchTimeMs))),
+		fmt.Sprintf("Click: %s", formatItalianNumber(stats.Clicks)),
+		fmt.Sprintf("CTR: %s", formatPercentage(stats.CTR)),
+	}
+}
+
+func buildSponsorReportFilename(report sponsorReport, extension string) string {
+	name := sanitizeFilenameComponent(report.SponsorName)
+	if name == "" {
+		name = fmt.Sprintf("sponsor-%d", report.SponsorID)
+	}
+	season := "tutte-le-stagioni"
+	if report.Season != "" {
+		season = strings.ReplaceAll(report.Season, "/", "-")
+	}
+	return fmt.Sprintf("%s_%s_report.%s", name, season, extension)
+}
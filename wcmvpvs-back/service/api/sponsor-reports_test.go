@@ -0,0 +1,128 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+func TestSponsorPortalReport(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	superadmin := h.createAdmin("root", "superadmin")
+
+	events, err := h.db.ListEvents()
+	if err != nil || len(events) != 1 {
+		t.Fatalf("cannot list events: %v", err)
+	}
+	nextEventID, err := h.db.CreateEvent(database.Event{Team1ID: events[0].Team1ID, Team2ID: events[0].Team2ID, StartDateTime: "2024-10-12T18:00:00Z"})
+	if err != nil {
+		t.Fatalf("cannot create event: %v", err)
+	}
+	sponsorID, err := h.db.CreateSponsor(database.Sponsor{Name: "Caffè Sport", LogoData: "logo", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+	otherID, err := h.db.CreateSponsor(database.Sponsor{Name: "Other", LogoData: "logo", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+
+	now := globaltime.Now()
+	exposure := func(eventID, sponsorID int, device, kind string, duration int) database.SponsorExposureRecord {
+		return database.SponsorExposureRecord{EventID: eventID, Impression: database.SponsorImpression{SponsorID: sponsorID}, DeviceID: device, ExposureType: kind, DurationMs: duration, At: now}
+	}
+	batch := database.SponsorTelemetryBatch{
+		Exposures: []database.SponsorExposureRecord{
+			exposure(fixture.EventID, sponsorID, "device-1", "seen", 0),
+			exposure(fixture.EventID, sponsorID, "device-2", "seen", 0),
+			exposure(fixture.EventID, sponsorID, "device-1", "watched", 1500),
+			exposure(fixture.EventID, otherID, "device-1", "seen", 0),
+			exposure(nextEventID, sponsorID, "device-3", "seen", 0),
+		},
+		Clicks: []database.SponsorClickRecord{{EventID: fixture.EventID, Impression: database.SponsorImpression{SponsorID: sponsorID}, DeviceID: "device-1", At: now}},
+	}
+	if _, err := h.db.RecordSponsorTelemetry(batch); err != nil {
+		t.Fatalf("cannot record telemetry: %v", err)
+	}
+	for _, id := range []int{fixture.EventID, nextEventID} {
+		if err := h.db.ConcludeEvent(id); err != nil {
+			t.Fatalf("cannot conclude event: %v", err)
+		}
+	}
+	// The report of an archived event comes from its summary
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/events/%d/archive", fixture.EventID), nil, adminHeaders(superadmin)); rec.Code != http.StatusOK {
+		t.Fatalf("archive: status = %d", rec.Code)
+	}
+
+	accounts := fmt.Sprintf("/admin/sponsors/%d/accounts", sponsorID)
+	if rec := h.do(http.MethodPost, accounts, map[string]string{"username": "caffe", "password": "espresso"}, adminHeaders(superadmin)); rec.Code != http.StatusCreated {
+		t.Fatalf("create account: status = %d", rec.Code)
+	}
+	if rec := h.do(http.MethodPost, accounts, map[string]string{"username": "CAFFE", "password": "other"}, adminHeaders(superadmin)); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicate account: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if rec := h.do(http.MethodPost, "/sponsor-portal/login", map[string]string{"username": "caffe", "password": "wrong"}, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	var login struct {
+		Token     string `json:"token"`
+		SponsorID int    `json:"sponsor_id"`
+	}
+	h.decode(h.do(http.MethodPost, "/sponsor-portal/login", map[string]string{"username": "caffe", "password": "espresso"}, nil), &login)
+	if login.Token == "" || login.SponsorID != sponsorID {
+		t.Fatalf("login = %+v", login)
+	}
+	sponsorHeaders := adminHeaders(login.Token)
+	if rec := h.do(http.MethodGet, "/admin/sponsors", nil, sponsorHeaders); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("sponsor token on admin endpoint: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	var report sponsorReport
+	h.decode(h.do(http.MethodGet, "/sponsor-portal/report", nil, sponsorHeaders), &report)
+	if len(report.Events) != 2 || len(report.Seasons) != 2 || report.Seasons[0].Season != "2023/24" || report.Seasons[1].Season != "2024/25" {
+		t.Fatalf("report = %+v", report)
+	}
+	archived := report.Events[0]
+	if archived.EventID != fixture.EventID || !archived.Archived || archived.Seen != 2 || archived.Watched != 1 || archived.WatchTimeMs != 1500 || archived.Clicks != 1 || archived.CTR != 50 {
+		t.Fatalf("archived event = %+v", archived)
+	}
+	if report.Totals.Seen != 3 || report.Totals.Clicks != 1 {
+		t.Fatalf("totals = %+v", report.Totals)
+	}
+
+	h.decode(h.do(http.MethodGet, "/sponsor-portal/report?season=2024/25", nil, sponsorHeaders), &report)
+	if len(report.Events) != 1 || report.Events[0].EventID != nextEventID || report.Season != "2024/25" {
+		t.Fatalf("season report = %+v", report)
+	}
+	if rec := h.do(http.MethodGet, "/sponsor-portal/report?season=autunno", nil, sponsorHeaders); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid season: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	csvReport := h.do(http.MethodGet, "/sponsor-portal/report?format=csv", nil, sponsorHeaders)
+	if lines := strings.Split(strings.TrimSpace(csvReport.Body.String()), "\n"); csvReport.Code != http.StatusOK || len(lines) != 6 || !strings.HasPrefix(lines[5], "total,") {
+		t.Fatalf("csv report: status = %d, body %q", csvReport.Code, csvReport.Body.String())
+	}
+	pdfReport := h.do(http.MethodGet, fmt.Sprintf("/admin/sponsors/%d/report?format=pdf", sponsorID), nil, adminHeaders(superadmin))
+	if pdfReport.Code != http.StatusOK || !bytes.HasPrefix(pdfReport.Body.Bytes(), []byte("%PDF")) {
+		t.Fatalf("pdf report: status = %d", pdfReport.Code)
+	}
+
+	var list []sponsorAccountResponse
+	h.decode(h.do(http.MethodGet, accounts, nil, adminHeaders(superadmin)), &list)
+	if len(list) != 1 {
+		t.Fatalf("accounts = %+v", list)
+	}
+	if rec := h.do(http.MethodDelete, fmt.Sprintf("/admin/sponsor-accounts/%d", list[0].ID), nil, adminHeaders(superadmin)); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete account: status = %d", rec.Code)
+	}
+	if rec := h.do(http.MethodGet, "/sponsor-portal/report", nil, sponsorHeaders); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("report after account deletion: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
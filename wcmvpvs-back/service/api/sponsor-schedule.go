@@ -0,0 +1,180 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+const (
+	// sponsorPlanMaxAge is how long the exposures of a plan are accepted
+	sponsorPlanMaxAge = 24 * time.Hour
+
+	sponsorPlanPruneInterval = time.Hour
+)
+
+// sponsorScreens are the screens a schedule fills, in order. The vote confirmation shows only the campaigns exclusive
+// to it.
+var sponsorScreens = []string{database.SponsorScreenMain, database.SponsorScreenVoteConfirmation}
+
+// sponsorSchedule is the sponsor plan issued to a device: what to show on each screen and for how long. The exposures
+// the device sends with PlanID are checked against it.
+type sponsorSchedule struct {
+	PlanID   string                `json:"plan_id"`
+	EventID  int                   `json:"event_id"`
+	IssuedAt string                `json:"issued_at"`
+	Slots    []sponsorScheduleSlot `json:"slots"`
+}
+
+// sponsorScheduleSlot is a sponsor of the schedule with its screen and the time it must stay visible to count as
+// watched, zero for no minimum.
+type sponsorScheduleSlot struct {
+	publicSponsor
+	Screen     string `json:"screen"`
+	MinDwellMs int    `json:"min_dwell_ms"`
+}
+
+// writeSponsorSchedule issues a new plan to the device. The plan is written with the sponsor telemetry, so that the
+// devices loading the sponsors do not wait for the database.
+func (rt *_router) writeSponsorSchedule(w http.ResponseWriter, ctx reqcontext.RequestContext, eventID int, deviceID string, placements []database.SponsorPlacement) {
+	counts, err := rt.sponsorPlanCounts(eventID, deviceID)
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot count the issued sponsor plans")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	planID, err := generateSessionToken()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot create sponsor plan id")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	now := globaltime.Now()
+	schedule := sponsorSchedule{PlanID: planID, EventID: eventID, Slots: buildSponsorSchedule(placements, counts)}
+
+	schedule.IssuedAt = now.UTC().Format(time.RFC3339)
+
+	plan := database.SponsorPlan{ID: planID, EventID: eventID, DeviceID: deviceID, IssuedAt: schedule.IssuedAt, Slots: make([]database.SponsorPlanSlot, 0, len(schedule.Slots))}
+	for _, slot := range schedule.Slots {
+		plan.Slots = append(plan.Slots, database.SponsorPlanSlot{
+			SponsorImpression: database.SponsorImpression{SponsorID: slot.ID, CampaignID: slot.CampaignID, CreativeID: slot.CreativeID},
+			Screen:            slot.Screen,
+			Position:          slot.Position,
+			MinDwellMs:        slot.MinDwellMs,
+		})
+	}
+	if !rt.enqueueTelemetry(w, ctx, database.SponsorTelemetryBatch{Plans: []database.SponsorPlan{plan}}) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = writeJSON(w, http.StatusOK, schedule)
+	ctx.Logger.WithField("event_id", eventID).WithField("slots", len(schedule.Slots)).Info("issued sponsor schedule")
+}
+
+// sponsorPlanCounts returns the counts of the plans issued for the event and for the device, including the plans not
+// yet written. The buffer is read first: a plan written in the meantime may be counted twice, which only makes the
+// caps stricter for one schedule.
+func (rt *_router) sponsorPlanCounts(eventID int, deviceID string) (database.SponsorPlanCounts, error) {
+	pending := database.SponsorPlanCounts{ByCampaign: make(map[int]int), DeviceCampaign: make(map[int]int)}
+	rt.telemetry.addPendingPlanCounts(&pending, eventID, deviceID)
+	counts, err := rt.db.GetSponsorPlanCounts(eventID, deviceID)
+	if err != nil {
+		return database.SponsorPlanCounts{}, err
+	}
+	counts.Plans += pending.Plans
+	counts.DevicePlans += pending.DevicePlans
+	for campaignID, plans := range pending.ByCampaign {
+		counts.ByCampaign[campaignID] += plans
+	}
+	for campaignID, plans := range pending.DeviceCampaign {
+		counts.DeviceCampaign[campaignID] += plans
+	}
+	return counts, nil
+}
+
+// sponsorPlan returns the plan with the given id, from the telemetry buffer while it is not yet written, or
+// sql.ErrNoRows.
+func (rt *_router) sponsorPlan(id string) (database.SponsorPlan, error) {
+	if plan, ok := rt.telemetry.plan(id); ok {
+		return plan, nil
+	}
+	return rt.db.GetSponsorPlan(id)
+}
+
+// pruneSponsorPlans deletes the plans older than sponsorPlanMaxAge: the devices ask for a new one at every visit.
+func (rt *_router) pruneSponsorPlans() error {
+	pruned, err := rt.db.PruneSponsorPlans(globaltime.Now().Add(-sponsorPlanMaxAge))
+	if pruned > 0 {
+		rt.baseLogger.WithField("plans", pruned).Info("pruned sponsor plans")
+	}
+	return err
+}
+
+// buildSponsorSchedule applies the exposure rules of the campaigns to the placements. A campaign is left out once the
+// device has reached its views, or while its share of the plans of the event is at its share of voice. The campaigns
+// exclusive to a screen fill it alone, the one with the highest priority winning; the others rotate on the main
+// screen as in sponsorRotation.
+func buildSponsorSchedule(placements []database.SponsorPlacement, counts database.SponsorPlanCounts) []sponsorScheduleSlot {
+	campaigns := make(map[int]*database.SponsorCampaign)
+	regular := make([]database.SponsorPlacement, 0, len(placements))
+	exclusive := make(map[string][]database.SponsorPlacement)
+	for _, placement := range placements {
+		campaign := placement.Campaign
+		if campaign == nil {
+			regular = append(regular, placement)
+			continue
+		}
+		if campaign.MaxViewsPerDevice > 0 && counts.DeviceCampaign[campaign.ID] >= campaign.MaxViewsPerDevice {
+			continue
+		}
+		if campaign.ShareOfVoice > 0 && counts.ByCampaign[campaign.ID]*100 >= campaign.ShareOfVoice*(counts.Plans+1) {
+			continue
+		}
+		campaigns[campaign.ID] = campaign
+		if campaign.ExclusiveScreen != "" {
+			exclusive[campaign.ExclusiveScreen] = append(exclusive[campaign.ExclusiveScreen], placement)
+		} else {
+			regular = append(regular, placement)
+		}
+	}
+
+	slots := []sponsorScheduleSlot{}
+	for _, screen := range sponsorScreens {
+		var rotation []publicSponsor
+		if candidates := exclusive[screen]; len(candidates) > 0 {
+			rotation = sponsorRotation(candidates)[:1]
+		} else if screen == database.SponsorScreenMain {
+			rotation = sponsorRotation(regular)
+		}
+		for _, sponsor := range rotation {
+			slot := sponsorScheduleSlot{publicSponsor: sponsor, Screen: screen}
+			if campaign, ok := campaigns[sponsor.CampaignID]; ok {
+				slot.MinDwellMs = campaign.MinDwellMs
+			}
+			slots = append(slots, slot)
+		}
+	}
+	return slots
+}
+
+// plannedImpressions keeps the impressions of an exposure that the plan shows on the screen, dropping the watched
+// ones that stayed on screen less than the minimum dwell time of their slot.
+func plannedImpressions(plan database.SponsorPlan, screen, exposureType string, durationMs int, impressions []database.SponsorImpression) []database.SponsorImpression {
+	planned := make([]database.SponsorImpression, 0, len(impressions))
+	for _, impression := range impressions {
+		slot, ok := plan.SlotFor(screen, impression)
+		if !ok {
+			continue
+		}
+		if exposureType == "watched" && durationMs < slot.MinDwellMs {
+			continue
+		}
+		planned = append(planned, impression)
+	}
+	return planned
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSponsorSchedule(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	var sponsorIDs []int
+	for _, name := range []string{"Capped", "Half", "Title"} {
+		id, err := h.db.CreateSponsor(database.Sponsor{Name: name, LogoData: "logo", IsActive: true})
+		if err != nil {
+			t.Fatalf("cannot create sponsor: %v", err)
+		}
+		sponsorIDs = append(sponsorIDs, id)
+	}
+	createCampaign := func(sponsorID int, body map[string]interface{}) int {
+		t.Helper()
+		rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsors/%d/campaigns", sponsorID), body, adminHeaders(token))
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create campaign %v: status = %d (%s)", body, rec.Code, rec.Body.String())
+		}
+		var campaign database.SponsorCampaign
+		h.decode(rec, &campaign)
+		return campaign.ID
+	}
+	capped := createCampaign(sponsorIDs[0], map[string]interface{}{"max_views_per_device": 1, "min_dwell_ms": 3000})
+	half := createCampaign(sponsorIDs[1], map[string]interface{}{"share_of_voice": 50})
+	title := createCampaign(sponsorIDs[2], map[string]interface{}{"exclusive_screen": database.SponsorScreenVoteConfirmation, "priority": 5})
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/admin/sponsors/%d/campaigns", sponsorIDs[0]), map[string]interface{}{"share_of_voice": 150}, adminHeaders(token)); rec.Code != http.StatusBadRequest {
+		t.Fatalf("share of voice over 100: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	schedule := func(device string) (sponsorSchedule, map[string][]int) {
+		t.Helper()
+		var s sponsorSchedule
+		h.decode(h.do(http.MethodGet, fmt.Sprintf("/sponsors?event_id=%d&device_id=%s", fixture.EventID, device), nil, nil), &s)
+		campaigns := make(map[string][]int)
+		for _, slot := range s.Slots {
+			campaigns[slot.Screen] = append(campaigns[slot.Screen], slot.CampaignID)
+		}
+		return s, campaigns
+	}
+
+	first, campaigns := schedule("device-1")
+	if first.PlanID == "" || len(campaigns[database.SponsorScreenMain]) != 2 || len(campaigns[database.SponsorScreenVoteConfirmation]) != 1 || campaigns[database.SponsorScreenVoteConfirmation][0] != title {
+		t.Fatalf("first schedule = %+v", first)
+	}
+	// The device has seen its capped campaign, and the half share is used up by the first plan
+	if _, campaigns := schedule("device-1"); len(campaigns[database.SponsorScreenMain]) != 0 {
+		t.Fatalf("second schedule of device-1 = %v, want no campaign on the main screen", campaigns)
+	}
+	if _, campaigns := schedule("device-2"); len(campaigns[database.SponsorScreenMain]) != 2 {
+		t.Fatalf("schedule of device-2 = %v, want both campaigns on the main screen", campaigns)
+	}
+	// The plans are written with the telemetry, and counted the same once written
+	h.router.telemetry.flush()
+	if plan, err := h.db.GetSponsorPlan(first.PlanID); err != nil || plan.DeviceID != "device-1" || len(plan.Slots) != 3 {
+		t.Fatalf("stored plan = %+v (%v)", plan, err)
+	}
+	if counts, err := h.db.GetSponsorPlanCounts(fixture.EventID, "device-1"); err != nil || counts.Plans != 3 || counts.DevicePlans != 2 || counts.DeviceCampaign[capped] != 1 || counts.ByCampaign[half] != 2 {
+		t.Fatalf("stored plan counts = %+v (%v)", counts, err)
+	}
+
+	exposures := fmt.Sprintf("/events/%d/sponsors/exposures", fixture.EventID)
+	impression := func(sponsorID, campaignID int) map[string]int {
+		return map[string]int{"sponsor_id": sponsorID, "campaign_id": campaignID}
+	}
+	for _, payload := range []map[string]interface{}{
+		// The title sponsor is not planned on the main screen
+		{"device_id": "device-1", "plan_id": first.PlanID, "type": "seen", "impressions": []map[string]int{impression(sponsorIDs[0], capped), impression(sponsorIDs[1], half), impression(sponsorIDs[2], title)}},
+		{"device_id": "device-1", "plan_id": first.PlanID, "screen": database.SponsorScreenVoteConfirmation, "type": "seen", "impressions": []map[string]int{impression(sponsorIDs[2], title)}},
+		// The capped campaign needs three seconds on screen to count as watched
+		{"device_id": "device-1", "plan_id": first.PlanID, "type": "watched", "duration_ms": 2000, "impressions": []map[string]int{impression(sponsorIDs[0], capped), impression(sponsorIDs[1], half)}},
+		// Another device cannot use the plan
+		{"device_id": "device-3", "plan_id": first.PlanID, "type": "seen", "impressions": []map[string]int{impression(sponsorIDs[0], capped)}},
+		// A device with a plan cannot leave it out
+		{"device_id": "device-2", "type": "seen", "impressions": []map[string]int{impression(sponsorIDs[0], capped)}},
+	} {
+		if rec := h.do(http.MethodPost, exposures, payload, nil); rec.Code != http.StatusNoContent {
+			t.Fatalf("exposure %v: status = %d", payload, rec.Code)
+		}
+	}
+	h.router.telemetry.flush()
+
+	stats, err := h.db.GetSponsorCampaignStats(fixture.EventID)
+	if err != nil {
+		t.Fatalf("cannot read campaign stats: %v", err)
+	}
+	got := make(map[int][2]int)
+	for _, stat := range stats {
+		got[stat.CampaignID] = [2]int{stat.Seen, stat.Watched}
+	}
+	want := map[int][2]int{capped: {1, 0}, half: {1, 1}, title: {1, 0}}
+	if len(got) != len(want) || got[capped] != want[capped] || got[half] != want[half] || got[title] != want[title] {
+		t.Fatalf("campaign stats = %v, want %v", got, want)
+	}
+
+	// Old plans are pruned, but still count for the caps
+	h.advance(sponsorPlanMaxAge + time.Minute)
+	if err := h.router.pruneSponsorPlans(); err != nil {
+		t.Fatalf("cannot prune sponsor plans: %v", err)
+	}
+	if _, err := h.db.GetSponsorPlan(first.PlanID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("plan after pruning: err = %v, want %v", err, sql.ErrNoRows)
+	}
+	_, campaigns = schedule("device-1")
+	for _, campaignID := range campaigns[database.SponsorScreenMain] {
+		if campaignID == capped {
+			t.Fatalf("schedule of device-1 after pruning = %v, want the capped campaign left out", campaigns)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// telemetryFlushInterval is the longest time a sponsor session, exposure or click waits before being written
+	telemetryFlushInterval = time.Second
+
+	// telemetryFlushSize is the number of pending records that triggers a flush before the interval
+	telemetryFlushSize = 500
+
+	// telemetryMaxPending is the number of pending records above which new telemetry is refused
+	telemetryMaxPending = 20000
+)
+
+// telemetryMetrics are the counters of the sponsor telemetry pipeline since the start of the server. Coalesced counts
+// the sessions merged into one already waiting; Dropped the records refused because the buffer was full and those lost
+// in a failed flush; Skipped the ones rejected by the database, like the exposures of a sponsor deleted in the meantime.
+type telemetryMetrics struct {
+	Pending     int    `json:"pending"`
+	Accepted    int64  `json:"accepted"`
+	Coalesced   int64  `json:"coalesced"`
+	Flushed     int64  `json:"flushed"`
+	Skipped     int64  `json:"skipped"`
+	Dropped     int64  `json:"dropped"`
+	Batches     int64  `json:"batches"`
+	Failures    int64  `json:"failures"`
+	LastFlushAt string `json:"last_flush_at,omitempty"`
+	LastError   string `json:"last_error,omitempty"`
+}
+
+// sponsorTelemetry buffers the sponsor sessions, exposures and clicks and writes them in batches, so that a full arena
+// does not compete with the votes for the database with one transaction per beacon. The sessions of the same device
+// and event waiting in the buffer are coalesced into one. The sponsor plans issued to the devices are buffered as
+// well, and stay readable from the buffer until they are written.
+type sponsorTelemetry struct {
+	db     database.AppDatabase
+	logger logrus.FieldLogger
+
+	flushSize  int
+	maxPending int
+
+	mu       sync.Mutex
+	pending  database.SponsorTelemetryBatch
+	sessions map[string]int // index in pending.Sessions, by event and device
+	metrics  telemetryMetrics
+	closed   bool
+
+	// plans are the plans in pending, flushing those of the batch being written
+	plans    pendingPlans
+	flushing pendingPlans
+
+	// flushMu keeps the flushes in order
+	flushMu sync.Mutex
+	kick    chan struct{}
+}
+
+func newSponsorTelemetry(db database.AppDatabase, logger logrus.FieldLogger) *sponsorTelemetry {
+	return &sponsorTelemetry{
+		db:         db,
+		logger:     logger.WithField("component", "sponsor telemetry"),
+		flushSize:  telemetryFlushSize,
+		maxPending: telemetryMaxPending,
+		sessions:   map[string]int{},
+		plans:      newPendingPlans(),
+		flushing:   newPendingPlans(),
+		kick:       make(chan struct{}, 1),
+	}
+}
+
+// pendingPlans are the sponsor plans not yet written, with what they add to the stored plan counts.
+type pendingPlans struct {
+	byID    map[string]database.SponsorPlan
+	events  map[int]*database.SponsorPlanCounts // Plans and ByCampaign, by event
+	devices map[string]map[int]int              // plans by campaign, 0 for all of them, by event and device
+}
+
+func newPendingPlans() pendingPlans {
+	return pendingPlans{
+		byID:    map[string]database.SponsorPlan{},
+		events:  map[int]*database.SponsorPlanCounts{},
+		devices: map[string]map[int]int{},
+	}
+}
+
+func (p pendingPlans) add(plan database.SponsorPlan) {
+	p.byID[plan.ID] = plan
+	event, ok := p.events[plan.EventID]
+	if !ok {
+		event = &database.SponsorPlanCounts{ByCampaign: map[int]int{}}
+		p.events[plan.EventID] = event
+	}
+	key := fmt.Sprintf("%d:%s", plan.EventID, plan.DeviceID)
+	device, ok := p.devices[key]
+	if !ok {
+		device = map[int]int{}
+		p.devices[key] = device
+	}
+
+	event.Plans++
+	device[0]++
+	counted := map[int]bool{}
+	for _, slot := range plan.Slots {
+		if slot.CampaignID > 0 && !counted[slot.CampaignID] {
+			counted[slot.CampaignID] = true
+			event.ByCampaign[slot.CampaignID]++
+			device[slot.CampaignID]++
+		}
+	}
+}
+
+// addTo adds the pending plans of the event and of the device to counts.
+func (p pendingPlans) addTo(counts *database.SponsorPlanCounts, eventID int, deviceID string) {
+	if event, ok := p.events[eventID]; ok {
+		counts.Plans += event.Plans
+		for campaignID, plans := range event.ByCampaign {
+			counts.ByCampaign[campaignID] += plans
+		}
+	}
+	for campaignID, plans := range p.devices[fmt.Sprintf("%d:%s", eventID, deviceID)] {
+		if campaignID == 0 {
+			counts.DevicePlans += plans
+		} else {
+			counts.DeviceCampaign[campaignID] += plans
+		}
+	}
+}
+
+// plan returns the plan with the given id while it waits to be written.
+func (t *sponsorTelemetry) plan(id string) (database.SponsorPlan, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if plan, ok := t.plans.byID[id]; ok {
+		return plan, true
+	}
+	plan, ok := t.flushing.byID[id]
+	return plan, ok
+}
+
+// addPendingPlanCounts adds to the stored counts the plans of the event and of the device waiting to be written.
+func (t *sponsorTelemetry) addPendingPlanCounts(counts *database.SponsorPlanCounts, eventID int, deviceID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.plans.addTo(counts, eventID, deviceID)
+	t.flushing.addTo(counts, eventID, deviceID)
+}
+
+// enqueue adds the records to the buffer. It returns false, and drops the records, when the buffer is full. Once the
+// pipeline is closed the records are written right away.
+func (t *sponsorTelemetry) enqueue(batch database.SponsorTelemetryBatch) bool {
+	size := batch.Len()
+	if size == 0 {
+		return true
+	}
+
+	t.mu.Lock()
+	if t.closed {
+		t.metrics.Accepted += int64(size)
+		t.mu.Unlock()
+		t.flushMu.Lock()
+		defer t.flushMu.Unlock()
+		t.write(batch)
+		return true
+	}
+	if t.pending.Len()+size > t.maxPending {
+		t.metrics.Dropped += int64(size)
+		t.mu.Unlock()
+		return false
+	}
+
+	for _, session := range batch.Sessions {
+		key := fmt.Sprintf("%d:%s", session.EventID, session.DeviceID)
+		if i, ok := t.sessions[key]; ok {
+			t.pending.Sessions[i].At = session.At
+			t.metrics.Coalesced++
+			continue
+		}
+		t.sessions[key] = len(t.pending.Sessions)
+		t.pending.Sessions = append(t.pending.Sessions, session)
+	}
+	t.pending.Exposures = append(t.pending.Exposures, batch.Exposures...)
+	t.pending.Clicks = append(t.pending.Clicks, batch.Clicks...)
+	t.pending.Plans = append(t.pending.Plans, batch.Plans...)
+	for _, plan := range batch.Plans {
+		t.plans.add(plan)
+	}
+	t.metrics.Accepted += int64(size)
+	full := t.pending.Len() >= t.flushSize
+	t.mu.Unlock()
+
+	if full {
+		select {
+		case t.kick <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// flush writes the pending records.
+func (t *sponsorTelemetry) flush() {
+	t.flushMu.Lock()
+	defer t.flushMu.Unlock()
+
+	t.mu.Lock()
+	batch := t.pending
+	t.pending = database.SponsorTelemetryBatch{}
+	t.sessions = map[string]int{}
+	t.flushing, t.plans = t.plans, newPendingPlans()
+	t.mu.Unlock()
+
+	t.write(batch)
+
+	t.mu.Lock()
+	t.flushing = newPendingPlans()
+	t.mu.Unlock()
+}
+
+// write stores the batch and updates the metrics. Callers hold flushMu.
+func (t *sponsorTelemetry) write(batch database.SponsorTelemetryBatch) {
+	size := batch.Len()
+	if size == 0 {
+		return
+	}
+	skipped, err := t.db.RecordSponsorTelemetry(batch)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.metrics.Batches++
+	t.metrics.LastFlushAt = globaltime.Now().UTC().Format(time.RFC3339)
+	if err != nil {
+		t.metrics.Failures++
+		t.metrics.Dropped += int64(size)
+		t.metrics.LastError = err.Error()
+		t.logger.WithError(err).WithField("records", size).Error("cannot write sponsor telemetry")
+		return
+	}
+	t.metrics.Flushed += int64(size - skipped)
+	t.metrics.Skipped += int64(skipped)
+}
+
+// run flushes the buffer every telemetryFlushInterval, or when it is full, until stop is closed. Then it drains the
+// buffer and writes any later record right away.
+func (t *sponsorTelemetry) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(telemetryFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			t.mu.Lock()
+			t.closed = true
+			t.mu.Unlock()
+			t.flush()
+			t.logger.WithField("metrics", t.snapshot()).Info("sponsor telemetry drained")
+			return
+		case <-ticker.C:
+			t.flush()
+		case <-t.kick:
+			t.flush()
+		}
+	}
+}
+
+func (t *sponsorTelemetry) snapshot() telemetryMetrics {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	metrics := t.metrics
+	metrics.Pending = t.pending.Len()
+	return metrics
+}
+
+// enqueueTelemetry buffers the records, replying 503 when the buffer is full. It tells whether they were accepted.
+func (rt *_router) enqueueTelemetry(w http.ResponseWriter, ctx reqcontext.RequestContext, batch database.SponsorTelemetryBatch) bool {
+	if rt.telemetry.enqueue(batch) {
+		return true
+	}
+	ctx.Logger.WithField("records", batch.Len()).Warn("sponsor telemetry buffer full, records dropped")
+	w.Header().Set("Retry-After", "1")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	return false
+}
+
+// getTelemetryMetrics returns the counters of the sponsor telemetry pipeline.
+func (rt *_router) getTelemetryMetrics(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	_ = writeJSON(w, http.StatusOK, rt.telemetry.snapshot())
+}
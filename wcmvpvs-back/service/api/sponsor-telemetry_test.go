@@ -0,0 +1,67 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestSponsorTelemetryPipeline(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "staff")
+	sponsorID, err := h.db.CreateSponsor(database.Sponsor{Name: "Shop", LogoData: "logo", LinkURL: "https://example.com", IsActive: true})
+	if err != nil {
+		t.Fatalf("cannot create sponsor: %v", err)
+	}
+
+	post := func(path string, body interface{}) int {
+		t.Helper()
+		return h.do(http.MethodPost, fmt.Sprintf("/events/%d/sponsors/%s", fixture.EventID, path), body, nil).Code
+	}
+	for _, device := range []string{"device-1", "device-1", "device-2"} {
+		if code := post("session", map[string]string{"device_id": device}); code != http.StatusNoContent {
+			t.Fatalf("session: status = %d", code)
+		}
+	}
+	// The exposure of an unknown sponsor is skipped when the batch is written
+	exposure := map[string]interface{}{"device_id": "device-1", "type": "watched", "duration_ms": 1500, "sponsor_ids": []int{sponsorID, sponsorID + 100}}
+	if code := post("exposures", exposure); code != http.StatusNoContent {
+		t.Fatalf("exposure: status = %d", code)
+	}
+	if code := post("exposures", map[string]interface{}{"device_id": "device-1", "type": "hovered", "sponsor_ids": []int{sponsorID}}); code != http.StatusBadRequest {
+		t.Fatalf("invalid exposure type: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// A full buffer refuses the telemetry instead of growing
+	h.router.telemetry.mu.Lock()
+	h.router.telemetry.maxPending = h.router.telemetry.pending.Len()
+	h.router.telemetry.mu.Unlock()
+	if code := post("exposures", map[string]interface{}{"device_id": "device-2", "type": "seen", "sponsor_ids": []int{sponsorID}}); code != http.StatusServiceUnavailable {
+		t.Fatalf("full buffer: status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+
+	// Closing the router drains the buffer; later telemetry is written right away
+	if err := h.router.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if code := post(fmt.Sprintf("%d/click", sponsorID), map[string]string{"device_id": "device-2"}); code != http.StatusNoContent {
+		t.Fatalf("click: status = %d", code)
+	}
+
+	var metrics telemetryMetrics
+	h.decode(h.do(http.MethodGet, "/admin/sponsors/telemetry", nil, adminHeaders(token)), &metrics)
+	if metrics.Pending != 0 || metrics.Accepted != 6 || metrics.Flushed+metrics.Coalesced != 5 || metrics.Skipped != 1 || metrics.Dropped != 1 {
+		t.Fatalf("metrics = %+v", metrics)
+	}
+
+	summary, err := h.db.GetSponsorAnalytics(fixture.EventID)
+	if err != nil {
+		t.Fatalf("cannot load analytics: %v", err)
+	}
+	if summary.TotalSessions != 2 || summary.WatchedSessions != 1 || summary.TotalWatchTimeMs != 1500 || summary.TotalClicks != 1 {
+		t.Fatalf("analytics = %+v", summary)
+	}
+}
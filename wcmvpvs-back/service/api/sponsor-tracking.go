@@ -12,6 +12,7 @@ import (
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -26,6 +27,14 @@ type sponsorExposurePayload struct {
 	Type       string    `json:"type"`
 	DurationMs int       `json:"duration_ms"`
 	Timestamp  time.Time `json:"-"`
+
+	// Impressions attribute the exposure to the campaigns and the creatives returned by /sponsors
+	Impressions []database.SponsorImpression `json:"impressions"`
+
+	// PlanID and Screen tie the exposure to a slot of the schedule issued by /sponsors; the exposures of the older
+	// clients, without plan, are recorded as they come
+	PlanID string `json:"plan_id"`
+	Screen string `json:"screen"`
 }
 
 type sponsorAnalyticsResponse struct {
@@ -40,6 +49,7 @@ type sponsorAnalyticsResponse struct {
 	UniqueClickers     int                             `json:"unique_clickers"`
 	TopSponsor         *sponsorAnalyticsTopSponsor     `json:"top_sponsor,omitempty"`
 	Timeline           []sponsorAnalyticsTimelinePoint `json:"timeline"`
+	Campaigns          []database.SponsorCampaignStat  `json:"campaigns"`
 }
 
 type sponsorAnalyticsTopSponsor struct {
@@ -64,6 +74,7 @@ func buildSponsorAnalyticsResponse(summary database.SponsorAnalytics) sponsorAna
                 TotalWatchTimeMs:   summary.TotalWatchTimeMs,
                 TotalClicks:        summary.TotalClicks,
                 UniqueClickers:     summary.UniqueClickers,
+                Campaigns:          summary.Campaigns,
         }
 
         if summary.TotalSessions > 0 {
@@ -94,21 +105,25 @@ func buildSponsorAnalyticsResponse(summary database.SponsorAnalytics) sponsorAna
         return response
 }
 
-func (payload *sponsorExposurePayload) normalizedSponsorIDs() []int {
-        ids := make([]int, 0, len(payload.SponsorIDs)+len(payload.Sponsors))
-        ids = append(ids, payload.SponsorIDs...)
-        ids = append(ids, payload.Sponsors...)
-        seen := make(map[int]struct{}, len(ids))
-	normalized := make([]int, 0, len(ids))
-	for _, id := range ids {
-		if id <= 0 {
+// normalizedImpressions returns the sponsors of the payload, once each. The plain sponsor ids of the older clients are
+// recorded without campaign.
+func (payload *sponsorExposurePayload) normalizedImpressions() []database.SponsorImpression {
+	impressions := make([]database.SponsorImpression, 0, len(payload.Impressions)+len(payload.SponsorIDs)+len(payload.Sponsors))
+	impressions = append(impressions, payload.Impressions...)
+	for _, id := range append(append([]int{}, payload.SponsorIDs...), payload.Sponsors...) {
+		impressions = append(impressions, database.SponsorImpression{SponsorID: id})
+	}
+	seen := make(map[int]struct{}, len(impressions))
+	normalized := make([]database.SponsorImpression, 0, len(impressions))
+	for _, impression := range impressions {
+		if impression.SponsorID <= 0 || impression.CampaignID < 0 || impression.CreativeID < 0 {
 			continue
 		}
-		if _, ok := seen[id]; ok {
+		if _, ok := seen[impression.SponsorID]; ok {
 			continue
 		}
-		seen[id] = struct{}{}
-		normalized = append(normalized, id)
+		seen[impression.SponsorID] = struct{}{}
+		normalized = append(normalized, impression)
 	}
 	return normalized
 }
@@ -135,9 +150,10 @@ func (rt *_router) recordSponsorSessionEvent(w http.ResponseWriter, r *http.Requ
 		deviceID = rt.deviceIDFromRequest(r)
 	}
 
-	if err := rt.db.RecordSponsorSession(eventID, deviceID); err != nil {
-		if !errors.Is(err, sql.ErrNoRows) {
-			ctx.Logger.WithError(err).Warn("cannot record sponsor session")
+	if deviceID != "" {
+		session := database.SponsorSessionRecord{EventID: eventID, DeviceID: deviceID, At: globaltime.Now()}
+		if !rt.enqueueTelemetry(w, ctx, database.SponsorTelemetryBatch{Sessions: []database.SponsorSessionRecord{session}}) {
+			return
 		}
 	}
 
@@ -163,8 +179,8 @@ func (rt *_router) recordSponsorExposureEvent(w http.ResponseWriter, r *http.Req
 		}
 	}
 
-	sponsorIDs := payload.normalizedSponsorIDs()
-	if len(sponsorIDs) == 0 {
+	impressions := payload.normalizedImpressions()
+	if len(impressions) == 0 {
 		w.WriteHeader(http.StatusNoContent)
 		return
 	}
@@ -174,16 +190,71 @@ func (rt *_router) recordSponsorExposureEvent(w http.ResponseWriter, r *http.Req
 		deviceID = rt.deviceIDFromRequest(r)
 	}
 
-	if err := rt.db.RecordSponsorExposure(eventID, sponsorIDs, deviceID, payload.Type, payload.DurationMs); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
+	if deviceID == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	exposureType := strings.ToLower(strings.TrimSpace(payload.Type))
+	if exposureType != "seen" && exposureType != "watched" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if planID := strings.TrimSpace(payload.PlanID); planID != "" {
+		screen := strings.TrimSpace(payload.Screen)
+		if screen == "" {
+			screen = database.SponsorScreenMain
+		}
+		received := len(impressions)
+		plan, err := rt.sponsorPlan(planID)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			impressions = nil
+		case err != nil:
+			ctx.Logger.WithError(err).Error("cannot load sponsor plan")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		case plan.EventID != eventID || plan.DeviceID != deviceID:
+			impressions = nil
+		default:
+			impressions = plannedImpressions(plan, screen, exposureType, payload.DurationMs, impressions)
+		}
+		if dropped := received - len(impressions); dropped > 0 {
+			ctx.Logger.WithFields(map[string]interface{}{"event_id": eventID, "plan_id": planID, "screen": screen, "dropped": dropped}).Warn("dropped sponsor exposures outside the issued plan")
+		}
+		if len(impressions) == 0 {
 			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		if errors.Is(err, database.ErrInvalidSponsorData) {
-			w.WriteHeader(http.StatusBadRequest)
+	} else {
+		// Only the pages opened before the plans existed send no plan: a device that was issued one must send it
+		counts, err := rt.sponsorPlanCounts(eventID, deviceID)
+		if err != nil {
+			ctx.Logger.WithError(err).Error("cannot count the sponsor plans of the device")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if counts.DevicePlans > 0 {
+			ctx.Logger.WithFields(map[string]interface{}{"event_id": eventID, "dropped": len(impressions)}).Warn("dropped sponsor exposures without the issued plan")
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
-		ctx.Logger.WithError(err).Warn("cannot record sponsor exposure")
+	}
+
+	now := globaltime.Now()
+	batch := database.SponsorTelemetryBatch{Exposures: make([]database.SponsorExposureRecord, 0, len(impressions))}
+	for _, impression := range impressions {
+		batch.Exposures = append(batch.Exposures, database.SponsorExposureRecord{
+			EventID:      eventID,
+			Impression:   impression,
+			DeviceID:     deviceID,
+			ExposureType: exposureType,
+			DurationMs:   payload.DurationMs,
+			At:           now,
+		})
+	}
+	if !rt.enqueueTelemetry(w, ctx, batch) {
+		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -197,6 +268,8 @@ func (rt *_router) getSponsorAnalytics(w http.ResponseWriter, r *http.Request, c
 		return
 	}
 
+	// The analytics include the telemetry still in the buffer
+	rt.telemetry.flush()
 	summary, err := rt.db.GetSponsorAnalytics(eventID)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
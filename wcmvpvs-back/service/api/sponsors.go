@@ -1,21 +1,176 @@
 package api
 
 import (
-	"encoding/json"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
 )
 
+// publicSponsor is a sponsor of the rotation with the campaign and the creative it is shown with, zero for the
+// sponsors without campaigns. The logo and LinkURL are those of the creative, when there is one, and Position is the
+// place in the rotation. LogoURL and LogoURLs are empty for the logos still stored inline in LogoData.
+type publicSponsor struct {
+	database.Sponsor
+	CampaignID int               `json:"campaign_id"`
+	CreativeID int               `json:"creative_id"`
+	LogoURL    string            `json:"logo_url"`
+	LogoURLs   map[string]string `json:"logo_urls,omitempty"`
+}
+
+// listPublicSponsors returns the sponsor rotation of the event in `event_id`, or of the active event. The devices
+// identified by `device_id` or X-Device-ID get a schedule instead, see writeSponsorSchedule.
 func (rt *_router) listPublicSponsors(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
-	sponsors, err := rt.db.ListActiveSponsors()
+	eventID := 0
+	if raw := strings.TrimSpace(r.URL.Query().Get("event_id")); raw != "" {
+		id, err := strconv.Atoi(raw)
+		if err != nil || id <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		eventID = id
+	} else if event, err := rt.db.GetActiveEvent(); err == nil {
+		eventID = event.ID
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		ctx.Logger.WithError(err).Error("cannot fetch active event for the sponsors")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	placements, err := rt.db.ListSponsorPlacements(eventID, globaltime.Now())
 	if err != nil {
 		ctx.Logger.WithError(err).Error("cannot list active sponsors")
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	if deviceID := rt.deviceIDFromRequest(r); deviceID != "" && eventID > 0 {
+		rt.writeSponsorSchedule(w, ctx, eventID, deviceID, placements)
+		return
+	}
+
+	// The pages without a device get the plain rotation of the main screen
+	mainScreen := placements[:0]
+	for _, placement := range placements {
+		if placement.Campaign == nil || placement.Campaign.ExclusiveScreen == "" || placement.Campaign.ExclusiveScreen == database.SponsorScreenMain {
+			mainScreen = append(mainScreen, placement)
+		}
+	}
+	sponsors := sponsorRotation(mainScreen)
+
+	w.Header().Set("Cache-Control", "no-store")
+	_ = writeJSON(w, http.StatusOK, sponsors)
+	ctx.Logger.WithField("event_id", eventID).WithField("sponsors", len(sponsors)).Info("listed active sponsors")
+}
+
+// sponsorRotation shows every sponsor once. A campaign is picked among the ones of the sponsor with the highest
+// priority, and a creative of the campaign, both at random by weight. The sponsors are sorted by priority, then at
+// random by weight; the sponsors without campaigns keep their position, after the campaigns with the same priority.
+func sponsorRotation(placements []database.SponsorPlacement) []publicSponsor {
+	type candidate struct {
+		sponsor  publicSponsor
+		priority int
+		key      float64
+	}
+
+	bySponsor := make(map[int][]*database.SponsorCampaign)
+	var order []database.Sponsor
+	for _, placement := range placements {
+		if _, ok := bySponsor[placement.Sponsor.ID]; !ok {
+			order = append(order, placement.Sponsor)
+			bySponsor[placement.Sponsor.ID] = nil
+		}
+		if placement.Campaign != nil {
+			bySponsor[placement.Sponsor.ID] = append(bySponsor[placement.Sponsor.ID], placement.Campaign)
+		}
+	}
+
+	candidates := make([]candidate, 0, len(order))
+	for _, sponsor := range order {
+		entry := candidate{sponsor: publicSponsor{Sponsor: sponsor}}
+		campaigns := bySponsor[sponsor.ID]
+		if len(campaigns) > 0 {
+			var top []*database.SponsorCampaign
+			for _, campaign := range campaigns {
+				if len(top) > 0 && campaign.Priority < top[0].Priority {
+					continue
+				}
+				if len(top) > 0 && campaign.Priority > top[0].Priority {
+					top = top[:0]
+				}
+				top = append(top, campaign)
+			}
+			weights := make([]int, len(top))
+			for i, campaign := range top {
+				weights[i] = campaign.Weight
+			}
+			campaign := top[weightedIndex(weights)]
+
+			entry.priority = campaign.Priority
+			entry.key = math.Pow(rand.Float64(), 1/float64(campaign.Weight))
+			entry.sponsor.CampaignID = campaign.ID
+			if len(campaign.Creatives) > 0 {
+				weights = make([]int, len(campaign.Creatives))
+				for i, creative := range campaign.Creatives {
+					weights[i] = creative.Weight
+				}
+				creative := campaign.Creatives[weightedIndex(weights)]
+				entry.sponsor.CreativeID = creative.ID
+				entry.sponsor.LogoData = creative.LogoData
+				entry.sponsor.LogoAsset = creative.LogoAsset
+				if creative.LinkURL != "" {
+					entry.sponsor.LinkURL = creative.LinkURL
+				}
+			}
+		}
+		candidates = append(candidates, entry)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if (a.sponsor.CampaignID > 0) != (b.sponsor.CampaignID > 0) {
+			return a.sponsor.CampaignID > 0
+		}
+		return a.key > b.key
+	})
 
-	w.Header().Set("content-type", "application/json")
-	_ = json.NewEncoder(w).Encode(sponsors)
-	ctx.Logger.WithField("sponsors", len(sponsors)).Info("listed active sponsors")
+	rotation := make([]publicSponsor, len(candidates))
+	for i, entry := range candidates {
+		rotation[i] = entry.sponsor
+		rotation[i].Position = i + 1
+		if entry.sponsor.LogoAsset != "" {
+			rotation[i].LogoURLs = sponsorAssetURLs(entry.sponsor.LogoAsset)
+			rotation[i].LogoURL = rotation[i].LogoURLs["medium"]
+		}
+	}
+	return rotation
+}
+
+// weightedIndex returns an index of weights at random, each with a probability proportional to its weight.
+func weightedIndex(weights []int) int {
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	if total <= 0 {
+		return 0
+	}
+	pick := rand.Intn(total)
+	for i, weight := range weights {
+		if pick < weight {
+			return i
+		}
+		pick -= weight
+	}
+	return len(weights) - 1
 }
@@ -0,0 +1,355 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/api/reqcontext"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/textfilter"
+	"github.com/go-chi/chi/v5"
+)
+
+// filteredText is the outcome of the text filter on a text written by a fan.
+type filteredText struct {
+	// Text is the text to store: the original one, or the masked one
+	Text string
+
+	// Rejected is set when the text must be refused; Flagged when it must be reviewed by the staff
+	Rejected bool
+	Flagged  bool
+}
+
+type textFilterResponse struct {
+	Languages []string                  `json:"languages"`
+	Actions   map[string]string         `json:"actions"`
+	Words     []database.TextFilterWord `json:"words"`
+}
+
+type flaggedTextResponse struct {
+	database.FlaggedText
+	Matches []textfilter.Match `json:"matches"`
+}
+
+func isTextFilterWordCollision(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "text_filter_words.list, text_filter_words.word")
+}
+
+// textFilterLanguages returns the languages of the built-in lists in use, all of them if none is configured.
+func (rt *_router) textFilterLanguages() []string {
+	if len(rt.textFilterLangs) == 0 {
+		return textfilter.Languages()
+	}
+	return rt.textFilterLangs
+}
+
+// currentTextFilter returns the filter made of the built-in lists and of the words added by the admins. It is built
+// once, and again after the words change.
+func (rt *_router) currentTextFilter() (*textfilter.Filter, error) {
+	rt.textFilterMu.Lock()
+	defer rt.textFilterMu.Unlock()
+	if rt.textFilter != nil {
+		return rt.textFilter, nil
+	}
+
+	words, err := rt.db.ListTextFilterWords()
+	if err != nil {
+		return nil, err
+	}
+	var deny, allow []string
+	for _, language := range rt.textFilterLanguages() {
+		deny = append(deny, textfilter.Builtin(language)...)
+	}
+	for _, word := range words {
+		if word.List == database.TextFilterListAllow {
+			allow = append(allow, word.Word)
+		} else {
+			deny = append(deny, word.Word)
+		}
+	}
+	rt.textFilter = textfilter.New(deny, allow)
+	return rt.textFilter, nil
+}
+
+func (rt *_router) resetTextFilter() {
+	rt.textFilterMu.Lock()
+	rt.textFilter = nil
+	rt.textFilterMu.Unlock()
+}
+
+// filterText applies to the text the action configured for the target.
+func (rt *_router) filterText(target, text string) (filteredText, error) {
+	result := filteredText{Text: text}
+	if strings.TrimSpace(text) == "" {
+		return result, nil
+	}
+	actions, err := rt.db.ListTextFilterActions()
+	if err != nil {
+		return result, err
+	}
+	action := actions[target]
+	if action == database.TextFilterActionNone {
+		return result, nil
+	}
+	filter, err := rt.currentTextFilter()
+	if err != nil {
+		return result, err
+	}
+	matches := filter.Find(text)
+	if len(matches) == 0 {
+		return result, nil
+	}
+
+	switch action {
+	case database.TextFilterActionReject:
+		result.Rejected = true
+	case database.TextFilterActionMask:
+		result.Text = textfilter.Mask(text, matches)
+	default:
+		result.Flagged = true
+	}
+	return result, nil
+}
+
+func (rt *_router) buildTextFilterResponse() (textFilterResponse, error) {
+	actions, err := rt.db.ListTextFilterActions()
+	if err != nil {
+		return textFilterResponse{}, err
+	}
+	words, err := rt.db.ListTextFilterWords()
+	if err != nil {
+		return textFilterResponse{}, err
+	}
+	return textFilterResponse{Languages: rt.textFilterLanguages(), Actions: actions, Words: words}, nil
+}
+
+func (rt *_router) getAdminTextFilter(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	response, err := rt.buildTextFilterResponse()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load text filter settings")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write text filter response")
+	}
+}
+
+// updateAdminTextFilterActions sets the actions of the targets in the payload, a map from target to action.
+func (rt *_router) updateAdminTextFilterActions(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		_ = writeJSONMessage(w, http.StatusForbidden, "Solo un superadmin può configurare il filtro dei testi.")
+		return
+	}
+	var payload map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || len(payload) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for target, action := range payload {
+		if !database.IsValidTextFilterTarget(target) {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Testo da filtrare non valido: usa selfie_caption, feedback_suggestion o order_notes.")
+			return
+		}
+		if !database.IsValidTextFilterAction(strings.ToLower(strings.TrimSpace(action))) {
+			_ = writeJSONMessage(w, http.StatusBadRequest, "Azione non valida: usa reject, mask, flag o none.")
+			return
+		}
+	}
+
+	now := globaltime.Now()
+	for target, action := range payload {
+		if err := rt.db.SetTextFilterAction(target, strings.ToLower(strings.TrimSpace(action)), ctx.AdminUsername, now); err != nil {
+			ctx.Logger.WithError(err).Error("cannot save text filter action")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	ctx.Logger.WithField("actions", payload).Info("text filter actions updated")
+
+	response, err := rt.buildTextFilterResponse()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load text filter settings")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write text filter response")
+	}
+}
+
+func (rt *_router) addAdminTextFilterWord(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		_ = writeJSONMessage(w, http.StatusForbidden, "Solo un superadmin può configurare il filtro dei testi.")
+		return
+	}
+	var payload struct {
+		Word     string `json:"word"`
+		List     string `json:"list"`
+		Language string `json:"language"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	list := strings.ToLower(strings.TrimSpace(payload.List))
+	if list == "" {
+		list = database.TextFilterListDeny
+	}
+	if list != database.TextFilterListDeny && list != database.TextFilterListAllow {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Lista non valida: usa deny o allow.")
+		return
+	}
+	if strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(payload.Word), "*")) == "" {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Inserisci la parola da filtrare.")
+		return
+	}
+
+	word, err := rt.db.AddTextFilterWord(database.TextFilterWord{
+		Word:      payload.Word,
+		List:      list,
+		Language:  payload.Language,
+		CreatedBy: ctx.AdminUsername,
+	}, globaltime.Now())
+	if isTextFilterWordCollision(err) {
+		_ = writeJSONMessage(w, http.StatusConflict, "La parola è già presente nella lista.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot add text filter word")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.resetTextFilter()
+	ctx.Logger.WithFields(map[string]interface{}{"word_id": word.ID, "list": word.List}).Info("text filter word added")
+	if err := writeJSON(w, http.StatusCreated, word); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write text filter word response")
+	}
+}
+
+func (rt *_router) deleteAdminTextFilterWord(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	if !strings.EqualFold(ctx.AdminRole, "superadmin") {
+		_ = writeJSONMessage(w, http.StatusForbidden, "Solo un superadmin può configurare il filtro dei testi.")
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if err := rt.db.DeleteTextFilterWord(id); errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot delete text filter word")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rt.resetTextFilter()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkAdminText shows what the filter finds in a text, to try the lists before a match.
+func (rt *_router) checkAdminText(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	var payload struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	filter, err := rt.currentTextFilter()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load text filter")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	matches := filter.Find(payload.Text)
+	response := struct {
+		Matches []textfilter.Match `json:"matches"`
+		Masked  string             `json:"masked"`
+	}{Matches: matches, Masked: textfilter.Mask(payload.Text, matches)}
+	if response.Matches == nil {
+		response.Matches = []textfilter.Match{}
+	}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write text check response")
+	}
+}
+
+func (rt *_router) listFlaggedTexts(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	texts, err := rt.db.ListFlaggedTexts()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot list flagged texts")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	filter, err := rt.currentTextFilter()
+	if err != nil {
+		ctx.Logger.WithError(err).Error("cannot load text filter")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	response := make([]flaggedTextResponse, 0, len(texts))
+	for _, text := range texts {
+		// The matches are found again, to highlight the words even after the lists change
+		matches := filter.Find(text.Text)
+		if matches == nil {
+			matches = []textfilter.Match{}
+		}
+		response = append(response, flaggedTextResponse{FlaggedText: text, Matches: matches})
+	}
+	if err := writeJSON(w, http.StatusOK, response); err != nil {
+		ctx.Logger.WithError(err).Error("cannot write flagged texts response")
+	}
+}
+
+// reviewFlaggedText keeps or removes a flagged text, according to the decision of the staff.
+func (rt *_router) reviewFlaggedText(w http.ResponseWriter, r *http.Request, ctx reqcontext.RequestContext) {
+	target := chi.URLParam(r, "target")
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id <= 0 || !database.IsValidTextFilterTarget(target) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var payload struct {
+		Decision string `json:"decision"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	decision := strings.ToLower(strings.TrimSpace(payload.Decision))
+	if decision != "keep" && decision != "remove" {
+		_ = writeJSONMessage(w, http.StatusBadRequest, "Decisione non valida: usa keep o remove.")
+		return
+	}
+
+	if err := rt.db.ReviewFlaggedText(target, id, decision == "remove"); errors.Is(err, sql.ErrNoRows) {
+		_ = writeJSONMessage(w, http.StatusNotFound, "Testo non trovato o già revisionato.")
+		return
+	} else if err != nil {
+		ctx.Logger.WithError(err).Error("cannot review flagged text")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ctx.Logger.WithFields(map[string]interface{}{
+		"target":    target,
+		"id":        id,
+		"decision":  decision,
+		"moderator": ctx.AdminUsername,
+	}).Info("flagged text reviewed")
+
+	// The arena screen shows the captions of its selfies
+	if target == database.TextFilterTargetSelfieCaption && decision == "remove" {
+		if selfie, err := rt.db.GetSelfieByID(id); err == nil && selfie.ShowOnScreen {
+			rt.screenHub.notify(selfie.EventID)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+func TestTextFilter(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	token := h.createAdmin(testAdminUsername, "superadmin")
+	h.mustVote(fixture, "device-1")
+
+	selfiePath := fmt.Sprintf("/events/%d/selfies", fixture.EventID)
+	upload := func(caption string) int {
+		t.Helper()
+		body := map[string]string{"caption": caption, "image_base64": testPNGDataURL(t)}
+		return h.do(http.MethodPost, selfiePath, body, map[string]string{"X-Device-ID": "device-1"}).Code
+	}
+	if code := upload("Arbitro str0nz0!"); code != http.StatusBadRequest {
+		t.Fatalf("offensive caption: status = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	// An allowed word is never filtered, a denied one is added to the built-in lists
+	for _, word := range []map[string]string{{"word": "stronzo", "list": "allow"}, {"word": "guf*", "list": "deny", "language": "it"}} {
+		if rec := h.do(http.MethodPost, "/admin/text-filter/words", word, adminHeaders(token)); rec.Code != http.StatusCreated {
+			t.Fatalf("add word %v: status = %d (%s)", word, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := h.do(http.MethodPost, "/admin/text-filter/words", map[string]string{"word": "Stronzo", "list": "allow"}, adminHeaders(token)); rec.Code != http.StatusConflict {
+		t.Fatalf("duplicated word: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if code := upload("Arbitro str0nz0!"); code != http.StatusCreated {
+		t.Fatalf("allowed caption: status = %d, want %d", code, http.StatusCreated)
+	}
+
+	actions := map[string]string{"selfie_caption": "mask", "feedback_suggestion": "flag"}
+	if rec := h.do(http.MethodPut, "/admin/text-filter/actions", actions, adminHeaders(token)); rec.Code != http.StatusOK {
+		t.Fatalf("update actions: status = %d (%s)", rec.Code, rec.Body.String())
+	}
+	if code := upload("Gufi ovunque"); code != http.StatusCreated {
+		t.Fatalf("masked caption: status = %d", code)
+	}
+	selfie, err := h.db.GetSelfieForDevice(fixture.EventID, "device-1")
+	if err != nil || selfie.Caption != "**** ovunque" || selfie.CaptionFlagged {
+		t.Fatalf("masked selfie = %+v (%v)", selfie, err)
+	}
+
+	feedback := map[string]string{"experience": "easy", "team_spirit": "high", "perks_interest": "yes", "mini_games_interest": "maybe", "suggestion": "Che cazzo di musica"}
+	if rec := h.do(http.MethodPost, fmt.Sprintf("/events/%d/feedback", fixture.EventID), feedback, nil); rec.Code != http.StatusCreated {
+		t.Fatalf("feedback: status = %d", rec.Code)
+	}
+	if summary, err := h.db.GetEventFeedbackSummary(fixture.EventID); err != nil || len(summary.Suggestions) != 0 {
+		t.Fatalf("summary before review = %+v (%v), want the suggestion hidden", summary.Suggestions, err)
+	}
+
+	var flagged []flaggedTextResponse
+	h.decode(h.do(http.MethodGet, "/admin/text-filter/flags", nil, adminHeaders(token)), &flagged)
+	if len(flagged) != 1 || flagged[0].Target != database.TextFilterTargetFeedbackSuggestion || len(flagged[0].Matches) != 1 {
+		t.Fatalf("flagged texts = %+v", flagged)
+	}
+	reviewPath := fmt.Sprintf("/admin/text-filter/flags/%s/%d", flagged[0].Target, flagged[0].ID)
+	if rec := h.do(http.MethodPut, reviewPath, map[string]string{"decision": "keep"}, adminHeaders(token)); rec.Code != http.StatusNoContent {
+		t.Fatalf("review: status = %d", rec.Code)
+	}
+	if rec := h.do(http.MethodPut, reviewPath, map[string]string{"decision": "remove"}, adminHeaders(token)); rec.Code != http.StatusNotFound {
+		t.Fatalf("second review: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	if summary, err := h.db.GetEventFeedbackSummary(fixture.EventID); err != nil || len(summary.Suggestions) != 1 {
+		t.Fatalf("summary after review = %+v (%v), want the suggestion kept", summary.Suggestions, err)
+	}
+}
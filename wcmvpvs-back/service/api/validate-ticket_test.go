@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestTicketRedemption(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	ticket := h.mustVote(fixture, "device-1")
+
+	validatePath := func(eventID int, code, signature string) string {
+		return fmt.Sprintf("/tickets/validate?e=%d&c=%s&s=%s", eventID, code, signature)
+	}
+
+	cases := []struct {
+		name            string
+		path            string
+		status          int
+		errorCode       string
+		alreadyRedeemed bool
+	}{
+		{name: "first redemption", path: validatePath(fixture.EventID, ticket.Code, ticket.Signature), status: http.StatusOK},
+		{name: "second redemption", path: validatePath(fixture.EventID, ticket.Code, ticket.Signature), status: http.StatusOK, alreadyRedeemed: true},
+		{name: "forged signature", path: validatePath(fixture.EventID, ticket.Code, signCode("other", ticket.Code)), status: http.StatusBadRequest, errorCode: "invalid_signature"},
+		{name: "unknown ticket", path: validatePath(fixture.EventID+1, ticket.Code, ticket.Signature), status: http.StatusNotFound, errorCode: "ticket_not_found"},
+		{name: "missing parameters", path: validatePath(fixture.EventID, "", ""), status: http.StatusBadRequest, errorCode: "missing_parameters"},
+	}
+
+	// Cases are sequential on purpose: redemption state is carried over between them.
+	for _, tc := range cases {
+		rec := h.do(http.MethodGet, tc.path, nil, nil)
+		if rec.Code != tc.status {
+			t.Fatalf("%s: status = %d, want %d (%s)", tc.name, rec.Code, tc.status, rec.Body.String())
+		}
+		var resp struct {
+			Valid           bool   `json:"valid"`
+			AlreadyRedeemed bool   `json:"already_redeemed"`
+			Error           string `json:"error"`
+		}
+		h.decode(rec, &resp)
+		if resp.Error != tc.errorCode {
+			t.Fatalf("%s: error = %q, want %q", tc.name, resp.Error, tc.errorCode)
+		}
+		if tc.errorCode == "" && (!resp.Valid || resp.AlreadyRedeemed != tc.alreadyRedeemed) {
+			t.Fatalf("%s: unexpected response %+v", tc.name, resp)
+		}
+	}
+}
+
+func TestAdminValidateTicket(t *testing.T) {
+	h := newTestHarness(t)
+	fixture := h.seedEvent(0)
+	ticket := h.mustVote(fixture, "device-1")
+	token := h.createAdmin(testAdminUsername, "staff")
+
+	path := fmt.Sprintf("/events/%d/validate-ticket", fixture.EventID)
+
+	rec := h.do(http.MethodPost, path, map[string]string{"code": ticket.Code, "signature": ticket.Signature}, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthenticated: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+
+	rec = h.do(http.MethodPost, path, map[string]string{"code": ticket.Code, "signature": ticket.Signature}, adminHeaders(token))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+
+	rec = h.do(http.MethodPost, path, map[string]string{"code": ticket.Code, "signature": "deadbeef"}, adminHeaders(token))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad signature: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
@@ -65,17 +65,35 @@ func (rt *_router) recordAttempt(store map[string][]time.Time, key string, now t
 }
 
 func (rt *_router) shouldThrottleVoteAttempt(deviceID, ip string, now time.Time) (bool, string) {
+	return rt.throttleAttempt(rt.voteRateByDevice, rt.voteRateByIP, deviceID, ip, now)
+}
+
+// shouldThrottleContestVote limits the votes of the selfie contest with the same rules as the MVP votes, but on their
+// own budget: the fans of a stadium network voting selfies must not use up the MVP votes of the address.
+func (rt *_router) shouldThrottleContestVote(deviceID, ip string, now time.Time) (bool, string) {
+	return rt.throttleAttempt(rt.contestVoteRateByDevice, rt.contestVoteRateByIP, deviceID, ip, now)
+}
+
+// shouldThrottleShopReservation limits the stock reservations by address: the shop has no device id, and each
+// reservation holds items that nobody else can buy until it expires.
+func (rt *_router) shouldThrottleShopReservation(ip string, now time.Time) bool {
+	rt.voteRateMu.Lock()
+	defer rt.voteRateMu.Unlock()
+	return ip != "" && !rt.recordAttempt(rt.shopReservationRateByIP, ip, now, shopReservationIPLimit, shopReservationIPWindow)
+}
+
+func (rt *_router) throttleAttempt(byDevice, byIP map[string][]time.Time, deviceID, ip string, now time.Time) (bool, string) {
 	rt.voteRateMu.Lock()
 	defer rt.voteRateMu.Unlock()
 
 	if deviceID != "" {
-		if !rt.recordAttempt(rt.voteRateByDevice, deviceID, now, voteDeviceLimit, voteDeviceWindow) {
+		if !rt.recordAttempt(byDevice, deviceID, now, voteDeviceLimit, voteDeviceWindow) {
 			return true, voteThrottleMessages["device"]
 		}
 	}
 
 	if ip != "" {
-		if !rt.recordAttempt(rt.voteRateByIP, ip, now, voteIPLimit, voteIPWindow) {
+		if !rt.recordAttempt(byIP, ip, now, voteIPLimit, voteIPWindow) {
 			return true, voteThrottleMessages["ip"]
 		}
 	}
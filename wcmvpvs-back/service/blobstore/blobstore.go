@@ -0,0 +1,93 @@
+/*
+Package blobstore stores binary objects (like the selfie images) outside the database. Two implementations are
+available: Local, which keeps the objects as files under a root directory, and S3, which talks to any S3-compatible
+service (AWS S3, MinIO, ...).
+
+Objects are addressed by keys made of slash-separated segments, like `selfies/event_1/<uuid>.jpg`. Keys are the same
+for every implementation, so that objects can be moved from one store to another without changing the references
+saved in the database.
+*/
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned when the requested object does not exist.
+	ErrNotFound = errors.New("blob not found")
+
+	// ErrInvalidKey is returned for empty keys and keys with empty, "." or ".." segments.
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// Info describes a stored object.
+type Info struct {
+	Size        int64
+	ContentType string
+	ModTime     time.Time
+}
+
+// Store is a blob storage backend.
+type Store interface {
+	// Put saves the object, replacing any object with the same key.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+
+	// Get returns the object content. The caller must close it. When the content implements io.ReadSeeker it can be
+	// served with http.ServeContent.
+	Get(ctx context.Context, key string) (io.ReadCloser, Info, error)
+
+	Stat(ctx context.Context, key string) (Info, error)
+
+	// Delete removes the object. Deleting a missing object is not an error.
+	Delete(ctx context.Context, key string) error
+
+	// PresignedURL returns a URL from which clients can download the object directly for the given time. It returns
+	// an empty string when the store does not support it, and the object must be proxied.
+	PresignedURL(key string, expires time.Duration) (string, error)
+}
+
+// ValidateKey checks that key can be used with every store.
+func ValidateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+const (
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+// Config selects and configures a Store. Dir is used by the local backend, S3 by the s3 backend.
+type Config struct {
+	Backend string
+	Dir     string
+	S3      S3Config
+}
+
+// New creates the store selected by cfg.Backend.
+func New(cfg Config) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", BackendLocal:
+		if cfg.Dir == "" {
+			return nil, errors.New("blob store directory is required")
+		}
+		return NewLocal(cfg.Dir)
+	case BackendS3:
+		return NewS3(cfg.S3)
+	default:
+		return nil, fmt.Errorf("unknown blob store backend %q", cfg.Backend)
+	}
+}
@@ -0,0 +1,126 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Local stores the objects as files under a root directory, using the key as relative path.
+type Local struct {
+	root string
+}
+
+// NewLocal returns a Local store rooted in dir, creating the directory if needed.
+func NewLocal(dir string) (*Local, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Local{root: root}, nil
+}
+
+// Root returns the absolute path of the root directory.
+func (l *Local) Root() string {
+	return l.root
+}
+
+func (l *Local) path(key string) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the object to a temporary file first, so that readers never see a partial object.
+func (l *Local) Put(_ context.Context, key string, data []byte, _ string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (l *Local) Get(_ context.Context, key string) (io.ReadCloser, Info, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, Info{}, ErrNotFound
+	} else if err != nil {
+		return nil, Info{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, Info{}, err
+	}
+	return file, localInfo(key, stat), nil
+}
+
+func (l *Local) Stat(_ context.Context, key string) (Info, error) {
+	target, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	stat, err := os.Stat(target)
+	if errors.Is(err, os.ErrNotExist) {
+		return Info{}, ErrNotFound
+	} else if err != nil {
+		return Info{}, err
+	}
+	return localInfo(key, stat), nil
+}
+
+func (l *Local) Delete(_ context.Context, key string) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// PresignedURL is not supported: local objects are always proxied.
+func (l *Local) PresignedURL(string, time.Duration) (string, error) {
+	return "", nil
+}
+
+func localInfo(key string, stat os.FileInfo) Info {
+	return Info{
+		Size:        stat.Size(),
+		ContentType: mime.TypeByExtension(path.Ext(key)),
+		ModTime:     stat.ModTime(),
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// backupPagesPerStep is the number of pages copied by each step of the online backup. Between steps the source
+// database is unlocked, so votes keep flowing while a backup is running.
+const backupPagesPerStep = 256
+
+// Backup writes a consistent copy of the database into destPath using the SQLite online backup API. destPath must
+// not exist: the copy is never written over an existing file.
+func (db *appdbimpl) Backup(destPath string) error {
+	if _, err := os.Stat(destPath); err == nil {
+		return fmt.Errorf("backup destination %s already exists", destPath)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = dest.Close() }()
+
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = destConn.Close() }()
+	srcConn, err := db.c.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = srcConn.Close() }()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			destSQLite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup destination is not a SQLite connection")
+			}
+			srcSQLite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return errors.New("backup source is not a SQLite connection")
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := backup.Step(backupPagesPerStep)
+				if err != nil {
+					_ = backup.Close()
+					return err
+				}
+				if done {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			return backup.Finish()
+		})
+	})
+}
+
+// VerifyIntegrity opens the SQLite file at filename in read-only mode and runs `PRAGMA integrity_check` on it.
+// A nil error means SQLite reported the file as "ok".
+func VerifyIntegrity(filename string) error {
+	if _, err := os.Stat(filename); err != nil {
+		return err
+	}
+	conn, err := sql.Open("sqlite3", "file:"+filename+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	rows, err := conn.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
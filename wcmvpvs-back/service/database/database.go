@@ -82,6 +82,9 @@ type EventFeedback struct {
 	MiniGamesInterest string `json:"mini_games_interest"`
 	Suggestion        string `json:"suggestion"`
 	CreatedAt         string `json:"created_at"`
+
+	// SuggestionFlagged keeps the suggestion out of the reports until a moderator reviews it
+	SuggestionFlagged bool `json:"suggestion_flagged"`
 }
 
 type EventFeedbackSummary struct {
@@ -110,6 +113,12 @@ type EventPrizeWinner struct {
 	AssignedAt      string `json:"assigned_at"`
 }
 
+// VoteContact is the email where the voter is told when their ticket wins, in the language they chose.
+type VoteContact struct {
+	Email  string
+	Locale string
+}
+
 type Vote struct {
 	ID              int    `json:"id"`
 	EventID         int    `json:"event_id"`
@@ -178,6 +187,9 @@ type Sponsor struct {
 	LogoData string `json:"logo_data"`
 	LinkURL  string `json:"link_url"`
 	IsActive bool   `json:"is_active"`
+
+	// LogoAsset is the hash of the logo uploaded as a file, empty for the logos still stored in LogoData
+	LogoAsset string `json:"logo_asset"`
 }
 
 type SponsorClickStat struct {
@@ -210,6 +222,7 @@ type SponsorAnalytics struct {
 	UniqueClickers   int                    `json:"unique_clickers"`
 	TopSponsor       *SponsorViewStat       `json:"top_sponsor,omitempty"`
 	Timeline         []SponsorTimelinePoint `json:"timeline"`
+	Campaigns        []SponsorCampaignStat  `json:"campaigns"`
 }
 
 type EventMVP struct {
@@ -231,6 +244,33 @@ type Selfie struct {
 	Approved     bool   `json:"approved"`
 	ShowOnScreen bool   `json:"show_on_screen"`
 	CreatedAt    string `json:"created_at"`
+
+	// Status is one of SelfieStatusPending, SelfieStatusApproved and SelfieStatusRejected
+	Status          string `json:"status"`
+	RejectionReason string `json:"rejection_reason"`
+	ModeratedBy     string `json:"moderated_by"`
+	ModeratedAt     string `json:"moderated_at"`
+	ClaimedBy       string `json:"claimed_by"`
+	ClaimedUntil    string `json:"claimed_until"`
+	PerceptualHash  string `json:"perceptual_hash"`
+	DuplicateOfID   int    `json:"duplicate_of_id"`
+
+	// CaptionFlagged is set when the text filter wants a moderator to review the caption
+	CaptionFlagged bool `json:"caption_flagged"`
+}
+
+// NewSelfie is an uploaded selfie to store. DuplicateOfID is an earlier selfie with the same picture, zero if none.
+type NewSelfie struct {
+	EventID        int
+	DeviceID       string
+	Caption        string
+	ImagePath      string
+	ContentType    string
+	PerceptualHash string
+	DuplicateOfID  int
+
+	// CaptionFlagged marks a caption the text filter wants a moderator to review
+	CaptionFlagged bool
 }
 
 type ReactionTestAttempt struct {
@@ -247,13 +287,39 @@ type ReactionTestStats struct {
 	Average  float64 `json:"average_ms"`
 }
 
+// ShopProduct is a product of the shop. Only the active products are listed and sold; Category groups them and
+// SortOrder sorts them, lowest first. A deleted product keeps its row, with DeletedAt set, so that the order items
+// still refer to it.
 type ShopProduct struct {
 	ID          int    `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	PriceCents  int    `json:"price_cents"`
 	ImageURL    string `json:"image_url"`
+	Category    string `json:"category"`
+	SortOrder   int    `json:"sort_order"`
+	IsActive    bool   `json:"is_active"`
 	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+	DeletedAt   string `json:"deleted_at,omitempty"`
+
+	// Stock is the quantity in the warehouse, nil when the product is not counted. Available is the part of it not
+	// held by the cart reservations. The products with variants keep the stock on the variants
+	Stock     *int                 `json:"stock"`
+	Available *int                 `json:"available"`
+	Variants  []ShopProductVariant `json:"variants"`
+}
+
+// ShopProductVariant is a size or model of a product. A product with active variants is sold only by variant.
+type ShopProductVariant struct {
+	ID        int    `json:"id"`
+	ProductID int    `json:"product_id"`
+	Name      string `json:"name"`
+	SortOrder int    `json:"sort_order"`
+	IsActive  bool   `json:"is_active"`
+	Stock     *int   `json:"stock"`
+	Available *int   `json:"available"`
+	DeletedAt string `json:"deleted_at,omitempty"`
 }
 
 type ShopOrder struct {
@@ -264,6 +330,21 @@ type ShopOrder struct {
 	TotalCents    int             `json:"total_cents"`
 	CreatedAt     string          `json:"created_at"`
 	Items         []ShopOrderItem `json:"items,omitempty"`
+
+	// NotesFlagged is set when the text filter wants a staff member to review the notes
+	NotesFlagged bool `json:"notes_flagged"`
+
+	// Status is one of the ShopOrderStatus constants, changed by UpdateShopOrderStatus
+	Status          string `json:"status"`
+	StatusUpdatedAt string `json:"status_updated_at,omitempty"`
+
+	// PickupEventID is the event where the customer collects the order, zero when not chosen yet
+	PickupEventID int                     `json:"pickup_event_id,omitempty"`
+	History       []ShopOrderStatusChange `json:"history,omitempty"`
+	Payments      []ShopPayment           `json:"payments,omitempty"`
+
+	// Locale is the language of the emails sent to the customer
+	Locale string `json:"locale,omitempty"`
 }
 
 type ShopOrderItem struct {
@@ -271,11 +352,23 @@ type ShopOrderItem struct {
 	OrderID         int    `json:"order_id"`
 	ProductID       int    `json:"product_id"`
 	ProductName     string `json:"product_name"`
+	VariantID       int    `json:"variant_id,omitempty"`
+	VariantName     string `json:"variant_name,omitempty"`
 	Quantity        int    `json:"quantity"`
 	UnitPriceCents  int    `json:"unit_price_cents"`
 	ProductImageURL string `json:"product_image_url,omitempty"`
 }
 
+type EventArchive struct {
+	EventID    int    `json:"event_id"`
+	Summary    string `json:"-"`
+	ArchivedBy string `json:"archived_by"`
+	ArchivedAt string `json:"archived_at"`
+	PurgeAfter string `json:"purge_after"`
+	PurgedAt   string `json:"purged_at,omitempty"`
+	Restorable bool   `json:"restorable"`
+}
+
 // AppDatabase is the high level interface for the DB
 type AppDatabase interface {
 	GetName() (string, error)
@@ -290,6 +383,7 @@ type AppDatabase interface {
 	UpdatePlayer(p Player) error
 	DeletePlayer(id int) error
 	CreateEvent(e Event) (int, error)
+	ImportRoster(batch RosterImport) (RosterImportIDs, error)
 	ListEvents() ([]Event, error)
 	UpdateEvent(e Event) error
 	DeleteEvent(id int) error
@@ -312,14 +406,43 @@ type AppDatabase interface {
 	GetEventMVP(eventID int) (EventMVP, error)
 	DeleteVote(id int) error
 	HasDeviceVoted(eventID int, deviceID string) (bool, error)
-	SaveSelfie(eventID int, deviceID, caption, imagePath, contentType string) (Selfie, error)
+	SaveSelfie(selfie NewSelfie) (Selfie, error)
 	UpdateSelfieURL(id int, imageURL string) error
+	UpdateSelfieImagePath(id int, imagePath string) error
+	ListAllSelfies() ([]Selfie, error)
 	GetSelfieForDevice(eventID int, deviceID string) (Selfie, error)
 	GetSelfieByID(id int) (Selfie, error)
 	ListEventSelfies(eventID int) ([]Selfie, error)
 	ListApprovedSelfies(eventID int) ([]Selfie, error)
-	UpdateSelfieStatus(id int, approved bool, showOnScreen bool) error
+	ListSelfieQueue(eventID int, status string, limit int) ([]Selfie, error)
+	CountSelfieQueue(eventID int) (SelfieQueueCounts, error)
+	ClaimSelfies(eventID int, moderator string, limit int, now, until time.Time) ([]Selfie, error)
+	ReleaseSelfieClaims(eventID int, moderator string) (int, error)
+	ModerateSelfies(eventID int, ids []int, moderation SelfieModeration) (SelfieModerationResult, error)
 	DeleteSelfie(id int) error
+	GetScreenPlaylist(eventID int) (ScreenPlaylist, error)
+	SaveScreenPlaylist(playlist ScreenPlaylist) error
+	ListScreenSelfies(eventID int) ([]ScreenSelfie, error)
+	RecordScreenPlay(eventID, selfieID int, playedAt time.Time) (int, error)
+	GetSelfieFrame(eventID int) (SelfieFrame, error)
+	SaveSelfieFrame(frame SelfieFrame) (SelfieFrame, error)
+	DeleteSelfieFrame(eventID int) error
+	GetSelfieRendition(selfieID int) (SelfieRendition, error)
+	SaveSelfieRendition(rendition SelfieRendition) error
+	GetSelfieContest(eventID int) (SelfieContest, error)
+	SaveSelfieContest(contest SelfieContest) (SelfieContest, error)
+	AddSelfieContestVote(eventID, selfieID int, deviceID string, at time.Time) error
+	GetSelfieContestVote(eventID int, deviceID string) (int, error)
+	ListSelfieContestRanking(eventID int) ([]SelfieContestEntry, error)
+	AnnounceSelfieContestWinner(eventID, selfieID int, at time.Time) (SelfieContest, error)
+	ClearSelfieContestWinner(eventID int) error
+	ListTextFilterWords() ([]TextFilterWord, error)
+	AddTextFilterWord(word TextFilterWord, at time.Time) (TextFilterWord, error)
+	DeleteTextFilterWord(id int) error
+	ListTextFilterActions() (map[string]string, error)
+	SetTextFilterAction(target, action, updatedBy string, at time.Time) error
+	ListFlaggedTexts() ([]FlaggedText, error)
+	ReviewFlaggedText(target string, id int, remove bool) error
 	RecordReactionTestAttempt(eventID int, deviceID string, reactionMs int) (ReactionTestAttempt, error)
 	GetLatestReactionTestAttempt(eventID int, deviceID string) (ReactionTestAttempt, error)
 	GetReactionTestStats(eventID int) (ReactionTestStats, error)
@@ -334,17 +457,72 @@ type AppDatabase interface {
 	DeleteSponsor(id int) error
 	ListSponsors() ([]Sponsor, error)
 	ListActiveSponsors() ([]Sponsor, error)
-	RecordSponsorSession(eventID int, deviceID string) error
-	RecordSponsorExposure(eventID int, sponsorIDs []int, deviceID, exposureType string, durationMs int) error
-	RecordSponsorClick(eventID, sponsorID int, deviceID string) error
+	GetSponsor(id int) (Sponsor, error)
 	GetSponsorAnalytics(eventID int) (SponsorAnalytics, error)
 	GetSponsorClickStats(eventID int) ([]SponsorClickStat, error)
+	ListSponsorCampaigns(sponsorID int) ([]SponsorCampaign, error)
+	GetSponsorCampaign(id int) (SponsorCampaign, error)
+	SaveSponsorCampaign(campaign SponsorCampaign, at time.Time) (SponsorCampaign, error)
+	DeleteSponsorCampaign(id int) error
+	SaveSponsorCreative(creative SponsorCreative, at time.Time) (SponsorCreative, error)
+	DeleteSponsorCreative(id int) error
+	ListSponsorPlacements(eventID int, at time.Time) ([]SponsorPlacement, error)
+	GetSponsorCampaignStats(eventID int) ([]SponsorCampaignStat, error)
+	RecordSponsorTelemetry(batch SponsorTelemetryBatch) (int, error)
+	SaveSponsorAsset(asset SponsorAsset, at time.Time) (SponsorAsset, error)
+	GetSponsorAsset(hash string) (SponsorAsset, error)
+	IsMaintenanceTaskDone(name string) (bool, error)
+	CompleteMaintenanceTask(name string, at time.Time) error
+	CreateSponsorAccount(account SponsorAccount, at time.Time) (int, error)
+	ListSponsorAccounts(sponsorID int) ([]SponsorAccount, error)
+	GetSponsorAccountByUsername(username string) (SponsorAccount, error)
+	DeleteSponsorAccount(id int) error
+	GetSponsorPlan(id string) (SponsorPlan, error)
+	GetSponsorPlanCounts(eventID int, deviceID string) (SponsorPlanCounts, error)
+	PruneSponsorPlans(before time.Time) (int, error)
 	PurgeEventData(eventID int) error
+	ArchiveEvent(eventID int, summary, archivedBy string, archivedAt, purgeAfter time.Time) error
+	ListEventArchives() ([]EventArchive, error)
+	GetEventArchive(eventID int) (EventArchive, error)
+	ExportEventDataset(eventID int, dataset string, out ExportWriter) (int, error)
+	RestoreArchivedEvent(eventID int, now time.Time) error
+	PurgeExpiredEventArchives(now time.Time) ([]string, error)
+	ApplyRetention(cutoffs RetentionCutoffs) (RetentionReport, error)
+	ErasePersonalData(req ErasureRequest) (ErasureReceipt, error)
+	ListErasureReceipts() ([]ErasureReceipt, error)
 	RecordEventFeedback(feedback EventFeedback) error
 	GetEventFeedbackSummary(eventID int) (EventFeedbackSummary, error)
-	ListShopProducts() ([]ShopProduct, error)
-	GetShopProduct(id int) (ShopProduct, error)
-	CreateShopOrder(order ShopOrder, items []ShopOrderItem) (ShopOrder, error)
+	ListShopProducts(at time.Time) ([]ShopProduct, error)
+	GetShopProduct(id int, at time.Time) (ShopProduct, error)
+	CreateShopOrder(order ShopOrder, items []ShopOrderItem, reservationID string, at time.Time) (ShopOrder, error)
+	ListAllShopProducts(includeDeleted bool, at time.Time) ([]ShopProduct, error)
+	GetAnyShopProduct(id int, at time.Time) (ShopProduct, error)
+	SaveShopProduct(product ShopProduct, at time.Time) (ShopProduct, error)
+	DeleteShopProduct(id int, at time.Time) error
+	SaveShopProductVariant(variant ShopProductVariant, at time.Time) (ShopProductVariant, error)
+	DeleteShopProductVariant(productID, variantID int, at time.Time) error
+	ReserveShopStock(id string, items []ShopOrderItem, expiresAt, at time.Time) error
+	ReleaseShopReservation(id string) error
+	AdjustShopStock(change ShopStockChange, at time.Time) (ShopStockAdjustment, error)
+	ListShopStockAdjustments(productID int) ([]ShopStockAdjustment, error)
+	ListShopOrders(filter ShopOrderFilter) ([]ShopOrder, int, error)
+	GetShopOrder(id int) (ShopOrder, error)
+	UpdateShopOrderStatus(id int, status, admin, note string, at time.Time) (ShopOrder, error)
+	SetShopOrderPickupEvent(id, eventID int) error
+	NextPickupEventID(at time.Time) (int, error)
+	CreateShopPayment(payment ShopPayment, at time.Time) (ShopPayment, error)
+	ApplyShopPaymentEvent(event ShopPaymentEvent, at time.Time) (ShopPayment, bool, error)
+	ExpireShopPayments(createdBefore, at time.Time) ([]ShopPayment, error)
+	SetVoteContact(eventID int, ticketCode, email, locale string) error
+	GetVoteContact(voteID int) (VoteContact, error)
+	EnqueueMail(msg MailMessage, at time.Time) (MailMessage, bool, error)
+	ListDueMail(limit int, at time.Time) ([]MailMessage, error)
+	MarkMailSent(id int, at time.Time) error
+	MarkMailFailed(id int, lastError string, retryAt, at time.Time) error
+	ListMailOutbox(status string, limit int) ([]MailMessage, error)
+	RetryMail(id int, at time.Time) (MailMessage, error)
+	PurgeMailOutbox(before time.Time) (int, error)
+	Backup(destPath string) error
 	Ping() error
 }
 
@@ -352,10 +530,7 @@ type appdbimpl struct {
 	c *sql.DB
 }
 
-const maxSponsorSlots = 4
-
 var (
-	ErrMaxSponsors             = errors.New("maximum number of sponsors reached")
 	ErrInvalidSponsorPos       = errors.New("invalid sponsor position")
 	ErrInvalidSponsorData      = errors.New("invalid sponsor data")
 	ErrPrizeAlreadyAssigned    = errors.New("prize already has a winner")
@@ -364,6 +539,8 @@ var (
 	ErrPrizeLockedByWinner     = errors.New("cannot remove a prize that already has a winner")
 	ErrTicketSignatureMismatch = errors.New("ticket signature mismatch")
 	ErrEventAlreadyConcluded   = errors.New("event already concluded")
+	ErrArchiveNotRestorable    = errors.New("archive grace period expired")
+	ErrInvalidErasureRequest   = errors.New("invalid erasure request")
 )
 
 type rowScanner interface {
@@ -527,6 +704,13 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_votes_event ON votes (event_id);`); err != nil {
 		return nil, fmt.Errorf("error ensuring votes event index: %w", err)
 	}
+	for _, column := range []string{"contact_email TEXT NOT NULL DEFAULT ''", "contact_locale TEXT NOT NULL DEFAULT ''"} {
+		if _, err = db.Exec(`ALTER TABLE votes ADD COLUMN ` + column); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring votes %s column: %w", strings.Fields(column)[0], err)
+			}
+		}
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='selfies';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -552,6 +736,28 @@ func New(db *sql.DB) (AppDatabase, error) {
 		return nil, fmt.Errorf("error verifying selfies table: %w", err)
 	}
 
+	if _, err = db.Exec(`ALTER TABLE selfies ADD COLUMN status TEXT NOT NULL DEFAULT 'pending'`); err != nil {
+		if !strings.Contains(err.Error(), "duplicate column name") {
+			return nil, fmt.Errorf("error ensuring selfies status column: %w", err)
+		}
+	} else if _, err = db.Exec(`UPDATE selfies SET status = 'approved' WHERE approved = 1`); err != nil {
+		return nil, fmt.Errorf("error migrating selfies status: %w", err)
+	}
+	for _, column := range []string{"rejection_reason", "moderated_by", "moderated_at", "claimed_by", "claimed_until", "perceptual_hash"} {
+		if _, err = db.Exec(`ALTER TABLE selfies ADD COLUMN ` + column + ` TEXT NOT NULL DEFAULT ''`); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring selfies %s column: %w", column, err)
+			}
+		}
+	}
+	for _, column := range []string{"duplicate_of", "caption_flagged"} {
+		if _, err = db.Exec(`ALTER TABLE selfies ADD COLUMN ` + column + ` INTEGER NOT NULL DEFAULT 0`); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring selfies %s column: %w", column, err)
+			}
+		}
+	}
+
 	if _, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_selfies_event_device ON selfies(event_id, device_id);`); err != nil {
 		return nil, fmt.Errorf("error ensuring selfies device index: %w", err)
 	}
@@ -561,6 +767,9 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_selfies_approved ON selfies(event_id, approved);`); err != nil {
 		return nil, fmt.Errorf("error ensuring selfies approval index: %w", err)
 	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_selfies_status ON selfies(event_id, status);`); err != nil {
+		return nil, fmt.Errorf("error ensuring selfies status index: %w", err)
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='reaction_tests';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -613,6 +822,11 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_event_feedback_event ON event_feedback(event_id)`); err != nil {
 		return nil, fmt.Errorf("error ensuring event_feedback event index: %w", err)
 	}
+	if _, err = db.Exec(`ALTER TABLE event_feedback ADD COLUMN suggestion_flagged INTEGER NOT NULL DEFAULT 0`); err != nil {
+		if !strings.Contains(err.Error(), "duplicate column name") {
+			return nil, fmt.Errorf("error ensuring event_feedback suggestion_flagged column: %w", err)
+		}
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='tickets';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -645,12 +859,15 @@ func New(db *sql.DB) (AppDatabase, error) {
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsors';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
-		sqlStmt := `CREATE TABLE sponsors (id INTEGER PRIMARY KEY AUTOINCREMENT, position INTEGER NOT NULL UNIQUE, name TEXT NOT NULL, logo_data TEXT NOT NULL, link_url TEXT, is_active INTEGER NOT NULL DEFAULT 1, CHECK(position BETWEEN 1 AND ` + fmt.Sprint(maxSponsorSlots) + `));`
+		sqlStmt := `CREATE TABLE sponsors (id INTEGER PRIMARY KEY AUTOINCREMENT, position INTEGER NOT NULL DEFAULT 1, name TEXT NOT NULL, logo_data TEXT NOT NULL, link_url TEXT, is_active INTEGER NOT NULL DEFAULT 1);`
 		_, err = db.Exec(sqlStmt)
 		if err != nil {
 			return nil, fmt.Errorf("error creating sponsors table: %w", err)
 		}
 	}
+	if err = migrateSponsorSlots(db); err != nil {
+		return nil, fmt.Errorf("error migrating sponsors table: %w", err)
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_clicks';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -736,6 +953,16 @@ func New(db *sql.DB) (AppDatabase, error) {
 		return nil, fmt.Errorf("error ensuring sponsor_exposures device index: %w", err)
 	}
 
+	for _, table := range []string{"sponsor_clicks", "sponsor_exposures"} {
+		for _, column := range []string{"campaign_id", "creative_id"} {
+			if _, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` INTEGER NOT NULL DEFAULT 0`); err != nil {
+				if !strings.Contains(err.Error(), "duplicate column name") {
+					return nil, fmt.Errorf("error ensuring %s %s column: %w", table, column, err)
+				}
+			}
+		}
+	}
+
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_products';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
 		sqlStmt := `CREATE TABLE shop_products (
@@ -756,6 +983,13 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_products_name ON shop_products(name)`); err != nil {
 		return nil, fmt.Errorf("error ensuring shop_products name index: %w", err)
 	}
+	for _, column := range []string{"category TEXT NOT NULL DEFAULT ''", "sort_order INTEGER NOT NULL DEFAULT 0", "is_active INTEGER NOT NULL DEFAULT 1", "updated_at TEXT NOT NULL DEFAULT ''", "deleted_at TEXT NOT NULL DEFAULT ''", "stock INTEGER"} {
+		if _, err = db.Exec(`ALTER TABLE shop_products ADD COLUMN ` + column); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring shop_products %s column: %w", strings.Fields(column)[0], err)
+			}
+		}
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_orders';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -773,6 +1007,110 @@ func New(db *sql.DB) (AppDatabase, error) {
 	} else if err != nil {
 		return nil, fmt.Errorf("error verifying shop_orders table: %w", err)
 	}
+	for _, column := range []string{"notes_flagged INTEGER NOT NULL DEFAULT 0", "status TEXT NOT NULL DEFAULT 'pending_payment'", "status_updated_at TEXT NOT NULL DEFAULT ''", "pickup_event_id INTEGER", "locale TEXT NOT NULL DEFAULT ''"} {
+		if _, err = db.Exec(`ALTER TABLE shop_orders ADD COLUMN ` + column); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring shop_orders %s column: %w", strings.Fields(column)[0], err)
+			}
+		}
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_orders_status ON shop_orders(status, pickup_event_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_orders status index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_order_status_changes';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_order_status_changes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        order_id INTEGER NOT NULL,
+        from_status TEXT NOT NULL,
+        to_status TEXT NOT NULL,
+        admin TEXT NOT NULL,
+        note TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (order_id) REFERENCES shop_orders(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_order_status_changes table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_order_status_changes table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_order_status_changes_order ON shop_order_status_changes(order_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_order_status_changes order index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_payments';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_payments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        order_id INTEGER NOT NULL,
+        provider TEXT NOT NULL,
+        intent_id TEXT NOT NULL,
+        amount_cents INTEGER NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        checkout_url TEXT NOT NULL DEFAULT '',
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL,
+        UNIQUE (provider, intent_id),
+        FOREIGN KEY (order_id) REFERENCES shop_orders(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_payments table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_payments table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_payments_order ON shop_payments(order_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_payments order index: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_payments_status ON shop_payments(status, created_at)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_payments status index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_payment_events';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_payment_events (
+        provider TEXT NOT NULL,
+        event_id TEXT NOT NULL,
+        intent_id TEXT NOT NULL,
+        succeeded INTEGER NOT NULL,
+        received_at TEXT NOT NULL,
+        PRIMARY KEY (provider, event_id)
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_payment_events table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_payment_events table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='mail_outbox';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE mail_outbox (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        kind TEXT NOT NULL,
+        dedupe_key TEXT NOT NULL UNIQUE,
+        recipient TEXT NOT NULL,
+        subject TEXT NOT NULL,
+        text_body TEXT NOT NULL,
+        html_body TEXT NOT NULL,
+        status TEXT NOT NULL DEFAULT 'pending',
+        attempts INTEGER NOT NULL DEFAULT 0,
+        next_attempt_at TEXT NOT NULL,
+        last_error TEXT NOT NULL DEFAULT '',
+        created_at TEXT NOT NULL,
+        sent_at TEXT NOT NULL DEFAULT ''
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating mail_outbox table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying mail_outbox table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_mail_outbox_due ON mail_outbox(status, next_attempt_at)`); err != nil {
+		return nil, fmt.Errorf("error ensuring mail_outbox due index: %w", err)
+	}
 
 	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_order_items';`).Scan(&tableName)
 	if errors.Is(err, sql.ErrNoRows) {
@@ -800,6 +1138,480 @@ func New(db *sql.DB) (AppDatabase, error) {
 	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_order_items_product ON shop_order_items(product_id)`); err != nil {
 		return nil, fmt.Errorf("error ensuring shop_order_items product index: %w", err)
 	}
+	for _, column := range []string{"variant_id INTEGER NOT NULL DEFAULT 0", "variant_name TEXT NOT NULL DEFAULT ''"} {
+		if _, err = db.Exec(`ALTER TABLE shop_order_items ADD COLUMN ` + column); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring shop_order_items %s column: %w", strings.Fields(column)[0], err)
+			}
+		}
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_product_variants';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_product_variants (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        product_id INTEGER NOT NULL,
+        name TEXT NOT NULL,
+        sort_order INTEGER NOT NULL DEFAULT 0,
+        is_active INTEGER NOT NULL DEFAULT 1,
+        stock INTEGER,
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL,
+        deleted_at TEXT NOT NULL DEFAULT '',
+        FOREIGN KEY (product_id) REFERENCES shop_products(id)
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_product_variants table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_product_variants table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_product_variants_product ON shop_product_variants(product_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_product_variants product index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_stock_reservations';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_stock_reservations (
+        id TEXT NOT NULL,
+        product_id INTEGER NOT NULL,
+        variant_id INTEGER NOT NULL DEFAULT 0,
+        quantity INTEGER NOT NULL,
+        expires_at TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        PRIMARY KEY (id, product_id, variant_id)
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_stock_reservations table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_stock_reservations table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_stock_reservations_product ON shop_stock_reservations(product_id, variant_id, expires_at)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_stock_reservations product index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='shop_stock_adjustments';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE shop_stock_adjustments (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        product_id INTEGER NOT NULL,
+        variant_id INTEGER NOT NULL DEFAULT 0,
+        delta INTEGER NOT NULL,
+        stock_after INTEGER NOT NULL,
+        reason TEXT NOT NULL,
+        admin TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (product_id) REFERENCES shop_products(id)
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating shop_stock_adjustments table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying shop_stock_adjustments table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_shop_stock_adjustments_product ON shop_stock_adjustments(product_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring shop_stock_adjustments product index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='event_archives';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE event_archives (
+        event_id INTEGER PRIMARY KEY,
+        summary TEXT NOT NULL,
+        payload BLOB,
+        archived_by TEXT,
+        archived_at TEXT NOT NULL,
+        purge_after TEXT NOT NULL,
+        purged_at TEXT
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating event_archives table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying event_archives table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='privacy_erasures';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE privacy_erasures (
+        id TEXT PRIMARY KEY,
+        subject_type TEXT NOT NULL,
+        subject_hash TEXT NOT NULL,
+        requested_by TEXT,
+        executed_at TEXT NOT NULL,
+        actions TEXT NOT NULL
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating privacy_erasures table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying privacy_erasures table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='screen_playlists';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE screen_playlists (
+        event_id INTEGER PRIMARY KEY,
+        order_mode TEXT NOT NULL,
+        dwell_seconds INTEGER NOT NULL,
+        max_repeats INTEGER NOT NULL DEFAULT 0,
+        updated_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating screen_playlists table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying screen_playlists table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='screen_pins';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE screen_pins (
+        event_id INTEGER NOT NULL,
+        selfie_id INTEGER NOT NULL,
+        position INTEGER NOT NULL,
+        PRIMARY KEY (event_id, selfie_id),
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+        FOREIGN KEY (selfie_id) REFERENCES selfies(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating screen_pins table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying screen_pins table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='screen_plays';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE screen_plays (
+        selfie_id INTEGER PRIMARY KEY,
+        event_id INTEGER NOT NULL,
+        plays INTEGER NOT NULL DEFAULT 0,
+        last_played_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+        FOREIGN KEY (selfie_id) REFERENCES selfies(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating screen_plays table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying screen_plays table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_screen_plays_event ON screen_plays(event_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring screen_plays event index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='selfie_frames';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE selfie_frames (
+        event_id INTEGER PRIMARY KEY,
+        overlay_data TEXT NOT NULL DEFAULT '',
+        caption_position TEXT NOT NULL,
+        sponsor_id INTEGER NOT NULL DEFAULT 0,
+        logo_position TEXT NOT NULL,
+        revision INTEGER NOT NULL DEFAULT 1,
+        updated_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating selfie_frames table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying selfie_frames table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='selfie_renditions';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE selfie_renditions (
+        selfie_id INTEGER PRIMARY KEY,
+        event_id INTEGER NOT NULL,
+        source_path TEXT NOT NULL,
+        frame_revision INTEGER NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+        FOREIGN KEY (selfie_id) REFERENCES selfies(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating selfie_renditions table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying selfie_renditions table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='selfie_contests';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE selfie_contests (
+        event_id INTEGER PRIMARY KEY,
+        status TEXT NOT NULL,
+        prize_id INTEGER NOT NULL DEFAULT 0,
+        winner_selfie_id INTEGER NOT NULL DEFAULT 0,
+        announced_at TEXT NOT NULL DEFAULT '',
+        updated_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating selfie_contests table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying selfie_contests table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='selfie_contest_votes';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE selfie_contest_votes (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        event_id INTEGER NOT NULL,
+        selfie_id INTEGER NOT NULL,
+        device_id TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE,
+        FOREIGN KEY (selfie_id) REFERENCES selfies(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating selfie_contest_votes table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying selfie_contest_votes table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS unique_selfie_contest_vote_per_event_device ON selfie_contest_votes (event_id, device_id);`); err != nil {
+		return nil, fmt.Errorf("error ensuring selfie_contest_votes device index: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_selfie_contest_votes_selfie ON selfie_contest_votes (selfie_id);`); err != nil {
+		return nil, fmt.Errorf("error ensuring selfie_contest_votes selfie index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='text_filter_words';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE text_filter_words (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        word TEXT NOT NULL,
+        list TEXT NOT NULL,
+        language TEXT NOT NULL DEFAULT '',
+        created_by TEXT NOT NULL DEFAULT '',
+        created_at TEXT NOT NULL,
+        UNIQUE(list, word)
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating text_filter_words table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying text_filter_words table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='text_filter_actions';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE text_filter_actions (
+        target TEXT PRIMARY KEY,
+        action TEXT NOT NULL,
+        updated_by TEXT NOT NULL DEFAULT '',
+        updated_at TEXT NOT NULL
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating text_filter_actions table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying text_filter_actions table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_campaigns';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_campaigns (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sponsor_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        starts_at TEXT NOT NULL DEFAULT '',
+        ends_at TEXT NOT NULL DEFAULT '',
+        weight INTEGER NOT NULL DEFAULT 1,
+        priority INTEGER NOT NULL DEFAULT 0,
+        is_active INTEGER NOT NULL DEFAULT 1,
+        created_at TEXT NOT NULL,
+        updated_at TEXT NOT NULL,
+        FOREIGN KEY (sponsor_id) REFERENCES sponsors(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_campaigns table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_campaigns table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_campaign_events';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_campaign_events (
+        campaign_id INTEGER NOT NULL,
+        event_id INTEGER NOT NULL,
+        PRIMARY KEY (campaign_id, event_id),
+        FOREIGN KEY (campaign_id) REFERENCES sponsor_campaigns(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_campaign_events table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_campaign_events table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_creatives';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_creatives (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        campaign_id INTEGER NOT NULL,
+        name TEXT NOT NULL DEFAULT '',
+        logo_data TEXT NOT NULL,
+        link_url TEXT NOT NULL DEFAULT '',
+        weight INTEGER NOT NULL DEFAULT 1,
+        is_active INTEGER NOT NULL DEFAULT 1,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (campaign_id) REFERENCES sponsor_campaigns(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_creatives table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_creatives table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_assets';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_assets (
+        hash TEXT PRIMARY KEY,
+        content_type TEXT NOT NULL,
+        width INTEGER NOT NULL DEFAULT 0,
+        height INTEGER NOT NULL DEFAULT 0,
+        size INTEGER NOT NULL DEFAULT 0,
+        created_at TEXT NOT NULL
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_assets table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_assets table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='maintenance_tasks';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE maintenance_tasks (
+        name TEXT PRIMARY KEY,
+        completed_at TEXT NOT NULL
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating maintenance_tasks table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying maintenance_tasks table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_accounts';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_accounts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        sponsor_id INTEGER NOT NULL,
+        username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+        password_hash TEXT NOT NULL,
+        created_at TEXT NOT NULL,
+        FOREIGN KEY (sponsor_id) REFERENCES sponsors(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_accounts table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_accounts table: %w", err)
+	}
+
+	for _, column := range []string{"max_views_per_device", "min_dwell_ms", "share_of_voice"} {
+		if _, err = db.Exec(`ALTER TABLE sponsor_campaigns ADD COLUMN ` + column + ` INTEGER NOT NULL DEFAULT 0`); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring sponsor_campaigns %s column: %w", column, err)
+			}
+		}
+	}
+	if _, err = db.Exec(`ALTER TABLE sponsor_campaigns ADD COLUMN exclusive_screen TEXT NOT NULL DEFAULT ''`); err != nil {
+		if !strings.Contains(err.Error(), "duplicate column name") {
+			return nil, fmt.Errorf("error ensuring sponsor_campaigns exclusive_screen column: %w", err)
+		}
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_plans';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_plans (
+        id TEXT PRIMARY KEY,
+        event_id INTEGER NOT NULL,
+        device_id TEXT NOT NULL,
+        issued_at TEXT NOT NULL,
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_plans table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_plans table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_sponsor_plans_event_device ON sponsor_plans(event_id, device_id)`); err != nil {
+		return nil, fmt.Errorf("error ensuring sponsor_plans event index: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_plan_slots';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_plan_slots (
+        plan_id TEXT NOT NULL,
+        screen TEXT NOT NULL,
+        position INTEGER NOT NULL,
+        sponsor_id INTEGER NOT NULL,
+        campaign_id INTEGER NOT NULL DEFAULT 0,
+        creative_id INTEGER NOT NULL DEFAULT 0,
+        min_dwell_ms INTEGER NOT NULL DEFAULT 0,
+        PRIMARY KEY (plan_id, screen, position),
+        FOREIGN KEY (plan_id) REFERENCES sponsor_plans(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_plan_slots table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_plan_slots table: %w", err)
+	}
+
+	err = db.QueryRow(`SELECT name FROM sqlite_master WHERE type='table' AND name='sponsor_plan_counts';`).Scan(&tableName)
+	if errors.Is(err, sql.ErrNoRows) {
+		sqlStmt := `CREATE TABLE sponsor_plan_counts (
+        event_id INTEGER NOT NULL,
+        campaign_id INTEGER NOT NULL,
+        device_id TEXT NOT NULL,
+        plans INTEGER NOT NULL,
+        updated_at TEXT NOT NULL,
+        PRIMARY KEY (event_id, campaign_id, device_id),
+        FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);`
+		if _, err = db.Exec(sqlStmt); err != nil {
+			return nil, fmt.Errorf("error creating sponsor_plan_counts table: %w", err)
+		}
+		// The counts of the plans issued before the table existed
+		if _, err = db.Exec(`
+INSERT INTO sponsor_plan_counts (event_id, campaign_id, device_id, plans, updated_at)
+SELECT event_id, 0, '', COUNT(*), MAX(issued_at) FROM sponsor_plans GROUP BY event_id
+UNION ALL
+SELECT event_id, 0, device_id, COUNT(*), MAX(issued_at) FROM sponsor_plans GROUP BY event_id, device_id
+UNION ALL
+SELECT p.event_id, s.campaign_id, '', COUNT(DISTINCT p.id), MAX(p.issued_at)
+FROM sponsor_plans p JOIN sponsor_plan_slots s ON s.plan_id = p.id WHERE s.campaign_id > 0 GROUP BY p.event_id, s.campaign_id
+UNION ALL
+SELECT p.event_id, s.campaign_id, p.device_id, COUNT(DISTINCT p.id), MAX(p.issued_at)
+FROM sponsor_plans p JOIN sponsor_plan_slots s ON s.plan_id = p.id WHERE s.campaign_id > 0 GROUP BY p.event_id, s.campaign_id, p.device_id`); err != nil {
+			return nil, fmt.Errorf("error filling sponsor_plan_counts table: %w", err)
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("error verifying sponsor_plan_counts table: %w", err)
+	}
+	if _, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_sponsor_plans_issued_at ON sponsor_plans(issued_at)`); err != nil {
+		return nil, fmt.Errorf("error ensuring sponsor_plans issue index: %w", err)
+	}
+
+	for _, table := range []string{"sponsors", "sponsor_creatives"} {
+		if _, err = db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN logo_asset TEXT NOT NULL DEFAULT ''`); err != nil {
+			if !strings.Contains(err.Error(), "duplicate column name") {
+				return nil, fmt.Errorf("error ensuring %s logo_asset column: %w", table, err)
+			}
+		}
+	}
 
 	var shopProductCount int
 	if err = db.QueryRow(`SELECT COUNT(*) FROM shop_products`).Scan(&shopProductCount); err != nil {
@@ -862,6 +1674,29 @@ func (db *appdbimpl) AddVote(eventID, playerID int, code, signature, deviceID st
 	return err
 }
 
+// SetVoteContact saves the email where the voter wants to be told when their ticket wins a prize. It returns
+// sql.ErrNoRows when the ticket does not exist.
+func (db *appdbimpl) SetVoteContact(eventID int, ticketCode, email, locale string) error {
+	res, err := db.c.Exec(`UPDATE votes SET contact_email = ?, contact_locale = ? WHERE event_id = ? AND ticket_code = ?`,
+		strings.TrimSpace(email), locale, eventID, ticketCode)
+	if err != nil {
+		return err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetVoteContact returns the contact left by the voter, with an empty email when there is none.
+func (db *appdbimpl) GetVoteContact(voteID int) (VoteContact, error) {
+	var contact VoteContact
+	err := db.c.QueryRow(`SELECT contact_email, contact_locale FROM votes WHERE id = ?`, voteID).Scan(&contact.Email, &contact.Locale)
+	return contact, err
+}
+
 // GetEventVoteCount returns the total number of votes for a specific event
 func (db *appdbimpl) GetEventVoteCount(eventID int) (int, error) {
 	var count int
@@ -999,15 +1834,23 @@ func (db *appdbimpl) HasDeviceVoted(eventID int, deviceID string) (bool, error)
 	return exists == 1, nil
 }
 
-func scanSelfieRow(scanner rowScanner) (Selfie, error) {
+// selfieColumns are the columns read by scanSelfieRow.
+const selfieColumns = `id, event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at,
+        status, rejection_reason, moderated_by, moderated_at, claimed_by, claimed_until, perceptual_hash, duplicate_of, caption_flagged`
+
+// scanSelfieRow reads the selfieColumns, followed by the extra columns of the query, if any.
+func scanSelfieRow(scanner rowScanner, extra ...interface{}) (Selfie, error) {
 	var s Selfie
-	var approved, showOnScreen int
+	var approved, showOnScreen, captionFlagged int
 	var createdRaw string
-	if err := scanner.Scan(&s.ID, &s.EventID, &s.DeviceID, &s.Caption, &s.ImagePath, &s.ImageURL, &s.ContentType, &approved, &showOnScreen, &createdRaw); err != nil {
+	dest := []interface{}{&s.ID, &s.EventID, &s.DeviceID, &s.Caption, &s.ImagePath, &s.ImageURL, &s.ContentType, &approved, &showOnScreen, &createdRaw,
+		&s.Status, &s.RejectionReason, &s.ModeratedBy, &s.ModeratedAt, &s.ClaimedBy, &s.ClaimedUntil, &s.PerceptualHash, &s.DuplicateOfID, &captionFlagged}
+	if err := scanner.Scan(append(dest, extra...)...); err != nil {
 		return Selfie{}, err
 	}
 	s.Approved = approved == 1
 	s.ShowOnScreen = showOnScreen == 1
+	s.CaptionFlagged = captionFlagged == 1
 	if ts, err := parseSQLiteTimestamp(createdRaw); err == nil && !ts.IsZero() {
 		s.CreatedAt = ts.UTC().Format(time.RFC3339)
 	} else {
@@ -1016,12 +1859,16 @@ func scanSelfieRow(scanner rowScanner) (Selfie, error) {
 	return s, nil
 }
 
-func (db *appdbimpl) SaveSelfie(eventID int, deviceID, caption, imagePath, contentType string) (Selfie, error) {
-	deviceID = strings.TrimSpace(deviceID)
-	if eventID <= 0 || deviceID == "" || strings.TrimSpace(imagePath) == "" {
+// SaveSelfie stores the selfie of the device for the event, replacing the previous one and dropping its contest votes.
+// The new selfie is pending moderation.
+func (db *appdbimpl) SaveSelfie(selfie NewSelfie) (Selfie, error) {
+	eventID := selfie.EventID
+	deviceID := strings.TrimSpace(selfie.DeviceID)
+	if eventID <= 0 || deviceID == "" || strings.TrimSpace(selfie.ImagePath) == "" {
 		return Selfie{}, fmt.Errorf("invalid selfie payload")
 	}
 
+	caption := selfie.Caption
 	if len([]rune(caption)) > 80 {
 		caption = string([]rune(caption)[:80])
 	}
@@ -1032,9 +1879,9 @@ func (db *appdbimpl) SaveSelfie(eventID int, deviceID, caption, imagePath, conte
 	}
 	defer tx.Rollback()
 
-	result, err := tx.Exec(`
-INSERT INTO selfies (event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at)
-VALUES (?, ?, ?, ?, '', ?, 0, 0, CURRENT_TIMESTAMP)
+	_, err = tx.Exec(`
+INSERT INTO selfies (event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at, status, perceptual_hash, duplicate_of, caption_flagged)
+VALUES (?, ?, ?, ?, '', ?, 0, 0, CURRENT_TIMESTAMP, 'pending', ?, ?, ?)
 ON CONFLICT(event_id, device_id) DO UPDATE SET
         caption=excluded.caption,
         image_path=excluded.image_path,
@@ -1042,20 +1889,30 @@ ON CONFLICT(event_id, device_id) DO UPDATE SET
         content_type=excluded.content_type,
         approved=0,
         show_on_screen=0,
-        created_at=CURRENT_TIMESTAMP
-`, eventID, deviceID, strings.TrimSpace(caption), strings.TrimSpace(imagePath), strings.TrimSpace(contentType))
+        created_at=CURRENT_TIMESTAMP,
+        status='pending',
+        rejection_reason='',
+        moderated_by='',
+        moderated_at='',
+        claimed_by='',
+        claimed_until='',
+        perceptual_hash=excluded.perceptual_hash,
+        duplicate_of=excluded.duplicate_of,
+        caption_flagged=excluded.caption_flagged
+`, eventID, deviceID, strings.TrimSpace(caption), strings.TrimSpace(selfie.ImagePath), strings.TrimSpace(selfie.ContentType), strings.TrimSpace(selfie.PerceptualHash), selfie.DuplicateOfID, selfie.CaptionFlagged)
 	if err != nil {
 		return Selfie{}, err
 	}
 
+	// LastInsertId is not reliable when the upsert updates the previous selfie: it can return the row inserted last
+	// in another table
 	var selfieID int
-	if id, err := result.LastInsertId(); err == nil && id > 0 {
-		selfieID = int(id)
+	if err := tx.QueryRow(`SELECT id FROM selfies WHERE event_id = ? AND device_id = ?`, eventID, deviceID).Scan(&selfieID); err != nil {
+		return Selfie{}, err
 	}
-	if selfieID == 0 {
-		if err := tx.QueryRow(`SELECT id FROM selfies WHERE event_id = ? AND device_id = ?`, eventID, deviceID).Scan(&selfieID); err != nil {
-			return Selfie{}, err
-		}
+	// The contest votes were given to the previous picture
+	if _, err := tx.Exec(`DELETE FROM selfie_contest_votes WHERE selfie_id = ?`, selfieID); err != nil {
+		return Selfie{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -1070,18 +1927,41 @@ func (db *appdbimpl) UpdateSelfieURL(id int, imageURL string) error {
 	return err
 }
 
+func (db *appdbimpl) UpdateSelfieImagePath(id int, imagePath string) error {
+	_, err := db.c.Exec(`UPDATE selfies SET image_path = ? WHERE id = ?`, strings.TrimSpace(imagePath), id)
+	return err
+}
+
+func (db *appdbimpl) ListAllSelfies() ([]Selfie, error) {
+	rows, err := db.c.Query(`SELECT ` + selfieColumns + ` FROM selfies ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var selfies []Selfie
+	for rows.Next() {
+		selfie, err := scanSelfieRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		selfies = append(selfies, selfie)
+	}
+	return selfies, rows.Err()
+}
+
 func (db *appdbimpl) GetSelfieForDevice(eventID int, deviceID string) (Selfie, error) {
-	row := db.c.QueryRow(`SELECT id, event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at FROM selfies WHERE event_id = ? AND device_id = ?`, eventID, deviceID)
+	row := db.c.QueryRow(`SELECT `+selfieColumns+` FROM selfies WHERE event_id = ? AND device_id = ?`, eventID, deviceID)
 	return scanSelfieRow(row)
 }
 
 func (db *appdbimpl) GetSelfieByID(id int) (Selfie, error) {
-	row := db.c.QueryRow(`SELECT id, event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at FROM selfies WHERE id = ?`, id)
+	row := db.c.QueryRow(`SELECT `+selfieColumns+` FROM selfies WHERE id = ?`, id)
 	return scanSelfieRow(row)
 }
 
 func (db *appdbimpl) ListEventSelfies(eventID int) ([]Selfie, error) {
-	rows, err := db.c.Query(`SELECT id, event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at FROM selfies WHERE event_id = ? ORDER BY created_at DESC, id DESC`, eventID)
+	rows, err := db.c.Query(`SELECT `+selfieColumns+` FROM selfies WHERE event_id = ? ORDER BY created_at DESC, id DESC`, eventID)
 	if err != nil {
 		return nil, err
 	}
@@ -1104,7 +1984,7 @@ func (db *appdbimpl) ListEventSelfies(eventID int) ([]Selfie, error) {
 }
 
 func (db *appdbimpl) ListApprovedSelfies(eventID int) ([]Selfie, error) {
-	rows, err := db.c.Query(`SELECT id, event_id, device_id, caption, image_path, image_url, content_type, approved, show_on_screen, created_at FROM selfies WHERE event_id = ? AND approved = 1 ORDER BY created_at DESC, id DESC`, eventID)
+	rows, err := db.c.Query(`SELECT `+selfieColumns+` FROM selfies WHERE event_id = ? AND approved = 1 ORDER BY created_at DESC, id DESC`, eventID)
 	if err != nil {
 		return nil, err
 	}
@@ -1124,14 +2004,6 @@ func (db *appdbimpl) ListApprovedSelfies(eventID int) ([]Selfie, error) {
 	return selfies, nil
 }
 
-func (db *appdbimpl) UpdateSelfieStatus(id int, approved bool, showOnScreen bool) error {
-	if !approved {
-		showOnScreen = false
-	}
-	_, err := db.c.Exec(`UPDATE selfies SET approved = ?, show_on_screen = ? WHERE id = ?`, boolToInt(approved), boolToInt(showOnScreen), id)
-	return err
-}
-
 func (db *appdbimpl) DeleteSelfie(id int) error {
 	_, err := db.c.Exec(`DELETE FROM selfies WHERE id = ?`, id)
 	return err
@@ -1487,6 +2359,22 @@ func (db *appdbimpl) PurgeEventData(eventID int) error {
 		return err
 	}
 
+	if _, err := tx.Exec(`DELETE FROM sponsor_campaign_events WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sponsor_plans WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM sponsor_plan_counts WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE shop_orders SET pickup_event_id = NULL WHERE pickup_event_id = ?`, eventID); err != nil {
+		return err
+	}
+
 	if _, err := tx.Exec(`DELETE FROM votes WHERE event_id = ?`, eventID); err != nil {
 		return err
 	}
@@ -1878,46 +2766,50 @@ func (db *appdbimpl) AssignPrizeWinner(eventID, prizeID, voteID int) (EventPrize
 	}
 	defer tx.Rollback()
 
+	if err := assignPrizeWinnerTx(tx, eventID, prizeID, voteID); err != nil {
+		return EventPrize{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return EventPrize{}, err
+	}
+
+	return db.getEventPrize(prizeID)
+}
+
+func assignPrizeWinnerTx(tx *sql.Tx, eventID, prizeID, voteID int) error {
 	var prizeEventID int
 	var winnerID sql.NullInt64
 	if err := tx.QueryRow(`SELECT event_id, winner_vote_id FROM event_prizes WHERE id = ?`, prizeID).Scan(&prizeEventID, &winnerID); err != nil {
-		return EventPrize{}, err
+		return err
 	}
 	if prizeEventID != eventID {
-		return EventPrize{}, sql.ErrNoRows
+		return sql.ErrNoRows
 	}
 	if winnerID.Valid && winnerID.Int64 > 0 {
-		return EventPrize{}, ErrPrizeAlreadyAssigned
+		return ErrPrizeAlreadyAssigned
 	}
 
 	var voteEventID int
 	if err := tx.QueryRow(`SELECT event_id FROM votes WHERE id = ?`, voteID).Scan(&voteEventID); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return EventPrize{}, ErrPrizeVoteMismatch
+			return ErrPrizeVoteMismatch
 		}
-		return EventPrize{}, err
+		return err
 	}
 	if voteEventID != eventID {
-		return EventPrize{}, ErrPrizeVoteMismatch
+		return ErrPrizeVoteMismatch
 	}
 
 	var alreadyAssigned int
 	if err := tx.QueryRow(`SELECT COUNT(1) FROM event_prizes WHERE event_id = ? AND winner_vote_id = ?`, eventID, voteID).Scan(&alreadyAssigned); err != nil {
-		return EventPrize{}, err
+		return err
 	}
 	if alreadyAssigned > 0 {
-		return EventPrize{}, ErrPrizeWinnerConflict
-	}
-
-	if _, err := tx.Exec(`UPDATE event_prizes SET winner_vote_id = ?, winner_assigned_at = CURRENT_TIMESTAMP WHERE id = ?`, voteID, prizeID); err != nil {
-		return EventPrize{}, err
-	}
-
-	if err := tx.Commit(); err != nil {
-		return EventPrize{}, err
+		return ErrPrizeWinnerConflict
 	}
 
-	return db.getEventPrize(prizeID)
+	_, err := tx.Exec(`UPDATE event_prizes SET winner_vote_id = ?, winner_assigned_at = CURRENT_TIMESTAMP WHERE id = ?`, voteID, prizeID)
+	return err
 }
 
 func (db *appdbimpl) ClearPrizeWinner(eventID, prizeID int) error {
@@ -2036,7 +2928,7 @@ func (db *appdbimpl) GetAdminByID(id int) (Admin, error) {
 // Sponsor operations
 func (db *appdbimpl) CreateSponsor(s Sponsor) (int, error) {
 	sanitizedName := strings.TrimSpace(s.Name)
-	if strings.TrimSpace(s.LogoData) == "" {
+	if strings.TrimSpace(s.LogoData) == "" && s.LogoAsset == "" {
 		return 0, ErrInvalidSponsorData
 	}
 
@@ -2044,12 +2936,9 @@ func (db *appdbimpl) CreateSponsor(s Sponsor) (int, error) {
 	if err := db.c.QueryRow(`SELECT COUNT(*) FROM sponsors`).Scan(&total); err != nil {
 		return 0, err
 	}
-	if total >= maxSponsorSlots {
-		return 0, ErrMaxSponsors
-	}
 
 	position := s.Position
-	if position <= 0 || position > maxSponsorSlots {
+	if position <= 0 {
 		nextPos, err := db.nextSponsorPosition()
 		if err != nil {
 			return 0, err
@@ -2063,11 +2952,8 @@ func (db *appdbimpl) CreateSponsor(s Sponsor) (int, error) {
 		position = total + 1
 	}
 
-	res, err := db.c.Exec(`INSERT INTO sponsors (position, name, logo_data, link_url, is_active) VALUES (?, ?, ?, ?, ?)`, position, sanitizedName, s.LogoData, sanitizedLink, boolToInt(isActive))
+	res, err := db.c.Exec(`INSERT INTO sponsors (position, name, logo_data, logo_asset, link_url, is_active) VALUES (?, ?, ?, ?, ?, ?)`, position, sanitizedName, s.LogoData, s.LogoAsset, sanitizedLink, boolToInt(isActive))
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed: sponsors.position") {
-			return 0, ErrInvalidSponsorPos
-		}
 		return 0, err
 	}
 	id, _ := res.LastInsertId()
@@ -2080,21 +2966,18 @@ func (db *appdbimpl) UpdateSponsor(s Sponsor) error {
 	}
 
 	sanitizedName := strings.TrimSpace(s.Name)
-	if strings.TrimSpace(s.LogoData) == "" {
+	if strings.TrimSpace(s.LogoData) == "" && s.LogoAsset == "" {
 		return ErrInvalidSponsorData
 	}
 
-	if s.Position <= 0 || s.Position > maxSponsorSlots {
+	if s.Position <= 0 {
 		return ErrInvalidSponsorPos
 	}
 
 	sanitizedLink := strings.TrimSpace(s.LinkURL)
 
-	res, err := db.c.Exec(`UPDATE sponsors SET position=?, name=?, logo_data=?, link_url=?, is_active=? WHERE id=?`, s.Position, sanitizedName, s.LogoData, sanitizedLink, boolToInt(s.IsActive), s.ID)
+	res, err := db.c.Exec(`UPDATE sponsors SET position=?, name=?, logo_data=?, logo_asset=?, link_url=?, is_active=? WHERE id=?`, s.Position, sanitizedName, s.LogoData, s.LogoAsset, sanitizedLink, boolToInt(s.IsActive), s.ID)
 	if err != nil {
-		if strings.Contains(err.Error(), "UNIQUE constraint failed: sponsors.position") {
-			return ErrInvalidSponsorPos
-		}
 		return err
 	}
 	affected, err := res.RowsAffected()
@@ -2104,7 +2987,8 @@ func (db *appdbimpl) UpdateSponsor(s Sponsor) error {
 	if affected == 0 {
 		return sql.ErrNoRows
 	}
-	return nil
+	// The logo may have changed
+	return db.invalidateSponsorFrames(s.ID, false)
 }
 
 func (db *appdbimpl) DeleteSponsor(id int) error {
@@ -2119,6 +3003,9 @@ func (db *appdbimpl) DeleteSponsor(id int) error {
 	if affected == 0 {
 		return sql.ErrNoRows
 	}
+	if err := db.invalidateSponsorFrames(id, true); err != nil {
+		return err
+	}
 	return db.normalizeSponsorPositions()
 }
 
@@ -2130,78 +3017,6 @@ func (db *appdbimpl) ListActiveSponsors() ([]Sponsor, error) {
 	return db.querySponsors(true)
 }
 
-func (db *appdbimpl) RecordSponsorSession(eventID int, deviceID string) error {
-	if eventID <= 0 {
-		return sql.ErrNoRows
-	}
-	trimmed := strings.TrimSpace(deviceID)
-	if trimmed == "" {
-		return sql.ErrNoRows
-	}
-
-	_, err := db.c.Exec(`
-INSERT INTO sponsor_sessions (event_id, device_id)
-VALUES (?, ?)
-ON CONFLICT(event_id, device_id) DO UPDATE SET last_seen = CURRENT_TIMESTAMP
-`, eventID, trimmed)
-	return err
-}
-
-func (db *appdbimpl) RecordSponsorExposure(eventID int, sponsorIDs []int, deviceID, exposureType string, durationMs int) error {
-	if eventID <= 0 {
-		return sql.ErrNoRows
-	}
-	trimmedDevice := strings.TrimSpace(deviceID)
-	if trimmedDevice == "" {
-		return sql.ErrNoRows
-	}
-	normalizedType := strings.ToLower(strings.TrimSpace(exposureType))
-	if normalizedType != "seen" && normalizedType != "watched" {
-		return ErrInvalidSponsorData
-	}
-	if len(sponsorIDs) == 0 {
-		return sql.ErrNoRows
-	}
-
-	tx, err := db.c.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	stmt, err := tx.Prepare(`INSERT INTO sponsor_exposures (event_id, sponsor_id, device_id, exposure_type, duration_ms) VALUES (?, ?, ?, ?, ?)`)
-	if err != nil {
-		return err
-	}
-	defer stmt.Close()
-
-	for _, sponsorID := range sponsorIDs {
-		if sponsorID <= 0 {
-			continue
-		}
-		var duration interface{}
-		if normalizedType == "watched" && durationMs > 0 {
-			duration = durationMs
-		} else {
-			duration = nil
-		}
-		if _, err := stmt.Exec(eventID, sponsorID, trimmedDevice, normalizedType, duration); err != nil {
-			return err
-		}
-	}
-
-	return tx.Commit()
-}
-
-func (db *appdbimpl) RecordSponsorClick(eventID, sponsorID int, deviceID string) error {
-	if eventID <= 0 || sponsorID <= 0 {
-		return sql.ErrNoRows
-	}
-	trimmed := strings.TrimSpace(deviceID)
-	_, err := db.c.Exec(`INSERT INTO sponsor_clicks (event_id, sponsor_id, device_id) VALUES (?, ?, ?)`, eventID, sponsorID, trimmed)
-	return err
-}
-
 func (db *appdbimpl) GetSponsorAnalytics(eventID int) (SponsorAnalytics, error) {
 	summary := SponsorAnalytics{}
 	if eventID <= 0 {
@@ -2323,6 +3138,12 @@ ORDER BY bucket ASC
 		}
 	}
 
+	campaigns, err := db.GetSponsorCampaignStats(eventID)
+	if err != nil {
+		return summary, err
+	}
+	summary.Campaigns = campaigns
+
 	return summary, nil
 }
 
@@ -2362,7 +3183,7 @@ ORDER BY s.position ASC, s.id ASC
 }
 
 func (db *appdbimpl) querySponsors(activeOnly bool) ([]Sponsor, error) {
-	baseQuery := `SELECT id, position, name, logo_data, IFNULL(link_url, ''), is_active FROM sponsors`
+	baseQuery := `SELECT id, position, name, logo_data, logo_asset, IFNULL(link_url, ''), is_active FROM sponsors`
 	if activeOnly {
 		baseQuery += ` WHERE is_active = 1`
 	}
@@ -2378,7 +3199,7 @@ func (db *appdbimpl) querySponsors(activeOnly bool) ([]Sponsor, error) {
 	for rows.Next() {
 		var s Sponsor
 		var isActive int
-		if err := rows.Scan(&s.ID, &s.Position, &s.Name, &s.LogoData, &s.LinkURL, &isActive); err != nil {
+		if err := rows.Scan(&s.ID, &s.Position, &s.Name, &s.LogoData, &s.LogoAsset, &s.LinkURL, &isActive); err != nil {
 			return nil, err
 		}
 		s.IsActive = isActive == 1
@@ -2387,29 +3208,24 @@ func (db *appdbimpl) querySponsors(activeOnly bool) ([]Sponsor, error) {
 	return sponsors, nil
 }
 
-func (db *appdbimpl) nextSponsorPosition() (int, error) {
-	rows, err := db.c.Query(`SELECT position FROM sponsors ORDER BY position ASC`)
+func (db *appdbimpl) GetSponsor(id int) (Sponsor, error) {
+	var s Sponsor
+	var isActive int
+	err := db.c.QueryRow(`SELECT id, position, name, logo_data, logo_asset, IFNULL(link_url, ''), is_active FROM sponsors WHERE id = ?`, id).
+		Scan(&s.ID, &s.Position, &s.Name, &s.LogoData, &s.LogoAsset, &s.LinkURL, &isActive)
 	if err != nil {
-		return 0, err
-	}
-	defer rows.Close()
-
-	used := make(map[int]struct{})
-	for rows.Next() {
-		var pos int
-		if err := rows.Scan(&pos); err != nil {
-			return 0, err
-		}
-		used[pos] = struct{}{}
+		return Sponsor{}, err
 	}
+	s.IsActive = isActive == 1
+	return s, nil
+}
 
-	for i := 1; i <= maxSponsorSlots; i++ {
-		if _, ok := used[i]; !ok {
-			return i, nil
-		}
+func (db *appdbimpl) nextSponsorPosition() (int, error) {
+	var last int
+	if err := db.c.QueryRow(`SELECT IFNULL(MAX(position), 0) FROM sponsors`).Scan(&last); err != nil {
+		return 0, err
 	}
-
-	return 0, ErrMaxSponsors
+	return last + 1, nil
 }
 
 func (db *appdbimpl) normalizeSponsorPositions() error {
@@ -2495,7 +3311,8 @@ func (db *appdbimpl) RecordEventFeedback(feedback EventFeedback) error {
 		}
 	}
 
-	_, err := db.c.Exec(`INSERT INTO event_feedback (event_id, experience, team_spirit, perks_interest, mini_games_interest, suggestion) VALUES (?, ?, ?, ?, ?, ?)`, feedback.EventID, experience, teamSpirit, perksInterest, miniGamesInterest, suggestion)
+	_, err := db.c.Exec(`INSERT INTO event_feedback (event_id, experience, team_spirit, perks_interest, mini_games_interest, suggestion, suggestion_flagged) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		feedback.EventID, experience, teamSpirit, perksInterest, miniGamesInterest, suggestion, feedback.SuggestionFlagged && suggestion != "")
 	return err
 }
 
@@ -2533,7 +3350,7 @@ func (db *appdbimpl) GetEventFeedbackSummary(eventID int) (EventFeedbackSummary,
 		return summary, err
 	}
 
-	rows, err := db.c.Query(`SELECT suggestion FROM event_feedback WHERE event_id=? AND TRIM(suggestion) <> '' ORDER BY created_at DESC, id DESC`, eventID)
+	rows, err := db.c.Query(`SELECT suggestion FROM event_feedback WHERE event_id=? AND TRIM(suggestion) <> '' AND suggestion_flagged = 0 ORDER BY created_at DESC, id DESC`, eventID)
 	if err != nil {
 		return summary, err
 	}
@@ -2582,45 +3399,38 @@ func (db *appdbimpl) loadFeedbackCounts(eventID int, query string, counts map[st
 	return rows.Err()
 }
 
-func (db *appdbimpl) ListShopProducts() ([]ShopProduct, error) {
-	rows, err := db.c.Query(`SELECT id, name, description, price_cents, image_url, IFNULL(created_at, '') FROM shop_products ORDER BY id ASC`)
+// ListShopProducts returns the products on sale: active and not deleted, with their active variants.
+func (db *appdbimpl) ListShopProducts(at time.Time) ([]ShopProduct, error) {
+	products, err := db.queryShopProducts(at, `WHERE is_active = 1 AND deleted_at = ''`)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var products []ShopProduct
-	for rows.Next() {
-		var product ShopProduct
-		if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.PriceCents, &product.ImageURL, &product.CreatedAt); err != nil {
-			return nil, err
-		}
-		products = append(products, product)
+	for i := range products {
+		products[i].Variants = activeShopVariants(products[i].Variants)
 	}
-
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-
 	return products, nil
 }
 
-func (db *appdbimpl) GetShopProduct(id int) (ShopProduct, error) {
+// GetShopProduct returns the product if it is on sale, with its active variants, or sql.ErrNoRows.
+func (db *appdbimpl) GetShopProduct(id int, at time.Time) (ShopProduct, error) {
 	if id <= 0 {
 		return ShopProduct{}, sql.ErrNoRows
 	}
-
-	var product ShopProduct
-	err := db.c.QueryRow(`SELECT id, name, description, price_cents, image_url, IFNULL(created_at, '') FROM shop_products WHERE id = ?`, id).
-		Scan(&product.ID, &product.Name, &product.Description, &product.PriceCents, &product.ImageURL, &product.CreatedAt)
+	products, err := db.queryShopProducts(at, `WHERE id = ? AND is_active = 1 AND deleted_at = ''`, id)
 	if err != nil {
 		return ShopProduct{}, err
 	}
-
-	return product, nil
+	if len(products) == 0 {
+		return ShopProduct{}, sql.ErrNoRows
+	}
+	products[0].Variants = activeShopVariants(products[0].Variants)
+	return products[0], nil
 }
 
-func (db *appdbimpl) CreateShopOrder(order ShopOrder, items []ShopOrderItem) (ShopOrder, error) {
+// CreateShopOrder stores the order and takes its items from the stock, in a single transaction. When the stock
+// available to the order, the reservation reservationID included, is not enough for an item nothing is stored and a
+// *ShopStockError is returned. The reservation is consumed by the order.
+func (db *appdbimpl) CreateShopOrder(order ShopOrder, items []ShopOrderItem, reservationID string, at time.Time) (ShopOrder, error) {
 	if len(items) == 0 {
 		return ShopOrder{}, fmt.Errorf("order must contain at least one item")
 	}
@@ -2644,7 +3454,29 @@ func (db *appdbimpl) CreateShopOrder(order ShopOrder, items []ShopOrderItem) (Sh
 		}
 	}()
 
-	res, err := tx.Exec(`INSERT INTO shop_orders (customer_name, customer_email, customer_notes, total_cents) VALUES (?, ?, ?, ?)`, customerName, customerEmail, customerNotes, order.TotalCents)
+	// Writing first takes the database lock, so that no other order reads the stock before this one is done
+	now := at.UTC().Format(time.RFC3339)
+	if err := deleteExpiredShopReservations(tx, now); err != nil {
+		return ShopOrder{}, err
+	}
+
+	var pickupEventID interface{}
+	if order.PickupEventID > 0 {
+		var open int
+		if err := tx.QueryRow(`SELECT COUNT(1) FROM events WHERE id = ? AND is_concluded = 0`, order.PickupEventID).Scan(&open); err != nil {
+			return ShopOrder{}, err
+		}
+		if open == 0 {
+			return ShopOrder{}, ErrInvalidPickupEvent
+		}
+		pickupEventID = order.PickupEventID
+	}
+
+	order.NotesFlagged = order.NotesFlagged && customerNotes != ""
+	order.Status = ShopOrderStatusPendingPayment
+	order.StatusUpdatedAt = now
+	res, err := tx.Exec(`INSERT INTO shop_orders (customer_name, customer_email, customer_notes, total_cents, notes_flagged, status, status_updated_at, pickup_event_id, locale) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		customerName, customerEmail, customerNotes, order.TotalCents, order.NotesFlagged, order.Status, order.StatusUpdatedAt, pickupEventID, order.Locale)
 	if err != nil {
 		return ShopOrder{}, err
 	}
@@ -2661,14 +3493,20 @@ func (db *appdbimpl) CreateShopOrder(order ShopOrder, items []ShopOrderItem) (Sh
 
 	storedItems := make([]ShopOrderItem, 0, len(items))
 	for _, item := range items {
-		if item.ProductID <= 0 || item.Quantity <= 0 {
+		if item.ProductID <= 0 || item.Quantity <= 0 || item.VariantID < 0 {
 			return ShopOrder{}, fmt.Errorf("invalid order item")
 		}
 
 		cleanName := strings.TrimSpace(item.ProductName)
 		cleanImage := strings.TrimSpace(item.ProductImageURL)
+		cleanVariant := strings.TrimSpace(item.VariantName)
 
-		result, err := tx.Exec(`INSERT INTO shop_order_items (order_id, product_id, product_name, product_image_url, quantity, unit_price_cents) VALUES (?, ?, ?, ?, ?, ?)`, order.ID, item.ProductID, cleanName, cleanImage, item.Quantity, item.UnitPriceCents)
+		if err := takeShopStock(tx, item, reservationID, now); err != nil {
+			return ShopOrder{}, err
+		}
+
+		result, err := tx.Exec(`INSERT INTO shop_order_items (order_id, product_id, product_name, product_image_url, variant_id, variant_name, quantity, unit_price_cents) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			order.ID, item.ProductID, cleanName, cleanImage, item.VariantID, cleanVariant, item.Quantity, item.UnitPriceCents)
 		if err != nil {
 			return ShopOrder{}, err
 		}
@@ -2684,11 +3522,19 @@ func (db *appdbimpl) CreateShopOrder(order ShopOrder, items []ShopOrderItem) (Sh
 			ProductID:       item.ProductID,
 			ProductName:     cleanName,
 			ProductImageURL: cleanImage,
+			VariantID:       item.VariantID,
+			VariantName:     cleanVariant,
 			Quantity:        item.Quantity,
 			UnitPriceCents:  item.UnitPriceCents,
 		})
 	}
 
+	if reservationID != "" {
+		if _, err := tx.Exec(`DELETE FROM shop_stock_reservations WHERE id = ?`, reservationID); err != nil {
+			return ShopOrder{}, err
+		}
+	}
+
 	if err := tx.QueryRow(`SELECT IFNULL(created_at, '') FROM shop_orders WHERE id = ?`, order.ID).Scan(&order.CreatedAt); err != nil {
 		return ShopOrder{}, err
 	}
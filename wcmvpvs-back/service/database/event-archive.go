@@ -0,0 +1,424 @@
+package database
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// archiveTimeLayout is used for the archive timestamps. It sorts lexicographically, so expired archives can be
+// selected with a plain string comparison.
+const archiveTimeLayout = "2006-01-02T15:04:05Z"
+
+// archivedEventTables lists the tables holding per-event rows, in an order that satisfies the foreign keys when
+// inserting them back. keyColumn is the column referencing the event, or where the condition selecting the rows of
+// the event for the tables that reference it through another one.
+var archivedEventTables = []struct {
+	name      string
+	keyColumn string
+	where     string
+}{
+	{name: "events", keyColumn: "id"},
+	{name: "votes", keyColumn: "event_id"},
+	{name: "event_prizes", keyColumn: "event_id"},
+	{name: "tickets", keyColumn: "event_id"},
+	{name: "selfies", keyColumn: "event_id"},
+	{name: "screen_playlists", keyColumn: "event_id"},
+	{name: "screen_pins", keyColumn: "event_id"},
+	{name: "screen_plays", keyColumn: "event_id"},
+	{name: "selfie_frames", keyColumn: "event_id"},
+	{name: "selfie_renditions", keyColumn: "event_id"},
+	{name: "selfie_contests", keyColumn: "event_id"},
+	{name: "selfie_contest_votes", keyColumn: "event_id"},
+	{name: "reaction_tests", keyColumn: "event_id"},
+	{name: "event_feedback", keyColumn: "event_id"},
+	{name: "sponsor_sessions", keyColumn: "event_id"},
+	{name: "sponsor_clicks", keyColumn: "event_id"},
+	{name: "sponsor_exposures", keyColumn: "event_id"},
+	{name: "sponsor_campaign_events", keyColumn: "event_id"},
+	{name: "sponsor_plans", keyColumn: "event_id"},
+	{name: "sponsor_plan_slots", where: "plan_id IN (SELECT id FROM sponsor_plans WHERE event_id = ?)"},
+	{name: "sponsor_plan_counts", keyColumn: "event_id"},
+}
+
+type archivedTable struct {
+	Table   string          `json:"table"`
+	Columns []string        `json:"columns"`
+	Rows    [][]interface{} `json:"rows"`
+}
+
+type eventArchivePayload struct {
+	Version int             `json:"version"`
+	Tables  []archivedTable `json:"tables"`
+
+	// PickupOrders are the shop orders to collect at the event: they are kept, and linked to it again on restore
+	PickupOrders []int64 `json:"pickup_orders,omitempty"`
+}
+
+// ArchiveEvent moves every row belonging to the event into a compressed entry of event_archives, keeping `summary`
+// (the aggregate history entry, serialized by the caller) readable. The rows can be put back with
+// RestoreArchivedEvent until purgeAfter.
+func (db *appdbimpl) ArchiveEvent(eventID int, summary, archivedBy string, archivedAt, purgeAfter time.Time) error {
+	if eventID <= 0 {
+		return sql.ErrNoRows
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	payload := eventArchivePayload{Version: 1}
+	for _, table := range archivedEventTables {
+		dump, err := dumpArchivedTable(tx, table.name, archivedTableWhere(table.keyColumn, table.where), eventID)
+		if err != nil {
+			return fmt.Errorf("archiving %s: %w", table.name, err)
+		}
+		if table.name == "events" && len(dump.Rows) == 0 {
+			return sql.ErrNoRows
+		}
+		payload.Tables = append(payload.Tables, dump)
+	}
+	orderRows, err := tx.Query(`SELECT id FROM shop_orders WHERE pickup_event_id = ? ORDER BY id`, eventID)
+	if err != nil {
+		return err
+	}
+	for orderRows.Next() {
+		var id int64
+		if err := orderRows.Scan(&id); err != nil {
+			_ = orderRows.Close()
+			return err
+		}
+		payload.PickupOrders = append(payload.PickupOrders, id)
+	}
+	if err := orderRows.Close(); err != nil {
+		return err
+	}
+
+	compressed, err := encodeEventArchivePayload(payload)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO event_archives (event_id, summary, payload, archived_by, archived_at, purge_after) VALUES (?, ?, ?, ?, ?, ?)`,
+		eventID, summary, compressed, archivedBy, archivedAt.UTC().Format(archiveTimeLayout), purgeAfter.UTC().Format(archiveTimeLayout)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`UPDATE event_prizes SET winner_vote_id = NULL, winner_assigned_at = NULL WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE shop_orders SET pickup_event_id = NULL WHERE pickup_event_id = ?`, eventID); err != nil {
+		return err
+	}
+	for i := len(archivedEventTables) - 1; i >= 0; i-- {
+		table := archivedEventTables[i]
+		if _, err := tx.Exec(fmt.Sprintf(`DELETE FROM %s WHERE %s`, table.name, archivedTableWhere(table.keyColumn, table.where)), eventID); err != nil {
+			return fmt.Errorf("removing archived %s: %w", table.name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// archivedTableWhere returns the condition selecting the rows of an archived table, with the event ID as parameter.
+func archivedTableWhere(keyColumn, where string) string {
+	if where != "" {
+		return where
+	}
+	return keyColumn + " = ?"
+}
+
+func dumpArchivedTable(tx *sql.Tx, table, where string, eventID int) (archivedTable, error) {
+	dump := archivedTable{Table: table, Rows: [][]interface{}{}}
+
+	rows, err := tx.Query(fmt.Sprintf(`SELECT * FROM %s WHERE %s`, table, where), eventID)
+	if err != nil {
+		return dump, err
+	}
+	defer rows.Close()
+
+	dump.Columns, err = rows.Columns()
+	if err != nil {
+		return dump, err
+	}
+	for rows.Next() {
+		values := make([]interface{}, len(dump.Columns))
+		pointers := make([]interface{}, len(dump.Columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return dump, err
+		}
+		for i, value := range values {
+			if raw, ok := value.([]byte); ok {
+				values[i] = string(raw)
+			}
+		}
+		dump.Rows = append(dump.Rows, values)
+	}
+	return dump, rows.Err()
+}
+
+func (db *appdbimpl) ListEventArchives() ([]EventArchive, error) {
+	rows, err := db.c.Query(`SELECT event_id, summary, payload IS NOT NULL, COALESCE(archived_by, ''), archived_at, purge_after, COALESCE(purged_at, '')
+FROM event_archives ORDER BY archived_at DESC, event_id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var archives []EventArchive
+	for rows.Next() {
+		archive, err := scanEventArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, archive)
+	}
+	return archives, rows.Err()
+}
+
+func (db *appdbimpl) GetEventArchive(eventID int) (EventArchive, error) {
+	row := db.c.QueryRow(`SELECT event_id, summary, payload IS NOT NULL, COALESCE(archived_by, ''), archived_at, purge_after, COALESCE(purged_at, '')
+FROM event_archives WHERE event_id = ?`, eventID)
+	return scanEventArchive(row)
+}
+
+func scanEventArchive(scanner rowScanner) (EventArchive, error) {
+	var archive EventArchive
+	var hasPayload int
+	if err := scanner.Scan(&archive.EventID, &archive.Summary, &hasPayload, &archive.ArchivedBy, &archive.ArchivedAt, &archive.PurgeAfter, &archive.PurgedAt); err != nil {
+		return archive, err
+	}
+	archive.Restorable = hasPayload == 1
+	return archive, nil
+}
+
+// RestoreArchivedEvent puts the archived rows of the event back in their tables and drops the archive entry. It
+// returns ErrArchiveNotRestorable when the grace period is over. Telemetry rows of sponsors or campaigns deleted in the
+// meantime are dropped, since they cannot be linked anymore, and the shop orders are linked to the event again unless
+// they were moved to another one.
+func (db *appdbimpl) RestoreArchivedEvent(eventID int, now time.Time) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var compressed []byte
+	var purgeAfter string
+	if err := tx.QueryRow(`SELECT payload, purge_after FROM event_archives WHERE event_id = ?`, eventID).Scan(&compressed, &purgeAfter); err != nil {
+		return err
+	}
+	if compressed == nil || now.UTC().Format(archiveTimeLayout) >= purgeAfter {
+		return ErrArchiveNotRestorable
+	}
+
+	payload, err := decodeEventArchivePayload(compressed)
+	if err != nil {
+		return err
+	}
+
+	sponsors, err := existingIDs(tx, "sponsors")
+	if err != nil {
+		return err
+	}
+	campaigns, err := existingIDs(tx, "sponsor_campaigns")
+	if err != nil {
+		return err
+	}
+
+	for _, table := range payload.Tables {
+		if len(table.Rows) == 0 {
+			continue
+		}
+		sponsorColumn, campaignColumn := -1, -1
+		for i, column := range table.Columns {
+			switch column {
+			case "sponsor_id":
+				sponsorColumn = i
+			case "campaign_id":
+				campaignColumn = i
+			}
+		}
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(table.Columns)), ", ")
+		stmt := fmt.Sprintf(`INSERT INTO %s (%s) VALUES (%s)`, table.Table, strings.Join(table.Columns, ", "), placeholders)
+		for _, row := range table.Rows {
+			if sponsorColumn >= 0 {
+				if id, ok := row[sponsorColumn].(int64); ok && !sponsors[id] {
+					continue
+				}
+			}
+			// Campaign 0 stands for no campaign, or for all of them in the plan counts
+			if campaignColumn >= 0 {
+				if id, ok := row[campaignColumn].(int64); ok && id != 0 && !campaigns[id] {
+					continue
+				}
+			}
+			if _, err := tx.Exec(stmt, row...); err != nil {
+				return fmt.Errorf("restoring %s: %w", table.Table, err)
+			}
+		}
+	}
+
+	for _, id := range payload.PickupOrders {
+		if _, err := tx.Exec(`UPDATE shop_orders SET pickup_event_id = ? WHERE id = ? AND pickup_event_id IS NULL`, eventID, id); err != nil {
+			return err
+		}
+	}
+
+	// Only one event can be active; a restored event never is.
+	if _, err := tx.Exec(`UPDATE events SET is_active = 0 WHERE id = ?`, eventID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM event_archives WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// existingIDs returns the IDs of the rows of the table.
+func existingIDs(tx *sql.Tx, table string) (map[int64]bool, error) {
+	rows, err := tx.Query(fmt.Sprintf(`SELECT id FROM %s`, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := map[int64]bool{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// PurgeExpiredEventArchives drops the detailed rows of every archive whose grace period is over, keeping only the
+// summary. It returns the paths of the selfie images referenced by the purged rows, so that the caller can remove
+// the files.
+func (db *appdbimpl) PurgeExpiredEventArchives(now time.Time) ([]string, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	cutoff := now.UTC().Format(archiveTimeLayout)
+	rows, err := tx.Query(`SELECT event_id, payload FROM event_archives WHERE payload IS NOT NULL AND purge_after <= ?`, cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	var eventIDs []int
+	var imagePaths []string
+	for rows.Next() {
+		var eventID int
+		var compressed []byte
+		if err := rows.Scan(&eventID, &compressed); err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+
+		payload, err := decodeEventArchivePayload(compressed)
+		if err != nil {
+			_ = rows.Close()
+			return nil, fmt.Errorf("decoding archive of event %d: %w", eventID, err)
+		}
+		for _, table := range payload.Tables {
+			if table.Table != "selfies" {
+				continue
+			}
+			for i, column := range table.Columns {
+				if column != "image_path" {
+					continue
+				}
+				for _, row := range table.Rows {
+					if path, ok := row[i].(string); ok && strings.TrimSpace(path) != "" {
+						imagePaths = append(imagePaths, path)
+					}
+				}
+			}
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, eventID := range eventIDs {
+		if _, err := tx.Exec(`UPDATE event_archives SET payload = NULL, purged_at = ? WHERE event_id = ?`, cutoff, eventID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return imagePaths, nil
+}
+
+func encodeEventArchivePayload(payload eventArchivePayload) ([]byte, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	if _, err := zw.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+func decodeEventArchivePayload(compressed []byte) (eventArchivePayload, error) {
+	var payload eventArchivePayload
+
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return payload, err
+	}
+	defer zr.Close()
+
+	decoder := json.NewDecoder(zr)
+	decoder.UseNumber()
+	if err := decoder.Decode(&payload); err != nil {
+		return payload, err
+	}
+	if payload.Version != 1 {
+		return payload, errors.New("unsupported archive version")
+	}
+
+	// Bring numbers back to the types the SQLite driver returned when archiving.
+	for _, table := range payload.Tables {
+		for _, row := range table.Rows {
+			for i, value := range row {
+				number, ok := value.(json.Number)
+				if !ok {
+					continue
+				}
+				if integer, err := number.Int64(); err == nil {
+					row[i] = integer
+				} else if float, err := number.Float64(); err == nil {
+					row[i] = float
+				}
+			}
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,84 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExportDatasets lists the datasets of an event that can be exported, in the order used by the export bundle.
+var ExportDatasets = []string{"votes", "tickets", "selfies", "sponsor_exposures", "feedback", "reaction_tests", "orders"}
+
+var ErrUnknownExportDataset = errors.New("unknown export dataset")
+
+// exportQueries select the rows of each dataset given the event ID. Image data and ticket signatures are left out.
+// The orders dataset contains the lines of the orders collected at the event, without the customer details.
+var exportQueries = map[string]string{
+	"votes": `SELECT v.id, v.created_at, v.player_id, p.first_name AS player_first_name, p.last_name AS player_last_name,
+	p.jersey_number AS player_jersey_number, v.ticket_code, v.device_id
+FROM votes v LEFT JOIN players p ON p.id = v.player_id WHERE v.event_id = ? ORDER BY v.id`,
+	"tickets": `SELECT code, redeemed_at FROM tickets WHERE event_id = ? ORDER BY code`,
+	"selfies": `SELECT id, created_at, device_id, caption, content_type, approved, show_on_screen
+FROM selfies WHERE event_id = ? ORDER BY id`,
+	"sponsor_exposures": `SELECT x.id, x.created_at, x.sponsor_id, s.name AS sponsor_name, x.campaign_id, x.creative_id, x.device_id, x.exposure_type, x.duration_ms
+FROM sponsor_exposures x LEFT JOIN sponsors s ON s.id = x.sponsor_id WHERE x.event_id = ? ORDER BY x.id`,
+	"feedback": `SELECT id, created_at, experience, team_spirit, perks_interest, mini_games_interest, suggestion
+FROM event_feedback WHERE event_id = ? ORDER BY id`,
+	"reaction_tests": `SELECT id, created_at, device_id, reaction_time_ms, is_valid
+FROM reaction_tests WHERE event_id = ? ORDER BY id`,
+	"orders": `SELECT o.id AS order_id, o.created_at, i.product_id, i.product_name, i.variant_name, i.quantity, i.unit_price_cents, o.total_cents AS order_total_cents
+FROM shop_orders o
+JOIN shop_order_items i ON i.order_id = o.id
+WHERE o.pickup_event_id = ?
+ORDER BY o.id, i.id`,
+}
+
+// ExportWriter receives the rows of an exported dataset while they are read from the database.
+type ExportWriter interface {
+	WriteHeader(columns []string) error
+	WriteRow(values []interface{}) error
+}
+
+// ExportEventDataset streams the rows of a dataset of the event to out, straight from the database cursor, and
+// returns the number of rows written. Text values are passed as strings, numbers as int64 or float64, NULL as nil.
+func (db *appdbimpl) ExportEventDataset(eventID int, dataset string, out ExportWriter) (int, error) {
+	query, ok := exportQueries[dataset]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownExportDataset, dataset)
+	}
+
+	rows, err := db.c.Query(query, eventID)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if err := out.WriteHeader(columns); err != nil {
+		return 0, err
+	}
+
+	count := 0
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return count, err
+		}
+		for i, value := range values {
+			if raw, ok := value.([]byte); ok {
+				values[i] = string(raw)
+			}
+		}
+		if err := out.WriteRow(values); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
@@ -0,0 +1,137 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// The states of a message of the mail outbox. A pending message is retried until it is sent, or until the sender
+// gives up and marks it failed.
+const (
+	MailStatusPending = "pending"
+	MailStatusSent    = "sent"
+	MailStatusFailed  = "failed"
+)
+
+// MailMessage is a rendered email waiting in the outbox, or already sent.
+type MailMessage struct {
+	ID int `json:"id"`
+
+	// Kind is the template the message was rendered from
+	Kind string `json:"kind"`
+
+	// DedupeKey identifies what the message is about, like the order it confirms, so that it is queued only once
+	DedupeKey     string `json:"dedupe_key"`
+	Recipient     string `json:"recipient"`
+	Subject       string `json:"subject"`
+	TextBody      string `json:"text_body"`
+	HTMLBody      string `json:"html_body"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt string `json:"next_attempt_at"`
+	LastError     string `json:"last_error,omitempty"`
+	CreatedAt     string `json:"created_at"`
+	SentAt        string `json:"sent_at,omitempty"`
+}
+
+const mailMessageColumns = `id, kind, dedupe_key, recipient, subject, text_body, html_body, status, attempts, next_attempt_at, last_error, created_at, sent_at`
+
+func scanMailMessage(scanner rowScanner) (MailMessage, error) {
+	var msg MailMessage
+	err := scanner.Scan(&msg.ID, &msg.Kind, &msg.DedupeKey, &msg.Recipient, &msg.Subject, &msg.TextBody, &msg.HTMLBody,
+		&msg.Status, &msg.Attempts, &msg.NextAttemptAt, &msg.LastError, &msg.CreatedAt, &msg.SentAt)
+	return msg, err
+}
+
+func (db *appdbimpl) queryMailMessages(query string, args ...interface{}) ([]MailMessage, error) {
+	rows, err := db.c.Query(`SELECT `+mailMessageColumns+` FROM mail_outbox `+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []MailMessage{}
+	for rows.Next() {
+		msg, err := scanMailMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// EnqueueMail adds the message to the outbox, due immediately. The returned flag is false when a message with the
+// same DedupeKey was already queued: the existing one is returned and nothing is added.
+func (db *appdbimpl) EnqueueMail(msg MailMessage, at time.Time) (MailMessage, bool, error) {
+	now := at.UTC().Format(time.RFC3339)
+	res, err := db.c.Exec(`INSERT OR IGNORE INTO mail_outbox (kind, dedupe_key, recipient, subject, text_body, html_body, status, next_attempt_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		msg.Kind, msg.DedupeKey, strings.TrimSpace(msg.Recipient), msg.Subject, msg.TextBody, msg.HTMLBody, MailStatusPending, now, now)
+	if err != nil {
+		return MailMessage{}, false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return MailMessage{}, false, err
+	}
+	queued, err := scanMailMessage(db.c.QueryRow(`SELECT `+mailMessageColumns+` FROM mail_outbox WHERE dedupe_key = ?`, msg.DedupeKey))
+	return queued, inserted > 0, err
+}
+
+// ListDueMail returns the pending messages whose next attempt is due, the oldest first.
+func (db *appdbimpl) ListDueMail(limit int, at time.Time) ([]MailMessage, error) {
+	return db.queryMailMessages(`WHERE status = ? AND next_attempt_at <= ? ORDER BY next_attempt_at, id LIMIT ?`,
+		MailStatusPending, at.UTC().Format(time.RFC3339), limit)
+}
+
+func (db *appdbimpl) MarkMailSent(id int, at time.Time) error {
+	_, err := db.c.Exec(`UPDATE mail_outbox SET status = ?, attempts = attempts + 1, last_error = '', sent_at = ? WHERE id = ?`,
+		MailStatusSent, at.UTC().Format(time.RFC3339), id)
+	return err
+}
+
+// MarkMailFailed records a failed attempt. The message is retried at retryAt, or marked failed when retryAt is zero.
+func (db *appdbimpl) MarkMailFailed(id int, lastError string, retryAt, at time.Time) error {
+	status, next := MailStatusPending, retryAt.UTC().Format(time.RFC3339)
+	if retryAt.IsZero() {
+		status, next = MailStatusFailed, at.UTC().Format(time.RFC3339)
+	}
+	_, err := db.c.Exec(`UPDATE mail_outbox SET status = ?, attempts = attempts + 1, next_attempt_at = ?, last_error = ? WHERE id = ?`,
+		status, next, lastError, id)
+	return err
+}
+
+// ListMailOutbox returns the most recent messages, only those in status when given.
+func (db *appdbimpl) ListMailOutbox(status string, limit int) ([]MailMessage, error) {
+	if status != "" {
+		return db.queryMailMessages(`WHERE status = ? ORDER BY id DESC LIMIT ?`, status, limit)
+	}
+	return db.queryMailMessages(`ORDER BY id DESC LIMIT ?`, limit)
+}
+
+// RetryMail puts a failed message back in the outbox, due immediately and with the attempts reset. It returns
+// sql.ErrNoRows when the message does not exist or was already sent.
+func (db *appdbimpl) RetryMail(id int, at time.Time) (MailMessage, error) {
+	res, err := db.c.Exec(`UPDATE mail_outbox SET status = ?, attempts = 0, next_attempt_at = ? WHERE id = ? AND status != ?`,
+		MailStatusPending, at.UTC().Format(time.RFC3339), id, MailStatusSent)
+	if err != nil {
+		return MailMessage{}, err
+	}
+	if affected, err := res.RowsAffected(); err != nil {
+		return MailMessage{}, err
+	} else if affected == 0 {
+		return MailMessage{}, sql.ErrNoRows
+	}
+	return scanMailMessage(db.c.QueryRow(`SELECT `+mailMessageColumns+` FROM mail_outbox WHERE id = ?`, id))
+}
+
+// PurgeMailOutbox deletes the messages sent or failed before the given time, as they hold the customers' addresses.
+func (db *appdbimpl) PurgeMailOutbox(before time.Time) (int, error) {
+	res, err := db.c.Exec(`DELETE FROM mail_outbox WHERE status != ? AND created_at < ?`, MailStatusPending, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	return int(affected), err
+}
@@ -0,0 +1,24 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// IsMaintenanceTaskDone tells whether the one-off task with the given name, like a data migration, already completed.
+func (db *appdbimpl) IsMaintenanceTaskDone(name string) (bool, error) {
+	var completedAt string
+	err := db.c.QueryRow(`SELECT completed_at FROM maintenance_tasks WHERE name = ?`, name).Scan(&completedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// CompleteMaintenanceTask records that the one-off task completed, so that it is not run again.
+func (db *appdbimpl) CompleteMaintenanceTask(name string, at time.Time) error {
+	_, err := db.c.Exec(`INSERT INTO maintenance_tasks (name, completed_at) VALUES (?, ?) ON CONFLICT(name) DO NOTHING`,
+		name, at.UTC().Format(time.RFC3339))
+	return err
+}
@@ -0,0 +1,462 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// anonymizedDevicePrefix marks device identifiers replaced by a random pseudonym.
+	anonymizedDevicePrefix = "anon-"
+
+	// sqliteTimestampLayout is the format of CURRENT_TIMESTAMP, used by every created_at column.
+	sqliteTimestampLayout = "2006-01-02 15:04:05"
+
+	ErasureSubjectDevice = "device"
+	ErasureSubjectEmail  = "email"
+
+	erasureActionDeleted    = "deleted"
+	erasureActionAnonymized = "anonymized"
+)
+
+// RetentionCutoffs tells ApplyRetention which rows are past their retention window. Rows created before the cutoff
+// are anonymized (or deleted, for selfies). A zero cutoff skips the data type.
+type RetentionCutoffs struct {
+	Votes            time.Time
+	Selfies          time.Time
+	ReactionTests    time.Time
+	SponsorTelemetry time.Time
+	ShopOrders       time.Time
+}
+
+// RetentionReport counts the rows touched by ApplyRetention, by table. SelfieImagePaths lists the image files of
+// the deleted selfies, that must be removed by the caller.
+type RetentionReport struct {
+	Rows             map[string]int
+	SelfieImagePaths []string
+}
+
+type ErasureRequest struct {
+	ReceiptID   string
+	SubjectType string
+	Subject     string
+	RequestedBy string
+	RequestedAt time.Time
+}
+
+type ErasureAction struct {
+	Table  string `json:"table"`
+	Action string `json:"action"`
+	Rows   int    `json:"rows"`
+}
+
+// ErasureReceipt documents an erasure. The subject itself is never stored, only its SHA-256 hash.
+type ErasureReceipt struct {
+	ID               string          `json:"id"`
+	SubjectType      string          `json:"subject_type"`
+	SubjectHash      string          `json:"subject_hash"`
+	RequestedBy      string          `json:"requested_by"`
+	ExecutedAt       string          `json:"executed_at"`
+	Actions          []ErasureAction `json:"actions"`
+	SelfieImagePaths []string        `json:"-"`
+}
+
+func randomDevicePseudonym() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return anonymizedDevicePrefix + hex.EncodeToString(buf), nil
+}
+
+// ApplyRetention anonymizes personal data older than the given cutoffs. Device identifiers are replaced by random
+// pseudonyms: sponsor telemetry gets one pseudonym per device and event across its three tables, so that unique
+// sessions and clickers are still counted correctly, while the sponsor plans and their counts by device are deleted.
+// The votes of the selfie contest expire with the MVP votes. Shop orders lose the customer data but keep totals and
+// items.
+func (db *appdbimpl) ApplyRetention(cutoffs RetentionCutoffs) (RetentionReport, error) {
+	report := RetentionReport{Rows: map[string]int{}}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	exec := func(table, query string, args ...interface{}) error {
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("applying retention to %s: %w", table, err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		report.Rows[table] += int(affected)
+		return nil
+	}
+
+	if !cutoffs.Votes.IsZero() {
+		if err := exec("votes", `UPDATE votes SET device_id = ? || lower(hex(randomblob(8))), contact_email = '', contact_locale = ''
+WHERE created_at < ? AND device_id NOT LIKE ?`, anonymizedDevicePrefix, cutoffs.Votes.UTC().Format(sqliteTimestampLayout), anonymizedDevicePrefix+"%"); err != nil {
+			return report, err
+		}
+		if err := exec("selfie_contest_votes", `UPDATE selfie_contest_votes SET device_id = ? || lower(hex(randomblob(8)))
+WHERE created_at < ? AND device_id NOT LIKE ?`, anonymizedDevicePrefix, cutoffs.Votes.UTC().Format(contestVoteTimeLayout), anonymizedDevicePrefix+"%"); err != nil {
+			return report, err
+		}
+	}
+
+	if !cutoffs.ReactionTests.IsZero() {
+		if err := exec("reaction_tests", `UPDATE reaction_tests SET device_id = ? || lower(hex(randomblob(8)))
+WHERE created_at < ? AND device_id NOT LIKE ?`, anonymizedDevicePrefix, cutoffs.ReactionTests.UTC().Format(sqliteTimestampLayout), anonymizedDevicePrefix+"%"); err != nil {
+			return report, err
+		}
+	}
+
+	if !cutoffs.Selfies.IsZero() {
+		cutoff := cutoffs.Selfies.UTC().Format(sqliteTimestampLayout)
+		paths, err := selectStrings(tx, `SELECT image_path FROM selfies WHERE created_at < ?`, cutoff)
+		if err != nil {
+			return report, err
+		}
+		report.SelfieImagePaths = append(report.SelfieImagePaths, paths...)
+		if err := exec("selfies", `DELETE FROM selfies WHERE created_at < ?`, cutoff); err != nil {
+			return report, err
+		}
+	}
+
+	if !cutoffs.SponsorTelemetry.IsZero() {
+		if err := anonymizeSponsorTelemetry(tx, cutoffs.SponsorTelemetry.UTC().Format(sqliteTimestampLayout), report.Rows); err != nil {
+			return report, err
+		}
+		// The plans and the counts by device only matter while the event is running
+		cutoff := cutoffs.SponsorTelemetry.UTC().Format(time.RFC3339)
+		if err := exec("sponsor_plans", `DELETE FROM sponsor_plans WHERE issued_at < ?`, cutoff); err != nil {
+			return report, err
+		}
+		if err := exec("sponsor_plan_counts", `DELETE FROM sponsor_plan_counts WHERE device_id != '' AND updated_at < ?`, cutoff); err != nil {
+			return report, err
+		}
+	}
+
+	if !cutoffs.ShopOrders.IsZero() {
+		if err := exec("shop_orders", `UPDATE shop_orders SET customer_name = '', customer_email = '', customer_notes = NULL, notes_flagged = 0
+WHERE created_at < ? AND customer_email != ''`, cutoffs.ShopOrders.UTC().Format(sqliteTimestampLayout)); err != nil {
+			return report, err
+		}
+	}
+
+	return report, tx.Commit()
+}
+
+func anonymizeSponsorTelemetry(tx *sql.Tx, cutoff string, counts map[string]int) error {
+	rows, err := tx.Query(`
+SELECT event_id, device_id FROM sponsor_sessions WHERE last_seen < ? AND device_id NOT LIKE ?
+UNION
+SELECT event_id, device_id FROM sponsor_exposures WHERE created_at < ? AND device_id NOT LIKE ?
+UNION
+SELECT event_id, device_id FROM sponsor_clicks WHERE clicked_at < ? AND device_id IS NOT NULL AND device_id != '' AND device_id NOT LIKE ?`,
+		cutoff, anonymizedDevicePrefix+"%", cutoff, anonymizedDevicePrefix+"%", cutoff, anonymizedDevicePrefix+"%")
+	if err != nil {
+		return err
+	}
+	type pair struct {
+		eventID  int
+		deviceID string
+	}
+	var pairs []pair
+	for rows.Next() {
+		var p pair
+		if err := rows.Scan(&p.eventID, &p.deviceID); err != nil {
+			_ = rows.Close()
+			return err
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, p := range pairs {
+		pseudonym, err := randomDevicePseudonym()
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"sponsor_sessions", "sponsor_exposures", "sponsor_clicks"} {
+			res, err := tx.Exec(fmt.Sprintf(`UPDATE %s SET device_id = ? WHERE event_id = ? AND device_id = ?`, table), pseudonym, p.eventID, p.deviceID)
+			if err != nil {
+				return fmt.Errorf("applying retention to %s: %w", table, err)
+			}
+			affected, err := res.RowsAffected()
+			if err != nil {
+				return err
+			}
+			counts[table] += int(affected)
+		}
+	}
+	return nil
+}
+
+func selectStrings(tx *sql.Tx, query string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		if value.Valid && strings.TrimSpace(value.String) != "" {
+			values = append(values, value.String)
+		}
+	}
+	return values, rows.Err()
+}
+
+// ErasePersonalData removes every row linked to a device identifier or to a customer email, and stores the receipt.
+// Votes and shop orders are anonymized instead of deleted, so that event results and accounting are preserved.
+// Archived events are rewritten as well.
+func (db *appdbimpl) ErasePersonalData(req ErasureRequest) (ErasureReceipt, error) {
+	subject := strings.TrimSpace(req.Subject)
+	if req.SubjectType == ErasureSubjectEmail {
+		subject = strings.ToLower(subject)
+	}
+	hash := sha256.Sum256([]byte(subject))
+	receipt := ErasureReceipt{
+		ID:          req.ReceiptID,
+		SubjectType: req.SubjectType,
+		SubjectHash: hex.EncodeToString(hash[:]),
+		RequestedBy: req.RequestedBy,
+		ExecutedAt:  req.RequestedAt.UTC().Format(time.RFC3339),
+		Actions:     []ErasureAction{},
+	}
+	if subject == "" {
+		return receipt, ErrInvalidErasureRequest
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return receipt, err
+	}
+	defer tx.Rollback()
+
+	exec := func(table, action, query string, args ...interface{}) error {
+		res, err := tx.Exec(query, args...)
+		if err != nil {
+			return fmt.Errorf("erasing %s: %w", table, err)
+		}
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		receipt.Actions = append(receipt.Actions, ErasureAction{Table: table, Action: action, Rows: int(affected)})
+		return nil
+	}
+
+	switch req.SubjectType {
+	case ErasureSubjectDevice:
+		receipt.SelfieImagePaths, err = selectStrings(tx, `SELECT image_path FROM selfies WHERE device_id = ?`, subject)
+		if err != nil {
+			return receipt, err
+		}
+		if err := exec("votes", erasureActionAnonymized, `UPDATE votes SET device_id = ? || lower(hex(randomblob(8))), contact_email = '', contact_locale = '' WHERE device_id = ?`, anonymizedDevicePrefix, subject); err != nil {
+			return receipt, err
+		}
+		for _, table := range []string{"selfies", "selfie_contest_votes", "reaction_tests", "sponsor_sessions", "sponsor_exposures", "sponsor_clicks", "sponsor_plans", "sponsor_plan_counts"} {
+			if err := exec(table, erasureActionDeleted, fmt.Sprintf(`DELETE FROM %s WHERE device_id = ?`, table), subject); err != nil {
+				return receipt, err
+			}
+		}
+		archived, paths, err := eraseDeviceFromArchives(tx, subject)
+		if err != nil {
+			return receipt, err
+		}
+		receipt.SelfieImagePaths = append(receipt.SelfieImagePaths, paths...)
+		receipt.Actions = append(receipt.Actions, ErasureAction{Table: "event_archives", Action: erasureActionAnonymized, Rows: archived})
+	case ErasureSubjectEmail:
+		if err := exec("shop_orders", erasureActionAnonymized, `UPDATE shop_orders SET customer_name = '', customer_email = '', customer_notes = NULL, notes_flagged = 0
+WHERE lower(trim(customer_email)) = ?`, subject); err != nil {
+			return receipt, err
+		}
+		if err := exec("votes", erasureActionAnonymized, `UPDATE votes SET contact_email = '', contact_locale = '' WHERE lower(trim(contact_email)) = ?`, subject); err != nil {
+			return receipt, err
+		}
+		if err := exec("mail_outbox", erasureActionDeleted, `DELETE FROM mail_outbox WHERE lower(trim(recipient)) = ?`, subject); err != nil {
+			return receipt, err
+		}
+	default:
+		return receipt, ErrInvalidErasureRequest
+	}
+
+	actions, err := json.Marshal(receipt.Actions)
+	if err != nil {
+		return receipt, err
+	}
+	if _, err := tx.Exec(`INSERT INTO privacy_erasures (id, subject_type, subject_hash, requested_by, executed_at, actions) VALUES (?, ?, ?, ?, ?, ?)`,
+		receipt.ID, receipt.SubjectType, receipt.SubjectHash, receipt.RequestedBy, receipt.ExecutedAt, string(actions)); err != nil {
+		return receipt, err
+	}
+
+	return receipt, tx.Commit()
+}
+
+// eraseDeviceFromArchives applies the device erasure to the archived events still holding their details. It returns
+// the number of archived rows touched and the image paths of the archived selfies dropped.
+func eraseDeviceFromArchives(tx *sql.Tx, deviceID string) (int, []string, error) {
+	rows, err := tx.Query(`SELECT event_id, payload FROM event_archives WHERE payload IS NOT NULL`)
+	if err != nil {
+		return 0, nil, err
+	}
+	payloads := map[int]eventArchivePayload{}
+	for rows.Next() {
+		var eventID int
+		var compressed []byte
+		if err := rows.Scan(&eventID, &compressed); err != nil {
+			_ = rows.Close()
+			return 0, nil, err
+		}
+		payload, err := decodeEventArchivePayload(compressed)
+		if err != nil {
+			_ = rows.Close()
+			return 0, nil, fmt.Errorf("decoding archive of event %d: %w", eventID, err)
+		}
+		payloads[eventID] = payload
+	}
+	if err := rows.Close(); err != nil {
+		return 0, nil, err
+	}
+
+	touched := 0
+	var imagePaths []string
+	for eventID, payload := range payloads {
+		changed := 0
+		// The rows referencing the dropped selfies and sponsor plans go with them, as their foreign keys would stop the
+		// restore. The tables come after the ones they reference in the archive.
+		droppedSelfies := map[int64]bool{}
+		droppedPlans := map[string]bool{}
+		for t := range payload.Tables {
+			table := &payload.Tables[t]
+			idColumn, deviceColumn, imageColumn, selfieColumn, planColumn, winnerColumn := -1, -1, -1, -1, -1, -1
+			for i, column := range table.Columns {
+				switch column {
+				case "id":
+					idColumn = i
+				case "device_id":
+					deviceColumn = i
+				case "image_path":
+					imageColumn = i
+				case "selfie_id":
+					selfieColumn = i
+				case "plan_id":
+					planColumn = i
+				case "winner_selfie_id":
+					winnerColumn = i
+				}
+			}
+
+			kept := table.Rows[:0]
+			for _, row := range table.Rows {
+				if selfieColumn >= 0 {
+					if id, ok := row[selfieColumn].(int64); ok && droppedSelfies[id] {
+						changed++
+						continue
+					}
+				}
+				if planColumn >= 0 {
+					if id, ok := row[planColumn].(string); ok && droppedPlans[id] {
+						changed++
+						continue
+					}
+				}
+				if winnerColumn >= 0 {
+					if id, ok := row[winnerColumn].(int64); ok && droppedSelfies[id] {
+						row[winnerColumn] = int64(0)
+						changed++
+					}
+				}
+				if deviceColumn < 0 {
+					kept = append(kept, row)
+					continue
+				}
+				if value, ok := row[deviceColumn].(string); !ok || value != deviceID {
+					kept = append(kept, row)
+					continue
+				}
+				changed++
+				if table.Table == "votes" {
+					pseudonym, err := randomDevicePseudonym()
+					if err != nil {
+						return 0, nil, err
+					}
+					row[deviceColumn] = pseudonym
+					kept = append(kept, row)
+					continue
+				}
+				if imageColumn >= 0 {
+					if path, ok := row[imageColumn].(string); ok && strings.TrimSpace(path) != "" {
+						imagePaths = append(imagePaths, path)
+					}
+				}
+				if idColumn >= 0 {
+					switch table.Table {
+					case "selfies":
+						if id, ok := row[idColumn].(int64); ok {
+							droppedSelfies[id] = true
+						}
+					case "sponsor_plans":
+						if id, ok := row[idColumn].(string); ok {
+							droppedPlans[id] = true
+						}
+					}
+				}
+			}
+			table.Rows = kept
+		}
+		if changed == 0 {
+			continue
+		}
+
+		compressed, err := encodeEventArchivePayload(payload)
+		if err != nil {
+			return 0, nil, err
+		}
+		if _, err := tx.Exec(`UPDATE event_archives SET payload = ? WHERE event_id = ?`, compressed, eventID); err != nil {
+			return 0, nil, err
+		}
+		touched += changed
+	}
+	return touched, imagePaths, nil
+}
+
+func (db *appdbimpl) ListErasureReceipts() ([]ErasureReceipt, error) {
+	rows, err := db.c.Query(`SELECT id, subject_type, subject_hash, COALESCE(requested_by, ''), executed_at, actions FROM privacy_erasures ORDER BY executed_at DESC, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var receipts []ErasureReceipt
+	for rows.Next() {
+		var receipt ErasureReceipt
+		var actions string
+		if err := rows.Scan(&receipt.ID, &receipt.SubjectType, &receipt.SubjectHash, &receipt.RequestedBy, &receipt.ExecutedAt, &actions); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(actions), &receipt.Actions); err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, receipt)
+	}
+	return receipts, rows.Err()
+}
@@ -0,0 +1,83 @@
+package database
+
+// RosterImport is a batch of teams, players and events to write at once. Rows with a zero ID are inserted, the
+// others replace every column of the existing row with the same ID, so they must carry the current value of the
+// columns that do not change.
+type RosterImport struct {
+	Teams   []Team
+	Players []Player
+	Events  []Event
+}
+
+// RosterImportIDs contains the IDs of the rows written by ImportRoster, in the same order as the batch.
+type RosterImportIDs struct {
+	Teams   []int
+	Players []int
+	Events  []int
+}
+
+// ImportRoster writes the whole batch in a single transaction: either every row is written, or none. Event prizes
+// are not touched.
+func (db *appdbimpl) ImportRoster(batch RosterImport) (RosterImportIDs, error) {
+	var ids RosterImportIDs
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return ids, err
+	}
+	defer tx.Rollback()
+
+	for _, team := range batch.Teams {
+		if team.ID > 0 {
+			if _, err := tx.Exec(`UPDATE teams SET name = ? WHERE id = ?`, team.Name, team.ID); err != nil {
+				return ids, err
+			}
+			ids.Teams = append(ids.Teams, team.ID)
+			continue
+		}
+		res, err := tx.Exec(`INSERT INTO teams (name) VALUES (?)`, team.Name)
+		if err != nil {
+			return ids, err
+		}
+		id, _ := res.LastInsertId()
+		ids.Teams = append(ids.Teams, int(id))
+	}
+
+	for _, p := range batch.Players {
+		if p.ID > 0 {
+			if _, err := tx.Exec(`UPDATE players SET first_name = ?, last_name = ?, role = ?, jersey_number = ?, image_url = ?, team_id = ? WHERE id = ?`,
+				p.FirstName, p.LastName, p.Role, p.JerseyNumber, p.ImageURL, p.TeamID, p.ID); err != nil {
+				return ids, err
+			}
+			ids.Players = append(ids.Players, p.ID)
+			continue
+		}
+		res, err := tx.Exec(`INSERT INTO players (first_name, last_name, role, jersey_number, image_url, team_id) VALUES (?, ?, ?, ?, ?, ?)`,
+			p.FirstName, p.LastName, p.Role, p.JerseyNumber, p.ImageURL, p.TeamID)
+		if err != nil {
+			return ids, err
+		}
+		id, _ := res.LastInsertId()
+		ids.Players = append(ids.Players, int(id))
+	}
+
+	for _, e := range batch.Events {
+		if e.ID > 0 {
+			if _, err := tx.Exec(`UPDATE events SET team1_id = ?, team2_id = ?, start_datetime = ?, location = ?, show_reaction_test = ?, show_selfie = ?, show_vote_trend = ?, show_feedback_survey = ? WHERE id = ?`,
+				e.Team1ID, e.Team2ID, e.StartDateTime, e.Location, boolToInt(e.ShowReactionTest), boolToInt(e.ShowSelfie), boolToInt(e.ShowVoteTrend), boolToInt(e.ShowFeedbackSurvey), e.ID); err != nil {
+				return ids, err
+			}
+			ids.Events = append(ids.Events, e.ID)
+			continue
+		}
+		res, err := tx.Exec(`INSERT INTO events (team1_id, team2_id, start_datetime, location, show_reaction_test, show_selfie, show_vote_trend, show_feedback_survey) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			e.Team1ID, e.Team2ID, e.StartDateTime, e.Location, boolToInt(e.ShowReactionTest), boolToInt(e.ShowSelfie), boolToInt(e.ShowVoteTrend), boolToInt(e.ShowFeedbackSurvey))
+		if err != nil {
+			return ids, err
+		}
+		id, _ := res.LastInsertId()
+		ids.Events = append(ids.Events, int(id))
+	}
+
+	return ids, tx.Commit()
+}
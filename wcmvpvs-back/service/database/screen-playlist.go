@@ -0,0 +1,152 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScreenPlaylist describes how the selfies of an event rotate on the arena screen. PinnedSelfieIDs are shown first,
+// in the given order.
+type ScreenPlaylist struct {
+	EventID         int    `json:"event_id"`
+	Order           string `json:"order"`
+	DwellSeconds    int    `json:"dwell_seconds"`
+	MaxRepeats      int    `json:"max_repeats"`
+	PinnedSelfieIDs []int  `json:"pinned_selfie_ids"`
+	UpdatedAt       string `json:"updated_at"`
+}
+
+// ScreenSelfie is a selfie approved for the arena screen, with the number of times the screen has shown it.
+type ScreenSelfie struct {
+	Selfie
+	Plays        int    `json:"plays"`
+	LastPlayedAt string `json:"last_played_at"`
+}
+
+// GetScreenPlaylist returns the screen settings of the event, or sql.ErrNoRows if they were never saved.
+func (db *appdbimpl) GetScreenPlaylist(eventID int) (ScreenPlaylist, error) {
+	playlist := ScreenPlaylist{EventID: eventID, PinnedSelfieIDs: []int{}}
+	err := db.c.QueryRow(`SELECT order_mode, dwell_seconds, max_repeats, updated_at FROM screen_playlists WHERE event_id = ?`, eventID).
+		Scan(&playlist.Order, &playlist.DwellSeconds, &playlist.MaxRepeats, &playlist.UpdatedAt)
+	if err != nil {
+		return ScreenPlaylist{}, err
+	}
+
+	rows, err := db.c.Query(`SELECT selfie_id FROM screen_pins WHERE event_id = ? ORDER BY position`, eventID)
+	if err != nil {
+		return ScreenPlaylist{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return ScreenPlaylist{}, err
+		}
+		playlist.PinnedSelfieIDs = append(playlist.PinnedSelfieIDs, id)
+	}
+	return playlist, rows.Err()
+}
+
+// SaveScreenPlaylist creates or replaces the screen settings of the event, pins included. Pinned selfies must belong
+// to the event, otherwise sql.ErrNoRows is returned and nothing is saved.
+func (db *appdbimpl) SaveScreenPlaylist(playlist ScreenPlaylist) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+INSERT INTO screen_playlists (event_id, order_mode, dwell_seconds, max_repeats, updated_at)
+VALUES (?, ?, ?, ?, ?)
+ON CONFLICT(event_id) DO UPDATE SET
+        order_mode = excluded.order_mode,
+        dwell_seconds = excluded.dwell_seconds,
+        max_repeats = excluded.max_repeats,
+        updated_at = excluded.updated_at`,
+		playlist.EventID, strings.TrimSpace(playlist.Order), playlist.DwellSeconds, playlist.MaxRepeats, strings.TrimSpace(playlist.UpdatedAt))
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM screen_pins WHERE event_id = ?`, playlist.EventID); err != nil {
+		return err
+	}
+	for position, selfieID := range playlist.PinnedSelfieIDs {
+		var exists int
+		if err := tx.QueryRow(`SELECT 1 FROM selfies WHERE id = ? AND event_id = ?`, selfieID, playlist.EventID).Scan(&exists); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO screen_pins (event_id, selfie_id, position) VALUES (?, ?, ?)`, playlist.EventID, selfieID, position); err != nil {
+			return fmt.Errorf("pinning selfie %d: %w", selfieID, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListScreenSelfies returns the approved selfies of the event flagged to be shown on screen, oldest first.
+func (db *appdbimpl) ListScreenSelfies(eventID int) ([]ScreenSelfie, error) {
+	rows, err := db.c.Query(`
+SELECT `+selfieColumns+`,
+       COALESCE((SELECT plays FROM screen_plays WHERE selfie_id = selfies.id), 0),
+       COALESCE((SELECT last_played_at FROM screen_plays WHERE selfie_id = selfies.id), '')
+FROM selfies
+WHERE event_id = ? AND approved = 1 AND show_on_screen = 1
+ORDER BY created_at, id`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selfies := []ScreenSelfie{}
+	for rows.Next() {
+		var s ScreenSelfie
+		selfie, err := scanSelfieRow(rows, &s.Plays, &s.LastPlayedAt)
+		if err != nil {
+			return nil, err
+		}
+		s.Selfie = selfie
+		selfies = append(selfies, s)
+	}
+	return selfies, rows.Err()
+}
+
+// screenPlayTimeLayout has a fixed width, so that last_played_at sorts lexicographically.
+const screenPlayTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// RecordScreenPlay counts one more appearance of the selfie on the arena screen and returns how many times it has
+// been shown. It returns sql.ErrNoRows if the selfie does not belong to the event or is not meant for the screen.
+func (db *appdbimpl) RecordScreenPlay(eventID, selfieID int, playedAt time.Time) (int, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+INSERT INTO screen_plays (selfie_id, event_id, plays, last_played_at)
+SELECT id, event_id, 1, ? FROM selfies WHERE id = ? AND event_id = ? AND approved = 1 AND show_on_screen = 1
+ON CONFLICT(selfie_id) DO UPDATE SET
+        plays = plays + 1,
+        last_played_at = excluded.last_played_at`,
+		playedAt.UTC().Format(screenPlayTimeLayout), selfieID, eventID)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if affected == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	var plays int
+	if err := tx.QueryRow(`SELECT plays FROM screen_plays WHERE selfie_id = ?`, selfieID).Scan(&plays); err != nil {
+		return 0, err
+	}
+	return plays, tx.Commit()
+}
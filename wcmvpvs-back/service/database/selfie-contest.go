@@ -0,0 +1,212 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Statuses of a selfie contest. Fans can vote only while the contest is open; announcing the winner closes it.
+const (
+	SelfieContestOpen   = "open"
+	SelfieContestClosed = "closed"
+)
+
+// contestVoteTimeLayout has a fixed width, so that the vote times sort lexicographically.
+const contestVoteTimeLayout = "2006-01-02T15:04:05.000Z"
+
+var (
+	// ErrSelfieContestAnnounced is returned when announcing the winner of a contest that already has one.
+	ErrSelfieContestAnnounced = errors.New("selfie contest winner already announced")
+
+	// ErrSelfieNotInContest is returned for selfies of other events, or not approved.
+	ErrSelfieNotInContest = errors.New("selfie is not part of the contest")
+
+	// ErrSelfieContestPrizeMissing is returned when the prize linked to the contest no longer exists.
+	ErrSelfieContestPrizeMissing = errors.New("selfie contest prize not found")
+)
+
+// SelfieContest is the photo contest of an event, where fans vote for their favourite approved selfie. PrizeID links
+// an EventPrize, assigned to the author of the winning selfie; zero for none.
+type SelfieContest struct {
+	EventID        int    `json:"event_id"`
+	Status         string `json:"status"`
+	PrizeID        int    `json:"prize_id"`
+	WinnerSelfieID int    `json:"winner_selfie_id"`
+	AnnouncedAt    string `json:"announced_at"`
+	UpdatedAt      string `json:"updated_at"`
+}
+
+// SelfieContestEntry is an approved selfie with the contest votes it received.
+type SelfieContestEntry struct {
+	Selfie
+	Votes      int    `json:"votes"`
+	LastVoteAt string `json:"last_vote_at"`
+}
+
+// GetSelfieContest returns the contest of the event, or sql.ErrNoRows if the event has none.
+func (db *appdbimpl) GetSelfieContest(eventID int) (SelfieContest, error) {
+	contest := SelfieContest{EventID: eventID}
+	err := db.c.QueryRow(`SELECT status, prize_id, winner_selfie_id, announced_at, updated_at FROM selfie_contests WHERE event_id = ?`, eventID).
+		Scan(&contest.Status, &contest.PrizeID, &contest.WinnerSelfieID, &contest.AnnouncedAt, &contest.UpdatedAt)
+	if err != nil {
+		return SelfieContest{}, err
+	}
+	return contest, nil
+}
+
+// SaveSelfieContest creates the contest of the event, or updates its status and prize. The winner is set only by
+// AnnounceSelfieContestWinner.
+func (db *appdbimpl) SaveSelfieContest(contest SelfieContest) (SelfieContest, error) {
+	_, err := db.c.Exec(`
+INSERT INTO selfie_contests (event_id, status, prize_id, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(event_id) DO UPDATE SET
+        status = excluded.status,
+        prize_id = excluded.prize_id,
+        updated_at = excluded.updated_at`,
+		contest.EventID, strings.TrimSpace(contest.Status), contest.PrizeID, strings.TrimSpace(contest.UpdatedAt))
+	if err != nil {
+		return SelfieContest{}, err
+	}
+	return db.GetSelfieContest(contest.EventID)
+}
+
+// AddSelfieContestVote records the vote of the device for an approved selfie of the event. Devices can vote once per
+// event: a second vote fails with the unique constraint on (event_id, device_id).
+func (db *appdbimpl) AddSelfieContestVote(eventID, selfieID int, deviceID string, at time.Time) error {
+	var exists int
+	err := db.c.QueryRow(`SELECT 1 FROM selfies WHERE id = ? AND event_id = ? AND approved = 1`, selfieID, eventID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSelfieNotInContest
+	} else if err != nil {
+		return err
+	}
+	_, err = db.c.Exec(`INSERT INTO selfie_contest_votes (event_id, selfie_id, device_id, created_at) VALUES (?, ?, ?, ?)`,
+		eventID, selfieID, strings.TrimSpace(deviceID), at.UTC().Format(contestVoteTimeLayout))
+	return err
+}
+
+// GetSelfieContestVote returns the selfie the device voted for, or sql.ErrNoRows.
+func (db *appdbimpl) GetSelfieContestVote(eventID int, deviceID string) (int, error) {
+	var selfieID int
+	err := db.c.QueryRow(`SELECT selfie_id FROM selfie_contest_votes WHERE event_id = ? AND device_id = ?`, eventID, strings.TrimSpace(deviceID)).Scan(&selfieID)
+	return selfieID, err
+}
+
+// ListSelfieContestRanking returns the approved selfies of the event, the most voted first. Among selfies with the
+// same votes, the one that reached them first comes first.
+func (db *appdbimpl) ListSelfieContestRanking(eventID int) ([]SelfieContestEntry, error) {
+	rows, err := db.c.Query(`
+SELECT * FROM (
+        SELECT `+selfieColumns+`,
+               (SELECT COUNT(*) FROM selfie_contest_votes WHERE selfie_id = selfies.id) AS votes,
+               COALESCE((SELECT MAX(created_at) FROM selfie_contest_votes WHERE selfie_id = selfies.id), '') AS last_vote_at
+        FROM selfies
+        WHERE event_id = ? AND approved = 1
+)
+ORDER BY votes DESC, last_vote_at ASC, id ASC`, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []SelfieContestEntry{}
+	for rows.Next() {
+		var entry SelfieContestEntry
+		selfie, err := scanSelfieRow(rows, &entry.Votes, &entry.LastVoteAt)
+		if err != nil {
+			return nil, err
+		}
+		entry.Selfie = selfie
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// AnnounceSelfieContestWinner closes the contest and declares the selfie the winner. When the contest has a prize,
+// it is assigned, in the same transaction, to the MVP vote of the device that uploaded the selfie, with the same
+// rules of AssignPrizeWinner.
+func (db *appdbimpl) AnnounceSelfieContestWinner(eventID, selfieID int, at time.Time) (SelfieContest, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return SelfieContest{}, err
+	}
+	defer tx.Rollback()
+
+	var prizeID, winnerID int
+	if err := tx.QueryRow(`SELECT prize_id, winner_selfie_id FROM selfie_contests WHERE event_id = ?`, eventID).Scan(&prizeID, &winnerID); err != nil {
+		return SelfieContest{}, err
+	}
+	if winnerID > 0 {
+		return SelfieContest{}, ErrSelfieContestAnnounced
+	}
+
+	var deviceID string
+	err = tx.QueryRow(`SELECT device_id FROM selfies WHERE id = ? AND event_id = ? AND approved = 1`, selfieID, eventID).Scan(&deviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return SelfieContest{}, ErrSelfieNotInContest
+	} else if err != nil {
+		return SelfieContest{}, err
+	}
+
+	if prizeID > 0 {
+		var voteID int
+		err := tx.QueryRow(`SELECT id FROM votes WHERE event_id = ? AND device_id = ?`, eventID, deviceID).Scan(&voteID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return SelfieContest{}, ErrPrizeVoteMismatch
+		} else if err != nil {
+			return SelfieContest{}, err
+		}
+		if err := assignPrizeWinnerTx(tx, eventID, prizeID, voteID); errors.Is(err, sql.ErrNoRows) {
+			return SelfieContest{}, ErrSelfieContestPrizeMissing
+		} else if err != nil {
+			return SelfieContest{}, err
+		}
+	}
+
+	announcedAt := at.UTC().Format(time.RFC3339)
+	_, err = tx.Exec(`UPDATE selfie_contests SET status = ?, winner_selfie_id = ?, announced_at = ?, updated_at = ? WHERE event_id = ?`,
+		SelfieContestClosed, selfieID, announcedAt, announcedAt, eventID)
+	if err != nil {
+		return SelfieContest{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return SelfieContest{}, err
+	}
+	return db.GetSelfieContest(eventID)
+}
+
+// ClearSelfieContestWinner withdraws the announced winner, and the prize assigned with it. The contest stays closed.
+// It returns sql.ErrNoRows if no winner has been announced.
+func (db *appdbimpl) ClearSelfieContestWinner(eventID int) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prizeID, winnerID int
+	if err := tx.QueryRow(`SELECT prize_id, winner_selfie_id FROM selfie_contests WHERE event_id = ?`, eventID).Scan(&prizeID, &winnerID); err != nil {
+		return err
+	}
+	if winnerID == 0 {
+		return sql.ErrNoRows
+	}
+
+	if prizeID > 0 {
+		_, err := tx.Exec(`
+UPDATE event_prizes SET winner_vote_id = NULL, winner_assigned_at = NULL
+WHERE id = ? AND event_id = ? AND winner_vote_id IN (
+        SELECT v.id FROM votes v JOIN selfies s ON s.device_id = v.device_id AND s.event_id = v.event_id WHERE s.id = ?
+)`, prizeID, eventID, winnerID)
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`UPDATE selfie_contests SET winner_selfie_id = 0, announced_at = '' WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// SelfieFrame is the branding composited onto the approved selfies of an event: an overlay image, the caption and a
+// sponsor logo. Revision changes every time the frame, or the logo of its sponsor, changes, so that the branded
+// renditions made with an older frame can be recognized.
+type SelfieFrame struct {
+	EventID int `json:"event_id"`
+
+	// OverlayData is a PNG data URL, empty for no overlay
+	OverlayData     string `json:"overlay_data"`
+	CaptionPosition string `json:"caption_position"`
+
+	// SponsorID is the sponsor whose logo is drawn on the selfies, zero for none
+	SponsorID    int    `json:"sponsor_id"`
+	LogoPosition string `json:"logo_position"`
+
+	Revision  int    `json:"revision"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// SelfieRendition records the branded image stored for a selfie: the image it was made from and the frame revision.
+type SelfieRendition struct {
+	SelfieID      int
+	EventID       int
+	SourcePath    string
+	FrameRevision int
+}
+
+// GetSelfieFrame returns the frame of the event, or sql.ErrNoRows if the event has none.
+func (db *appdbimpl) GetSelfieFrame(eventID int) (SelfieFrame, error) {
+	frame := SelfieFrame{EventID: eventID}
+	err := db.c.QueryRow(`SELECT overlay_data, caption_position, sponsor_id, logo_position, revision, updated_at FROM selfie_frames WHERE event_id = ?`, eventID).
+		Scan(&frame.OverlayData, &frame.CaptionPosition, &frame.SponsorID, &frame.LogoPosition, &frame.Revision, &frame.UpdatedAt)
+	if err != nil {
+		return SelfieFrame{}, err
+	}
+	return frame, nil
+}
+
+// SaveSelfieFrame creates or replaces the frame of the event and returns it with the new revision.
+func (db *appdbimpl) SaveSelfieFrame(frame SelfieFrame) (SelfieFrame, error) {
+	_, err := db.c.Exec(`
+INSERT INTO selfie_frames (event_id, overlay_data, caption_position, sponsor_id, logo_position, revision, updated_at)
+VALUES (?, ?, ?, ?, ?, 1, ?)
+ON CONFLICT(event_id) DO UPDATE SET
+        overlay_data = excluded.overlay_data,
+        caption_position = excluded.caption_position,
+        sponsor_id = excluded.sponsor_id,
+        logo_position = excluded.logo_position,
+        revision = selfie_frames.revision + 1,
+        updated_at = excluded.updated_at`,
+		frame.EventID, strings.TrimSpace(frame.OverlayData), strings.TrimSpace(frame.CaptionPosition), frame.SponsorID,
+		strings.TrimSpace(frame.LogoPosition), strings.TrimSpace(frame.UpdatedAt))
+	if err != nil {
+		return SelfieFrame{}, err
+	}
+	return db.GetSelfieFrame(frame.EventID)
+}
+
+// DeleteSelfieFrame removes the frame of the event, and the records of its branded renditions, so that a new frame
+// starting again from revision 1 does not reuse them. It returns sql.ErrNoRows if the event has no frame.
+func (db *appdbimpl) DeleteSelfieFrame(eventID int) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM selfie_frames WHERE event_id = ?`, eventID)
+	if err != nil {
+		return err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM selfie_renditions WHERE event_id = ?`, eventID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetSelfieRendition returns the branded rendition recorded for the selfie, or sql.ErrNoRows.
+func (db *appdbimpl) GetSelfieRendition(selfieID int) (SelfieRendition, error) {
+	rendition := SelfieRendition{SelfieID: selfieID}
+	err := db.c.QueryRow(`SELECT event_id, source_path, frame_revision FROM selfie_renditions WHERE selfie_id = ?`, selfieID).
+		Scan(&rendition.EventID, &rendition.SourcePath, &rendition.FrameRevision)
+	if err != nil {
+		return SelfieRendition{}, err
+	}
+	return rendition, nil
+}
+
+func (db *appdbimpl) SaveSelfieRendition(rendition SelfieRendition) error {
+	_, err := db.c.Exec(`
+INSERT INTO selfie_renditions (selfie_id, event_id, source_path, frame_revision)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(selfie_id) DO UPDATE SET
+        event_id = excluded.event_id,
+        source_path = excluded.source_path,
+        frame_revision = excluded.frame_revision`,
+		rendition.SelfieID, rendition.EventID, rendition.SourcePath, rendition.FrameRevision)
+	return err
+}
+
+// invalidateSponsorFrames bumps the revision of the frames using the sponsor logo, so that the selfies are branded
+// again. When the sponsor has been deleted, the logo is removed from the frames.
+func (db *appdbimpl) invalidateSponsorFrames(sponsorID int, deleted bool) error {
+	query := `UPDATE selfie_frames SET revision = revision + 1 WHERE sponsor_id = ?`
+	if deleted {
+		query = `UPDATE selfie_frames SET revision = revision + 1, sponsor_id = 0 WHERE sponsor_id = ?`
+	}
+	_, err := db.c.Exec(query, sponsorID)
+	return err
+}
@@ -0,0 +1,226 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Moderation statuses of a selfie. Approved selfies also have Selfie.Approved set.
+const (
+	SelfieStatusPending  = "pending"
+	SelfieStatusApproved = "approved"
+	SelfieStatusRejected = "rejected"
+)
+
+// moderationTimeLayout is used for moderated_at and claimed_until. It sorts lexicographically, so expired claims can
+// be selected with a plain string comparison.
+const moderationTimeLayout = "2006-01-02T15:04:05Z"
+
+// ErrInvalidSelfieStatus is returned for statuses other than the SelfieStatus* constants.
+var ErrInvalidSelfieStatus = errors.New("invalid selfie status")
+
+// SelfieModeration is a decision taken by a moderator on one or more selfies.
+type SelfieModeration struct {
+	Status string
+
+	// ShowOnScreen is ignored unless Status is SelfieStatusApproved
+	ShowOnScreen bool
+
+	// RejectionReason is shown to the fan; it is ignored unless Status is SelfieStatusRejected
+	RejectionReason string
+
+	Moderator string
+	At        time.Time
+}
+
+// SelfieModerationResult lists what ModerateSelfies did with each selfie.
+type SelfieModerationResult struct {
+	Updated []int `json:"updated"`
+
+	// Claimed are the selfies skipped because another moderator is reviewing them
+	Claimed []int `json:"claimed"`
+
+	// Missing are the selfies not found in the event
+	Missing []int `json:"missing"`
+}
+
+type SelfieQueueCounts struct {
+	Pending  int `json:"pending"`
+	Approved int `json:"approved"`
+	Rejected int `json:"rejected"`
+}
+
+func isValidSelfieStatus(status string) bool {
+	switch status {
+	case SelfieStatusPending, SelfieStatusApproved, SelfieStatusRejected:
+		return true
+	}
+	return false
+}
+
+// ListSelfieQueue returns up to limit selfies of the event with the given status: pending selfies oldest first, the
+// others most recently moderated first.
+func (db *appdbimpl) ListSelfieQueue(eventID int, status string, limit int) ([]Selfie, error) {
+	if !isValidSelfieStatus(status) {
+		return nil, ErrInvalidSelfieStatus
+	}
+	order := `moderated_at DESC, id DESC`
+	if status == SelfieStatusPending {
+		order = `created_at, id`
+	}
+	rows, err := db.c.Query(`SELECT `+selfieColumns+` FROM selfies WHERE event_id = ? AND status = ? ORDER BY `+order+` LIMIT ?`, eventID, status, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selfies := []Selfie{}
+	for rows.Next() {
+		selfie, err := scanSelfieRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		selfies = append(selfies, selfie)
+	}
+	return selfies, rows.Err()
+}
+
+func (db *appdbimpl) CountSelfieQueue(eventID int) (SelfieQueueCounts, error) {
+	var counts SelfieQueueCounts
+	rows, err := db.c.Query(`SELECT status, COUNT(*) FROM selfies WHERE event_id = ? GROUP BY status`, eventID)
+	if err != nil {
+		return counts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return counts, err
+		}
+		switch status {
+		case SelfieStatusPending:
+			counts.Pending = count
+		case SelfieStatusApproved:
+			counts.Approved = count
+		case SelfieStatusRejected:
+			counts.Rejected = count
+		}
+	}
+	return counts, rows.Err()
+}
+
+// ClaimSelfies assigns to the moderator, until `until`, up to limit pending selfies of the event that nobody else is
+// reviewing, oldest first. The selfies already claimed by the moderator are returned first, with the claim extended.
+func (db *appdbimpl) ClaimSelfies(eventID int, moderator string, limit int, now, until time.Time) ([]Selfie, error) {
+	moderator = strings.TrimSpace(moderator)
+	nowText := now.UTC().Format(moderationTimeLayout)
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+UPDATE selfies SET claimed_by = ?, claimed_until = ?
+WHERE id IN (
+        SELECT id FROM selfies
+        WHERE event_id = ? AND status = 'pending' AND (claimed_by = ? OR claimed_by = '' OR claimed_until <= ?)
+        ORDER BY claimed_by = ? DESC, created_at, id
+        LIMIT ?
+)`, moderator, until.UTC().Format(moderationTimeLayout), eventID, moderator, nowText, moderator, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.Query(`SELECT `+selfieColumns+` FROM selfies WHERE event_id = ? AND status = 'pending' AND claimed_by = ? AND claimed_until > ? ORDER BY created_at, id`, eventID, moderator, nowText)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	selfies := []Selfie{}
+	for rows.Next() {
+		selfie, err := scanSelfieRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		selfies = append(selfies, selfie)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	return selfies, tx.Commit()
+}
+
+// ReleaseSelfieClaims gives back to the queue the selfies of the event claimed by the moderator.
+func (db *appdbimpl) ReleaseSelfieClaims(eventID int, moderator string) (int, error) {
+	res, err := db.c.Exec(`UPDATE selfies SET claimed_by = '', claimed_until = '' WHERE event_id = ? AND claimed_by = ?`, eventID, strings.TrimSpace(moderator))
+	if err != nil {
+		return 0, err
+	}
+	released, err := res.RowsAffected()
+	return int(released), err
+}
+
+// ModerateSelfies applies the decision to the selfies of the event, in a single transaction. Selfies claimed by
+// another moderator, with a claim not yet expired, are left untouched. Applying a decision releases the claim. A selfie
+// that is no longer approved leaves the contest: its votes are deleted, so that the voters can vote again.
+func (db *appdbimpl) ModerateSelfies(eventID int, ids []int, moderation SelfieModeration) (SelfieModerationResult, error) {
+	result := SelfieModerationResult{Updated: []int{}, Claimed: []int{}, Missing: []int{}}
+	if !isValidSelfieStatus(moderation.Status) {
+		return result, ErrInvalidSelfieStatus
+	}
+
+	approved := moderation.Status == SelfieStatusApproved
+	showOnScreen := approved && moderation.ShowOnScreen
+	reason := ""
+	if moderation.Status == SelfieStatusRejected {
+		reason = strings.TrimSpace(moderation.RejectionReason)
+	}
+	moderator := strings.TrimSpace(moderation.Moderator)
+	at := moderation.At.UTC().Format(moderationTimeLayout)
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		var claimedBy, claimedUntil string
+		err := tx.QueryRow(`SELECT claimed_by, claimed_until FROM selfies WHERE id = ? AND event_id = ?`, id, eventID).Scan(&claimedBy, &claimedUntil)
+		if errors.Is(err, sql.ErrNoRows) {
+			result.Missing = append(result.Missing, id)
+			continue
+		} else if err != nil {
+			return result, err
+		}
+		if claimedBy != "" && claimedBy != moderator && claimedUntil > at {
+			result.Claimed = append(result.Claimed, id)
+			continue
+		}
+
+		_, err = tx.Exec(`
+UPDATE selfies SET status = ?, approved = ?, show_on_screen = ?, rejection_reason = ?, moderated_by = ?, moderated_at = ?,
+        claimed_by = '', claimed_until = ''
+WHERE id = ?`, moderation.Status, boolToInt(approved), boolToInt(showOnScreen), reason, moderator, at, id)
+		if err != nil {
+			return result, err
+		}
+		if !approved {
+			if _, err := tx.Exec(`DELETE FROM selfie_contest_votes WHERE selfie_id = ?`, id); err != nil {
+				return result, err
+			}
+		}
+		result.Updated = append(result.Updated, id)
+	}
+
+	return result, tx.Commit()
+}
@@ -0,0 +1,375 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The states of a shop order. An order is created waiting for the payment; cancelled and refunded are final.
+const (
+	ShopOrderStatusPendingPayment = "pending_payment"
+	ShopOrderStatusPaid           = "paid"
+	ShopOrderStatusReadyForPickup = "ready_for_pickup"
+	ShopOrderStatusCollected      = "collected"
+	ShopOrderStatusCancelled      = "cancelled"
+	ShopOrderStatusRefunded       = "refunded"
+)
+
+var (
+	// ErrInvalidShopOrderTransition is returned when the order cannot move from its status to the one asked.
+	ErrInvalidShopOrderTransition = errors.New("invalid shop order status transition")
+
+	// ErrInvalidPickupEvent is returned for pickup events that do not exist or are concluded.
+	ErrInvalidPickupEvent = errors.New("invalid pickup event")
+)
+
+// shopOrderTransitions are the states an order can move to from each state.
+var shopOrderTransitions = map[string][]string{
+	ShopOrderStatusPendingPayment: {ShopOrderStatusPaid, ShopOrderStatusCancelled},
+	ShopOrderStatusPaid:           {ShopOrderStatusReadyForPickup, ShopOrderStatusRefunded},
+	ShopOrderStatusReadyForPickup: {ShopOrderStatusCollected, ShopOrderStatusRefunded},
+	ShopOrderStatusCollected:      {ShopOrderStatusRefunded},
+}
+
+// IsShopOrderStatus tells whether status is one of the states of an order.
+func IsShopOrderStatus(status string) bool {
+	switch status {
+	case ShopOrderStatusPendingPayment, ShopOrderStatusPaid, ShopOrderStatusReadyForPickup, ShopOrderStatusCollected, ShopOrderStatusCancelled, ShopOrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+// CanTransitionShopOrder tells whether an order can move from the status from to the status to.
+func CanTransitionShopOrder(from, to string) bool {
+	for _, next := range shopOrderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ShopOrderStatusChange is an entry of the status history of an order.
+type ShopOrderStatusChange struct {
+	ID         int    `json:"id"`
+	OrderID    int    `json:"order_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Admin      string `json:"admin"`
+	Note       string `json:"note,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ShopOrderFilter selects the orders of ListShopOrders. The zero value selects all of them.
+type ShopOrderFilter struct {
+	// Statuses keeps the orders in one of the states, all of them when empty
+	Statuses []string
+
+	// Query matches the order number, or part of the customer name or email
+	Query string
+
+	// PickupEventID keeps the orders collected at the event; -1 keeps those without a pickup event
+	PickupEventID int
+
+	// From and To bound the creation date, as YYYY-MM-DD, both included
+	From string
+	To   string
+
+	// Limit is the maximum number of orders returned, zero for all of them
+	Limit  int
+	Offset int
+}
+
+// ListShopOrders returns the orders matching the filter with their items, newest first, and the number of orders
+// matching it regardless of Limit and Offset.
+func (db *appdbimpl) ListShopOrders(filter ShopOrderFilter) ([]ShopOrder, int, error) {
+	var conditions []string
+	var args []interface{}
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, `status IN (?`+strings.Repeat(`, ?`, len(filter.Statuses)-1)+`)`)
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+	if query := strings.TrimSpace(filter.Query); query != "" {
+		pattern := "%" + strings.ToLower(query) + "%"
+		id, _ := strconv.Atoi(strings.TrimPrefix(query, "#"))
+		conditions = append(conditions, `(id = ? OR LOWER(customer_name) LIKE ? OR LOWER(customer_email) LIKE ?)`)
+		args = append(args, id, pattern, pattern)
+	}
+	switch {
+	case filter.PickupEventID > 0:
+		conditions = append(conditions, `pickup_event_id = ?`)
+		args = append(args, filter.PickupEventID)
+	case filter.PickupEventID < 0:
+		conditions = append(conditions, `pickup_event_id IS NULL`)
+	}
+	if filter.From != "" {
+		conditions = append(conditions, `date(created_at) >= date(?)`)
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		conditions = append(conditions, `date(created_at) <= date(?)`)
+		args = append(args, filter.To)
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = `WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	var total int
+	if err := db.c.QueryRow(`SELECT COUNT(1) FROM shop_orders `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	query := `SELECT ` + shopOrderColumns + ` FROM shop_orders ` + where + ` ORDER BY id DESC`
+	if filter.Limit > 0 {
+		query += ` LIMIT ? OFFSET ?`
+		args = append(args, filter.Limit, filter.Offset)
+	}
+	orders, err := db.queryShopOrders(query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	return orders, total, nil
+}
+
+// GetShopOrder returns the order with its items, status history and payments, or sql.ErrNoRows.
+func (db *appdbimpl) GetShopOrder(id int) (ShopOrder, error) {
+	orders, err := db.queryShopOrders(`SELECT `+shopOrderColumns+` FROM shop_orders WHERE id = ?`, id)
+	if err != nil {
+		return ShopOrder{}, err
+	}
+	if len(orders) == 0 {
+		return ShopOrder{}, sql.ErrNoRows
+	}
+	order := orders[0]
+
+	rows, err := db.c.Query(`SELECT id, order_id, from_status, to_status, admin, note, created_at FROM shop_order_status_changes WHERE order_id = ? ORDER BY id`, id)
+	if err != nil {
+		return ShopOrder{}, err
+	}
+	defer rows.Close()
+	order.History = []ShopOrderStatusChange{}
+	for rows.Next() {
+		var change ShopOrderStatusChange
+		if err := rows.Scan(&change.ID, &change.OrderID, &change.FromStatus, &change.ToStatus, &change.Admin, &change.Note, &change.CreatedAt); err != nil {
+			return ShopOrder{}, err
+		}
+		order.History = append(order.History, change)
+	}
+	if err := rows.Err(); err != nil {
+		return ShopOrder{}, err
+	}
+
+	order.Payments, err = queryShopPayments(db.c, `WHERE order_id = ? ORDER BY id`, id)
+	return order, err
+}
+
+// UpdateShopOrderStatus moves the order to status and records the change in its history. Cancelling or refunding an
+// order not collected yet gives its items back to the stock. It returns sql.ErrNoRows for unknown orders and
+// ErrInvalidShopOrderTransition when the order cannot move to status.
+func (db *appdbimpl) UpdateShopOrderStatus(id int, status, admin, note string, at time.Time) (ShopOrder, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return ShopOrder{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if err := changeShopOrderStatus(tx, id, status, admin, note, at.UTC().Format(time.RFC3339)); err != nil {
+		return ShopOrder{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ShopOrder{}, err
+	}
+	committed = true
+	return db.GetShopOrder(id)
+}
+
+// changeShopOrderStatus moves the order to status within tx, logging the change and giving the items back to the stock
+// when the order is cancelled or refunded before being collected.
+func changeShopOrderStatus(tx *sql.Tx, id int, status, admin, note, now string) error {
+	var current string
+	if err := tx.QueryRow(`SELECT status FROM shop_orders WHERE id = ?`, id).Scan(&current); err != nil {
+		return err
+	}
+	if !CanTransitionShopOrder(current, status) {
+		return ErrInvalidShopOrderTransition
+	}
+	// The status in the condition keeps a concurrent change from being applied twice
+	result, err := tx.Exec(`UPDATE shop_orders SET status = ?, status_updated_at = ? WHERE id = ? AND status = ?`, status, now, id, current)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return ErrInvalidShopOrderTransition
+	}
+	if _, err := tx.Exec(`INSERT INTO shop_order_status_changes (order_id, from_status, to_status, admin, note, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, current, status, admin, strings.TrimSpace(note), now); err != nil {
+		return err
+	}
+
+	if (status == ShopOrderStatusCancelled || status == ShopOrderStatusRefunded) && current != ShopOrderStatusCollected {
+		return restockShopOrder(tx, id, fmt.Sprintf("Ordine #%d: %s", id, status), admin, now)
+	}
+	return nil
+}
+
+// SetShopOrderPickupEvent changes the event where the order is collected; zero clears it. It returns sql.ErrNoRows for
+// unknown orders and ErrInvalidPickupEvent for events that do not exist or are concluded.
+func (db *appdbimpl) SetShopOrderPickupEvent(id, eventID int) error {
+	var pickupEventID interface{}
+	if eventID > 0 {
+		var open int
+		if err := db.c.QueryRow(`SELECT COUNT(1) FROM events WHERE id = ? AND is_concluded = 0`, eventID).Scan(&open); err != nil {
+			return err
+		}
+		if open == 0 {
+			return ErrInvalidPickupEvent
+		}
+		pickupEventID = eventID
+	}
+	result, err := db.c.Exec(`UPDATE shop_orders SET pickup_event_id = ? WHERE id = ?`, pickupEventID, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// NextPickupEventID returns the first event not concluded starting from at, or zero when there is none.
+func (db *appdbimpl) NextPickupEventID(at time.Time) (int, error) {
+	var id int
+	err := db.c.QueryRow(`SELECT id FROM events WHERE is_concluded = 0 AND datetime(start_datetime) >= datetime(?) ORDER BY datetime(start_datetime), id LIMIT 1`,
+		at.UTC().Format(time.RFC3339)).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// restockShopOrder gives the items of the order back to the stock they were taken from, logging the change where the
+// stock is tracked.
+func restockShopOrder(tx *sql.Tx, orderID int, reason, admin, now string) error {
+	rows, err := tx.Query(`SELECT product_id, variant_id, SUM(quantity) FROM shop_order_items WHERE order_id = ? GROUP BY product_id, variant_id`, orderID)
+	if err != nil {
+		return err
+	}
+	var items []ShopOrderItem
+	for rows.Next() {
+		var item ShopOrderItem
+		if err := rows.Scan(&item.ProductID, &item.VariantID, &item.Quantity); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, item := range items {
+		table, where, args := shopStockRow(item.ProductID, item.VariantID)
+		var stock sql.NullInt64
+		if err := tx.QueryRow(`SELECT stock FROM `+table+` WHERE `+where, args...).Scan(&stock); errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !stock.Valid {
+			continue
+		}
+		after := int(stock.Int64) + item.Quantity
+		if _, err := tx.Exec(`UPDATE `+table+` SET stock = ? WHERE `+where, append([]interface{}{after}, args...)...); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO shop_stock_adjustments (product_id, variant_id, delta, stock_after, reason, admin, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			item.ProductID, item.VariantID, item.Quantity, after, reason, admin, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const shopOrderColumns = `id, customer_name, customer_email, IFNULL(customer_notes, ''), total_cents, IFNULL(created_at, ''), notes_flagged, status, status_updated_at, IFNULL(pickup_event_id, 0), locale`
+
+// queryShopOrders runs the query, selecting shopOrderColumns, and loads the items of the orders.
+func (db *appdbimpl) queryShopOrders(query string, args ...interface{}) ([]ShopOrder, error) {
+	rows, err := db.c.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	orders := []ShopOrder{}
+	byID := make(map[int]int)
+	for rows.Next() {
+		var order ShopOrder
+		var flagged int
+		if err := rows.Scan(&order.ID, &order.CustomerName, &order.CustomerEmail, &order.CustomerNotes, &order.TotalCents, &order.CreatedAt, &flagged, &order.Status, &order.StatusUpdatedAt, &order.PickupEventID, &order.Locale); err != nil {
+			return nil, err
+		}
+		order.NotesFlagged = flagged == 1
+		order.Items = []ShopOrderItem{}
+		byID[order.ID] = len(orders)
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return orders, nil
+	}
+
+	// The items are loaded in chunks, to stay below the limit of SQLite on the query parameters
+	const chunk = 500
+	for start := 0; start < len(orders); start += chunk {
+		end := start + chunk
+		if end > len(orders) {
+			end = len(orders)
+		}
+		ids := make([]interface{}, 0, end-start)
+		for _, order := range orders[start:end] {
+			ids = append(ids, order.ID)
+		}
+		if err := db.loadShopOrderItems(orders, byID, ids); err != nil {
+			return nil, err
+		}
+	}
+	return orders, nil
+}
+
+func (db *appdbimpl) loadShopOrderItems(orders []ShopOrder, byID map[int]int, ids []interface{}) error {
+	rows, err := db.c.Query(`SELECT id, order_id, product_id, product_name, IFNULL(product_image_url, ''), variant_id, variant_name, quantity, unit_price_cents
+FROM shop_order_items WHERE order_id IN (?`+strings.Repeat(`, ?`, len(ids)-1)+`) ORDER BY id`, ids...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var item ShopOrderItem
+		if err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.ProductName, &item.ProductImageURL, &item.VariantID, &item.VariantName, &item.Quantity, &item.UnitPriceCents); err != nil {
+			return err
+		}
+		i := byID[item.OrderID]
+		orders[i].Items = append(orders[i].Items, item)
+	}
+	return rows.Err()
+}
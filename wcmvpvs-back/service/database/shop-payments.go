@@ -0,0 +1,212 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The states of a shop payment. A payment waits for the webhook of the provider, which makes it succeeded or failed.
+const (
+	ShopPaymentStatusPending   = "pending"
+	ShopPaymentStatusSucceeded = "succeeded"
+	ShopPaymentStatusFailed    = "failed"
+)
+
+// ErrShopPaymentMismatch is returned when the provider confirms an amount different from the one of the payment.
+var ErrShopPaymentMismatch = errors.New("shop payment amount mismatch")
+
+// ShopPayment is a payment intent created on the provider for an order.
+type ShopPayment struct {
+	ID          int    `json:"id"`
+	OrderID     int    `json:"order_id"`
+	Provider    string `json:"provider"`
+	IntentID    string `json:"intent_id"`
+	AmountCents int    `json:"amount_cents"`
+	Status      string `json:"status"`
+	CheckoutURL string `json:"checkout_url,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// ShopPaymentEvent is the outcome of a payment notified by the provider. EventID is unique for each provider, and
+// identifies the repeated deliveries of the same event.
+type ShopPaymentEvent struct {
+	Provider    string
+	EventID     string
+	IntentID    string
+	Succeeded   bool
+	AmountCents int
+}
+
+const shopPaymentColumns = `id, order_id, provider, intent_id, amount_cents, status, checkout_url, created_at, updated_at`
+
+func scanShopPayment(scanner rowScanner) (ShopPayment, error) {
+	var payment ShopPayment
+	err := scanner.Scan(&payment.ID, &payment.OrderID, &payment.Provider, &payment.IntentID, &payment.AmountCents,
+		&payment.Status, &payment.CheckoutURL, &payment.CreatedAt, &payment.UpdatedAt)
+	return payment, err
+}
+
+func queryShopPayments(c *sql.DB, where string, args ...interface{}) ([]ShopPayment, error) {
+	rows, err := c.Query(`SELECT `+shopPaymentColumns+` FROM shop_payments `+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	payments := []ShopPayment{}
+	for rows.Next() {
+		payment, err := scanShopPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+// CreateShopPayment saves the intent created on the provider for the order, waiting for its outcome.
+func (db *appdbimpl) CreateShopPayment(payment ShopPayment, at time.Time) (ShopPayment, error) {
+	now := at.UTC().Format(time.RFC3339)
+	res, err := db.c.Exec(`INSERT INTO shop_payments (order_id, provider, intent_id, amount_cents, status, checkout_url, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		payment.OrderID, payment.Provider, payment.IntentID, payment.AmountCents, ShopPaymentStatusPending, payment.CheckoutURL, now, now)
+	if err != nil {
+		return ShopPayment{}, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return ShopPayment{}, err
+	}
+	return scanShopPayment(db.c.QueryRow(`SELECT `+shopPaymentColumns+` FROM shop_payments WHERE id = ?`, id))
+}
+
+// ApplyShopPaymentEvent records the event and applies it to its payment: a succeeded payment moves the order to
+// paid, a failed one cancels the order and gives its items back to the stock. Orders that already left the
+// pending payment state are not changed. The returned flag is false when the event was delivered before, or did not
+// change the payment; in both cases nothing is applied again. It returns sql.ErrNoRows for unknown intents and
+// ErrShopPaymentMismatch when the amount differs from the payment one.
+func (db *appdbimpl) ApplyShopPaymentEvent(event ShopPaymentEvent, at time.Time) (ShopPayment, bool, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return ShopPayment{}, false, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := at.UTC().Format(time.RFC3339)
+	// Recording the event first makes the transaction a writer from the start, so that concurrent deliveries of the
+	// same event wait for each other
+	res, err := tx.Exec(`INSERT OR IGNORE INTO shop_payment_events (provider, event_id, intent_id, succeeded, received_at) VALUES (?, ?, ?, ?, ?)`,
+		event.Provider, event.EventID, event.IntentID, event.Succeeded, now)
+	if err != nil {
+		return ShopPayment{}, false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return ShopPayment{}, false, err
+	}
+	payment, err := scanShopPayment(tx.QueryRow(`SELECT `+shopPaymentColumns+` FROM shop_payments WHERE provider = ? AND intent_id = ?`, event.Provider, event.IntentID))
+	if err != nil {
+		return ShopPayment{}, false, err
+	}
+	if inserted == 0 {
+		return payment, false, nil
+	}
+	if event.Succeeded && event.AmountCents != payment.AmountCents {
+		return ShopPayment{}, false, ErrShopPaymentMismatch
+	}
+
+	// A failed attempt does not close a payment that already succeeded, while a success after a failure, like a
+	// retry on the page of the provider, is still recorded
+	status, orderStatus, note := ShopPaymentStatusFailed, ShopOrderStatusCancelled, "Pagamento non riuscito"
+	if event.Succeeded {
+		status, orderStatus, note = ShopPaymentStatusSucceeded, ShopOrderStatusPaid, "Pagamento confermato"
+	}
+	applied := payment.Status == ShopPaymentStatusPending || (event.Succeeded && payment.Status == ShopPaymentStatusFailed)
+	if applied {
+		if _, err := tx.Exec(`UPDATE shop_payments SET status = ?, updated_at = ? WHERE id = ?`, status, now, payment.ID); err != nil {
+			return ShopPayment{}, false, err
+		}
+		payment.Status, payment.UpdatedAt = status, now
+
+		var current string
+		if err := tx.QueryRow(`SELECT status FROM shop_orders WHERE id = ?`, payment.OrderID).Scan(&current); err != nil {
+			return ShopPayment{}, false, err
+		}
+		if current == ShopOrderStatusPendingPayment {
+			note = fmt.Sprintf("%s (%s %s)", note, payment.Provider, payment.IntentID)
+			if err := changeShopOrderStatus(tx, payment.OrderID, orderStatus, "", note, now); err != nil {
+				return ShopPayment{}, false, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return ShopPayment{}, false, err
+	}
+	committed = true
+	return payment, applied, nil
+}
+
+// ExpireShopPayments fails the payments still pending that were created before createdBefore, cancelling their
+// orders so that the items go back to the stock. It returns the expired payments.
+func (db *appdbimpl) ExpireShopPayments(createdBefore, at time.Time) ([]ShopPayment, error) {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return nil, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := at.UTC().Format(time.RFC3339)
+	rows, err := tx.Query(`SELECT `+shopPaymentColumns+` FROM shop_payments WHERE status = ? AND created_at < ? ORDER BY id`,
+		ShopPaymentStatusPending, createdBefore.UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	}
+	expired := []ShopPayment{}
+	for rows.Next() {
+		payment, err := scanShopPayment(rows)
+		if err != nil {
+			_ = rows.Close()
+			return nil, err
+		}
+		expired = append(expired, payment)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	for i, payment := range expired {
+		if _, err := tx.Exec(`UPDATE shop_payments SET status = ?, updated_at = ? WHERE id = ?`, ShopPaymentStatusFailed, now, payment.ID); err != nil {
+			return nil, err
+		}
+		expired[i].Status, expired[i].UpdatedAt = ShopPaymentStatusFailed, now
+
+		var current string
+		if err := tx.QueryRow(`SELECT status FROM shop_orders WHERE id = ?`, payment.OrderID).Scan(&current); err != nil {
+			return nil, err
+		}
+		if current == ShopOrderStatusPendingPayment {
+			if err := changeShopOrderStatus(tx, payment.OrderID, ShopOrderStatusCancelled, "", "Pagamento scaduto", now); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	committed = true
+	return expired, nil
+}
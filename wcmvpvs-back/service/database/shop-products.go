@@ -0,0 +1,132 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidShopProduct is returned for products without a name or with a non-positive price.
+var ErrInvalidShopProduct = errors.New("invalid shop product")
+
+// ListAllShopProducts returns the products, hidden ones included, sorted as in the shop. The deleted products are
+// returned only when includeDeleted is true.
+func (db *appdbimpl) ListAllShopProducts(includeDeleted bool, at time.Time) ([]ShopProduct, error) {
+	if includeDeleted {
+		return db.queryShopProducts(at, ``)
+	}
+	return db.queryShopProducts(at, `WHERE deleted_at = ''`)
+}
+
+// GetAnyShopProduct returns the product even if hidden or deleted, or sql.ErrNoRows.
+func (db *appdbimpl) GetAnyShopProduct(id int, at time.Time) (ShopProduct, error) {
+	products, err := db.queryShopProducts(at, `WHERE id = ?`, id)
+	if err != nil {
+		return ShopProduct{}, err
+	}
+	if len(products) == 0 {
+		return ShopProduct{}, sql.ErrNoRows
+	}
+	return products[0], nil
+}
+
+// SaveShopProduct creates the product when its ID is zero, or updates it. It returns sql.ErrNoRows for products that
+// do not exist or were deleted. The price of the orders already placed does not change.
+func (db *appdbimpl) SaveShopProduct(product ShopProduct, at time.Time) (ShopProduct, error) {
+	product.Name = strings.TrimSpace(product.Name)
+	product.Description = strings.TrimSpace(product.Description)
+	product.ImageURL = strings.TrimSpace(product.ImageURL)
+	product.Category = strings.TrimSpace(product.Category)
+	if product.Name == "" || product.PriceCents <= 0 {
+		return ShopProduct{}, ErrInvalidShopProduct
+	}
+	now := at.UTC().Format(time.RFC3339)
+
+	if product.ID == 0 {
+		result, err := db.c.Exec(`INSERT INTO shop_products (name, description, price_cents, image_url, category, sort_order, is_active, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			product.Name, product.Description, product.PriceCents, product.ImageURL, product.Category, product.SortOrder, boolToInt(product.IsActive), now, now)
+		if err != nil {
+			return ShopProduct{}, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return ShopProduct{}, err
+		}
+		return db.GetAnyShopProduct(int(id), at)
+	}
+
+	result, err := db.c.Exec(`UPDATE shop_products SET name = ?, description = ?, price_cents = ?, image_url = ?, category = ?, sort_order = ?, is_active = ?, updated_at = ? WHERE id = ? AND deleted_at = ''`,
+		product.Name, product.Description, product.PriceCents, product.ImageURL, product.Category, product.SortOrder, boolToInt(product.IsActive), now, product.ID)
+	if err != nil {
+		return ShopProduct{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return ShopProduct{}, err
+	} else if affected == 0 {
+		return ShopProduct{}, sql.ErrNoRows
+	}
+	return db.GetAnyShopProduct(product.ID, at)
+}
+
+// DeleteShopProduct hides the product and marks it as deleted, keeping its row for the order items referring to it. It
+// returns sql.ErrNoRows for products that do not exist or were already deleted.
+func (db *appdbimpl) DeleteShopProduct(id int, at time.Time) error {
+	now := at.UTC().Format(time.RFC3339)
+	result, err := db.c.Exec(`UPDATE shop_products SET is_active = 0, deleted_at = ?, updated_at = ? WHERE id = ? AND deleted_at = ''`, now, now, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// queryShopProducts returns the products matching where, with their variants not deleted and the stock available at
+// the time at.
+func (db *appdbimpl) queryShopProducts(at time.Time, where string, args ...interface{}) ([]ShopProduct, error) {
+	now := at.UTC().Format(time.RFC3339)
+	rows, err := db.c.Query(`SELECT id, name, description, price_cents, image_url, category, sort_order, is_active, IFNULL(created_at, ''), updated_at, deleted_at, stock,
+	(SELECT IFNULL(SUM(r.quantity), 0) FROM shop_stock_reservations r WHERE r.product_id = shop_products.id AND r.variant_id = 0 AND r.expires_at > ?)
+FROM shop_products `+where+` ORDER BY sort_order ASC, id ASC`, append([]interface{}{now}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []ShopProduct{}
+	byID := make(map[int]int)
+	for rows.Next() {
+		var product ShopProduct
+		var isActive, reserved int
+		var stock sql.NullInt64
+		if err := rows.Scan(&product.ID, &product.Name, &product.Description, &product.PriceCents, &product.ImageURL, &product.Category, &product.SortOrder, &isActive, &product.CreatedAt, &product.UpdatedAt, &product.DeletedAt, &stock, &reserved); err != nil {
+			return nil, err
+		}
+		product.IsActive = isActive == 1
+		product.Stock, product.Available = stockLevels(stock, reserved)
+		product.Variants = []ShopProductVariant{}
+		byID[product.ID] = len(products)
+		products = append(products, product)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return products, nil
+	}
+
+	variants, err := db.queryShopProductVariants(now, `WHERE deleted_at = ''`)
+	if err != nil {
+		return nil, err
+	}
+	for _, variant := range variants {
+		if i, ok := byID[variant.ProductID]; ok {
+			products[i].Variants = append(products[i].Variants, variant)
+		}
+	}
+	return products, nil
+}
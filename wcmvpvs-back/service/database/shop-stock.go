@@ -0,0 +1,342 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidShopVariant is returned for variants without a name.
+	ErrInvalidShopVariant = errors.New("invalid shop product variant")
+
+	// ErrInvalidShopStock is returned for stock changes without a reason or leaving a negative stock.
+	ErrInvalidShopStock = errors.New("invalid shop stock change")
+)
+
+// ShopStockError is returned when the stock available is less than the quantity asked for an item.
+type ShopStockError struct {
+	ProductID int
+	VariantID int
+	Available int
+}
+
+func (e *ShopStockError) Error() string {
+	return fmt.Sprintf("only %d left of product %d variant %d", e.Available, e.ProductID, e.VariantID)
+}
+
+// ShopStockChange is a change of the stock made by an admin: Delta is added to the stock, unless Stock is set to
+// replace it. A VariantID of zero changes the stock of the product itself.
+type ShopStockChange struct {
+	ProductID int
+	VariantID int
+	Delta     int
+	Stock     *int
+	Reason    string
+	Admin     string
+}
+
+// ShopStockAdjustment is an entry of the log of the stock changes made by the admins.
+type ShopStockAdjustment struct {
+	ID         int    `json:"id"`
+	ProductID  int    `json:"product_id"`
+	VariantID  int    `json:"variant_id,omitempty"`
+	Delta      int    `json:"delta"`
+	StockAfter int    `json:"stock_after"`
+	Reason     string `json:"reason"`
+	Admin      string `json:"admin"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// SaveShopProductVariant creates the variant when its ID is zero, or updates it; the stock is changed only by
+// AdjustShopStock. It returns sql.ErrNoRows when the product or the variant do not exist or were deleted.
+func (db *appdbimpl) SaveShopProductVariant(variant ShopProductVariant, at time.Time) (ShopProductVariant, error) {
+	variant.Name = strings.TrimSpace(variant.Name)
+	if variant.Name == "" {
+		return ShopProductVariant{}, ErrInvalidShopVariant
+	}
+	now := at.UTC().Format(time.RFC3339)
+
+	var result sql.Result
+	var err error
+	if variant.ID == 0 {
+		result, err = db.c.Exec(`INSERT INTO shop_product_variants (product_id, name, sort_order, is_active, created_at, updated_at)
+SELECT id, ?, ?, ?, ?, ? FROM shop_products WHERE id = ? AND deleted_at = ''`,
+			variant.Name, variant.SortOrder, boolToInt(variant.IsActive), now, now, variant.ProductID)
+	} else {
+		result, err = db.c.Exec(`UPDATE shop_product_variants SET name = ?, sort_order = ?, is_active = ?, updated_at = ? WHERE id = ? AND product_id = ? AND deleted_at = ''`,
+			variant.Name, variant.SortOrder, boolToInt(variant.IsActive), now, variant.ID, variant.ProductID)
+	}
+	if err != nil {
+		return ShopProductVariant{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return ShopProductVariant{}, err
+	} else if affected == 0 {
+		return ShopProductVariant{}, sql.ErrNoRows
+	}
+	if variant.ID == 0 {
+		id, err := result.LastInsertId()
+		if err != nil {
+			return ShopProductVariant{}, err
+		}
+		variant.ID = int(id)
+	}
+
+	variants, err := db.queryShopProductVariants(now, `WHERE id = ?`, variant.ID)
+	if err != nil {
+		return ShopProductVariant{}, err
+	}
+	if len(variants) == 0 {
+		return ShopProductVariant{}, sql.ErrNoRows
+	}
+	return variants[0], nil
+}
+
+// DeleteShopProductVariant removes the variant from the shop, keeping its row for the order items referring to it. It
+// returns sql.ErrNoRows for variants that do not exist or were already deleted.
+func (db *appdbimpl) DeleteShopProductVariant(productID, variantID int, at time.Time) error {
+	now := at.UTC().Format(time.RFC3339)
+	result, err := db.c.Exec(`UPDATE shop_product_variants SET is_active = 0, deleted_at = ?, updated_at = ? WHERE id = ? AND product_id = ? AND deleted_at = ''`, now, now, variantID, productID)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReserveShopStock holds the items for the cart id until expiresAt, replacing what the cart held before. When the
+// stock available is not enough for an item nothing is held and a *ShopStockError is returned.
+func (db *appdbimpl) ReserveShopStock(id string, items []ShopOrderItem, expiresAt, at time.Time) error {
+	tx, err := db.c.Begin()
+	if err != nil {
+		return err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	now := at.UTC().Format(time.RFC3339)
+	if err := deleteExpiredShopReservations(tx, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM shop_stock_reservations WHERE id = ?`, id); err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.ProductID <= 0 || item.Quantity <= 0 || item.VariantID < 0 {
+			return fmt.Errorf("invalid reservation item")
+		}
+		if err := checkShopStock(tx, item, id, now, false); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`INSERT INTO shop_stock_reservations (id, product_id, variant_id, quantity, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			id, item.ProductID, item.VariantID, item.Quantity, expiresAt.UTC().Format(time.RFC3339), now); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// ReleaseShopReservation gives back to the shop the items held by the cart id.
+func (db *appdbimpl) ReleaseShopReservation(id string) error {
+	_, err := db.c.Exec(`DELETE FROM shop_stock_reservations WHERE id = ?`, id)
+	return err
+}
+
+// AdjustShopStock applies the change to the stock of the product or of its variant, and logs it. An untracked stock
+// starts from zero. It returns sql.ErrNoRows when the product or the variant do not exist or were deleted.
+func (db *appdbimpl) AdjustShopStock(change ShopStockChange, at time.Time) (ShopStockAdjustment, error) {
+	change.Reason = strings.TrimSpace(change.Reason)
+	if change.Reason == "" {
+		return ShopStockAdjustment{}, ErrInvalidShopStock
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return ShopStockAdjustment{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			_ = tx.Rollback()
+		}
+	}()
+
+	table, where, args := shopStockRow(change.ProductID, change.VariantID)
+	var stock sql.NullInt64
+	if err := tx.QueryRow(`SELECT stock FROM `+table+` WHERE deleted_at = '' AND `+where, args...).Scan(&stock); err != nil {
+		return ShopStockAdjustment{}, err
+	}
+	after := int(stock.Int64) + change.Delta
+	if change.Stock != nil {
+		after = *change.Stock
+	}
+	if after < 0 {
+		return ShopStockAdjustment{}, ErrInvalidShopStock
+	}
+	if _, err := tx.Exec(`UPDATE `+table+` SET stock = ? WHERE `+where, append([]interface{}{after}, args...)...); err != nil {
+		return ShopStockAdjustment{}, err
+	}
+
+	adjustment := ShopStockAdjustment{
+		ProductID:  change.ProductID,
+		VariantID:  change.VariantID,
+		Delta:      after - int(stock.Int64),
+		StockAfter: after,
+		Reason:     change.Reason,
+		Admin:      change.Admin,
+		CreatedAt:  at.UTC().Format(time.RFC3339),
+	}
+	result, err := tx.Exec(`INSERT INTO shop_stock_adjustments (product_id, variant_id, delta, stock_after, reason, admin, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		adjustment.ProductID, adjustment.VariantID, adjustment.Delta, adjustment.StockAfter, adjustment.Reason, adjustment.Admin, adjustment.CreatedAt)
+	if err != nil {
+		return ShopStockAdjustment{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return ShopStockAdjustment{}, err
+	}
+	adjustment.ID = int(id)
+
+	if err := tx.Commit(); err != nil {
+		return ShopStockAdjustment{}, err
+	}
+	committed = true
+	return adjustment, nil
+}
+
+// ListShopStockAdjustments returns the stock changes of the product and of its variants, newest first.
+func (db *appdbimpl) ListShopStockAdjustments(productID int) ([]ShopStockAdjustment, error) {
+	rows, err := db.c.Query(`SELECT id, product_id, variant_id, delta, stock_after, reason, admin, created_at FROM shop_stock_adjustments WHERE product_id = ? ORDER BY id DESC`, productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	adjustments := []ShopStockAdjustment{}
+	for rows.Next() {
+		var adjustment ShopStockAdjustment
+		if err := rows.Scan(&adjustment.ID, &adjustment.ProductID, &adjustment.VariantID, &adjustment.Delta, &adjustment.StockAfter, &adjustment.Reason, &adjustment.Admin, &adjustment.CreatedAt); err != nil {
+			return nil, err
+		}
+		adjustments = append(adjustments, adjustment)
+	}
+	return adjustments, rows.Err()
+}
+
+// takeShopStock removes the item from the stock, failing as checkShopStock.
+func takeShopStock(tx *sql.Tx, item ShopOrderItem, reservationID, now string) error {
+	return checkShopStock(tx, item, reservationID, now, true)
+}
+
+// checkShopStock returns a *ShopStockError when the stock of the item, less what the carts other than reservationID
+// hold, is not enough, and removes the item from the stock when take is set. Untracked stock is always enough. It
+// returns sql.ErrNoRows when the variant does not exist or is not on sale.
+func checkShopStock(tx *sql.Tx, item ShopOrderItem, reservationID, now string, take bool) error {
+	table, where, args := shopStockRow(item.ProductID, item.VariantID)
+	if item.VariantID > 0 {
+		where += ` AND is_active = 1 AND deleted_at = ''`
+	}
+	var stock sql.NullInt64
+	if err := tx.QueryRow(`SELECT stock FROM `+table+` WHERE `+where, args...).Scan(&stock); err != nil {
+		return err
+	}
+	if !stock.Valid {
+		return nil
+	}
+
+	var reserved int
+	if err := tx.QueryRow(`SELECT IFNULL(SUM(quantity), 0) FROM shop_stock_reservations WHERE product_id = ? AND variant_id = ? AND id != ? AND expires_at > ?`,
+		item.ProductID, item.VariantID, reservationID, now).Scan(&reserved); err != nil {
+		return err
+	}
+	if available := int(stock.Int64) - reserved; item.Quantity > available {
+		if available < 0 {
+			available = 0
+		}
+		return &ShopStockError{ProductID: item.ProductID, VariantID: item.VariantID, Available: available}
+	}
+	if !take {
+		return nil
+	}
+	_, err := tx.Exec(`UPDATE `+table+` SET stock = stock - ? WHERE `+where, append([]interface{}{item.Quantity}, args...)...)
+	return err
+}
+
+// shopStockRow returns the table and the condition of the row holding the stock of the product or of its variant.
+func shopStockRow(productID, variantID int) (string, string, []interface{}) {
+	if variantID > 0 {
+		return "shop_product_variants", "id = ? AND product_id = ?", []interface{}{variantID, productID}
+	}
+	return "shop_products", "id = ?", []interface{}{productID}
+}
+
+// deleteExpiredShopReservations is the first statement of the transactions reading the stock: being a write, it
+// makes them wait for each other.
+func deleteExpiredShopReservations(tx *sql.Tx, now string) error {
+	_, err := tx.Exec(`DELETE FROM shop_stock_reservations WHERE expires_at <= ?`, now)
+	return err
+}
+
+func (db *appdbimpl) queryShopProductVariants(now string, where string, args ...interface{}) ([]ShopProductVariant, error) {
+	rows, err := db.c.Query(`SELECT id, product_id, name, sort_order, is_active, stock, deleted_at,
+	(SELECT IFNULL(SUM(r.quantity), 0) FROM shop_stock_reservations r WHERE r.product_id = shop_product_variants.product_id AND r.variant_id = shop_product_variants.id AND r.expires_at > ?)
+FROM shop_product_variants `+where+` ORDER BY sort_order ASC, id ASC`, append([]interface{}{now}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []ShopProductVariant{}
+	for rows.Next() {
+		var variant ShopProductVariant
+		var isActive, reserved int
+		var stock sql.NullInt64
+		if err := rows.Scan(&variant.ID, &variant.ProductID, &variant.Name, &variant.SortOrder, &isActive, &stock, &variant.DeletedAt, &reserved); err != nil {
+			return nil, err
+		}
+		variant.IsActive = isActive == 1
+		variant.Stock, variant.Available = stockLevels(stock, reserved)
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+// stockLevels returns the stock and the part of it not reserved, both nil for untracked stock.
+func stockLevels(stock sql.NullInt64, reserved int) (*int, *int) {
+	if !stock.Valid {
+		return nil, nil
+	}
+	total := int(stock.Int64)
+	available := total - reserved
+	if available < 0 {
+		available = 0
+	}
+	return &total, &available
+}
+
+// activeShopVariants returns the variants on sale.
+func activeShopVariants(variants []ShopProductVariant) []ShopProductVariant {
+	active := make([]ShopProductVariant, 0, len(variants))
+	for _, variant := range variants {
+		if variant.IsActive {
+			active = append(active, variant)
+		}
+	}
+	return active
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidSponsorAccount is returned for sponsor accounts without a username or a password.
+	ErrInvalidSponsorAccount = errors.New("invalid sponsor account")
+
+	// ErrSponsorAccountExists is returned when the username of a sponsor account is already taken.
+	ErrSponsorAccountExists = errors.New("sponsor account already exists")
+)
+
+// SponsorAccount is a read-only login of the sponsor portal, limited to the data of its sponsor.
+type SponsorAccount struct {
+	ID           int    `json:"id"`
+	SponsorID    int    `json:"sponsor_id"`
+	Username     string `json:"username"`
+	PasswordHash string `json:"-"`
+	CreatedAt    string `json:"created_at"`
+}
+
+// CreateSponsorAccount stores the account and returns its ID. It returns sql.ErrNoRows if the sponsor does not exist.
+func (db *appdbimpl) CreateSponsorAccount(account SponsorAccount, at time.Time) (int, error) {
+	account.Username = strings.TrimSpace(account.Username)
+	if account.SponsorID <= 0 || account.Username == "" || account.PasswordHash == "" {
+		return 0, ErrInvalidSponsorAccount
+	}
+
+	var exists int
+	if err := db.c.QueryRow(`SELECT COUNT(*) FROM sponsors WHERE id = ?`, account.SponsorID).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists == 0 {
+		return 0, sql.ErrNoRows
+	}
+
+	result, err := db.c.Exec(`INSERT INTO sponsor_accounts (sponsor_id, username, password_hash, created_at) VALUES (?, ?, ?, ?)`,
+		account.SponsorID, account.Username, account.PasswordHash, at.UTC().Format(time.RFC3339))
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return 0, ErrSponsorAccountExists
+		}
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// ListSponsorAccounts returns the accounts of the sponsor.
+func (db *appdbimpl) ListSponsorAccounts(sponsorID int) ([]SponsorAccount, error) {
+	rows, err := db.c.Query(`SELECT id, sponsor_id, username, password_hash, created_at FROM sponsor_accounts WHERE sponsor_id = ? ORDER BY username`, sponsorID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []SponsorAccount{}
+	for rows.Next() {
+		var account SponsorAccount
+		if err := rows.Scan(&account.ID, &account.SponsorID, &account.Username, &account.PasswordHash, &account.CreatedAt); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// GetSponsorAccountByUsername returns the account, or sql.ErrNoRows. Usernames are compared ignoring case.
+func (db *appdbimpl) GetSponsorAccountByUsername(username string) (SponsorAccount, error) {
+	var account SponsorAccount
+	err := db.c.QueryRow(`SELECT id, sponsor_id, username, password_hash, created_at FROM sponsor_accounts WHERE username = ?`, strings.TrimSpace(username)).
+		Scan(&account.ID, &account.SponsorID, &account.Username, &account.PasswordHash, &account.CreatedAt)
+	if err != nil {
+		return SponsorAccount{}, err
+	}
+	return account, nil
+}
+
+// DeleteSponsorAccount removes the account, or returns sql.ErrNoRows.
+func (db *appdbimpl) DeleteSponsorAccount(id int) error {
+	result, err := db.c.Exec(`DELETE FROM sponsor_accounts WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return err
+	} else if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
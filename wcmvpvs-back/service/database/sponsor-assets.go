@@ -0,0 +1,34 @@
+package database
+
+import (
+	"time"
+)
+
+// SponsorAsset is a sponsor logo uploaded as a file. Hash is the SHA-256 of the uploaded bytes and names the
+// renditions in the blob store; Width and Height are zero when the size is unknown.
+type SponsorAsset struct {
+	Hash        string `json:"hash"`
+	ContentType string `json:"content_type"`
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	Size        int    `json:"size"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// SaveSponsorAsset records the asset, keeping the first record when the same logo is uploaded again.
+func (db *appdbimpl) SaveSponsorAsset(asset SponsorAsset, at time.Time) (SponsorAsset, error) {
+	_, err := db.c.Exec(`INSERT INTO sponsor_assets (hash, content_type, width, height, size, created_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT(hash) DO NOTHING`,
+		asset.Hash, asset.ContentType, asset.Width, asset.Height, asset.Size, at.UTC().Format(time.RFC3339))
+	if err != nil {
+		return SponsorAsset{}, err
+	}
+	return db.GetSponsorAsset(asset.Hash)
+}
+
+// GetSponsorAsset returns the asset with the given hash, or sql.ErrNoRows.
+func (db *appdbimpl) GetSponsorAsset(hash string) (SponsorAsset, error) {
+	var asset SponsorAsset
+	err := db.c.QueryRow(`SELECT hash, content_type, width, height, size, created_at FROM sponsor_assets WHERE hash = ?`, hash).
+		Scan(&asset.Hash, &asset.ContentType, &asset.Width, &asset.Height, &asset.Size, &asset.CreatedAt)
+	return asset, err
+}
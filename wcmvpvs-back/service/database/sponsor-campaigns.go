@@ -0,0 +1,510 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidSponsorCampaign is returned for campaigns and creatives with invalid data: a missing sponsor or logo, a
+// non-positive weight, a date range ending before it starts or invalid exposure rules.
+var ErrInvalidSponsorCampaign = errors.New("invalid sponsor campaign")
+
+// The screens of the public page showing sponsors: the sponsor grid of the vote page and the vote confirmation dialog.
+const (
+	SponsorScreenMain             = "main"
+	SponsorScreenVoteConfirmation = "vote_confirmation"
+)
+
+// SponsorCampaign schedules a sponsor in the rotation of the public page. EventIDs restricts the campaign to some
+// events, empty for every event; StartsAt and EndsAt (RFC 3339) bound it in time, empty for no bound. Campaigns with a
+// higher Priority are shown first, Weight sets how often a campaign wins against the others with the same priority.
+//
+// The exposure rules are applied to the schedules issued to the devices, zero meaning no rule: MaxViewsPerDevice caps
+// the schedules of an event a device gets the campaign in, MinDwellMs is how long the campaign must stay on screen to
+// count as watched, ShareOfVoice is the percentage of the schedules of the event the campaign is in. A campaign with
+// an ExclusiveScreen is shown only on that screen, alone.
+type SponsorCampaign struct {
+	ID        int               `json:"id"`
+	SponsorID int               `json:"sponsor_id"`
+	Name      string            `json:"name"`
+	EventIDs  []int             `json:"event_ids"`
+	StartsAt  string            `json:"starts_at"`
+	EndsAt    string            `json:"ends_at"`
+	Weight    int               `json:"weight"`
+	Priority  int               `json:"priority"`
+	IsActive  bool              `json:"is_active"`
+	Creatives []SponsorCreative `json:"creatives"`
+	CreatedAt string            `json:"created_at"`
+	UpdatedAt string            `json:"updated_at"`
+
+	MaxViewsPerDevice int    `json:"max_views_per_device"`
+	MinDwellMs        int    `json:"min_dwell_ms"`
+	ShareOfVoice      int    `json:"share_of_voice"`
+	ExclusiveScreen   string `json:"exclusive_screen"`
+}
+
+// SponsorCreative is a logo of a campaign, stored like the logo of the sponsor. LinkURL overrides the link of the
+// sponsor when set.
+type SponsorCreative struct {
+	ID         int    `json:"id"`
+	CampaignID int    `json:"campaign_id"`
+	Name       string `json:"name"`
+	LogoData   string `json:"logo_data"`
+	LogoAsset  string `json:"logo_asset"`
+	LinkURL    string `json:"link_url"`
+	Weight     int    `json:"weight"`
+	IsActive   bool   `json:"is_active"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// SponsorPlacement is an active sponsor that can enter the rotation of an event. Campaign is nil for the sponsors
+// without campaigns, which are always shown with their own logo.
+type SponsorPlacement struct {
+	Sponsor  Sponsor
+	Campaign *SponsorCampaign
+}
+
+// SponsorImpression identifies what a fan saw or clicked: the sponsor and, when it came from a campaign, the campaign
+// and the creative. CampaignID and CreativeID are zero otherwise.
+type SponsorImpression struct {
+	SponsorID  int `json:"sponsor_id"`
+	CampaignID int `json:"campaign_id"`
+	CreativeID int `json:"creative_id"`
+}
+
+// SponsorCampaignStat counts the exposures and the clicks of a sponsor for a campaign and a creative. The names are
+// empty for the campaigns and creatives deleted since.
+type SponsorCampaignStat struct {
+	SponsorID    int    `json:"sponsor_id"`
+	SponsorName  string `json:"sponsor_name"`
+	CampaignID   int    `json:"campaign_id"`
+	CampaignName string `json:"campaign_name"`
+	CreativeID   int    `json:"creative_id"`
+	CreativeName string `json:"creative_name"`
+	Seen         int    `json:"seen"`
+	Watched      int    `json:"watched"`
+	WatchTimeMs  int64  `json:"watch_time_ms"`
+	Clicks       int    `json:"clicks"`
+}
+
+// migrateSponsorSlots rebuilds the sponsors table created when at most four sponsors were allowed, dropping the
+// unique and range constraints on the position. The foreign keys are disabled on the connection running the rebuild,
+// otherwise dropping the old table would delete the clicks and the exposures of the sponsors.
+func migrateSponsorSlots(db *sql.DB) error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type='table' AND name='sponsors'`).Scan(&schema); err != nil {
+		return err
+	}
+	if !strings.Contains(strings.ToUpper(schema), "CHECK(POSITION") {
+		return nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys=OFF`); err != nil {
+		return err
+	}
+	defer func() { _, _ = conn.ExecContext(ctx, `PRAGMA foreign_keys=ON`) }()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		`CREATE TABLE sponsors_new (id INTEGER PRIMARY KEY AUTOINCREMENT, position INTEGER NOT NULL DEFAULT 1, name TEXT NOT NULL, logo_data TEXT NOT NULL, link_url TEXT, is_active INTEGER NOT NULL DEFAULT 1)`,
+		`INSERT INTO sponsors_new (id, position, name, logo_data, link_url, is_active) SELECT id, position, name, logo_data, link_url, is_active FROM sponsors`,
+		`DROP TABLE sponsors`,
+		`ALTER TABLE sponsors_new RENAME TO sponsors`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("rebuilding sponsors table: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// ListSponsorCampaigns returns the campaigns of the sponsor with their creatives, or of every sponsor when sponsorID
+// is zero.
+func (db *appdbimpl) ListSponsorCampaigns(sponsorID int) ([]SponsorCampaign, error) {
+	if sponsorID > 0 {
+		return db.querySponsorCampaigns(`WHERE sponsor_id = ?`, sponsorID)
+	}
+	return db.querySponsorCampaigns(``)
+}
+
+// GetSponsorCampaign returns the campaign with its creatives, or sql.ErrNoRows.
+func (db *appdbimpl) GetSponsorCampaign(id int) (SponsorCampaign, error) {
+	campaigns, err := db.querySponsorCampaigns(`WHERE id = ?`, id)
+	if err != nil {
+		return SponsorCampaign{}, err
+	}
+	if len(campaigns) == 0 {
+		return SponsorCampaign{}, sql.ErrNoRows
+	}
+	return campaigns[0], nil
+}
+
+// SaveSponsorCampaign creates the campaign when its ID is zero, or updates it, replacing its events. The creatives are
+// managed by SaveSponsorCreative. It returns sql.ErrNoRows if the sponsor or the campaign do not exist.
+func (db *appdbimpl) SaveSponsorCampaign(campaign SponsorCampaign, at time.Time) (SponsorCampaign, error) {
+	campaign, err := normalizeSponsorCampaign(campaign)
+	if err != nil {
+		return SponsorCampaign{}, err
+	}
+	now := at.UTC().Format(time.RFC3339)
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return SponsorCampaign{}, err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM sponsors WHERE id = ?`, campaign.SponsorID).Scan(&exists); err != nil {
+		return SponsorCampaign{}, err
+	}
+	if exists == 0 {
+		return SponsorCampaign{}, sql.ErrNoRows
+	}
+
+	if campaign.ID == 0 {
+		result, err := tx.Exec(`INSERT INTO sponsor_campaigns (sponsor_id, name, starts_at, ends_at, weight, priority, is_active, max_views_per_device, min_dwell_ms, share_of_voice, exclusive_screen, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			campaign.SponsorID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Weight, campaign.Priority, boolToInt(campaign.IsActive),
+			campaign.MaxViewsPerDevice, campaign.MinDwellMs, campaign.ShareOfVoice, campaign.ExclusiveScreen, now, now)
+		if err != nil {
+			return SponsorCampaign{}, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return SponsorCampaign{}, err
+		}
+		campaign.ID = int(id)
+	} else {
+		result, err := tx.Exec(`UPDATE sponsor_campaigns SET sponsor_id = ?, name = ?, starts_at = ?, ends_at = ?, weight = ?, priority = ?, is_active = ?, max_views_per_device = ?, min_dwell_ms = ?, share_of_voice = ?, exclusive_screen = ?, updated_at = ? WHERE id = ?`,
+			campaign.SponsorID, campaign.Name, campaign.StartsAt, campaign.EndsAt, campaign.Weight, campaign.Priority, boolToInt(campaign.IsActive),
+			campaign.MaxViewsPerDevice, campaign.MinDwellMs, campaign.ShareOfVoice, campaign.ExclusiveScreen, now, campaign.ID)
+		if err != nil {
+			return SponsorCampaign{}, err
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return SponsorCampaign{}, err
+		} else if affected == 0 {
+			return SponsorCampaign{}, sql.ErrNoRows
+		}
+		if _, err := tx.Exec(`DELETE FROM sponsor_campaign_events WHERE campaign_id = ?`, campaign.ID); err != nil {
+			return SponsorCampaign{}, err
+		}
+	}
+
+	for _, eventID := range campaign.EventIDs {
+		if _, err := tx.Exec(`INSERT INTO sponsor_campaign_events (campaign_id, event_id) VALUES (?, ?)`, campaign.ID, eventID); err != nil {
+			return SponsorCampaign{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return SponsorCampaign{}, err
+	}
+	return db.GetSponsorCampaign(campaign.ID)
+}
+
+// DeleteSponsorCampaign removes the campaign with its creatives, or returns sql.ErrNoRows. The exposures and the
+// clicks attributed to it are kept.
+func (db *appdbimpl) DeleteSponsorCampaign(id int) error {
+	result, err := db.c.Exec(`DELETE FROM sponsor_campaigns WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// SaveSponsorCreative adds the creative to its campaign when its ID is zero, or updates it, keeping its campaign. It
+// returns sql.ErrNoRows if the campaign or the creative do not exist.
+func (db *appdbimpl) SaveSponsorCreative(creative SponsorCreative, at time.Time) (SponsorCreative, error) {
+	creative.Name = strings.TrimSpace(creative.Name)
+	creative.LinkURL = strings.TrimSpace(creative.LinkURL)
+	if creative.Weight == 0 {
+		creative.Weight = 1
+	}
+	if (strings.TrimSpace(creative.LogoData) == "" && creative.LogoAsset == "") || creative.Weight < 0 {
+		return SponsorCreative{}, ErrInvalidSponsorCampaign
+	}
+
+	if creative.ID == 0 {
+		var exists int
+		if err := db.c.QueryRow(`SELECT COUNT(*) FROM sponsor_campaigns WHERE id = ?`, creative.CampaignID).Scan(&exists); err != nil {
+			return SponsorCreative{}, err
+		}
+		if exists == 0 {
+			return SponsorCreative{}, sql.ErrNoRows
+		}
+		creative.CreatedAt = at.UTC().Format(time.RFC3339)
+		result, err := db.c.Exec(`INSERT INTO sponsor_creatives (campaign_id, name, logo_data, logo_asset, link_url, weight, is_active, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			creative.CampaignID, creative.Name, creative.LogoData, creative.LogoAsset, creative.LinkURL, creative.Weight, boolToInt(creative.IsActive), creative.CreatedAt)
+		if err != nil {
+			return SponsorCreative{}, err
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return SponsorCreative{}, err
+		}
+		creative.ID = int(id)
+		return creative, nil
+	}
+
+	result, err := db.c.Exec(`UPDATE sponsor_creatives SET name = ?, logo_data = ?, logo_asset = ?, link_url = ?, weight = ?, is_active = ? WHERE id = ?`,
+		creative.Name, creative.LogoData, creative.LogoAsset, creative.LinkURL, creative.Weight, boolToInt(creative.IsActive), creative.ID)
+	if err != nil {
+		return SponsorCreative{}, err
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return SponsorCreative{}, err
+	} else if affected == 0 {
+		return SponsorCreative{}, sql.ErrNoRows
+	}
+	err = db.c.QueryRow(`SELECT campaign_id, created_at FROM sponsor_creatives WHERE id = ?`, creative.ID).Scan(&creative.CampaignID, &creative.CreatedAt)
+	return creative, err
+}
+
+// DeleteSponsorCreative removes the creative, or returns sql.ErrNoRows.
+func (db *appdbimpl) DeleteSponsorCreative(id int) error {
+	result, err := db.c.Exec(`DELETE FROM sponsor_creatives WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// ListSponsorPlacements returns the active sponsors that can be shown for the event at the given time, eventID zero
+// when no event is running. A sponsor with campaigns is returned once for every campaign running for the event, with
+// its active creatives only; a sponsor whose campaigns are all over or scheduled elsewhere is not returned.
+func (db *appdbimpl) ListSponsorPlacements(eventID int, at time.Time) ([]SponsorPlacement, error) {
+	sponsors, err := db.querySponsors(true)
+	if err != nil {
+		return nil, err
+	}
+	campaigns, err := db.querySponsorCampaigns(``)
+	if err != nil {
+		return nil, err
+	}
+	bySponsor := make(map[int][]SponsorCampaign)
+	for _, campaign := range campaigns {
+		bySponsor[campaign.SponsorID] = append(bySponsor[campaign.SponsorID], campaign)
+	}
+
+	placements := []SponsorPlacement{}
+	for _, sponsor := range sponsors {
+		scheduled, ok := bySponsor[sponsor.ID]
+		if !ok {
+			placements = append(placements, SponsorPlacement{Sponsor: sponsor})
+			continue
+		}
+		for i := range scheduled {
+			campaign := scheduled[i]
+			if !campaign.runsFor(eventID, at) {
+				continue
+			}
+			creatives := make([]SponsorCreative, 0, len(campaign.Creatives))
+			for _, creative := range campaign.Creatives {
+				if creative.IsActive && creative.Weight > 0 {
+					creatives = append(creatives, creative)
+				}
+			}
+			campaign.Creatives = creatives
+			placements = append(placements, SponsorPlacement{Sponsor: sponsor, Campaign: &campaign})
+		}
+	}
+	return placements, nil
+}
+
+// runsFor tells whether the campaign is active and scheduled for the event at the given time.
+func (c SponsorCampaign) runsFor(eventID int, at time.Time) bool {
+	if !c.IsActive || c.Weight <= 0 {
+		return false
+	}
+	if starts, err := time.Parse(time.RFC3339, c.StartsAt); err == nil && at.Before(starts) {
+		return false
+	}
+	if ends, err := time.Parse(time.RFC3339, c.EndsAt); err == nil && !at.Before(ends) {
+		return false
+	}
+	if len(c.EventIDs) == 0 {
+		return true
+	}
+	for _, id := range c.EventIDs {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSponsorCampaignStats returns the exposures and the clicks of the event grouped by sponsor, campaign and creative.
+func (db *appdbimpl) GetSponsorCampaignStats(eventID int) ([]SponsorCampaignStat, error) {
+	rows, err := db.c.Query(`
+SELECT t.sponsor_id,
+       IFNULL((SELECT name FROM sponsors WHERE id = t.sponsor_id), ''),
+       t.campaign_id,
+       IFNULL((SELECT name FROM sponsor_campaigns WHERE id = t.campaign_id), ''),
+       t.creative_id,
+       IFNULL((SELECT name FROM sponsor_creatives WHERE id = t.creative_id), ''),
+       SUM(t.seen), SUM(t.watched), SUM(t.watch_ms), SUM(t.clicks)
+FROM (
+        SELECT sponsor_id, campaign_id, creative_id,
+               CASE WHEN exposure_type = 'seen' THEN 1 ELSE 0 END AS seen,
+               CASE WHEN exposure_type = 'watched' THEN 1 ELSE 0 END AS watched,
+               CASE WHEN exposure_type = 'watched' THEN COALESCE(duration_ms, 0) ELSE 0 END AS watch_ms,
+               0 AS clicks
+        FROM sponsor_exposures
+        WHERE event_id = ?
+        UNION ALL
+        SELECT sponsor_id, campaign_id, creative_id, 0, 0, 0, 1
+        FROM sponsor_clicks
+        WHERE event_id = ?
+) t
+GROUP BY t.sponsor_id, t.campaign_id, t.creative_id
+ORDER BY t.sponsor_id, t.campaign_id, t.creative_id`, eventID, eventID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []SponsorCampaignStat{}
+	for rows.Next() {
+		var stat SponsorCampaignStat
+		if err := rows.Scan(&stat.SponsorID, &stat.SponsorName, &stat.CampaignID, &stat.CampaignName, &stat.CreativeID, &stat.CreativeName, &stat.Seen, &stat.Watched, &stat.WatchTimeMs, &stat.Clicks); err != nil {
+			return nil, err
+		}
+		stats = append(stats, stat)
+	}
+	return stats, rows.Err()
+}
+
+func normalizeSponsorCampaign(campaign SponsorCampaign) (SponsorCampaign, error) {
+	campaign.Name = strings.TrimSpace(campaign.Name)
+	if campaign.Weight == 0 {
+		campaign.Weight = 1
+	}
+	if campaign.SponsorID <= 0 || campaign.Weight < 0 {
+		return SponsorCampaign{}, ErrInvalidSponsorCampaign
+	}
+	if campaign.MaxViewsPerDevice < 0 || campaign.MinDwellMs < 0 || campaign.ShareOfVoice < 0 || campaign.ShareOfVoice > 100 {
+		return SponsorCampaign{}, ErrInvalidSponsorCampaign
+	}
+	campaign.ExclusiveScreen = strings.ToLower(strings.TrimSpace(campaign.ExclusiveScreen))
+	if campaign.ExclusiveScreen != "" && campaign.ExclusiveScreen != SponsorScreenMain && campaign.ExclusiveScreen != SponsorScreenVoteConfirmation {
+		return SponsorCampaign{}, ErrInvalidSponsorCampaign
+	}
+
+	var bounds [2]time.Time
+	for i, value := range []*string{&campaign.StartsAt, &campaign.EndsAt} {
+		*value = strings.TrimSpace(*value)
+		if *value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, *value)
+		if err != nil {
+			return SponsorCampaign{}, ErrInvalidSponsorCampaign
+		}
+		bounds[i] = parsed.UTC()
+		*value = bounds[i].Format(time.RFC3339)
+	}
+	if !bounds[0].IsZero() && !bounds[1].IsZero() && !bounds[1].After(bounds[0]) {
+		return SponsorCampaign{}, ErrInvalidSponsorCampaign
+	}
+
+	seen := make(map[int]struct{}, len(campaign.EventIDs))
+	events := make([]int, 0, len(campaign.EventIDs))
+	for _, id := range campaign.EventIDs {
+		if id <= 0 {
+			return SponsorCampaign{}, ErrInvalidSponsorCampaign
+		}
+		if _, ok := seen[id]; !ok {
+			seen[id] = struct{}{}
+			events = append(events, id)
+		}
+	}
+	sort.Ints(events)
+	campaign.EventIDs = events
+	return campaign, nil
+}
+
+func (db *appdbimpl) querySponsorCampaigns(where string, args ...interface{}) ([]SponsorCampaign, error) {
+	rows, err := db.c.Query(`SELECT id, sponsor_id, name, starts_at, ends_at, weight, priority, is_active, max_views_per_device, min_dwell_ms, share_of_voice, exclusive_screen, created_at, updated_at FROM sponsor_campaigns `+where+` ORDER BY sponsor_id, priority DESC, id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	campaigns := []SponsorCampaign{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var c SponsorCampaign
+		var isActive int
+		if err := rows.Scan(&c.ID, &c.SponsorID, &c.Name, &c.StartsAt, &c.EndsAt, &c.Weight, &c.Priority, &isActive, &c.MaxViewsPerDevice, &c.MinDwellMs, &c.ShareOfVoice, &c.ExclusiveScreen, &c.CreatedAt, &c.UpdatedAt); err != nil {
+			return nil, err
+		}
+		c.IsActive = isActive == 1
+		c.EventIDs = []int{}
+		c.Creatives = []SponsorCreative{}
+		index[c.ID] = len(campaigns)
+		campaigns = append(campaigns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(campaigns) == 0 {
+		return campaigns, nil
+	}
+
+	eventRows, err := db.c.Query(`SELECT campaign_id, event_id FROM sponsor_campaign_events ORDER BY campaign_id, event_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer eventRows.Close()
+	for eventRows.Next() {
+		var campaignID, eventID int
+		if err := eventRows.Scan(&campaignID, &eventID); err != nil {
+			return nil, err
+		}
+		if i, ok := index[campaignID]; ok {
+			campaigns[i].EventIDs = append(campaigns[i].EventIDs, eventID)
+		}
+	}
+	if err := eventRows.Err(); err != nil {
+		return nil, err
+	}
+
+	creativeRows, err := db.c.Query(`SELECT id, campaign_id, name, logo_data, logo_asset, link_url, weight, is_active, created_at FROM sponsor_creatives ORDER BY campaign_id, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer creativeRows.Close()
+	for creativeRows.Next() {
+		var c SponsorCreative
+		var isActive int
+		if err := creativeRows.Scan(&c.ID, &c.CampaignID, &c.Name, &c.LogoData, &c.LogoAsset, &c.LinkURL, &c.Weight, &isActive, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		c.IsActive = isActive == 1
+		if i, ok := index[c.CampaignID]; ok {
+			campaigns[i].Creatives = append(campaigns[i].Creatives, c)
+		}
+	}
+	return campaigns, creativeRows.Err()
+}
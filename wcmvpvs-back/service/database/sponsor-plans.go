@@ -0,0 +1,151 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+// SponsorPlan is the sponsor schedule issued to a device for an event. The exposures the device sends back are checked
+// against its slots.
+type SponsorPlan struct {
+	ID       string            `json:"id"`
+	EventID  int               `json:"event_id"`
+	DeviceID string            `json:"device_id"`
+	IssuedAt string            `json:"issued_at"`
+	Slots    []SponsorPlanSlot `json:"slots"`
+}
+
+// SponsorPlanSlot is a sponsor shown on a screen of the plan, in the given position. MinDwellMs is the time it must
+// stay on screen to count as watched, zero for no minimum.
+type SponsorPlanSlot struct {
+	SponsorImpression
+	Screen     string `json:"screen"`
+	Position   int    `json:"position"`
+	MinDwellMs int    `json:"min_dwell_ms"`
+}
+
+// SponsorPlanCounts counts the plans issued for an event: all of them, those including each campaign, and those of
+// the device, all of them and by campaign.
+type SponsorPlanCounts struct {
+	Plans          int
+	ByCampaign     map[int]int
+	DevicePlans    int
+	DeviceCampaign map[int]int
+}
+
+// recordSponsorPlan stores the plan with its slots and adds it to the plan counts, in the transaction of a telemetry
+// batch. It returns false when the plan breaks a constraint, like the plans of an event deleted in the meantime.
+func recordSponsorPlan(tx *sql.Tx, plan SponsorPlan) (bool, error) {
+	if _, err := tx.Exec(`INSERT INTO sponsor_plans (id, event_id, device_id, issued_at) VALUES (?, ?, ?, ?)`, plan.ID, plan.EventID, plan.DeviceID, plan.IssuedAt); err != nil {
+		if strings.Contains(err.Error(), "constraint failed") {
+			return false, nil
+		}
+		return false, err
+	}
+	// Campaign 0 counts all the plans
+	campaigns := []int{0}
+	for _, slot := range plan.Slots {
+		if _, err := tx.Exec(`INSERT INTO sponsor_plan_slots (plan_id, screen, position, sponsor_id, campaign_id, creative_id, min_dwell_ms) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			plan.ID, slot.Screen, slot.Position, slot.SponsorID, slot.CampaignID, slot.CreativeID, slot.MinDwellMs); err != nil {
+			return false, err
+		}
+		if slot.CampaignID > 0 && !containsInt(campaigns, slot.CampaignID) {
+			campaigns = append(campaigns, slot.CampaignID)
+		}
+	}
+	for _, campaignID := range campaigns {
+		for _, deviceID := range []string{"", plan.DeviceID} {
+			if _, err := tx.Exec(`
+INSERT INTO sponsor_plan_counts (event_id, campaign_id, device_id, plans, updated_at) VALUES (?, ?, ?, 1, ?)
+ON CONFLICT(event_id, campaign_id, device_id) DO UPDATE SET plans = plans + 1, updated_at = excluded.updated_at`,
+				plan.EventID, campaignID, deviceID, plan.IssuedAt); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSponsorPlan returns the plan with its slots, or sql.ErrNoRows.
+func (db *appdbimpl) GetSponsorPlan(id string) (SponsorPlan, error) {
+	var plan SponsorPlan
+	err := db.c.QueryRow(`SELECT id, event_id, device_id, issued_at FROM sponsor_plans WHERE id = ?`, id).Scan(&plan.ID, &plan.EventID, &plan.DeviceID, &plan.IssuedAt)
+	if err != nil {
+		return SponsorPlan{}, err
+	}
+
+	rows, err := db.c.Query(`SELECT screen, position, sponsor_id, campaign_id, creative_id, min_dwell_ms FROM sponsor_plan_slots WHERE plan_id = ? ORDER BY screen, position`, id)
+	if err != nil {
+		return SponsorPlan{}, err
+	}
+	defer rows.Close()
+	plan.Slots = []SponsorPlanSlot{}
+	for rows.Next() {
+		var slot SponsorPlanSlot
+		if err := rows.Scan(&slot.Screen, &slot.Position, &slot.SponsorID, &slot.CampaignID, &slot.CreativeID, &slot.MinDwellMs); err != nil {
+			return SponsorPlan{}, err
+		}
+		plan.Slots = append(plan.Slots, slot)
+	}
+	return plan, rows.Err()
+}
+
+// GetSponsorPlanCounts returns the counts of the plans issued for the event and for the device, kept up to date when
+// the plans are stored. The plans still in the telemetry buffer are not included.
+func (db *appdbimpl) GetSponsorPlanCounts(eventID int, deviceID string) (SponsorPlanCounts, error) {
+	counts := SponsorPlanCounts{ByCampaign: make(map[int]int), DeviceCampaign: make(map[int]int)}
+	rows, err := db.c.Query(`SELECT campaign_id, device_id, plans FROM sponsor_plan_counts WHERE event_id = ? AND device_id IN ('', ?)`, eventID, deviceID)
+	if err != nil {
+		return SponsorPlanCounts{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var campaignID, plans int
+		var device string
+		if err := rows.Scan(&campaignID, &device, &plans); err != nil {
+			return SponsorPlanCounts{}, err
+		}
+		switch {
+		case device == "" && campaignID == 0:
+			counts.Plans = plans
+		case device == "":
+			counts.ByCampaign[campaignID] = plans
+		case campaignID == 0:
+			counts.DevicePlans = plans
+		default:
+			counts.DeviceCampaign[campaignID] = plans
+		}
+	}
+	return counts, rows.Err()
+}
+
+// PruneSponsorPlans deletes the plans issued before the given time, that the devices no longer use, and returns how
+// many. The plan counts are kept.
+func (db *appdbimpl) PruneSponsorPlans(before time.Time) (int, error) {
+	res, err := db.c.Exec(`DELETE FROM sponsor_plans WHERE issued_at < ?`, before.UTC().Format(time.RFC3339))
+	if err != nil {
+		return 0, err
+	}
+	pruned, err := res.RowsAffected()
+	return int(pruned), err
+}
+
+// SlotFor returns the slot of the plan showing the impression on the screen.
+func (p SponsorPlan) SlotFor(screen string, impression SponsorImpression) (SponsorPlanSlot, bool) {
+	for _, slot := range p.Slots {
+		if slot.Screen == screen && slot.SponsorImpression == impression {
+			return slot, true
+		}
+	}
+	return SponsorPlanSlot{}, false
+}
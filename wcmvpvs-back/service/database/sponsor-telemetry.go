@@ -0,0 +1,126 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+// SponsorSessionRecord is a visit of a device to the sponsors of an event.
+type SponsorSessionRecord struct {
+	EventID  int
+	DeviceID string
+	At       time.Time
+}
+
+// SponsorExposureRecord is a sponsor seen or watched by a device. DurationMs is only stored for watched exposures.
+type SponsorExposureRecord struct {
+	EventID      int
+	Impression   SponsorImpression
+	DeviceID     string
+	ExposureType string
+	DurationMs   int
+	At           time.Time
+}
+
+// SponsorClickRecord is a click of a device on a sponsor.
+type SponsorClickRecord struct {
+	EventID    int
+	Impression SponsorImpression
+	DeviceID   string
+	At         time.Time
+}
+
+// SponsorTelemetryBatch holds the sponsor telemetry written together by RecordSponsorTelemetry, with the sponsor plans
+// issued to the devices.
+type SponsorTelemetryBatch struct {
+	Sessions  []SponsorSessionRecord
+	Exposures []SponsorExposureRecord
+	Clicks    []SponsorClickRecord
+	Plans     []SponsorPlan
+}
+
+// Len returns the number of records in the batch.
+func (b SponsorTelemetryBatch) Len() int {
+	return len(b.Sessions) + len(b.Exposures) + len(b.Clicks) + len(b.Plans)
+}
+
+// RecordSponsorTelemetry writes the batch in a single transaction, with the time of each record. The records
+// referring to events or sponsors deleted in the meantime, or otherwise invalid, are skipped: it returns how many.
+func (db *appdbimpl) RecordSponsorTelemetry(batch SponsorTelemetryBatch) (int, error) {
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	tx, err := db.c.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	skipped := 0
+	// exec runs a statement of the batch, skipping the record when it breaks a constraint
+	exec := func(query string, args ...interface{}) error {
+		if _, err := tx.Exec(query, args...); err != nil {
+			if !strings.Contains(err.Error(), "constraint failed") {
+				return err
+			}
+			skipped++
+		}
+		return nil
+	}
+
+	for _, plan := range batch.Plans {
+		stored, err := recordSponsorPlan(tx, plan)
+		if err != nil {
+			return 0, err
+		} else if !stored {
+			skipped++
+		}
+	}
+
+	for _, session := range batch.Sessions {
+		deviceID := strings.TrimSpace(session.DeviceID)
+		if session.EventID <= 0 || deviceID == "" {
+			skipped++
+			continue
+		}
+		at := session.At.UTC().Format(sqliteTimestampLayout)
+		if err := exec(`
+INSERT INTO sponsor_sessions (event_id, device_id, first_seen, last_seen)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(event_id, device_id) DO UPDATE SET last_seen = MAX(last_seen, excluded.last_seen)
+`, session.EventID, deviceID, at, at); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, exposure := range batch.Exposures {
+		deviceID := strings.TrimSpace(exposure.DeviceID)
+		exposureType := strings.ToLower(strings.TrimSpace(exposure.ExposureType))
+		if exposure.EventID <= 0 || exposure.Impression.SponsorID <= 0 || deviceID == "" || (exposureType != "seen" && exposureType != "watched") {
+			skipped++
+			continue
+		}
+		var duration interface{}
+		if exposureType == "watched" && exposure.DurationMs > 0 {
+			duration = exposure.DurationMs
+		}
+		if err := exec(`INSERT INTO sponsor_exposures (event_id, sponsor_id, campaign_id, creative_id, device_id, exposure_type, duration_ms, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			exposure.EventID, exposure.Impression.SponsorID, exposure.Impression.CampaignID, exposure.Impression.CreativeID, deviceID, exposureType, duration, exposure.At.UTC().Format(sqliteTimestampLayout)); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, click := range batch.Clicks {
+		if click.EventID <= 0 || click.Impression.SponsorID <= 0 {
+			skipped++
+			continue
+		}
+		if err := exec(`INSERT INTO sponsor_clicks (event_id, sponsor_id, campaign_id, creative_id, device_id, clicked_at) VALUES (?, ?, ?, ?, ?, ?)`,
+			click.EventID, click.Impression.SponsorID, click.Impression.CampaignID, click.Impression.CreativeID, strings.TrimSpace(click.DeviceID), click.At.UTC().Format(sqliteTimestampLayout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return skipped, tx.Commit()
+}
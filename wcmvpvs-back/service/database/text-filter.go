@@ -0,0 +1,230 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Texts written by fans that go through the text filter.
+const (
+	TextFilterTargetSelfieCaption      = "selfie_caption"
+	TextFilterTargetFeedbackSuggestion = "feedback_suggestion"
+	TextFilterTargetOrderNotes         = "order_notes"
+)
+
+// Actions taken when the text filter finds an offensive word: refuse the text, replace the words with asterisks, or
+// keep the text and flag it for review. TextFilterActionNone disables the filter.
+const (
+	TextFilterActionReject = "reject"
+	TextFilterActionMask   = "mask"
+	TextFilterActionFlag   = "flag"
+	TextFilterActionNone   = "none"
+)
+
+// Lists of the words managed by the admins: denied words are added to the built-in lists, allowed words are never
+// filtered.
+const (
+	TextFilterListDeny  = "deny"
+	TextFilterListAllow = "allow"
+)
+
+var (
+	// ErrInvalidTextFilterTarget is returned for targets other than the TextFilterTarget* constants.
+	ErrInvalidTextFilterTarget = errors.New("invalid text filter target")
+
+	// ErrInvalidTextFilterAction is returned for actions other than the TextFilterAction* constants.
+	ErrInvalidTextFilterAction = errors.New("invalid text filter action")
+)
+
+// textFilterTargets describes where the text of each target is stored, with the default action.
+var textFilterTargets = map[string]struct {
+	table, textColumn, flagColumn, eventColumn string
+	defaultAction                              string
+}{
+	TextFilterTargetSelfieCaption:      {"selfies", "caption", "caption_flagged", "event_id", TextFilterActionReject},
+	TextFilterTargetFeedbackSuggestion: {"event_feedback", "suggestion", "suggestion_flagged", "event_id", TextFilterActionFlag},
+	TextFilterTargetOrderNotes:         {"shop_orders", "customer_notes", "notes_flagged", "0", TextFilterActionFlag},
+}
+
+// IsValidTextFilterTarget tells whether target is one of the TextFilterTarget* constants.
+func IsValidTextFilterTarget(target string) bool {
+	_, ok := textFilterTargets[target]
+	return ok
+}
+
+// IsValidTextFilterAction tells whether action is one of the TextFilterAction* constants.
+func IsValidTextFilterAction(action string) bool {
+	switch action {
+	case TextFilterActionReject, TextFilterActionMask, TextFilterActionFlag, TextFilterActionNone:
+		return true
+	}
+	return false
+}
+
+// TextFilterWord is a word added by the admins to the deny or allow list. Language is informative, empty for words
+// valid in every language.
+type TextFilterWord struct {
+	ID        int    `json:"id"`
+	Word      string `json:"word"`
+	List      string `json:"list"`
+	Language  string `json:"language"`
+	CreatedBy string `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+// FlaggedText is a text kept by the filter and waiting for review. EventID is zero for shop orders.
+type FlaggedText struct {
+	Target    string `json:"target"`
+	ID        int    `json:"id"`
+	EventID   int    `json:"event_id"`
+	Text      string `json:"text"`
+	CreatedAt string `json:"created_at"`
+}
+
+func (db *appdbimpl) ListTextFilterWords() ([]TextFilterWord, error) {
+	rows, err := db.c.Query(`SELECT id, word, list, language, created_by, created_at FROM text_filter_words ORDER BY list, word`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	words := []TextFilterWord{}
+	for rows.Next() {
+		var w TextFilterWord
+		if err := rows.Scan(&w.ID, &w.Word, &w.List, &w.Language, &w.CreatedBy, &w.CreatedAt); err != nil {
+			return nil, err
+		}
+		words = append(words, w)
+	}
+	return words, rows.Err()
+}
+
+// AddTextFilterWord stores the word, lower-cased, in its list. A word already in the list fails with the unique
+// constraint on (list, word).
+func (db *appdbimpl) AddTextFilterWord(word TextFilterWord, at time.Time) (TextFilterWord, error) {
+	word.Word = strings.ToLower(strings.Join(strings.Fields(word.Word), " "))
+	word.Language = strings.ToLower(strings.TrimSpace(word.Language))
+	word.CreatedBy = strings.TrimSpace(word.CreatedBy)
+	if word.Word == "" || (word.List != TextFilterListDeny && word.List != TextFilterListAllow) {
+		return TextFilterWord{}, errors.New("invalid text filter word")
+	}
+	word.CreatedAt = at.UTC().Format(time.RFC3339)
+
+	result, err := db.c.Exec(`INSERT INTO text_filter_words (word, list, language, created_by, created_at) VALUES (?, ?, ?, ?, ?)`,
+		word.Word, word.List, word.Language, word.CreatedBy, word.CreatedAt)
+	if err != nil {
+		return TextFilterWord{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return TextFilterWord{}, err
+	}
+	word.ID = int(id)
+	return word, nil
+}
+
+// DeleteTextFilterWord removes the word from its list, or returns sql.ErrNoRows.
+func (db *appdbimpl) DeleteTextFilterWord(id int) error {
+	result, err := db.c.Exec(`DELETE FROM text_filter_words WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
+
+// ListTextFilterActions returns the action of every target, the default one for the targets never configured.
+func (db *appdbimpl) ListTextFilterActions() (map[string]string, error) {
+	actions := make(map[string]string, len(textFilterTargets))
+	for target, info := range textFilterTargets {
+		actions[target] = info.defaultAction
+	}
+
+	rows, err := db.c.Query(`SELECT target, action FROM text_filter_actions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target, action string
+		if err := rows.Scan(&target, &action); err != nil {
+			return nil, err
+		}
+		if IsValidTextFilterTarget(target) && IsValidTextFilterAction(action) {
+			actions[target] = action
+		}
+	}
+	return actions, rows.Err()
+}
+
+func (db *appdbimpl) SetTextFilterAction(target, action, updatedBy string, at time.Time) error {
+	if !IsValidTextFilterTarget(target) {
+		return ErrInvalidTextFilterTarget
+	}
+	if !IsValidTextFilterAction(action) {
+		return ErrInvalidTextFilterAction
+	}
+	_, err := db.c.Exec(`
+INSERT INTO text_filter_actions (target, action, updated_by, updated_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(target) DO UPDATE SET
+        action = excluded.action,
+        updated_by = excluded.updated_by,
+        updated_at = excluded.updated_at`,
+		target, action, strings.TrimSpace(updatedBy), at.UTC().Format(time.RFC3339))
+	return err
+}
+
+// ListFlaggedTexts returns the texts waiting for review, oldest first.
+func (db *appdbimpl) ListFlaggedTexts() ([]FlaggedText, error) {
+	var queries []string
+	var args []interface{}
+	for _, target := range []string{TextFilterTargetSelfieCaption, TextFilterTargetFeedbackSuggestion, TextFilterTargetOrderNotes} {
+		info := textFilterTargets[target]
+		queries = append(queries, `SELECT ?, id, `+info.eventColumn+`, IFNULL(`+info.textColumn+`, ''), IFNULL(created_at, '') FROM `+info.table+` WHERE `+info.flagColumn+` = 1`)
+		args = append(args, target)
+	}
+	rows, err := db.c.Query(strings.Join(queries, ` UNION ALL `)+` ORDER BY 5, 2`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	texts := []FlaggedText{}
+	for rows.Next() {
+		var text FlaggedText
+		if err := rows.Scan(&text.Target, &text.ID, &text.EventID, &text.Text, &text.CreatedAt); err != nil {
+			return nil, err
+		}
+		if ts, err := parseSQLiteTimestamp(text.CreatedAt); err == nil && !ts.IsZero() {
+			text.CreatedAt = ts.UTC().Format(time.RFC3339)
+		}
+		texts = append(texts, text)
+	}
+	return texts, rows.Err()
+}
+
+// ReviewFlaggedText closes the review of a flagged text: the text is kept as it is, or removed when remove is set.
+// It returns sql.ErrNoRows if the text is not flagged.
+func (db *appdbimpl) ReviewFlaggedText(target string, id int, remove bool) error {
+	info, ok := textFilterTargets[target]
+	if !ok {
+		return ErrInvalidTextFilterTarget
+	}
+	query := `UPDATE ` + info.table + ` SET ` + info.flagColumn + ` = 0 WHERE id = ? AND ` + info.flagColumn + ` = 1`
+	if remove {
+		query = `UPDATE ` + info.table + ` SET ` + info.flagColumn + ` = 0, ` + info.textColumn + ` = '' WHERE id = ? AND ` + info.flagColumn + ` = 1`
+	}
+	result, err := db.c.Exec(query, id)
+	if err != nil {
+		return err
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return sql.ErrNoRows
+	}
+	return err
+}
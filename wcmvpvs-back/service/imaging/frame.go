@@ -0,0 +1,167 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+
+	"golang.org/x/image/webp"
+)
+
+// Caption positions of a Frame.
+const (
+	CaptionNone   = "none"
+	CaptionTop    = "top"
+	CaptionBottom = "bottom"
+)
+
+// Logo positions of a Frame.
+const (
+	LogoTopLeft     = "top-left"
+	LogoTopRight    = "top-right"
+	LogoBottomLeft  = "bottom-left"
+	LogoBottomRight = "bottom-right"
+)
+
+// Frame is the branding that Brand composites onto a picture.
+type Frame struct {
+	// Overlay is drawn over the picture, which is scaled and cropped to fill it: its transparent areas let the picture
+	// show through. Without an overlay the picture keeps its size.
+	Overlay image.Image
+
+	// Caption is written in a band at CaptionPosition (CaptionTop or CaptionBottom)
+	Caption         string
+	CaptionPosition string
+
+	// Logo is scaled down to fit a corner of the picture, at LogoPosition
+	Logo         image.Image
+	LogoPosition string
+}
+
+var captionBandColor = color.RGBA{A: 160}
+
+// Decode decodes a JPEG, PNG or WebP image, refusing images with more than maxPixels pixels (when maxPixels is positive).
+func Decode(data []byte, maxPixels int) (image.Image, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decoding image header: %w", err)
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+	var img image.Image
+	switch format {
+	case "jpeg":
+		img, err = jpeg.Decode(bytes.NewReader(data))
+	case "png":
+		img, err = png.Decode(bytes.NewReader(data))
+	case "webp":
+		img, err = webp.Decode(bytes.NewReader(data))
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding image: %w", err)
+	}
+	return img, nil
+}
+
+// Brand composites the frame onto the picture (a JPEG or PNG image, like those returned by Process) and returns the
+// result as a JPEG image.
+func Brand(picture []byte, frame Frame, opts Options) ([]byte, error) {
+	decoded, err := Decode(picture, opts.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	photo := toRGBA(decoded)
+
+	var canvas *image.RGBA
+	if frame.Overlay != nil {
+		overlay := fit(toRGBA(frame.Overlay), opts.MaxSide)
+		canvas = image.NewRGBA(overlay.Bounds())
+		cover(canvas, photo)
+		draw.Draw(canvas, canvas.Bounds(), overlay, image.Point{}, draw.Over)
+	} else {
+		canvas = photo
+	}
+	bounds := canvas.Bounds()
+
+	margin := max(4, min(bounds.Dx(), bounds.Dy())/30)
+	band := image.Rectangle{}
+	text := captionRunes(frame.Caption)
+	if len(text) > 0 && (frame.CaptionPosition == CaptionTop || frame.CaptionPosition == CaptionBottom) {
+		height := max(glyphHeight+4, bounds.Dy()/10)
+		band = image.Rect(bounds.Min.X, bounds.Max.Y-height, bounds.Max.X, bounds.Max.Y)
+		if frame.CaptionPosition == CaptionTop {
+			band = image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+height)
+		}
+		draw.Draw(canvas, band, image.NewUniform(captionBandColor), image.Point{}, draw.Over)
+		drawText(canvas, band.Inset(height/6), text, color.White)
+	}
+
+	if frame.Logo != nil {
+		logo := toRGBA(frame.Logo)
+		lw, lh := logo.Bounds().Dx(), logo.Bounds().Dy()
+		boxW, boxH := max(1, bounds.Dx()/4), max(1, bounds.Dy()/8)
+		if lw > boxW || lh > boxH {
+			if lw*boxH > lh*boxW {
+				lw, lh = boxW, max(1, lh*boxW/lw)
+			} else {
+				lw, lh = max(1, lw*boxH/lh), boxH
+			}
+			logo = resample(logo, lw, lh)
+		}
+
+		x, y := bounds.Min.X+margin, bounds.Min.Y+margin
+		if frame.LogoPosition == LogoTopRight || frame.LogoPosition == LogoBottomRight {
+			x = bounds.Max.X - margin - lw
+		}
+		if frame.LogoPosition == LogoBottomLeft || frame.LogoPosition == LogoBottomRight {
+			y = bounds.Max.Y - margin - lh
+			if !band.Empty() && band.Max.Y == bounds.Max.Y {
+				y = band.Min.Y - margin - lh
+			}
+		} else if !band.Empty() && band.Min.Y == bounds.Min.Y {
+			y = band.Max.Y + margin
+		}
+		draw.Draw(canvas, image.Rect(x, y, x+lw, y+lh), logo, image.Point{}, draw.Over)
+	}
+
+	return encodeJPEG(canvas, opts.Quality)
+}
+
+// toRGBA converts the image to RGBA, keeping its transparency. Images that are already RGBA are returned as they are.
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// cover scales the picture to fill dst, keeping the aspect ratio, and crops it around the center.
+func cover(dst *image.RGBA, picture *image.RGBA) {
+	dw, dh := dst.Bounds().Dx(), dst.Bounds().Dy()
+	pw, ph := picture.Bounds().Dx(), picture.Bounds().Dy()
+	if pw == 0 || ph == 0 {
+		return
+	}
+	sw, sh := dw, max(1, (ph*dw+pw-1)/pw)
+	if sh < dh {
+		sw, sh = max(1, (pw*dh+ph-1)/ph), dh
+	}
+	scaled := resample(picture, sw, sh)
+	draw.Draw(dst, dst.Bounds(), scaled, image.Pt((sw-dw)/2, (sh-dh)/2), draw.Src)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,77 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// differenceHash returns the 64-bit difference hash (dHash) of the image: the image is reduced to a 9x8 grayscale
+// grid and every bit tells whether a cell is darker than its right neighbour. Re-encoded, resized or slightly edited
+// copies of a picture have hashes a few bits apart.
+func differenceHash(img *image.RGBA) uint64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	var grid [8][9]uint64
+	for cy := 0; cy < 8; cy++ {
+		y0, y1 := cy*h/8, (cy+1)*h/8
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for cx := 0; cx < 9; cx++ {
+			x0, x1 := cx*w/9, (cx+1)*w/9
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var sum, count uint64
+			for y := y0; y < y1 && y < h; y++ {
+				for x := x0; x < x1 && x < w; x++ {
+					offset := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+					r, g, b := uint64(img.Pix[offset]), uint64(img.Pix[offset+1]), uint64(img.Pix[offset+2])
+					sum += 299*r + 587*g + 114*b
+					count++
+				}
+			}
+			if count > 0 {
+				grid[cy][cx] = sum / count
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grid[y][x] < grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// HashDistance returns the number of different bits between two hashes returned in Result.Hash, or -1 if any of them
+// is missing or invalid. Copies of the same picture are usually within 10 bits.
+func HashDistance(a, b string) int {
+	if len(a) != 16 || len(b) != 16 {
+		return -1
+	}
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return -1
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return -1
+	}
+	return bits.OnesCount64(x ^ y)
+}
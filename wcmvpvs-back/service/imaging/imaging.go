@@ -0,0 +1,135 @@
+/*
+Package imaging normalizes the images uploaded by the public (the selfies) before they are stored: it removes every
+metadata (EXIF, GPS position, XMP, ...), applies the EXIF orientation to the pixels, bounds the image size and
+generates a thumbnail.
+
+JPEG, PNG and WebP images are decoded and re-encoded as JPEG, which drops every metadata.
+*/
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"strings"
+
+	"golang.org/x/image/webp"
+)
+
+var (
+	// ErrUnsupportedFormat is returned for content types that cannot be processed.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+
+	// ErrTooLarge is returned for images with more pixels than Options.MaxPixels, to avoid decompression bombs.
+	ErrTooLarge = errors.New("image too large")
+)
+
+type Options struct {
+	// MaxSide is the maximum width and height of the normalized image
+	MaxSide int
+
+	// ThumbnailSide is the maximum width and height of the thumbnail
+	ThumbnailSide int
+
+	// Quality is the JPEG quality of the normalized image and of the thumbnail
+	Quality          int
+	ThumbnailQuality int
+
+	// MaxPixels is the maximum number of pixels of the decoded image. Every pixel takes 4 bytes once decoded, and
+	// again for each copy made while orienting and resizing
+	MaxPixels int
+}
+
+// DefaultOptions are the options used for the selfies.
+var DefaultOptions = Options{
+	MaxSide:          2048,
+	ThumbnailSide:    480,
+	Quality:          85,
+	ThumbnailQuality: 75,
+	MaxPixels:        24_000_000,
+}
+
+type Result struct {
+	Image       []byte
+	ContentType string
+	Width       int
+	Height      int
+
+	// Thumbnail is a JPEG image
+	Thumbnail []byte
+
+	// Hash is the perceptual hash of the image (see HashDistance)
+	Hash string
+}
+
+// Process normalizes an image of the given content type (image/jpeg, image/png or image/webp).
+func Process(data []byte, contentType string, opts Options) (Result, error) {
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "image/jpeg", "image/png", "image/webp":
+	default:
+		return Result{}, ErrUnsupportedFormat
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Result{}, fmt.Errorf("decoding image header: %w", err)
+	}
+	if format != "jpeg" && format != "png" && format != "webp" {
+		return Result{}, ErrUnsupportedFormat
+	}
+	if opts.MaxPixels > 0 && cfg.Width*cfg.Height > opts.MaxPixels {
+		return Result{}, ErrTooLarge
+	}
+
+	var decoded image.Image
+	orientation := 1
+	switch format {
+	case "jpeg":
+		decoded, err = jpeg.Decode(bytes.NewReader(data))
+		orientation = jpegOrientation(data)
+	case "webp":
+		decoded, err = webp.Decode(bytes.NewReader(data))
+		orientation = webpOrientation(data)
+	default:
+		decoded, err = png.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return Result{}, fmt.Errorf("decoding image: %w", err)
+	}
+
+	// Transparent areas become white, since JPEG has no alpha channel.
+	canvas := image.NewRGBA(image.Rect(0, 0, decoded.Bounds().Dx(), decoded.Bounds().Dy()))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(canvas, canvas.Bounds(), decoded, decoded.Bounds().Min, draw.Over)
+
+	normalized := fit(orient(canvas, orientation), opts.MaxSide)
+	full, err := encodeJPEG(normalized, opts.Quality)
+	if err != nil {
+		return Result{}, err
+	}
+	thumbnail, err := encodeJPEG(fit(normalized, opts.ThumbnailSide), opts.ThumbnailQuality)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Image:       full,
+		ContentType: "image/jpeg",
+		Width:       normalized.Bounds().Dx(),
+		Height:      normalized.Bounds().Dy(),
+		Thumbnail:   thumbnail,
+		Hash:        formatHash(differenceHash(normalized)),
+	}, nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,290 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// withOrientation inserts an EXIF segment with the given orientation right after the SOI marker of a JPEG.
+func withOrientation(t *testing.T, data []byte, orientation uint16) []byte {
+	t.Helper()
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	ifd := make([]byte, 2+12+4)
+	binary.BigEndian.PutUint16(ifd[0:2], 1)
+	binary.BigEndian.PutUint16(ifd[2:4], 0x0112)
+	binary.BigEndian.PutUint16(ifd[4:6], 3)
+	binary.BigEndian.PutUint32(ifd[6:10], 1)
+	binary.BigEndian.PutUint16(ifd[10:12], orientation)
+	payload := append([]byte("Exif\x00\x00"), append(tiff, ifd...)...)
+
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:4], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+	return append(append([]byte{0xFF, 0xD8}, segment...), data[2:]...)
+}
+
+func TestProcessJPEG(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 3000, 1000))
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, nil); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+	data := withOrientation(t, buf.Bytes(), 6)
+	if got := jpegOrientation(data); got != 6 {
+		t.Fatalf("orientation = %d, want 6", got)
+	}
+
+	result, err := Process(data, "image/jpeg", DefaultOptions)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if result.Width != 683 || result.Height != 2048 {
+		t.Fatalf("normalized size = %dx%d, want 683x2048", result.Width, result.Height)
+	}
+	if bytes.Contains(result.Image, []byte("Exif")) {
+		t.Fatal("EXIF metadata not removed")
+	}
+	thumb, err := jpeg.DecodeConfig(bytes.NewReader(result.Thumbnail))
+	if err != nil || thumb.Width != 160 || thumb.Height != 480 {
+		t.Fatalf("thumbnail = %+v (%v), want 160x480", thumb, err)
+	}
+}
+
+func TestProcessPNG(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+
+	result, err := Process(buf.Bytes(), "image/png", DefaultOptions)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(result.Image))
+	if err != nil || result.ContentType != "image/jpeg" {
+		t.Fatalf("normalized image is not a JPEG (%v)", err)
+	}
+	// The transparent pixels become white.
+	if r, g, b, _ := decoded.At(3, 3).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+		t.Fatalf("transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestProcessTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+	// Only the header is read: make it claim 6000x5000 pixels.
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data[16:20], 6000)
+	binary.BigEndian.PutUint32(data[20:24], 5000)
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+
+	if _, err := Process(data, "image/png", DefaultOptions); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Process error = %v, want ErrTooLarge", err)
+	}
+}
+
+func TestProcessWebP(t *testing.T) {
+	// A 150x100 lossy WebP, wrapped in an extended container with an EXIF chunk rotating it by 90°.
+	lossy, err := os.ReadFile("testdata/blue-purple-pink.lossy.webp")
+	if err != nil {
+		t.Fatalf("cannot read test image: %v", err)
+	}
+	chunk := func(fourCC string, payload []byte) []byte {
+		header := make([]byte, 8)
+		copy(header, fourCC)
+		binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+		if len(payload)%2 == 1 {
+			payload = append(payload, 0)
+		}
+		return append(header, payload...)
+	}
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 // EXIF flag
+	vp8x[4], vp8x[7] = 150-1, 100-1
+	exif := withOrientation(t, []byte{0xFF, 0xD8}, 6)[6:]
+	body := append([]byte("WEBP"), chunk("VP8X", vp8x)...)
+	body = append(body, lossy[12:]...)
+	body = append(body, chunk("EXIF", exif)...)
+	data := append([]byte("RIFF\x00\x00\x00\x00"), body...)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(len(body)))
+	if got := webpOrientation(data); got != 6 {
+		t.Fatalf("orientation = %d, want 6", got)
+	}
+
+	result, err := Process(data, "image/webp", Options{MaxSide: 80, ThumbnailSide: 40, Quality: 85, ThumbnailQuality: 75})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if result.ContentType != "image/jpeg" || result.Width != 53 || result.Height != 80 || result.Hash == "" {
+		t.Fatalf("result = %s %dx%d hash %q, want a 53x80 JPEG", result.ContentType, result.Width, result.Height, result.Hash)
+	}
+	if bytes.Contains(result.Image, []byte("Exif")) {
+		t.Fatal("EXIF metadata not removed")
+	}
+	thumb, err := jpeg.DecodeConfig(bytes.NewReader(result.Thumbnail))
+	if err != nil || thumb.Width != 27 || thumb.Height != 40 {
+		t.Fatalf("thumbnail = %+v (%v), want 27x40", thumb, err)
+	}
+}
+
+func TestHashDistance(t *testing.T) {
+	encode := func(w, h int, shade func(x, y int) uint8) []byte {
+		img := image.NewRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				v := shade(x*100/w, y*100/h)
+				img.Set(x, y, color.RGBA{R: v, G: v, B: v, A: 255})
+			}
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatalf("cannot encode test image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	waves := func(x, y int) uint8 { return uint8((x*x + 3*y) % 256) }
+	stripes := func(x, y int) uint8 { return uint8(255 * ((x/7 + y/13) % 2)) }
+
+	hash := func(data []byte) string {
+		result, err := Process(data, "image/png", DefaultOptions)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return result.Hash
+	}
+	original := hash(encode(800, 600, waves))
+	if d := HashDistance(original, hash(encode(400, 300, waves))); d < 0 || d > 6 {
+		t.Fatalf("distance to a resized copy = %d, want at most 6", d)
+	}
+	if d := HashDistance(original, hash(encode(800, 600, stripes))); d <= 10 {
+		t.Fatalf("distance to a different image = %d, want more than 10", d)
+	}
+	if d := HashDistance(original, ""); d != -1 {
+		t.Fatalf("distance to a missing hash = %d, want -1", d)
+	}
+}
+
+func TestBrand(t *testing.T) {
+	photo := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	draw.Draw(photo, photo.Bounds(), image.NewUniform(color.RGBA{G: 200, A: 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, photo, nil); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+
+	// A red border around a transparent window
+	overlay := image.NewRGBA(image.Rect(0, 0, 200, 250))
+	draw.Draw(overlay, overlay.Bounds(), image.NewUniform(color.RGBA{R: 255, A: 255}), image.Point{}, draw.Src)
+	draw.Draw(overlay, image.Rect(20, 20, 180, 230), image.Transparent, image.Point{}, draw.Src)
+	logo := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(logo, logo.Bounds(), image.NewUniform(color.RGBA{B: 255, A: 255}), image.Point{}, draw.Src)
+
+	frame := Frame{Overlay: overlay, Caption: "Forza ragazzi! 🏐", CaptionPosition: CaptionBottom, Logo: logo, LogoPosition: LogoTopRight}
+	branded, err := Brand(buf.Bytes(), frame, DefaultOptions)
+	if err != nil {
+		t.Fatalf("Brand() error: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(branded))
+	if err != nil {
+		t.Fatalf("cannot decode branded image: %v", err)
+	}
+	if img.Bounds().Dx() != 200 || img.Bounds().Dy() != 250 {
+		t.Fatalf("branded size = %v, want the overlay size", img.Bounds())
+	}
+
+	dominant := func(x, y int) string {
+		r, g, b, _ := img.At(x, y).RGBA()
+		switch {
+		case r > 0xc000 && g < 0x4000 && b < 0x4000:
+			return "red"
+		case g > 0x8000 && r < 0x4000 && b < 0x4000:
+			return "green"
+		case b > 0xc000 && r < 0x4000 && g < 0x4000:
+			return "blue"
+		}
+		return "other"
+	}
+	checks := []struct {
+		x, y int
+		want string
+	}{
+		{5, 5, "red"},      // overlay border
+		{60, 80, "green"},  // picture through the window
+		{175, 15, "blue"},  // logo, top right
+		{100, 60, "green"}, // below the logo
+	}
+	for _, c := range checks {
+		if got := dominant(c.x, c.y); got != c.want {
+			t.Errorf("pixel (%d,%d) is %s, want %s", c.x, c.y, got, c.want)
+		}
+	}
+	// The caption band darkens the bottom of the window
+	if _, g, _, _ := img.At(100, 226).RGBA(); g > 0x8000 {
+		t.Errorf("caption band pixel = %v, want darkened", img.At(100, 226))
+	}
+
+	if got := string(captionRunes("  Forza   ragazzi! 🏐 Perché ")); got != "FORZA RAGAZZI! PERCHE" {
+		t.Errorf("captionRunes() = %q", got)
+	}
+}
+
+func TestProcessLogo(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 600, 300))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatalf("cannot encode test image: %v", err)
+	}
+
+	logo, err := ProcessLogo(buf.Bytes(), "image/png", map[string]int{"small": 150, "large": 1000}, DefaultOptions.MaxPixels)
+	if err != nil {
+		t.Fatalf("ProcessLogo: %v", err)
+	}
+	if logo.ContentType != "image/png" || logo.Width != 600 || logo.Height != 300 {
+		t.Fatalf("logo = %s %dx%d, want image/png 600x300", logo.ContentType, logo.Width, logo.Height)
+	}
+	for name, want := range map[string]image.Point{"small": {150, 75}, "large": {600, 300}} {
+		decoded, err := png.Decode(bytes.NewReader(logo.Renditions[name]))
+		if err != nil || decoded.Bounds().Size() != want {
+			t.Fatalf("%s rendition = %v (%v), want %v", name, decoded.Bounds().Size(), err, want)
+		}
+		// The transparent pixels stay transparent.
+		if _, _, _, a := decoded.At(decoded.Bounds().Dx()-1, decoded.Bounds().Dy()-1).RGBA(); a != 0 {
+			t.Fatalf("%s rendition: transparent pixel has alpha %d", name, a>>8)
+		}
+	}
+
+	// WebP logos are resized too, and stored as PNG to keep their transparency
+	webpLogo, err := os.ReadFile("testdata/blue-purple-pink.lossy.webp")
+	if err != nil {
+		t.Fatalf("cannot read test image: %v", err)
+	}
+	logo, err = ProcessLogo(webpLogo, "image/webp", map[string]int{"small": 60}, DefaultOptions.MaxPixels)
+	if err != nil {
+		t.Fatalf("ProcessLogo WebP: %v", err)
+	}
+	if logo.ContentType != "image/png" || logo.Width != 150 || logo.Height != 100 {
+		t.Fatalf("WebP logo = %s %dx%d, want image/png 150x100", logo.ContentType, logo.Width, logo.Height)
+	}
+	if decoded, err := png.Decode(bytes.NewReader(logo.Renditions["small"])); err != nil || decoded.Bounds().Size() != image.Pt(60, 40) {
+		t.Fatalf("WebP small rendition = %v (%v), want 60x40", decoded, err)
+	}
+
+	if _, err := ProcessLogo([]byte("<svg/>"), "image/svg+xml", map[string]int{"small": 150}, 0); err != ErrUnsupportedFormat {
+		t.Fatalf("SVG logo: err = %v, want %v", err, ErrUnsupportedFormat)
+	}
+}
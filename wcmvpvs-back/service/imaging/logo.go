@@ -0,0 +1,76 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+)
+
+// logoQuality is the JPEG quality of the renditions of JPEG logos.
+const logoQuality = 90
+
+// Logo is a sponsor logo processed by ProcessLogo.
+type Logo struct {
+	ContentType string
+
+	// Width and Height are the size of the logo as uploaded
+	Width  int
+	Height int
+
+	// Renditions holds the logo scaled down to every size asked, by name
+	Renditions map[string][]byte
+}
+
+// ProcessLogo validates a sponsor logo (image/png, image/jpeg or image/webp) and scales it down, keeping the aspect
+// ratio, to fit a square of each of sides; smaller logos are not enlarged. PNG logos keep their transparency and stay
+// PNG, JPEG logos stay JPEG; WebP logos become PNG, since they can be transparent too. All of them lose their
+// metadata.
+func ProcessLogo(data []byte, contentType string, sides map[string]int, maxPixels int) (Logo, error) {
+	switch strings.ToLower(strings.TrimSpace(contentType)) {
+	case "image/png", "image/jpeg", "image/webp":
+	default:
+		return Logo{}, ErrUnsupportedFormat
+	}
+
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Logo{}, fmt.Errorf("decoding image header: %w", err)
+	}
+	decoded, err := Decode(data, maxPixels)
+	if err != nil {
+		return Logo{}, err
+	}
+	canvas := toRGBA(decoded)
+	switch format {
+	case "jpeg":
+		canvas = orient(canvas, jpegOrientation(data))
+	case "webp":
+		canvas = orient(canvas, webpOrientation(data))
+		format = "png"
+	}
+
+	logo := Logo{
+		ContentType: "image/" + format,
+		Width:       canvas.Bounds().Dx(),
+		Height:      canvas.Bounds().Dy(),
+		Renditions:  make(map[string][]byte, len(sides)),
+	}
+	for name, side := range sides {
+		scaled := fit(canvas, side)
+		var encoded []byte
+		if format == "png" {
+			var buf bytes.Buffer
+			err = png.Encode(&buf, scaled)
+			encoded = buf.Bytes()
+		} else {
+			encoded, err = encodeJPEG(scaled, logoQuality)
+		}
+		if err != nil {
+			return Logo{}, err
+		}
+		logo.Renditions[name] = encoded
+	}
+	return logo, nil
+}
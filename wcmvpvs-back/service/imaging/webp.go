@@ -0,0 +1,26 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// webpOrientation returns the EXIF orientation (1-8) of a WebP image, or 1 when it is missing or unreadable.
+func webpOrientation(data []byte) int {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return 1
+	}
+	for pos := 12; pos+8 <= len(data); {
+		size := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		end := pos + 8 + size
+		if size < 0 || end > len(data) {
+			return 1
+		}
+		if string(data[pos:pos+4]) == "EXIF" {
+			// Some encoders keep the "Exif" header of the JPEG segment
+			return exifOrientation(bytes.TrimPrefix(data[pos+8:end], []byte("Exif\x00\x00")))
+		}
+		pos = end + size%2
+	}
+	return 1
+}
@@ -0,0 +1,37 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// Dir writes the messages as .eml files in a directory, instead of sending them. It is meant for development.
+type Dir struct {
+	root string
+}
+
+// NewDir returns a Dir writing in dir, creating the directory if needed.
+func NewDir(dir string) (*Dir, error) {
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, err
+	}
+	return &Dir{root: root}, nil
+}
+
+// Send writes the message to `<date>-<id>.eml`, so that the files sort by sending time.
+func (d *Dir) Send(_ context.Context, msg Message) error {
+	content, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	name := msg.Date.UTC().Format("20060102-150405") + "-" + unsafeFileChars.ReplaceAllString(msg.ID, "_") + ".eml"
+	return os.WriteFile(filepath.Join(d.root, name), content, 0o600)
+}
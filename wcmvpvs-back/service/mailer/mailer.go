@@ -0,0 +1,135 @@
+/*
+Package mailer sends the transactional emails, like the shop order confirmations. Two transports are available: SMTP,
+which delivers the messages to a mail server, and Dir, which writes them as .eml files for development, so that they
+can be opened with any mail client without sending anything.
+
+The messages are rendered from the templates embedded in the package, one set for each supported language.
+*/
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// ErrInvalidAddress is returned for sender and recipient addresses that cannot be parsed.
+var ErrInvalidAddress = errors.New("invalid email address")
+
+// Message is an email ready to be sent.
+type Message struct {
+	// ID is unique for each message, and is used for the Message-ID header
+	ID      string
+	From    string
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	Date    time.Time
+}
+
+// Sender delivers the messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+const (
+	TransportNone = "none"
+	TransportSMTP = "smtp"
+	TransportDir  = "eml"
+)
+
+// Config selects and configures a Sender. Dir is used by the eml transport, SMTP by the smtp transport.
+type Config struct {
+	Transport string
+	Dir       string
+	SMTP      SMTPConfig
+}
+
+// New creates the sender selected by cfg.Transport. It returns nil, without error, when sending is disabled.
+func New(cfg Config) (Sender, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Transport)) {
+	case "", TransportNone:
+		return nil, nil
+	case TransportDir:
+		if cfg.Dir == "" {
+			return nil, errors.New("mail directory is required")
+		}
+		return NewDir(cfg.Dir)
+	case TransportSMTP:
+		return NewSMTP(cfg.SMTP)
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.Transport)
+	}
+}
+
+// ParseAddress returns the address of a "Name <address>" or bare address string.
+func ParseAddress(value string) (*mail.Address, error) {
+	address, err := mail.ParseAddress(strings.TrimSpace(value))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidAddress, value)
+	}
+	return address, nil
+}
+
+// Bytes encodes the message in the Internet Message Format, with the text and the HTML bodies as alternatives.
+func (m Message) Bytes() ([]byte, error) {
+	from, err := ParseAddress(m.From)
+	if err != nil {
+		return nil, err
+	}
+	to, err := ParseAddress(m.To)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from.Address, "@"); at >= 0 {
+		domain = from.Address[at+1:]
+	}
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace(m.Subject)
+
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\n", from.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", to.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", m.Date.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", m.ID, domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n\r\n", body.Boundary())
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		writer, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		if _, err := encoder.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := encoder.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,144 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	data := OrderData{
+		OrderID:      42,
+		CustomerName: "Mario <b>",
+		Items:        []OrderItem{{Name: "Maglia", Variant: "M", Quantity: 2, TotalCents: 12000}},
+		TotalCents:   12000,
+		PickupEvent:  "Wearing Cash - Roma",
+		PickupStart:  time.Date(2024, 6, 1, 20, 30, 0, 0, time.UTC),
+	}
+	italian, err := Render(TemplateOrderConfirmation, "it-IT", data)
+	if err != nil {
+		t.Fatalf("Render: %v", err)
+	}
+	if italian.Subject != "Conferma dell'ordine #42" || !strings.Contains(italian.Text, "Maglia (M) x 2: 120,00 €") || !strings.Contains(italian.Text, "01/06/2024 20:30") {
+		t.Fatalf("italian message = %+v", italian)
+	}
+	if !strings.Contains(italian.HTML, "Mario &lt;b&gt;") {
+		t.Fatalf("html body is not escaped: %s", italian.HTML)
+	}
+
+	english, err := Render(TemplateOrderConfirmation, "en", data)
+	if err != nil || !strings.Contains(english.Text, "€120.00") {
+		t.Fatalf("english message = %+v (%v)", english, err)
+	}
+	if fallback, err := Render(TemplatePrizeWinner, "fr", PrizeData{PrizeName: "Pallone", TicketCode: "1234"}); err != nil || fallback.Subject != "Hai vinto: Pallone" {
+		t.Fatalf("unsupported locale = %+v (%v)", fallback, err)
+	}
+}
+
+func testMessage() Message {
+	return Message{
+		ID:      "test-1",
+		From:    "Wearing Cash <noreply@example.com>",
+		To:      "mario@example.com",
+		Subject: "Conferma dell'ordine #42\r\nBcc: someone@example.com",
+		Text:    "Ciao Mario",
+		HTML:    "<p>Ciao Mario</p>",
+		Date:    time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	sender, err := New(Config{Transport: TransportDir, Dir: dir})
+	if err != nil {
+		t.Fatalf("cannot create sender: %v", err)
+	}
+	if err := sender.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	file, err := os.Open(filepath.Join(dir, "20240601-200000-test-1.eml"))
+	if err != nil {
+		t.Fatalf("eml file not written: %v", err)
+	}
+	defer file.Close()
+	msg, err := mail.ReadMessage(file)
+	if err != nil {
+		t.Fatalf("cannot parse eml: %v", err)
+	}
+	if msg.Header.Get("To") != "<mario@example.com>" || msg.Header.Get("Bcc") != "" || msg.Header.Get("Message-Id") != "<test-1@example.com>" {
+		t.Fatalf("headers = %v", msg.Header)
+	}
+}
+
+func TestSMTP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+	defer listener.Close()
+	received := make(chan string, 1)
+	go serveSMTP(listener, received)
+
+	addr := listener.Addr().(*net.TCPAddr)
+	sender, err := NewSMTP(SMTPConfig{Host: "127.0.0.1", Port: addr.Port, Security: SMTPSecurityNone})
+	if err != nil {
+		t.Fatalf("cannot create sender: %v", err)
+	}
+	if err := sender.Send(context.Background(), testMessage()); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	data := <-received
+	if !strings.Contains(data, "MAIL FROM:<noreply@example.com>") || !strings.Contains(data, "RCPT TO:<mario@example.com>") || !strings.Contains(data, "Ciao Mario") {
+		t.Fatalf("server received %q", data)
+	}
+}
+
+// serveSMTP accepts one connection, answering the commands of a plain delivery, and sends what it received.
+func serveSMTP(listener net.Listener, received chan<- string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	var transcript strings.Builder
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		transcript.WriteString(line)
+		switch command := strings.ToUpper(strings.TrimSpace(line)); {
+		case strings.HasPrefix(command, "EHLO"):
+			reply("250 localhost")
+		case command == "DATA":
+			reply("354 go ahead")
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				transcript.WriteString(dataLine)
+			}
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			received <- transcript.String()
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+	received <- transcript.String()
+}
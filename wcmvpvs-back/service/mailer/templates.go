@@ -0,0 +1,135 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// The templates of the messages. Each one is a file in templates/<locale>/ defining the "subject", "text" and "html"
+// blocks.
+const (
+	TemplateOrderConfirmation = "order-confirmation"
+	TemplatePickupReady       = "pickup-ready"
+	TemplatePrizeWinner       = "prize-winner"
+)
+
+const (
+	LocaleItalian = "it"
+	LocaleEnglish = "en"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// OrderData is the data of the order templates.
+type OrderData struct {
+	OrderID      int
+	CustomerName string
+	Items        []OrderItem
+	TotalCents   int
+
+	// PickupEvent is the title of the event where the order is collected, empty when not chosen yet
+	PickupEvent string
+	PickupStart time.Time
+}
+
+type OrderItem struct {
+	Name       string
+	Variant    string
+	Quantity   int
+	TotalCents int
+}
+
+// PrizeData is the data of the prize winner template.
+type PrizeData struct {
+	EventTitle string
+	EventStart time.Time
+	PrizeName  string
+	Position   int
+	TicketCode string
+}
+
+// Content is a rendered message.
+type Content struct {
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type localeTemplates struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+var templates = map[string]map[string]localeTemplates{
+	LocaleItalian: parseTemplates(LocaleItalian, "%d,%02d €", "02/01/2006 15:04"),
+	LocaleEnglish: parseTemplates(LocaleEnglish, "€%d.%02d", "2 Jan 2006, 15:04"),
+}
+
+// NormalizeLocale returns the supported locale matching a language tag like "en-GB", Italian when none matches.
+func NormalizeLocale(locale string) string {
+	language := strings.ToLower(strings.TrimSpace(locale))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	if _, ok := templates[language]; ok {
+		return language
+	}
+	return LocaleItalian
+}
+
+// Render renders the template in the locale, Italian for the unsupported ones.
+func Render(name, locale string, data interface{}) (Content, error) {
+	tmpl, ok := templates[NormalizeLocale(locale)][name]
+	if !ok {
+		return Content{}, fmt.Errorf("unknown mail template %q", name)
+	}
+	var content Content
+	var buf bytes.Buffer
+	for _, block := range []struct {
+		name   string
+		target *string
+	}{{"subject", &content.Subject}, {"text", &content.Text}} {
+		buf.Reset()
+		if err := tmpl.text.ExecuteTemplate(&buf, block.name, data); err != nil {
+			return Content{}, err
+		}
+		*block.target = strings.TrimSpace(buf.String())
+	}
+	buf.Reset()
+	if err := tmpl.html.ExecuteTemplate(&buf, "html", data); err != nil {
+		return Content{}, err
+	}
+	content.HTML = strings.TrimSpace(buf.String())
+	return content, nil
+}
+
+// parseTemplates parses the templates of a locale, with the functions formatting amounts and dates in its style.
+func parseTemplates(locale, moneyFormat, dateFormat string) map[string]localeTemplates {
+	funcs := map[string]interface{}{
+		"money": func(cents int) string {
+			return fmt.Sprintf(moneyFormat, cents/100, cents%100)
+		},
+		"datetime": func(at time.Time) string {
+			if at.IsZero() {
+				return ""
+			}
+			return at.Format(dateFormat)
+		},
+	}
+
+	parsed := map[string]localeTemplates{}
+	for _, name := range []string{TemplateOrderConfirmation, TemplatePickupReady, TemplatePrizeWinner} {
+		path := "templates/" + locale + "/" + name + ".tmpl"
+		parsed[name] = localeTemplates{
+			text: texttemplate.Must(texttemplate.New(name).Funcs(funcs).ParseFS(templateFiles, path)),
+			html: htmltemplate.Must(htmltemplate.New(name).Funcs(funcs).ParseFS(templateFiles, path)),
+		}
+	}
+	return parsed
+}
@@ -0,0 +1,149 @@
+package payments
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/globaltime"
+)
+
+// FakeCheckoutPath is the path, under the API base URL, of the checkout pages of the Fake provider. The intent ID
+// follows it.
+const FakeCheckoutPath = "/payments/fake/"
+
+// ErrIntentClosed is returned when completing an intent that was already paid or cancelled.
+var ErrIntentClosed = errors.New("payment intent already completed")
+
+const (
+	FakeIntentOpen      = "open"
+	FakeIntentSucceeded = "succeeded"
+	FakeIntentFailed    = "failed"
+)
+
+// FakeIntent is an intent created on the Fake provider.
+type FakeIntent struct {
+	IntentRequest
+	ID     string
+	Status string
+}
+
+// FakeWebhook is the webhook call the Fake provider makes when a checkout completes, with the page where the
+// customer is sent afterwards.
+type FakeWebhook struct {
+	Header      http.Header
+	Body        []byte
+	RedirectURL string
+}
+
+// Fake is a provider for development and tests. Its intents live in memory, and the checkout page served by the
+// application lets the customer pay or cancel without any card. The outcome goes through the same signed webhook
+// as a real provider.
+type Fake struct {
+	secret string
+
+	mu      sync.Mutex
+	intents map[string]*FakeIntent
+}
+
+// NewFake returns a Fake provider signing its webhooks with secret. An empty secret is replaced by a random one,
+// as the webhooks never leave the process.
+func NewFake(secret string) (*Fake, error) {
+	if secret == "" {
+		var err error
+		if secret, err = randomID(""); err != nil {
+			return nil, err
+		}
+	}
+	return &Fake{secret: secret, intents: map[string]*FakeIntent{}}, nil
+}
+
+func (f *Fake) Name() string {
+	return ProviderFake
+}
+
+func (f *Fake) CreateIntent(_ context.Context, req IntentRequest) (Intent, error) {
+	if req.AmountCents <= 0 {
+		return Intent{}, fmt.Errorf("invalid payment amount %d", req.AmountCents)
+	}
+	id, err := randomID("fake_pi_")
+	if err != nil {
+		return Intent{}, err
+	}
+
+	f.mu.Lock()
+	f.intents[id] = &FakeIntent{IntentRequest: req, ID: id, Status: FakeIntentOpen}
+	f.mu.Unlock()
+	return Intent{ID: id, CheckoutURL: FakeCheckoutPath + id}, nil
+}
+
+// Intent returns the intent with the given ID, or ErrUnknownIntent.
+func (f *Fake) Intent(id string) (FakeIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, ok := f.intents[id]
+	if !ok {
+		return FakeIntent{}, ErrUnknownIntent
+	}
+	return *intent, nil
+}
+
+// Complete closes the checkout of the intent, paid or cancelled by the customer, and returns the signed webhook
+// call notifying the outcome.
+func (f *Fake) Complete(id string, paid bool) (FakeWebhook, error) {
+	f.mu.Lock()
+	intent, ok := f.intents[id]
+	if !ok {
+		f.mu.Unlock()
+		return FakeWebhook{}, ErrUnknownIntent
+	}
+	if intent.Status != FakeIntentOpen {
+		f.mu.Unlock()
+		return FakeWebhook{}, ErrIntentClosed
+	}
+	event := Event{Type: EventPaymentFailed, IntentID: id, AmountCents: intent.AmountCents}
+	redirect := intent.CancelURL
+	intent.Status = FakeIntentFailed
+	if paid {
+		event.Type = EventPaymentSucceeded
+		intent.Status, redirect = FakeIntentSucceeded, intent.ReturnURL
+	}
+	f.mu.Unlock()
+
+	var err error
+	if event.ID, err = randomID("fake_evt_"); err != nil {
+		return FakeWebhook{}, err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return FakeWebhook{}, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(SignatureHeader, Sign(f.secret, body, globaltime.Now()))
+	return FakeWebhook{Header: header, Body: body, RedirectURL: redirect}, nil
+}
+
+func (f *Fake) ParseWebhook(header http.Header, body []byte) (Event, error) {
+	if err := VerifySignature(f.secret, header.Get(SignatureHeader), body, globaltime.Now()); err != nil {
+		return Event{}, err
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil || event.ID == "" || event.IntentID == "" {
+		return Event{}, ErrInvalidEvent
+	}
+	return event, nil
+}
+
+func randomID(prefix string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,98 @@
+/*
+Package payments collects the payments of the shop orders through a hosted checkout. The flow is the same for every
+provider: the server creates a payment intent for the order and redirects the customer to the checkout page of the
+provider, then the provider confirms the outcome by calling a webhook signed with a shared secret.
+
+The Fake provider implements the flow in-process, with a checkout page served by this application, so that the shop
+can be tested end to end without an account on a real provider.
+*/
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrInvalidSignature is returned for webhooks without a valid signature, or signed too long ago.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+
+	// ErrInvalidEvent is returned for webhooks with a body that cannot be parsed.
+	ErrInvalidEvent = errors.New("invalid webhook event")
+
+	// ErrUnknownIntent is returned when the intent does not exist on the provider.
+	ErrUnknownIntent = errors.New("unknown payment intent")
+)
+
+const (
+	EventPaymentSucceeded = "payment.succeeded"
+	EventPaymentFailed    = "payment.failed"
+)
+
+// IntentRequest describes the payment to collect for an order.
+type IntentRequest struct {
+	OrderID       int
+	AmountCents   int
+	Currency      string
+	Description   string
+	CustomerEmail string
+
+	// ReturnURL is where the provider sends the customer after the payment, CancelURL where it sends them when they
+	// give up
+	ReturnURL string
+	CancelURL string
+}
+
+// Intent is a payment created on the provider. CheckoutURL is the page where the customer pays; it may be relative
+// to the API base URL for providers served by this application.
+type Intent struct {
+	ID          string
+	CheckoutURL string
+}
+
+// Event is the outcome of a payment, notified by the provider through the webhook. The same event can be delivered
+// more than once: ID is unique for each event, and must be used to ignore the repeated deliveries.
+type Event struct {
+	ID          string `json:"id"`
+	Type        string `json:"type"`
+	IntentID    string `json:"intent_id"`
+	AmountCents int    `json:"amount_cents"`
+}
+
+// Provider is a payment provider with a hosted checkout.
+type Provider interface {
+	// Name identifies the provider in the saved payments
+	Name() string
+
+	// CreateIntent creates the payment on the provider.
+	CreateIntent(ctx context.Context, req IntentRequest) (Intent, error)
+
+	// ParseWebhook verifies the signature of a webhook call and returns its event.
+	ParseWebhook(header http.Header, body []byte) (Event, error)
+}
+
+const (
+	ProviderNone = "none"
+	ProviderFake = "fake"
+)
+
+// Config selects and configures a Provider. WebhookSecret signs the webhook calls.
+type Config struct {
+	Provider      string
+	WebhookSecret string
+}
+
+// New creates the provider selected by cfg.Provider. It returns nil, without error, when payments are disabled.
+func New(cfg Config) (Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Provider)) {
+	case "", ProviderNone:
+		return nil, nil
+	case ProviderFake:
+		return NewFake(cfg.WebhookSecret)
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+	}
+}
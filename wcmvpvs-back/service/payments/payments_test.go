@@ -0,0 +1,57 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	signedAt := time.Date(2024, 6, 1, 20, 0, 0, 0, time.UTC)
+	header := Sign("secret", body, signedAt)
+
+	if err := VerifySignature("secret", header, body, signedAt.Add(time.Minute)); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+	for name, check := range map[string]error{
+		"wrong secret":  VerifySignature("other", header, body, signedAt),
+		"changed body":  VerifySignature("secret", header, []byte(`{"id":"evt_2"}`), signedAt),
+		"replayed late": VerifySignature("secret", header, body, signedAt.Add(SignatureTolerance+time.Second)),
+		"missing":       VerifySignature("secret", "", body, signedAt),
+	} {
+		if !errors.Is(check, ErrInvalidSignature) {
+			t.Fatalf("%s: error = %v, want ErrInvalidSignature", name, check)
+		}
+	}
+}
+
+func TestFakeProvider(t *testing.T) {
+	provider, err := New(Config{Provider: ProviderFake, WebhookSecret: "secret"})
+	if err != nil {
+		t.Fatalf("cannot create provider: %v", err)
+	}
+	fake := provider.(*Fake)
+
+	intent, err := fake.CreateIntent(context.Background(), IntentRequest{OrderID: 7, AmountCents: 1500, ReturnURL: "/ok", CancelURL: "/ko"})
+	if err != nil || intent.CheckoutURL != FakeCheckoutPath+intent.ID {
+		t.Fatalf("CreateIntent = %+v, %v", intent, err)
+	}
+
+	webhook, err := fake.Complete(intent.ID, true)
+	if err != nil || webhook.RedirectURL != "/ok" {
+		t.Fatalf("Complete = %+v, %v", webhook, err)
+	}
+	event, err := fake.ParseWebhook(webhook.Header, webhook.Body)
+	if err != nil || event.Type != EventPaymentSucceeded || event.IntentID != intent.ID || event.AmountCents != 1500 {
+		t.Fatalf("ParseWebhook = %+v, %v", event, err)
+	}
+	if _, err := fake.Complete(intent.ID, false); !errors.Is(err, ErrIntentClosed) {
+		t.Fatalf("second Complete = %v, want ErrIntentClosed", err)
+	}
+
+	if disabled, err := New(Config{}); disabled != nil || err != nil {
+		t.Fatalf("New without provider = %v, %v", disabled, err)
+	}
+}
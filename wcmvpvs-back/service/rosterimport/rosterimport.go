@@ -0,0 +1,593 @@
+/*
+Package rosterimport loads teams, players and event fixtures in bulk from CSV or JSON files. It is used both by the
+admin import endpoints in service/api and by the `mvpvsimport` command.
+
+Every row is validated before writing anything: if a single row is invalid, nothing is written and the report lists
+the errors by row. In dry-run mode the report is computed without writing, even when all the rows are valid.
+
+Rows are matched against the database using natural keys:
+
+  - teams: the name;
+  - players: the team and the first and last name;
+  - events: the two teams and the start date/time.
+
+In insert mode a row matching an existing one is an error; in upsert mode the existing row is updated. An update only
+changes the columns the file has: the others keep their current value.
+
+CSV files must have a header row with the column names. JSON files must contain an array of objects with the same
+keys. The columns are:
+
+  - teams: name
+  - players: first_name, last_name, role, jersey_number, image_url, team
+  - events: home_team, away_team, start_datetime, location, show_reaction_test, show_selfie, show_vote_trend,
+    show_feedback_survey
+*/
+package rosterimport
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/albyma98/wcmvpvotingsystem/wcmvpvs-back/service/database"
+)
+
+type Kind string
+
+const (
+	KindTeams   Kind = "teams"
+	KindPlayers Kind = "players"
+	KindEvents  Kind = "events"
+)
+
+type Format string
+
+const (
+	FormatCSV  Format = "csv"
+	FormatJSON Format = "json"
+)
+
+type Mode string
+
+const (
+	ModeInsert Mode = "insert"
+	ModeUpsert Mode = "upsert"
+)
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+)
+
+// maxRows limits the size of an import, to keep the single transaction reasonable.
+const maxRows = 5000
+
+var (
+	// ErrInvalidOptions is returned when kind, format or mode are not supported.
+	ErrInvalidOptions = errors.New("invalid import options")
+
+	// ErrMalformedInput is returned when the file cannot be parsed at all (as opposed to invalid rows).
+	ErrMalformedInput = errors.New("malformed import file")
+)
+
+type Options struct {
+	Kind   Kind
+	Format Format
+	Mode   Mode
+	DryRun bool
+}
+
+// RowError is a validation error. Row is the line number for CSV files (the header is line 1) and the 1-based
+// position in the array for JSON files.
+type RowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type RowResult struct {
+	Row    int    `json:"row"`
+	Action string `json:"action"`
+	Key    string `json:"key"`
+	ID     int    `json:"id,omitempty"`
+}
+
+type Report struct {
+	Kind    Kind        `json:"kind"`
+	Mode    Mode        `json:"mode"`
+	DryRun  bool        `json:"dry_run"`
+	Applied bool        `json:"applied"`
+	Rows    int         `json:"rows"`
+	Created int         `json:"created"`
+	Updated int         `json:"updated"`
+	Results []RowResult `json:"results"`
+	Errors  []RowError  `json:"errors"`
+}
+
+type record struct {
+	row    int
+	fields map[string]string
+}
+
+func (r record) get(names ...string) string {
+	for _, name := range names {
+		if value, ok := r.fields[name]; ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
+
+func (r record) has(names ...string) bool {
+	for _, name := range names {
+		if value, ok := r.fields[name]; ok && strings.TrimSpace(value) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// provided tells whether the file has one of the columns, even if empty.
+func (r record) provided(names ...string) bool {
+	for _, name := range names {
+		if _, ok := r.fields[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseFormat returns the format matching a name, a file extension or a content type.
+func ParseFormat(value string) (Format, bool) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch {
+	case value == "csv" || strings.HasSuffix(value, ".csv") || strings.HasPrefix(value, "text/csv"):
+		return FormatCSV, true
+	case value == "json" || strings.HasSuffix(value, ".json") || strings.HasPrefix(value, "application/json"):
+		return FormatJSON, true
+	}
+	return "", false
+}
+
+// Run parses the input, validates every row against the database and, unless in dry-run mode or with invalid rows,
+// writes the rows in a single transaction.
+func Run(db database.AppDatabase, input io.Reader, opts Options) (Report, error) {
+	if opts.Mode == "" {
+		opts.Mode = ModeInsert
+	}
+	report := Report{Kind: opts.Kind, Mode: opts.Mode, DryRun: opts.DryRun, Results: []RowResult{}, Errors: []RowError{}}
+
+	if opts.Mode != ModeInsert && opts.Mode != ModeUpsert {
+		return report, fmt.Errorf("%w: unknown mode %q", ErrInvalidOptions, opts.Mode)
+	}
+	if opts.Kind != KindTeams && opts.Kind != KindPlayers && opts.Kind != KindEvents {
+		return report, fmt.Errorf("%w: unknown kind %q", ErrInvalidOptions, opts.Kind)
+	}
+
+	var records []record
+	var err error
+	switch opts.Format {
+	case FormatCSV:
+		records, err = readCSV(input)
+	case FormatJSON:
+		records, err = readJSON(input)
+	default:
+		return report, fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, opts.Format)
+	}
+	if err != nil {
+		return report, err
+	}
+	if len(records) > maxRows {
+		return report, fmt.Errorf("%w: too many rows (%d, max %d)", ErrMalformedInput, len(records), maxRows)
+	}
+	report.Rows = len(records)
+
+	teams, err := db.ListTeams()
+	if err != nil {
+		return report, err
+	}
+	teamsByName := map[string]database.Team{}
+	for _, team := range teams {
+		teamsByName[normalizeKey(team.Name)] = team
+	}
+
+	var batch database.RosterImport
+	switch opts.Kind {
+	case KindTeams:
+		batch.Teams = planTeams(records, teamsByName, opts.Mode, &report)
+	case KindPlayers:
+		players, err := db.ListPlayers()
+		if err != nil {
+			return report, err
+		}
+		batch.Players = planPlayers(records, teamsByName, players, opts.Mode, &report)
+	case KindEvents:
+		events, err := db.ListEvents()
+		if err != nil {
+			return report, err
+		}
+		batch.Events = planEvents(records, teamsByName, events, opts.Mode, &report)
+	}
+
+	for _, result := range report.Results {
+		if result.Action == ActionCreate {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+	}
+	if opts.DryRun || len(report.Errors) > 0 {
+		return report, nil
+	}
+
+	ids, err := db.ImportRoster(batch)
+	if err != nil {
+		return report, err
+	}
+	written := append(append(ids.Teams, ids.Players...), ids.Events...)
+	for i := range report.Results {
+		if i < len(written) {
+			report.Results[i].ID = written[i]
+		}
+	}
+	report.Applied = true
+	return report, nil
+}
+
+func readCSV(input io.Reader) ([]record, error) {
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: missing header row", ErrMalformedInput)
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedInput, err)
+	}
+	for i := range header {
+		header[i] = normalizeKey(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	var records []record
+	line := 1
+	for {
+		values, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMalformedInput, err)
+		}
+		if len(values) == 1 && strings.TrimSpace(values[0]) == "" {
+			continue
+		}
+		rec := record{row: line, fields: map[string]string{}}
+		for i, value := range values {
+			if i < len(header) {
+				rec.fields[header[i]] = value
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func readJSON(input io.Reader) ([]record, error) {
+	var rows []map[string]interface{}
+	if err := json.NewDecoder(input).Decode(&rows); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedInput, err)
+	}
+
+	records := make([]record, 0, len(rows))
+	for i, row := range rows {
+		rec := record{row: i + 1, fields: map[string]string{}}
+		for key, value := range row {
+			switch v := value.(type) {
+			case nil:
+			case string:
+				rec.fields[normalizeKey(key)] = v
+			case float64:
+				rec.fields[normalizeKey(key)] = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				rec.fields[normalizeKey(key)] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("%w: row %d: unsupported value for %q", ErrMalformedInput, i+1, key)
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func normalizeKey(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func planTeams(records []record, existing map[string]database.Team, mode Mode, report *Report) []database.Team {
+	var teams []database.Team
+	seen := map[string]int{}
+	for _, rec := range records {
+		name := strings.Join(strings.Fields(rec.get("name", "team")), " ")
+		if name == "" {
+			report.Errors = append(report.Errors, RowError{Row: rec.row, Field: "name", Message: "Il nome della squadra è obbligatorio."})
+			continue
+		}
+		key := normalizeKey(name)
+		if first, ok := seen[key]; ok {
+			report.Errors = append(report.Errors, RowError{Row: rec.row, Field: "name", Message: fmt.Sprintf("Squadra duplicata (già presente alla riga %d).", first)})
+			continue
+		}
+		seen[key] = rec.row
+
+		team := database.Team{Name: name}
+		action := ActionCreate
+		if current, ok := existing[key]; ok {
+			if mode != ModeUpsert {
+				report.Errors = append(report.Errors, RowError{Row: rec.row, Field: "name", Message: "La squadra esiste già."})
+				continue
+			}
+			team.ID = current.ID
+			action = ActionUpdate
+		}
+		teams = append(teams, team)
+		report.Results = append(report.Results, RowResult{Row: rec.row, Action: action, Key: name})
+	}
+	return teams
+}
+
+func planPlayers(records []record, teams map[string]database.Team, current []database.Player, mode Mode, report *Report) []database.Player {
+	type jerseyOwner struct {
+		key string
+		row int
+	}
+	existing := map[string]database.Player{}
+	jerseys := map[string]jerseyOwner{}
+	for _, p := range current {
+		key := playerKey(p.TeamID, p.FirstName, p.LastName)
+		existing[key] = p
+		if p.JerseyNumber > 0 {
+			jerseys[fmt.Sprintf("%d/%d", p.TeamID, p.JerseyNumber)] = jerseyOwner{key: key}
+		}
+	}
+
+	var players []database.Player
+	seen := map[string]int{}
+	for _, rec := range records {
+		errCount := len(report.Errors)
+		addError := func(field, message string) {
+			report.Errors = append(report.Errors, RowError{Row: rec.row, Field: field, Message: message})
+		}
+
+		p := database.Player{
+			FirstName: rec.get("first_name", "nome"),
+			LastName:  rec.get("last_name", "cognome"),
+			Role:      rec.get("role", "ruolo"),
+			ImageURL:  rec.get("image_url"),
+		}
+		if p.FirstName == "" {
+			addError("first_name", "Il nome del giocatore è obbligatorio.")
+		}
+		if p.LastName == "" {
+			addError("last_name", "Il cognome del giocatore è obbligatorio.")
+		}
+		if raw := rec.get("jersey_number", "number"); raw != "" {
+			number, err := strconv.Atoi(raw)
+			if err != nil || number < 0 || number > 99 {
+				addError("jersey_number", "Il numero di maglia deve essere un intero tra 0 e 99.")
+			}
+			p.JerseyNumber = number
+		}
+		if p.ImageURL != "" && !validImageURL(p.ImageURL) {
+			addError("image_url", "L'URL dell'immagine non è valido.")
+		}
+		teamName := rec.get("team", "team_name")
+		if team, ok := teams[normalizeKey(teamName)]; ok {
+			p.TeamID = team.ID
+		} else if teamName == "" {
+			addError("team", "La squadra è obbligatoria.")
+		} else {
+			addError("team", fmt.Sprintf("La squadra %q non esiste.", teamName))
+		}
+		if len(report.Errors) > errCount {
+			continue
+		}
+
+		key := playerKey(p.TeamID, p.FirstName, p.LastName)
+		if first, ok := seen[key]; ok {
+			addError("last_name", fmt.Sprintf("Giocatore duplicato (già presente alla riga %d).", first))
+			continue
+		}
+		seen[key] = rec.row
+
+		action := ActionCreate
+		if current, ok := existing[key]; ok {
+			if mode != ModeUpsert {
+				addError("last_name", "Il giocatore esiste già in questa squadra.")
+				continue
+			}
+			p.ID = current.ID
+			action = ActionUpdate
+			if !rec.provided("role", "ruolo") {
+				p.Role = current.Role
+			}
+			if !rec.provided("jersey_number", "number") {
+				p.JerseyNumber = current.JerseyNumber
+			}
+			if !rec.provided("image_url") {
+				p.ImageURL = current.ImageURL
+			}
+		}
+
+		if p.JerseyNumber > 0 {
+			jerseyKey := fmt.Sprintf("%d/%d", p.TeamID, p.JerseyNumber)
+			if owner, ok := jerseys[jerseyKey]; ok && owner.key != key {
+				if owner.row > 0 {
+					addError("jersey_number", fmt.Sprintf("Numero di maglia già assegnato alla riga %d.", owner.row))
+				} else {
+					addError("jersey_number", "Numero di maglia già assegnato a un altro giocatore della squadra.")
+				}
+				continue
+			}
+			jerseys[jerseyKey] = jerseyOwner{key: key, row: rec.row}
+		}
+
+		players = append(players, p)
+		report.Results = append(report.Results, RowResult{Row: rec.row, Action: action, Key: fmt.Sprintf("%s %s (%s)", p.FirstName, p.LastName, teamName)})
+	}
+	return players
+}
+
+func playerKey(teamID int, firstName, lastName string) string {
+	return fmt.Sprintf("%d/%s/%s", teamID, normalizeKey(firstName), normalizeKey(lastName))
+}
+
+func validImageURL(value string) bool {
+	if strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//") {
+		return true
+	}
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func planEvents(records []record, teams map[string]database.Team, current []database.Event, mode Mode, report *Report) []database.Event {
+	existing := map[string]database.Event{}
+	for _, e := range current {
+		if start, ok := parseStart(e.StartDateTime); ok {
+			existing[eventKey(e.Team1ID, e.Team2ID, start)] = e
+		}
+	}
+
+	var events []database.Event
+	seen := map[string]int{}
+	for _, rec := range records {
+		errCount := len(report.Errors)
+		addError := func(field, message string) {
+			report.Errors = append(report.Errors, RowError{Row: rec.row, Field: field, Message: message})
+		}
+
+		e := database.Event{
+			StartDateTime: rec.get("start_datetime", "start"),
+			Location:      rec.get("location"),
+		}
+		homeName := rec.get("home_team", "team1")
+		awayName := rec.get("away_team", "team2")
+		for _, side := range []struct {
+			field string
+			name  string
+			id    *int
+		}{{"home_team", homeName, &e.Team1ID}, {"away_team", awayName, &e.Team2ID}} {
+			if side.name == "" {
+				addError(side.field, "La squadra è obbligatoria.")
+			} else if team, ok := teams[normalizeKey(side.name)]; ok {
+				*side.id = team.ID
+			} else {
+				addError(side.field, fmt.Sprintf("La squadra %q non esiste.", side.name))
+			}
+		}
+		if e.Team1ID > 0 && e.Team1ID == e.Team2ID {
+			addError("away_team", "Le due squadre devono essere diverse.")
+		}
+		start, ok := parseStart(e.StartDateTime)
+		if e.StartDateTime == "" {
+			addError("start_datetime", "La data di inizio è obbligatoria.")
+		} else if !ok {
+			addError("start_datetime", "Data di inizio non valida (formato atteso: 2006-01-02T15:04).")
+		}
+
+		flags := []struct {
+			field string
+			value *bool
+		}{
+			{"show_reaction_test", &e.ShowReactionTest},
+			{"show_selfie", &e.ShowSelfie},
+			{"show_vote_trend", &e.ShowVoteTrend},
+			{"show_feedback_survey", &e.ShowFeedbackSurvey},
+		}
+		for _, flag := range flags {
+			*flag.value = true
+			if !rec.has(flag.field) {
+				continue
+			}
+			value, ok := parseBool(rec.get(flag.field))
+			if !ok {
+				addError(flag.field, "Valore non valido: usa true/false.")
+			}
+			*flag.value = value
+		}
+		if len(report.Errors) > errCount {
+			continue
+		}
+
+		key := eventKey(e.Team1ID, e.Team2ID, start)
+		if first, ok := seen[key]; ok {
+			addError("start_datetime", fmt.Sprintf("Evento duplicato (già presente alla riga %d).", first))
+			continue
+		}
+		seen[key] = rec.row
+
+		action := ActionCreate
+		if current, ok := existing[key]; ok {
+			if mode != ModeUpsert {
+				addError("start_datetime", "L'evento esiste già.")
+				continue
+			}
+			e.ID = current.ID
+			// The stored value is kept, since it is part of the key.
+			e.StartDateTime = current.StartDateTime
+			action = ActionUpdate
+			if !rec.provided("location") {
+				e.Location = current.Location
+			}
+			for _, flag := range []struct {
+				field   string
+				value   *bool
+				current bool
+			}{
+				{"show_reaction_test", &e.ShowReactionTest, current.ShowReactionTest},
+				{"show_selfie", &e.ShowSelfie, current.ShowSelfie},
+				{"show_vote_trend", &e.ShowVoteTrend, current.ShowVoteTrend},
+				{"show_feedback_survey", &e.ShowFeedbackSurvey, current.ShowFeedbackSurvey},
+			} {
+				if !rec.has(flag.field) {
+					*flag.value = flag.current
+				}
+			}
+		}
+
+		events = append(events, e)
+		report.Results = append(report.Results, RowResult{Row: rec.row, Action: action, Key: fmt.Sprintf("%s - %s %s", homeName, awayName, e.StartDateTime)})
+	}
+	return events
+}
+
+func eventKey(team1ID, team2ID int, start time.Time) string {
+	return fmt.Sprintf("%d/%d/%d", team1ID, team2ID, start.Unix())
+}
+
+func parseStart(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"} {
+		if parsed, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "si", "sì", "y":
+		return true, true
+	case "0", "false", "no", "n":
+		return false, true
+	}
+	return false, false
+}
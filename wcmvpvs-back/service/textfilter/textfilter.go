@@ -0,0 +1,290 @@
+/*
+Package textfilter finds offensive words in the texts written by fans, like selfie captions and feedback suggestions.
+
+Texts and word lists are normalized before matching them: letters are lower-cased and stripped of accents, leetspeak
+digits and symbols are read as the letters they stand for ("m3rd@" is "merda"), repeated letters match a single one
+("cazzzzo" is "cazzo") and letters separated by spaces or dots are joined ("c.a.z.z.o").
+
+Words are matched as a whole, so that "classe" does not match "ass". A list entry ending with "*" matches every word
+starting with it ("stronz*" matches "stronzo" and "stronzate"), and an entry made of more words matches them in
+sequence. Entries of the allow list win over the deny list.
+*/
+package textfilter
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Match is an entry of the deny list found in a text. Start and End are offsets in runes of the original text.
+type Match struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Entry string `json:"entry"`
+}
+
+// Filter finds the entries of a deny list in texts. It is safe for concurrent use.
+type Filter struct {
+	deny  []pattern
+	allow []pattern
+}
+
+// New returns a filter for the given deny and allow lists. Empty and invalid entries are ignored.
+func New(deny, allow []string) *Filter {
+	return &Filter{deny: compile(deny), allow: compile(allow)}
+}
+
+// Find returns the entries of the deny list found in text, in order of appearance, not overlapping an entry of the
+// allow list.
+func (f *Filter) Find(text string) []Match {
+	if f == nil || len(f.deny) == 0 {
+		return nil
+	}
+	tokens := tokenize([]rune(text))
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	var allowed [][2]int
+	for i := range tokens {
+		for _, p := range f.allow {
+			if n := p.match(tokens[i:]); n > 0 {
+				allowed = append(allowed, [2]int{tokens[i].start, tokens[i+n-1].end})
+			}
+		}
+	}
+
+	var matches []Match
+	for i := 0; i < len(tokens); i++ {
+		for _, p := range f.deny {
+			n := p.match(tokens[i:])
+			if n == 0 {
+				continue
+			}
+			m := Match{Start: tokens[i].start, End: tokens[i+n-1].end, Entry: p.entry}
+			if covered(allowed, m) {
+				continue
+			}
+			matches = append(matches, m)
+			i += n - 1
+			break
+		}
+	}
+	return matches
+}
+
+// Mask replaces with asterisks the characters of the matches in text, except spaces.
+func Mask(text string, matches []Match) string {
+	if len(matches) == 0 {
+		return text
+	}
+	runes := []rune(text)
+	for _, m := range matches {
+		for i := m.Start; i < m.End && i < len(runes); i++ {
+			if !unicode.IsSpace(runes[i]) {
+				runes[i] = '*'
+			}
+		}
+	}
+	return string(runes)
+}
+
+func covered(allowed [][2]int, m Match) bool {
+	for _, span := range allowed {
+		if span[0] <= m.Start && m.End <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// run is a letter repeated count times in a row.
+type run struct {
+	letter rune
+	count  int
+}
+
+// token is a normalized word of a text, as runs of letters. start and end are offsets in the original text.
+type token struct {
+	runs       []run
+	start, end int
+}
+
+// pattern is a compiled list entry: a sequence of words, the last one possibly a prefix.
+type pattern struct {
+	entry  string
+	words  [][]run
+	prefix bool
+}
+
+func compile(entries []string) []pattern {
+	patterns := make([]pattern, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		text := strings.TrimSuffix(entry, "*")
+		p := pattern{entry: entry, prefix: text != entry}
+		for _, word := range strings.Fields(text) {
+			var letters []rune
+			for _, r := range word {
+				if l, ok := foldLetter(r); ok {
+					letters = append(letters, l)
+				}
+			}
+			if len(letters) > 0 {
+				p.words = append(p.words, runsOf(letters))
+			}
+		}
+		if len(p.words) > 0 {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// match returns how many tokens, from the first one, the pattern matches; zero if it does not match.
+func (p pattern) match(tokens []token) int {
+	if len(tokens) < len(p.words) {
+		return 0
+	}
+	for i, word := range p.words {
+		prefix := p.prefix && i == len(p.words)-1
+		if !matchWord(word, tokens[i].runs, prefix) {
+			return 0
+		}
+	}
+	return len(p.words)
+}
+
+// matchWord tells whether the runs of a token spell the word, allowing each letter to be repeated more times. When
+// prefix is set the token may continue after the word.
+func matchWord(word, runs []run, prefix bool) bool {
+	if len(runs) < len(word) || (!prefix && len(runs) != len(word)) {
+		return false
+	}
+	for i, w := range word {
+		if runs[i].letter != w.letter || runs[i].count < w.count {
+			return false
+		}
+	}
+	return true
+}
+
+func runsOf(letters []rune) []run {
+	var runs []run
+	for _, l := range letters {
+		if n := len(runs); n > 0 && runs[n-1].letter == l {
+			runs[n-1].count++
+			continue
+		}
+		runs = append(runs, run{letter: l, count: 1})
+	}
+	return runs
+}
+
+// tokenize splits the text into normalized words. Words made of a single letter in a row, with the same separator,
+// are joined, so that spelling a word letter by letter does not hide it.
+func tokenize(text []rune) []token {
+	var tokens []token
+	var letters []rune
+	start, hasLetter := -1, false
+	flush := func(end int) {
+		// An exclamation mark at the edges of a word is punctuation, like in "ciao!"
+		for len(letters) > 0 && text[start] == '!' {
+			letters, start = letters[1:], start+1
+		}
+		for len(letters) > 0 && text[end-1] == '!' {
+			letters, end = letters[:len(letters)-1], end-1
+		}
+		if len(letters) > 0 && hasLetter {
+			tokens = append(tokens, token{runs: runsOf(letters), start: start, end: end})
+		}
+		letters, start, hasLetter = nil, -1, false
+	}
+	for i, r := range text {
+		l, ok := foldLetter(r)
+		if !ok {
+			l, ok = leet[r]
+		} else {
+			hasLetter = true
+		}
+		if !ok {
+			if start >= 0 {
+				flush(i)
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+		letters = append(letters, l)
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+	return joinSpelled(text, tokens)
+}
+
+func joinSpelled(text []rune, tokens []token) []token {
+	joined := make([]token, 0, len(tokens))
+	for i := 0; i < len(tokens); {
+		j := i
+		for j < len(tokens) && single(tokens[j]) && (j <= i+1 || separator(text, tokens, j) == separator(text, tokens, i+1)) {
+			j++
+		}
+		if j-i < 3 {
+			joined = append(joined, tokens[i])
+			i++
+			continue
+		}
+		var letters []rune
+		for _, t := range tokens[i:j] {
+			letters = append(letters, t.runs[0].letter)
+		}
+		joined = append(joined, token{runs: runsOf(letters), start: tokens[i].start, end: tokens[j-1].end})
+		i = j
+	}
+	return joined
+}
+
+// separator returns the text between the token and the previous one.
+func separator(text []rune, tokens []token, i int) string {
+	return string(text[tokens[i-1].end:tokens[i].start])
+}
+
+func single(t token) bool {
+	return len(t.runs) == 1 && t.runs[0].count == 1 && t.end-t.start == 1
+}
+
+// leet maps the digits and symbols used in place of letters.
+var leet = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+	'!': 'i',
+}
+
+// foldLetter returns the lower-case letter without accents; false if r is not a letter.
+func foldLetter(r rune) (rune, bool) {
+	if !unicode.IsLetter(r) {
+		return 0, false
+	}
+	r = unicode.ToLower(r)
+	if folded, ok := accents[r]; ok {
+		return folded, true
+	}
+	return r, true
+}
+
+var accents = map[rune]rune{
+	'à': 'a', 'á': 'a', 'â': 'a', 'ä': 'a', 'ã': 'a', 'å': 'a',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e',
+	'ì': 'i', 'í': 'i', 'î': 'i', 'ï': 'i',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'ö': 'o', 'õ': 'o',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u',
+	'ç': 'c', 'ñ': 'n',
+}
@@ -0,0 +1,40 @@
+package textfilter
+
+import "testing"
+
+func TestFind(t *testing.T) {
+	f := New(append(Builtin("it"), Builtin("en")...), []string{"dick"})
+	for _, c := range []struct {
+		text   string
+		masked string
+	}{
+		{"Forza ragazzi!", "Forza ragazzi!"},
+		{"che cazzo", "che *****"},
+		{"Che CAZZZZO!", "Che *******!"},
+		{"sei uno str0nz0", "sei uno *******"},
+		{"m3rd@ di arbitro", "***** di arbitro"},
+		{"c a z z o", "* * * * *"},
+		{"c.a.z.z.o e basta", "********* e basta"},
+		{"porco   dio", "*****   ***"},
+		{"È una MÈRDA", "È una *****"},
+		{"una classe di assi", "una classe di assi"},
+		{"Dick è il migliore", "Dick è il migliore"},
+		{"what the fuuuck", "what the ******"},
+		{"tribuna 1 e 3", "tribuna 1 e 3"},
+	} {
+		if got := Mask(c.text, f.Find(c.text)); got != c.masked {
+			t.Errorf("Mask(%q) = %q, want %q", c.text, got, c.masked)
+		}
+	}
+}
+
+func TestFindPrefix(t *testing.T) {
+	f := New([]string{"stronz*"}, nil)
+	matches := f.Find("che stronzata, stronzo")
+	if len(matches) != 2 || matches[0].Start != 4 || matches[0].End != 13 || matches[1].Entry != "stronz*" {
+		t.Fatalf("Find = %+v", matches)
+	}
+	if matches := f.Find("stron"); len(matches) != 0 {
+		t.Fatalf("Find of a shorter word = %+v", matches)
+	}
+}
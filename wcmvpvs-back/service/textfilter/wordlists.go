@@ -0,0 +1,82 @@
+package textfilter
+
+import (
+	"sort"
+	"strings"
+)
+
+// builtinLists are the deny lists shipped with the application, by language. They cover the insults and slurs most
+// often heard in the stands; the admins extend them with their own words.
+var builtinLists = map[string][]string{
+	"it": {
+		"bastard*",
+		"cazz*",
+		"coglion*",
+		"cornut*",
+		"culattone",
+		"dio cane",
+		"diocane",
+		"dio porco",
+		"fanculo",
+		"figa",
+		"froci*",
+		"inculat*",
+		"merd*",
+		"mignott*",
+		"minchi*",
+		"mongoloid*",
+		"negr*",
+		"porca madonna",
+		"porcamadonna",
+		"porco dio",
+		"porcodio",
+		"puttan*",
+		"ricchion*",
+		"ritardat*",
+		"stronz*",
+		"terron*",
+		"troia",
+		"troie",
+		"vaffanculo",
+		"zoccol*",
+	},
+	"en": {
+		"asshole*",
+		"bastard*",
+		"bitch*",
+		"bullshit",
+		"cunt*",
+		"dick",
+		"dickhead*",
+		"fag",
+		"faggot*",
+		"fuck*",
+		"motherfuck*",
+		"nigga*",
+		"nigger*",
+		"pussy",
+		"retard*",
+		"shit",
+		"shitty",
+		"slut*",
+		"twat*",
+		"wanker*",
+		"whore*",
+	},
+}
+
+// Languages returns the languages with a built-in deny list.
+func Languages() []string {
+	languages := make([]string, 0, len(builtinLists))
+	for language := range builtinLists {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// Builtin returns the built-in deny list of the language, nil if there is none.
+func Builtin(language string) []string {
+	list := builtinLists[strings.ToLower(strings.TrimSpace(language))]
+	return append([]string(nil), list...)
+}
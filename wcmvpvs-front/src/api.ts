@@ -175,6 +175,22 @@ export async function vote({ eventId, playerId }) {
   }
 }
 
+export async function saveVoteContact({ eventId, code, signature, email }) {
+  try {
+    const { data } = await apiClient.post('/vote/contact', {
+      event_id: eventId,
+      code,
+      signature,
+      email,
+      locale: typeof navigator !== 'undefined' ? navigator.language : '',
+    });
+    return { ok: true, message: data?.message };
+  } catch (error) {
+    const message = axios.isAxiosError(error) ? error.response?.data?.message : undefined;
+    return { ok: false, error, message };
+  }
+}
+
 export async function validateTicketStatus({ eventId, code, signature }) {
   try {
     const params = new URLSearchParams();
@@ -357,6 +373,53 @@ export async function listApprovedSelfies(eventId: number) {
   }
 }
 
+export async function fetchSelfieContest(eventId: number) {
+  if (!eventId) {
+    return { ok: false, error: new Error('missing_event_id') };
+  }
+  try {
+    const { data } = await apiClient.get(`/events/${eventId}/selfie-contest`, {
+      headers: getDeviceHeaders(),
+    });
+    return { ok: true, data };
+  } catch (error) {
+    if (axios.isAxiosError(error) && error.response?.status === 404) {
+      return { ok: true, data: null };
+    }
+    return { ok: false, error };
+  }
+}
+
+export async function voteSelfieContest(eventId: number, selfieId: number) {
+  if (!eventId) {
+    return { ok: false, error: new Error('missing_event_id') };
+  }
+
+  const headers = getDeviceHeaders();
+  if (!headers['X-Device-ID']) {
+    return { ok: false, error: new Error('missing_device_id') };
+  }
+
+  try {
+    const { data } = await apiClient.post(
+      `/events/${eventId}/selfie-contest/votes`,
+      { selfie_id: selfieId },
+      { headers },
+    );
+    return { ok: true, data };
+  } catch (error) {
+    if (axios.isAxiosError(error)) {
+      return {
+        ok: false,
+        status: error.response?.status,
+        data: error.response?.data,
+        error,
+      };
+    }
+    return { ok: false, error };
+  }
+}
+
 type EventFeedbackPayload = {
   experience: string;
   team_spirit: string;